@@ -2,9 +2,11 @@ package sam3
 
 import (
 	"net"
+	"sync"
 	"time"
 
 	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3/internal/netutil"
 )
 
 /*
@@ -17,6 +19,10 @@ type SAMConn struct {
 	laddr i2pkeys.I2PAddr
 	raddr i2pkeys.I2PAddr
 	conn  net.Conn
+	// onClose is called once when the connection is closed, see
+	// StreamListener.SetPolicy
+	onClose   func()
+	closeOnce sync.Once
 }
 
 // Implements net.Conn
@@ -33,7 +39,17 @@ func (sc *SAMConn) Write(buf []byte) (int, error) {
 
 // Implements net.Conn
 func (sc *SAMConn) Close() error {
-	return sc.conn.Close()
+	err := sc.conn.Close()
+	if sc.onClose != nil {
+		sc.closeOnce.Do(sc.onClose)
+	}
+	return err
+}
+
+// CloseWrite ends the stream in the sending direction, the peer reads EOF
+// while it can still reply.
+func (sc *SAMConn) CloseWrite() error {
+	return netutil.CloseWrite(sc.conn)
 }
 
 func (sc *SAMConn) LocalAddr() net.Addr {
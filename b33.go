@@ -0,0 +1,230 @@
+package sam3
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"time"
+
+	"filippo.io/edwards25519"
+	"github.com/go-i2p/i2pkeys"
+)
+
+// Flags of a b33 address, the first byte of the decoded address.
+const (
+	b33FlagTwoByteSigTypes = 0x01
+	b33FlagSecret          = 0x02
+	b33FlagPerClientAuth   = 0x04
+)
+
+// b32Len is the length of the base32 part of an unblinded .b32.i2p name.
+const b32Len = 52
+
+var b32enc = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// LeaseSetAuthType is the per-client authentication scheme of an encrypted
+// leaseset, as used by i2cp.leaseSetAuthType.
+type LeaseSetAuthType int
+
+const (
+	LeaseSetAuthNone LeaseSetAuthType = 0
+	LeaseSetAuthDH   LeaseSetAuthType = 1 // X25519 key agreement per client
+	LeaseSetAuthPSK  LeaseSetAuthType = 2 // pre-shared key per client
+)
+
+// B33Credentials are what a client needs to look up an encrypted leaseset
+// behind a b33 address. Which of them are needed is encoded in the address.
+// SAM has no way to pass them to the bridge, so they have to be entered into
+// the client's router, for example on the page its HTTP proxy shows for the
+// address.
+type B33Credentials struct {
+	// Secret is the lookup password, required if the address has the
+	// "secret required" flag
+	Secret string
+	// AuthType and PrivateKey are required if the address has the
+	// "per-client auth" flag. PrivateKey is the client's X25519 private key
+	// for LeaseSetAuthDH or the pre-shared key for LeaseSetAuthPSK, 32 bytes
+	// either way.
+	AuthType   LeaseSetAuthType
+	PrivateKey []byte
+}
+
+// B33Error is returned when the bridge could not look up a b33 address which
+// needs credentials. SAM can not pass credentials with NAMING LOOKUP, so the
+// router itself must know them.
+type B33Error struct {
+	Name            string
+	SecretRequired  bool  // the address needs a lookup password
+	PrivKeyRequired bool  // the address needs a per-client private key
+	Err             error // the bridge's answer, usually a *ResolveError
+}
+
+func (e *B33Error) Error() string {
+	var need []string
+	if e.SecretRequired {
+		need = append(need, "a lookup password")
+	}
+	if e.PrivKeyRequired {
+		need = append(need, "a per-client private key")
+	}
+	return "encrypted address " + e.Name + " requires " + strings.Join(need, " and ") +
+		", which SAM can not pass to the router; configure them in the router: " + e.Err.Error()
+}
+
+func (e *B33Error) Unwrap() error {
+	return e.Err
+}
+
+// IsB33 reports whether name is an encrypted leaseset address, a .b32.i2p
+// name longer than the 52 characters of a plain destination hash.
+func IsB33(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".b32.i2p") && len(name)-len(".b32.i2p") > b32Len
+}
+
+// B33Address is an encrypted leaseset address. It carries the unblinded
+// signing public key of the destination; the key the leaseset is actually
+// published under is derived from it and the current date, see BlindedKey.
+type B33Address struct {
+	SigType        SigType // signature type of the destination
+	BlindedSigType SigType // signature type of the blinded key, always RedDSA
+	PublicKey      []byte  // signing public key of the destination
+	SecretRequired bool    // clients need a lookup password
+	PerClientAuth  bool    // clients need a per-client key
+}
+
+// NewB33Address creates the b33 address for a destination's Ed25519 or
+// RedDSA signing public key.
+func NewB33Address(pubkey []byte, sigType SigType, secretRequired, perClientAuth bool) (*B33Address, error) {
+	if sigType != Sig_EdDSA_SHA512_Ed25519 && sigType != Sig_RedDSA_SHA512_Ed25519 {
+		return nil, fmt.Errorf("b33 addresses can not be made for %s keys", sigType)
+	}
+	if _, err := edDecompress(pubkey); err != nil {
+		return nil, fmt.Errorf("invalid signing public key: %w", err)
+	}
+	return &B33Address{
+		SigType:        sigType,
+		BlindedSigType: Sig_RedDSA_SHA512_Ed25519,
+		PublicKey:      append([]byte(nil), pubkey...),
+		SecretRequired: secretRequired,
+		PerClientAuth:  perClientAuth,
+	}, nil
+}
+
+// AddrB33 returns the b33 address of a destination with an Ed25519 or
+// RedDSA signing key.
+func AddrB33(addr i2pkeys.I2PAddr, secretRequired, perClientAuth bool) (*B33Address, error) {
+	st, err := AddrSigType(addr)
+	if err != nil {
+		return nil, err
+	}
+	b, err := addr.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	end := destPublicKeyLen + destSigningKeyLen
+	return NewB33Address(b[end-32:end], st, secretRequired, perClientAuth)
+}
+
+// ParseB33 checks that name is a well-formed b33 address, including its
+// checksum, and decodes it.
+func ParseB33(name string) (*B33Address, error) {
+	host := strings.TrimSuffix(strings.ToLower(name), ".b32.i2p")
+	if host == strings.ToLower(name) || len(host) <= b32Len {
+		return nil, fmt.Errorf("%s is not a b33 address", name)
+	}
+	data, err := b32enc.DecodeString(strings.ToUpper(host))
+	if err != nil || len(data) < 4 {
+		return nil, fmt.Errorf("%s is not a b33 address", name)
+	}
+	sum := crc32.ChecksumIEEE(data[3:])
+	data[0] ^= byte(sum)
+	data[1] ^= byte(sum >> 8)
+	data[2] ^= byte(sum >> 16)
+	flags := data[0]
+	if flags&b33FlagTwoByteSigTypes != 0 {
+		return nil, errors.New("b33 addresses with two byte signature types are not supported")
+	}
+	if len(data[3:]) != 32 {
+		return nil, fmt.Errorf("b33 address has a %d byte key, want 32", len(data[3:]))
+	}
+	b, err := NewB33Address(data[3:], SigType(data[1]), flags&b33FlagSecret != 0, flags&b33FlagPerClientAuth != 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if bt := SigType(data[2]); bt != Sig_RedDSA_SHA512_Ed25519 {
+		return nil, fmt.Errorf("b33 address with unsupported blinded signature type %s", bt)
+	}
+	return b, nil
+}
+
+func (b *B33Address) flags() byte {
+	var f byte
+	if b.SecretRequired {
+		f |= b33FlagSecret
+	}
+	if b.PerClientAuth {
+		f |= b33FlagPerClientAuth
+	}
+	return f
+}
+
+// String returns the address as a .b32.i2p name.
+func (b *B33Address) String() string {
+	data := append([]byte{b.flags(), byte(b.SigType), byte(b.BlindedSigType)}, b.PublicKey...)
+	sum := crc32.ChecksumIEEE(data[3:])
+	data[0] ^= byte(sum)
+	data[1] ^= byte(sum >> 8)
+	data[2] ^= byte(sum >> 16)
+	return strings.ToLower(b32enc.EncodeToString(data)) + ".b32.i2p"
+}
+
+// BlindedKey returns the blinded public key the leaseset is published under
+// on date, which changes at midnight UTC. secret is the lookup password, or
+// "" if none is used.
+func (b *B33Address) BlindedKey(date time.Time, secret string) ([]byte, error) {
+	a, err := edDecompress(b.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	alphaB := new(edwards25519.Point).ScalarBaseMult(b.alpha(date, secret))
+	return new(edwards25519.Point).Add(a, alphaB).Bytes(), nil
+}
+
+// alpha is the blinding factor for date, GENERATE_ALPHA in the encrypted
+// leaseset specification.
+func (b *B33Address) alpha(date time.Time, secret string) *edwards25519.Scalar {
+	keydata := append(append([]byte(nil), b.PublicKey...),
+		byte(b.SigType>>8), byte(b.SigType), byte(b.BlindedSigType>>8), byte(b.BlindedSigType))
+	salt := sha256.Sum256(append([]byte("I2PGenerateAlpha"), keydata...))
+	ikm := date.UTC().Format("20060102") + secret
+	return edScalar(hkdfSHA256(salt[:], []byte(ikm), []byte("i2pblinding1"), 64))
+}
+
+// BlindedHash returns the hash the encrypted leaseset is stored under in the
+// network database on date.
+func (b *B33Address) BlindedHash(date time.Time, secret string) ([]byte, error) {
+	key, err := b.BlindedKey(date, secret)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(append([]byte{byte(b.BlindedSigType >> 8), byte(b.BlindedSigType)}, key...))
+	return h[:], nil
+}
+
+// b33Failure explains why the bridge failed to look up name with err if name
+// is a b33 address which needs credentials.
+func b33Failure(name string, err error) error {
+	var rerr *ResolveError
+	if !IsB33(name) || !errors.As(err, &rerr) {
+		return err
+	}
+	b, perr := ParseB33(name)
+	if perr != nil || !b.SecretRequired && !b.PerClientAuth {
+		return err
+	}
+	return &B33Error{Name: name, SecretRequired: b.SecretRequired, PrivKeyRequired: b.PerClientAuth, Err: err}
+}
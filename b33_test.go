@@ -0,0 +1,197 @@
+package sam3
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"filippo.io/edwards25519"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+// testB33 builds a b33 name with the given flags around a random key.
+func testB33(t *testing.T, flags byte) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewB33Address(pub, Sig_EdDSA_SHA512_Ed25519, flags&b33FlagSecret != 0, flags&b33FlagPerClientAuth != 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func Test_IsB33(t *testing.T) {
+	b32 := samtest.Keys(t).Addr().Base32()
+	b33 := testB33(t, 0)
+	if IsB33(b32) || !IsB33(b33) || IsB33("example.i2p") {
+		t.Errorf("IsB33 misclassified %s or %s", b32, b33)
+	}
+	if _, err := ParseB33(b33); err != nil {
+		t.Errorf("ParseB33: %v", err)
+	}
+	// a changed character breaks the checksum
+	broken := []byte(b33)
+	broken[10] = map[bool]byte{true: 'b', false: 'a'}[broken[10] == 'a']
+	if _, err := ParseB33(string(broken)); err == nil {
+		t.Error("ParseB33 accepted a corrupted address")
+	}
+}
+
+func Test_B33Lookup(t *testing.T) {
+	dest := samtest.Keys(t).Addr().Base64()
+	open := testB33(t, 0)
+	auth := testB33(t, b33FlagSecret|b33FlagPerClientAuth)
+	var lookups int32
+	b := namingBridge(t, map[string]string{open: dest}, &lookups, 0)
+	r, err := NewFullSAMResolver(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if addr, err := r.Resolve(open); err != nil || addr.Base64() != dest {
+		t.Fatalf("Resolve(%s) = %v", open, err)
+	}
+	// the router which does not know the credentials can not find it
+	_, err = r.Resolve(auth)
+	var berr *B33Error
+	var rerr *ResolveError
+	if !errors.As(err, &berr) || !berr.SecretRequired || !berr.PrivKeyRequired || !errors.As(err, &rerr) {
+		t.Fatalf("Resolve without credentials = %v", err)
+	}
+	if !strings.Contains(err.Error(), "configure them in the router") {
+		t.Errorf("unhelpful error %q", err)
+	}
+	// NAMING LOOKUP takes no credentials
+	if cmd := b.Command("NAMING LOOKUP"); cmd != "NAMING LOOKUP NAME="+auth {
+		t.Errorf("lookup %q", cmd)
+	}
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Errorf("%d lookups", n)
+	}
+}
+
+func Test_B33Address(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the private scalar of crypto/ed25519 keys
+	h := sha512.Sum512(priv.Seed())
+	a, err := edwards25519.NewScalar().SetBytesWithClamping(h[:32])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewB33Address(pub, Sig_EdDSA_SHA512_Ed25519, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := b.String()
+	if len(name) != 56+len(".b32.i2p") || !IsB33(name) {
+		t.Errorf("unexpected b33 name %s", name)
+	}
+	parsed, err := ParseB33(strings.ToUpper(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.SecretRequired || !parsed.PerClientAuth || parsed.SigType != Sig_EdDSA_SHA512_Ed25519 || string(parsed.PublicKey) != string(pub) {
+		t.Errorf("ParseB33 = %+v", parsed)
+	}
+
+	day := time.Date(2019, 4, 26, 12, 0, 0, 0, time.UTC)
+	k1, err := b.BlindedKey(day, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the blinded key belongs to the blinded private scalar a + alpha
+	aPrime := edwards25519.NewScalar().Add(a, b.alpha(day, ""))
+	if want := new(edwards25519.Point).ScalarBaseMult(aPrime).Bytes(); string(k1) != string(want) {
+		t.Error("blinded key does not match the blinded private key")
+	}
+	same, _ := b.BlindedKey(day.Add(11*time.Hour), "")
+	next, _ := b.BlindedKey(day.Add(24*time.Hour), "")
+	secret, _ := b.BlindedKey(day, "pw")
+	if string(same) != string(k1) || string(next) == string(k1) || string(secret) == string(k1) {
+		t.Error("blinded key does not depend on exactly the date and secret")
+	}
+	if h, err := b.BlindedHash(day, ""); err != nil || len(h) != 32 {
+		t.Errorf("BlindedHash = %x, %v", h, err)
+	}
+
+	addr, _ := signedDestination(t)
+	if fromAddr, err := AddrB33(addr, false, false); err != nil || fromAddr.SigType != Sig_EdDSA_SHA512_Ed25519 {
+		t.Errorf("AddrB33 = %v", err)
+	}
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Test_HKDF checks hkdfSHA256 against test case 1 of RFC 5869.
+func Test_HKDF(t *testing.T) {
+	okm := hkdfSHA256(unhex(t, "000102030405060708090a0b0c"), unhex(t, "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b"),
+		unhex(t, "f0f1f2f3f4f5f6f7f8f9"), 42)
+	if want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"; hex.EncodeToString(okm) != want {
+		t.Errorf("HKDF = %x", okm)
+	}
+}
+
+// Test_B33Regression pins the b33 names, blinded keys and blinded hashes
+// this package derives for the public key of test 1 of RFC 8032. The
+// expected values were produced by this package, so they only catch
+// unintended changes; they are not vectors from Java I2P or i2pd, and do
+// not show that other routers derive the same addresses.
+func Test_B33Regression(t *testing.T) {
+	pub := unhex(t, "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	if priv := ed25519.NewKeyFromSeed(unhex(t, "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")); string(priv.Public().(ed25519.PublicKey)) != string(pub) {
+		t.Fatal("RFC 8032 test 1 key mismatch")
+	}
+	for _, c := range []struct {
+		secret, auth bool
+		name         string
+	}{
+		{false, false, "wia2tv22taayfmikw7kux7wtzfsaooqo4fzphwvgems26aq2nd3qoui2.b32.i2p"},
+		{true, true, "wqa2tv22taayfmikw7kux7wtzfsaooqo4fzphwvgems26aq2nd3qoui2.b32.i2p"},
+	} {
+		b, err := NewB33Address(pub, Sig_EdDSA_SHA512_Ed25519, c.secret, c.auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != c.name {
+			t.Errorf("b33 %v/%v = %s, want %s", c.secret, c.auth, got, c.name)
+		}
+	}
+
+	b, _ := NewB33Address(pub, Sig_EdDSA_SHA512_Ed25519, false, false)
+	day := time.Date(2019, 4, 26, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct{ secret, key, hash string }{
+		{"", "591991debb3c4996304d431ec25f31d5796ce443339aa169d412db8c33389c73", "30ddbc6c4fa90686b95cfcab459fd16f06af3b52d7c4c791cacf68716cc83b44"},
+		{"pw", "9f4278cca80dcb3869a392b6dd207f9247f2cdd973dbdbe3f4314e11a4a824f9", "5bdd59832b6b75421ad89609fa78b324a9a046bb7deecd180c8fd22f97fab147"},
+	} {
+		key, err := b.BlindedKey(day, c.secret)
+		if err != nil || hex.EncodeToString(key) != c.key {
+			t.Errorf("BlindedKey(%q) = %x, %v", c.secret, key, err)
+		}
+		hash, err := b.BlindedHash(day, c.secret)
+		if err != nil || hex.EncodeToString(hash) != c.hash {
+			t.Errorf("BlindedHash(%q) = %x, %v", c.secret, hash, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package sam3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+
+	"filippo.io/edwards25519"
+)
+
+// Just enough of the Ed25519 curve to blind a public key offline, on top of
+// filippo.io/edwards25519, the constant-time implementation behind
+// crypto/ed25519.
+
+// edDecompress decodes a 32 byte Ed25519 public key.
+func edDecompress(b []byte) (*edwards25519.Point, error) {
+	p, err := new(edwards25519.Point).SetBytes(b)
+	if err != nil {
+		return nil, errors.New("invalid ed25519 point")
+	}
+	return p, nil
+}
+
+// edScalar reduces 64 little-endian bytes, such as a hash, modulo the order
+// of the curve.
+func edScalar(b []byte) *edwards25519.Scalar {
+	s, err := edwards25519.NewScalar().SetUniformBytes(b)
+	if err != nil {
+		panic("sam3: " + err.Error()) // b is always 64 bytes
+	}
+	return s
+}
+
+// hkdfSHA256 is HKDF from RFC 5869 with SHA-256.
+func hkdfSHA256(salt, ikm, info []byte, n int) []byte {
+	ext := hmac.New(sha256.New, salt)
+	ext.Write(ikm)
+	prk := ext.Sum(nil)
+	var out, t []byte
+	for i := byte(1); len(out) < n; i++ {
+		exp := hmac.New(sha256.New, prk)
+		exp.Write(t)
+		exp.Write(info)
+		exp.Write([]byte{i})
+		t = exp.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:n]
+}
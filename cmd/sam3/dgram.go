@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+)
+
+// maxDatagram is the largest payload of a repliable datagram.
+const maxDatagram = 31744
+
+// dgramFlags adds the flags of the datagram commands.
+func dgramFlags(fs *flag.FlagSet) (file *string, sig *sam3.SigType, name *string, udpPort *int) {
+	file, sig, name = keysFlags(fs)
+	udpPort = fs.Int("udp", 7655, "UDP port of the SAM bridge")
+	return file, sig, name, udpPort
+}
+
+// dgramSession opens a SAM connection and creates a datagram session on it.
+// Closing the session closes both.
+func (e *env) dgramSession(file string, sig sam3.SigType, name string, udpPort int) (*sam3.DatagramSession, error) {
+	sam, err := sam3.NewSAM(e.sam)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := e.sessionKeys(sam, file, sig)
+	if err != nil {
+		sam.Close()
+		return nil, err
+	}
+	session, err := sam.NewDatagramSession(name, keys, nil, udpPort)
+	if err != nil {
+		sam.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// dgramSend sends its argument, or stdin, as one datagram.
+func dgramSend(e *env, args []string) error {
+	fs := e.flags("dgram send")
+	file, sig, name, udpPort := dgramFlags(fs)
+	if err := parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+	var msg []byte
+	if fs.NArg() == 2 {
+		msg = []byte(fs.Arg(1))
+	} else {
+		var err error
+		if msg, err = io.ReadAll(io.LimitReader(e.stdin, maxDatagram+1)); err != nil {
+			return err
+		}
+	}
+	if len(msg) > maxDatagram {
+		return fmt.Errorf("message longer than %d bytes", maxDatagram)
+	}
+	session, err := e.dgramSession(*file, *sig, *name, *udpPort)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	dest, err := session.Lookup(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = session.WriteTo(msg, dest)
+	return err
+}
+
+// dgramRecv prints the datagrams which arrive, one per line after the
+// sender's address, until interrupted or -n arrived.
+func dgramRecv(e *env, args []string) error {
+	fs := e.flags("dgram recv")
+	file, sig, name, udpPort := dgramFlags(fs)
+	count := fs.Int("n", 0, "exit after this many datagrams, 0 for no limit")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	session, err := e.dgramSession(*file, *sig, *name, *udpPort)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	fmt.Fprintln(e.stdout, session.LocalI2PAddr().Base32())
+	go func() {
+		<-e.ctx.Done()
+		session.Close()
+	}()
+	buf := make([]byte, maxDatagram)
+	for i := 0; *count == 0 || i < *count; i++ {
+		n, from, err := session.ReadFrom(buf)
+		if err != nil {
+			if e.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		fmt.Fprintf(e.stdout, "%s\t%s\n", from.(i2pkeys.I2PAddr).Base32(), buf[:n])
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+)
+
+// sigFlag adds the -sig flag to fs.
+func sigFlag(fs *flag.FlagSet) *sam3.SigType {
+	sig := sam3.Sig_DEFAULT
+	fs.TextVar(&sig, "sig", sig, "signature type of new keys, by name or code")
+	return &sig
+}
+
+// keygen generates keys on the bridge and stores each in a new keyfile.
+func keygen(e *env, args []string) error {
+	fs := e.flags("keygen")
+	sig := sigFlag(fs)
+	if err := parse(fs, args, 1, -1); err != nil {
+		return err
+	}
+	sam, err := sam3.NewSAM(e.sam)
+	if err != nil {
+		return err
+	}
+	defer sam.Close()
+	for _, file := range fs.Args() {
+		keys, err := sam.NewKeys(*sig)
+		if err != nil {
+			return err
+		}
+		if err := storeKeys(keys, file); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "%s\t%s\n", file, keys.Addr().Base32())
+	}
+	return nil
+}
+
+// storeKeys stores keys in file, which must not exist yet.
+func storeKeys(keys i2pkeys.I2PKeys, file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := i2pkeys.StoreKeysIncompat(keys, f); err != nil {
+		f.Close()
+		os.Remove(file)
+		return fmt.Errorf("storing keys in %s: %w", file, err)
+	}
+	return f.Close()
+}
+
+// loadKeys loads the keys stored in file.
+func loadKeys(file string) (i2pkeys.I2PKeys, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	defer f.Close()
+	keys, err := i2pkeys.LoadKeysIncompat(f)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("loading keys from %s: %w", file, err)
+	}
+	return keys, nil
+}
+
+// sessionKeys returns the keys a session is created with: those in file, or
+// new ones which are stored in file if it does not exist yet. Without a
+// file the keys are transient.
+func (e *env) sessionKeys(sam *sam3.SAM, file string, sig sam3.SigType) (i2pkeys.I2PKeys, error) {
+	if file != "" {
+		keys, err := loadKeys(file)
+		if !os.IsNotExist(err) {
+			return keys, err
+		}
+	}
+	keys, err := sam.NewKeys(sig)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	if file != "" {
+		if err := storeKeys(keys, file); err != nil {
+			return i2pkeys.I2PKeys{}, err
+		}
+		fmt.Fprintf(e.stderr, "stored new keys in %s\n", file)
+	}
+	return keys, nil
+}
+
+// keysFlags adds the flags of commands which create a session.
+func keysFlags(fs *flag.FlagSet) (file *string, sig *sam3.SigType, name *string) {
+	file = fs.String("keys", "", "keyfile of the destination, created if it does not exist; transient keys if empty")
+	sig = sigFlag(fs)
+	name = fs.String("name", fmt.Sprintf("sam3-%d", os.Getpid()), "session ID")
+	return file, sig, name
+}
+
+// printAddr writes the addresses of a destination.
+func printAddr(w io.Writer, addr i2pkeys.I2PAddr) {
+	fmt.Fprintf(w, "b32\t%s\n", addr.Base32())
+	if sig, err := sam3.AddrSigType(addr); err == nil {
+		fmt.Fprintf(w, "sig\t%s\n", sig)
+	}
+	fmt.Fprintf(w, "b64\t%s\n", addr.Base64())
+}
+
+// addr shows the addresses of the keys in a keyfile.
+func addr(e *env, args []string) error {
+	fs := e.flags("addr")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	keys, err := loadKeys(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	printAddr(e.stdout, keys.Addr())
+	return nil
+}
+
+// lookup resolves a name with the router's addressbook.
+func lookup(e *env, args []string) error {
+	fs := e.flags("lookup")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	r, err := sam3.NewFullSAMResolver(e.sam)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	dest, err := r.Resolve(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	printAddr(e.stdout, dest)
+	return nil
+}
+
+// ping shows the version the bridge speaks and times PINGs.
+func ping(e *env, args []string) error {
+	fs := e.flags("ping")
+	count := fs.Int("c", 4, "number of PINGs")
+	interval := fs.Duration("i", time.Second, "time between PINGs")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	sam, err := sam3.NewSAM(e.sam)
+	if err != nil {
+		return err
+	}
+	defer sam.Close()
+	version := sam.Version()
+	if version == "" {
+		version = "3.0"
+	}
+	fmt.Fprintf(e.stdout, "SAM %s at %s\n", version, e.sam)
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(*interval):
+			case <-e.ctx.Done():
+				return nil
+			}
+		}
+		rtt, err := sam.Ping()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "PONG from %s: time=%s\n", e.sam, rtt)
+	}
+	return nil
+}
+
+// diagnose runs sam3.Diagnose and prints the report. It fails if a check
+// failed.
+func diagnose(e *env, args []string) error {
+	fs := e.flags("diagnose")
+	name := fs.String("lookup", "i2p-projekt.i2p", "name the naming lookup check looks up")
+	udpPort := fs.Int("udp", 7655, "UDP port of the SAM bridge")
+	loopback := fs.Duration("loopback", 30*time.Second, "how long to wait for the looped back datagram")
+	timeout := fs.Duration("timeout", 5*time.Minute, "how long all checks may take")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(e.ctx, *timeout)
+	defer cancel()
+	report, err := sam3.Diagnose(ctx, e.sam,
+		sam3.SetDiagnoseLookupName(*name),
+		sam3.SetDiagnoseUDPPort(*udpPort),
+		sam3.SetDiagnoseLoopbackTimeout(*loopback))
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(e.stdout, report)
+	if !report.OK() {
+		return errors.New("some checks failed")
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// Command sam3 does everyday things with a SAM bridge from the shell.
+//
+// Usage:
+//
+//	sam3 <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	keygen FILE...        generate keys and store them in new keyfiles
+//	addr KEYFILE          show the addresses of the keys in a keyfile
+//	lookup NAME           look up a name in the router's addressbook
+//	ping                  show the SAM version and time PINGs to the bridge
+//	diagnose              check what the bridge supports and what fails
+//	serve TARGET          make the TCP service at TARGET reachable over I2P
+//	forward TARGET        the same as serve
+//	connect DEST          connect stdin and stdout to a stream to DEST
+//	dgram send DEST [MSG] send MSG, or stdin, as one datagram to DEST
+//	dgram recv            print the datagrams which arrive
+//
+// Every command takes -sam, the address of the bridge, which defaults to
+// 127.0.0.1:7656 or $sam_host and $sam_port. Run "sam3 <command> -h" for the
+// flags of a command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/go-i2p/sam3"
+)
+
+// errUsage is returned when the arguments are wrong, after the usage of the
+// command was printed.
+var errUsage = errors.New("usage")
+
+// env is what commands run with. Long running commands stop when ctx is
+// done.
+type env struct {
+	ctx    context.Context
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// sam is the bridge address, set by the -sam flag
+	sam string
+}
+
+type command struct {
+	args, help string
+	run        func(e *env, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"keygen":   {"FILE...", "generate keys and store them in new keyfiles", keygen},
+		"addr":     {"KEYFILE", "show the addresses of the keys in a keyfile", addr},
+		"lookup":   {"NAME", "look up a name in the router's addressbook", lookup},
+		"ping":     {"", "show the SAM version and time PINGs to the bridge", ping},
+		"diagnose": {"", "check what the bridge supports and what fails", diagnose},
+		"serve":    {"TARGET", "make the TCP service at TARGET reachable over I2P", serve},
+		"forward":  {"TARGET", "the same as serve", serve},
+		"connect":  {"DEST", "connect stdin and stdout to a stream to DEST", connect},
+		// commands with subcommands are looked up by both words
+		"dgram send": {"DEST [MSG]", "send MSG, or stdin, as one datagram to DEST", dgramSend},
+		"dgram recv": {"", "print the datagrams which arrive", dgramRecv},
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	e := &env{ctx: ctx, stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	err := e.run(os.Args[1:])
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "sam3:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command named by args[0], or by args[0] and args[1].
+func (e *env) run(args []string) error {
+	if len(args) == 0 {
+		e.usage()
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok && len(args) > 1 {
+		if cmd, ok = commands[args[0]+" "+args[1]]; ok {
+			args = args[1:]
+		}
+	}
+	if !ok {
+		if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			e.usage()
+			return nil
+		}
+		fmt.Fprintf(e.stderr, "sam3: unknown command %q\n", args[0])
+		e.usage()
+		return errUsage
+	}
+	return cmd.run(e, args[1:])
+}
+
+func (e *env) usage() {
+	fmt.Fprintln(e.stderr, "usage: sam3 <command> [flags] [arguments]")
+	fmt.Fprintln(e.stderr, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := commands[name]
+		fmt.Fprintf(e.stderr, "  %-24s %s\n", name+" "+c.args, c.help)
+	}
+}
+
+// flags returns the flag set of command name, with the -sam flag.
+func (e *env) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.StringVar(&e.sam, "sam", sam3.SAMDefaultAddr(""), "address of the SAM bridge")
+	args := ""
+	if c, ok := commands[name]; ok {
+		args = c.args
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "usage: sam3 %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args into fs and checks there are between min and max
+// arguments left, max < 0 meaning any number.
+func parse(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+// buffer is a bytes.Buffer commands running in the background may write to.
+type buffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// withSAM adds the -sam flag after the command name in args.
+func withSAM(b *samtest.Bridge, args []string) []string {
+	n := 1
+	if _, ok := commands[args[0]]; !ok {
+		n = 2
+	}
+	return append(append(args[:n:n], "-sam", b.Addr()), args[n:]...)
+}
+
+// runCmd runs the command line args against the bridge and returns stdout.
+func runCmd(t *testing.T, b *samtest.Bridge, stdin string, args ...string) string {
+	t.Helper()
+	var stdout, stderr buffer
+	e := &env{ctx: context.Background(), stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr}
+	args = withSAM(b, args)
+	if err := e.run(args); err != nil {
+		t.Fatalf("sam3 %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String()
+}
+
+// background runs the command line args until the test ends.
+func background(t *testing.T, b *samtest.Bridge, args ...string) *buffer {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stdout := new(buffer)
+	e := &env{ctx: ctx, stdin: strings.NewReader(""), stdout: stdout, stderr: io.Discard}
+	args = withSAM(b, args)
+	done := make(chan error, 1)
+	go func() { done <- e.run(args) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("sam3 %s: %v", strings.Join(args, " "), err)
+		}
+	})
+	return stdout
+}
+
+// waitFor waits for the bridge to receive a command starting with prefix.
+func waitFor(t *testing.T, b *samtest.Bridge, prefix string) {
+	t.Helper()
+	for i := 0; i < 500 && b.Command(prefix) == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.Command(prefix) == "" {
+		t.Fatalf("no %s", prefix)
+	}
+}
+
+func Test_KeygenAddr(t *testing.T) {
+	b := samtest.New(t)
+	file := filepath.Join(t.TempDir(), "keys.dat")
+	out := runCmd(t, b, "", "keygen", "-sig", "EdDSA_SHA512_Ed25519", file)
+	if !strings.Contains(b.Command("DEST GENERATE"), "SIGNATURE_TYPE=7") {
+		t.Errorf("DEST GENERATE %q", b.Command("DEST GENERATE"))
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 || fields[0] != file {
+		t.Fatalf("keygen printed %q", out)
+	}
+	out = runCmd(t, b, "", "addr", file)
+	if !strings.HasPrefix(out, "b32\t"+fields[1]+"\nsig\tEdDSA_SHA512_Ed25519\nb64\t") {
+		t.Errorf("addr printed %q", out)
+	}
+
+	var stderr bytes.Buffer
+	e := &env{ctx: context.Background(), stdout: io.Discard, stderr: &stderr}
+	if err := e.run([]string{"keygen", "-sam", b.Addr(), file}); err == nil {
+		t.Error("keygen overwrote a keyfile")
+	}
+	if err := e.run([]string{"addr"}); err != errUsage || !strings.Contains(stderr.String(), "usage: sam3 addr") {
+		t.Errorf("addr without arguments: %v %q", err, stderr.String())
+	}
+}
+
+func Test_LookupPing(t *testing.T) {
+	b := samtest.New(t)
+	dest := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", dest)
+	if out := runCmd(t, b, "", "lookup", "site.i2p"); !strings.HasPrefix(out, "b32\t"+dest.Base32()+"\n") {
+		t.Errorf("lookup printed %q", out)
+	}
+	out := runCmd(t, b, "", "ping", "-c", "2", "-i", "0")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || lines[0] != "SAM 3.3 at "+b.Addr() || !strings.HasPrefix(lines[2], "PONG from ") {
+		t.Errorf("ping printed %q", out)
+	}
+}
+
+func Test_ServeConnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4)
+			io.ReadFull(c, buf)
+			c.Write(bytes.ToUpper(buf))
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	b := samtest.New(t)
+	dest := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", dest)
+	b.Forward(dest, l.Addr().String())
+	if out := runCmd(t, b, "ping", "connect", "-port", "80", "site.i2p"); out != "PING" {
+		t.Errorf("connect printed %q", out)
+	}
+	if p := samtest.Arg(b.Command("STREAM CONNECT"), "TO_PORT"); p != "80" {
+		t.Errorf("TO_PORT = %q", p)
+	}
+
+	background(t, b, "serve", port)
+	c, err := b.Connect(samtest.Keys(t).Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "pong")
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if got, err := io.ReadAll(c); err != nil || string(got) != "PONG" {
+		t.Errorf("served %q %v", got, err)
+	}
+}
+
+func Test_Dgram(t *testing.T) {
+	b := samtest.New(t)
+	dest := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", dest)
+	udp := strconv.Itoa(b.UDPPort())
+	runCmd(t, b, "hello", "dgram", "send", "-udp", udp, "-name", "sender", "site.i2p")
+	d, err := b.Sent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "sender" || d.Dest != dest.String() || string(d.Payload) != "hello" {
+		t.Errorf("sent %+v", d)
+	}
+
+	out := background(t, b, "dgram", "recv", "-udp", udp, "-name", "receiver")
+	waitFor(t, b, "SESSION CREATE STYLE=DATAGRAM ID=receiver")
+	from := samtest.Keys(t).Addr()
+	b.Deliver("receiver", from, []byte("hi"))
+	want := from.Base32() + "\thi\n"
+	for i := 0; i < 500 && !strings.HasSuffix(out.String(), want); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.HasSuffix(out.String(), want) {
+		t.Errorf("recv printed %q", out.String())
+	}
+}
+
+func Test_Diagnose(t *testing.T) {
+	b := samtest.New(t)
+	b.AddName("i2p-projekt.i2p", samtest.Keys(t).Addr())
+	var stdout, stderr buffer
+	e := &env{ctx: context.Background(), stdout: &stdout, stderr: &stderr}
+	err := e.run(withSAM(b, []string{"diagnose", "-udp", strconv.Itoa(b.UDPPort()), "-loopback", "100ms"}))
+	// samtest does not send datagrams back
+	if err == nil || !strings.Contains(stdout.String(), "FAIL  datagram loopback") {
+		t.Errorf("diagnose: %v\n%s", err, stdout.String())
+	}
+	for _, check := range []string{"tcp connect", "hello", "stream session", "primary session", "naming lookup"} {
+		if !strings.Contains(stdout.String(), "ok    "+check) {
+			t.Errorf("%s did not pass:\n%s", check, stdout.String())
+		}
+	}
+}
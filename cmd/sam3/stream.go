@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/go-i2p/sam3"
+)
+
+// streamSession opens a SAM connection and creates a stream session on it
+// with the keys asked for by the flags, sig being the type of new keys.
+// Closing the session closes both.
+func (e *env) streamSession(file string, sig sam3.SigType, name string) (*sam3.StreamSession, error) {
+	sam, err := sam3.NewSAM(e.sam)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := e.sessionKeys(sam, file, sig)
+	if err != nil {
+		sam.Close()
+		return nil, err
+	}
+	session, err := sam.NewStreamSession(name, keys, nil)
+	if err != nil {
+		sam.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// target adds the loopback address to a target given as a bare port.
+func target(s string) string {
+	if !strings.Contains(s, ":") {
+		return net.JoinHostPort("127.0.0.1", s)
+	}
+	return s
+}
+
+// serve accepts streams and connects each to a TCP service until
+// interrupted.
+func serve(e *env, args []string) error {
+	fs := e.flags("serve")
+	file, sig, name := keysFlags(fs)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	to := target(fs.Arg(0))
+	session, err := e.streamSession(*file, *sig, *name)
+	if err != nil {
+		return err
+	}
+	l, err := session.Listen()
+	if err != nil {
+		session.Close()
+		return err
+	}
+	fmt.Fprintln(e.stdout, session.Addr().Base32())
+	fmt.Fprintf(e.stderr, "forwarding streams to %s\n", to)
+	go func() {
+		<-e.ctx.Done()
+		l.Close()
+	}()
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if e.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer c.Close()
+			local, err := net.Dial("tcp", to)
+			if err != nil {
+				fmt.Fprintf(e.stderr, "%s: %v\n", c.RemoteAddr(), err)
+				return
+			}
+			defer local.Close()
+			pipe(c, local)
+		}()
+	}
+}
+
+// pipe copies between a and b until either side is done.
+func pipe(a, b io.ReadWriter) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// connect connects stdin and stdout to a stream, like netcat. At the end of
+// stdin the command waits for the peer to close the stream.
+func connect(e *env, args []string) error {
+	fs := e.flags("connect")
+	file, sig, name := keysFlags(fs)
+	port := fs.String("port", "", "I2P port to connect to")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	session, err := e.streamSession(*file, *sig, *name)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	dest, err := session.Lookup(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var c *sam3.SAMConn
+	if *port != "" {
+		c, err = session.DialI2PPort(dest, *port)
+	} else {
+		c, err = session.DialI2P(dest)
+	}
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	go func() {
+		<-e.ctx.Done()
+		c.Close()
+	}()
+	go io.Copy(c, e.stdin)
+	_, err = io.Copy(e.stdout, c)
+	if e.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
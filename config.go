@@ -2,61 +2,126 @@ package sam3
 
 import (
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"math/rand"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/go-i2p/i2pkeys"
 )
 
-// I2PConfig is a struct which manages I2P configuration options
+// I2PConfig is a struct which manages I2P configuration options. The struct
+// tags name the fields for JSON and YAML; see also ReadTunnelConfig for the
+// i2ptunnel format and ApplyEnv for environment variables.
 type I2PConfig struct {
-	SamHost string
-	SamPort string
-	TunName string
-
-	SamMin string
-	SamMax string
-
-	Fromport string
-	Toport   string
-
-	Style   string
-	TunType string
-
-	DestinationKeys i2pkeys.I2PKeys
-
-	SigType                   string
-	EncryptLeaseSet           string
-	LeaseSetKey               string
-	LeaseSetPrivateKey        string
-	LeaseSetPrivateSigningKey string
-	LeaseSetKeys              i2pkeys.I2PKeys
-	InAllowZeroHop            string
-	OutAllowZeroHop           string
-	InLength                  string
-	OutLength                 string
-	InQuantity                string
-	OutQuantity               string
-	InVariance                string
-	OutVariance               string
-	InBackupQuantity          string
-	OutBackupQuantity         string
-	FastRecieve               string
-	UseCompression            string
-	MessageReliability        string
-	CloseIdle                 string
-	CloseIdleTime             string
-	ReduceIdle                string
-	ReduceIdleTime            string
-	ReduceIdleQuantity        string
-	LeaseSetEncryption        string
+	SamHost string `json:"samHost,omitempty" yaml:"samHost,omitempty"`
+	SamPort string `json:"samPort,omitempty" yaml:"samPort,omitempty"`
+	TunName string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	SamMin string `json:"samMin,omitempty" yaml:"samMin,omitempty"`
+	SamMax string `json:"samMax,omitempty" yaml:"samMax,omitempty"`
+
+	Fromport string `json:"fromPort,omitempty" yaml:"fromPort,omitempty"`
+	Toport   string `json:"toPort,omitempty" yaml:"toPort,omitempty"`
+
+	Style   string `json:"style,omitempty" yaml:"style,omitempty"`
+	TunType string `json:"type,omitempty" yaml:"type,omitempty"`
+
+	DestinationKeys i2pkeys.I2PKeys `json:"-" yaml:"-"`
+
+	// SigType is the signature type of new destinations; nil leaves it to
+	// the bridge, or to the tunnel package, which defaults to Ed25519.
+	SigType                   *SigType         `json:"signatureType,omitempty" yaml:"signatureType,omitempty"`
+	EncryptLeaseSet           string           `json:"encryptLeaseSet,omitempty" yaml:"encryptLeaseSet,omitempty"`
+	LeaseSetKey               string           `json:"leaseSetKey,omitempty" yaml:"leaseSetKey,omitempty"`
+	LeaseSetPrivateKey        string           `json:"leaseSetPrivateKey,omitempty" yaml:"leaseSetPrivateKey,omitempty"`
+	LeaseSetPrivateSigningKey string           `json:"leaseSetPrivateSigningKey,omitempty" yaml:"leaseSetPrivateSigningKey,omitempty"`
+	LeaseSetKeys              i2pkeys.I2PKeys  `json:"-" yaml:"-"`
+	LeaseSetSecret            string           `json:"leaseSetSecret,omitempty" yaml:"leaseSetSecret,omitempty"`
+	LeaseSetAuthType          LeaseSetAuthType `json:"leaseSetAuthType,omitempty" yaml:"leaseSetAuthType,omitempty"`
+	LeaseSetClients           []LeaseSetClient `json:"leaseSetClients,omitempty" yaml:"leaseSetClients,omitempty"`
+	InAllowZeroHop            string           `json:"inboundAllowZeroHop,omitempty" yaml:"inboundAllowZeroHop,omitempty"`
+	OutAllowZeroHop           string           `json:"outboundAllowZeroHop,omitempty" yaml:"outboundAllowZeroHop,omitempty"`
+	InLength                  string           `json:"inboundLength,omitempty" yaml:"inboundLength,omitempty"`
+	OutLength                 string           `json:"outboundLength,omitempty" yaml:"outboundLength,omitempty"`
+	InQuantity                string           `json:"inboundQuantity,omitempty" yaml:"inboundQuantity,omitempty"`
+	OutQuantity               string           `json:"outboundQuantity,omitempty" yaml:"outboundQuantity,omitempty"`
+	InVariance                string           `json:"inboundLengthVariance,omitempty" yaml:"inboundLengthVariance,omitempty"`
+	OutVariance               string           `json:"outboundLengthVariance,omitempty" yaml:"outboundLengthVariance,omitempty"`
+	InBackupQuantity          string           `json:"inboundBackupQuantity,omitempty" yaml:"inboundBackupQuantity,omitempty"`
+	OutBackupQuantity         string           `json:"outboundBackupQuantity,omitempty" yaml:"outboundBackupQuantity,omitempty"`
+	FastRecieve               string           `json:"fastReceive,omitempty" yaml:"fastReceive,omitempty"`
+	UseCompression            string           `json:"gzip,omitempty" yaml:"gzip,omitempty"`
+	MessageReliability        string           `json:"messageReliability,omitempty" yaml:"messageReliability,omitempty"`
+	CloseIdle                 string           `json:"closeOnIdle,omitempty" yaml:"closeOnIdle,omitempty"`
+	CloseIdleTime             string           `json:"closeIdleTime,omitempty" yaml:"closeIdleTime,omitempty"`
+	ReduceIdle                string           `json:"reduceOnIdle,omitempty" yaml:"reduceOnIdle,omitempty"`
+	ReduceIdleTime            string           `json:"reduceIdleTime,omitempty" yaml:"reduceIdleTime,omitempty"`
+	ReduceIdleQuantity        string           `json:"reduceQuantity,omitempty" yaml:"reduceQuantity,omitempty"`
+	LeaseSetEncryption        string           `json:"leaseSetEncType,omitempty" yaml:"leaseSetEncType,omitempty"`
 
 	//Streaming Library options
-	AccessListType string
-	AccessList     []string
+	AccessListType       string   `json:"accessListType,omitempty" yaml:"accessListType,omitempty"`
+	AccessList           []string `json:"accessList,omitempty" yaml:"accessList,omitempty"`
+	StreamConnectDelay   string   `json:"connectDelay,omitempty" yaml:"connectDelay,omitempty"`
+	MaxWindowSize        string   `json:"maxWindowSize,omitempty" yaml:"maxWindowSize,omitempty"`
+	InitialWindowSize    string   `json:"initialWindowSize,omitempty" yaml:"initialWindowSize,omitempty"`
+	MaxConnsPerMinute    string   `json:"maxConnsPerMinute,omitempty" yaml:"maxConnsPerMinute,omitempty"`
+	MaxConnsPerHour      string   `json:"maxConnsPerHour,omitempty" yaml:"maxConnsPerHour,omitempty"`
+	MaxConnsPerDay       string   `json:"maxConnsPerDay,omitempty" yaml:"maxConnsPerDay,omitempty"`
+	MaxConcurrentStreams string   `json:"maxConcurrentStreams,omitempty" yaml:"maxConcurrentStreams,omitempty"`
+	AnswerPings          string   `json:"answerPings,omitempty" yaml:"answerPings,omitempty"`
+	StreamProfile        string   `json:"profile,omitempty" yaml:"profile,omitempty"`
+	InactivityTimeout    string   `json:"inactivityTimeout,omitempty" yaml:"inactivityTimeout,omitempty"`
+	InactivityAction     string   `json:"inactivityAction,omitempty" yaml:"inactivityAction,omitempty"`
+	EnforceProtocol      string   `json:"enforceProtocol,omitempty" yaml:"enforceProtocol,omitempty"`
+
+	// ExtraOptions are further I2CP or streaming options passed to the
+	// router as they are, such as those read from a tunnel config file
+	// which have no field of their own.
+	ExtraOptions map[string]string `json:"options,omitempty" yaml:"options,omitempty"`
+	// Tunnel holds tunnel settings from a config file which are not session
+	// options, such as targetHost or listenPort, keyed as in the file.
+	Tunnel map[string]string `json:"tunnel,omitempty" yaml:"tunnel,omitempty"`
+}
+
+// SessionOption is a single key=value option of a SESSION CREATE.
+type SessionOption struct {
+	Key   string
+	Value string
+}
+
+// String renders the option for a SAM command, quoting the value if it
+// contains spaces.
+func (o SessionOption) String() string {
+	return o.Key + "=" + quoteValue(o.Value)
+}
+
+// sessionOptions collects options, leaving out those without a value.
+type sessionOptions []SessionOption
+
+func (o *sessionOptions) add(key, value string) {
+	if value != "" {
+		*o = append(*o, SessionOption{key, value})
+	}
+}
+
+func (o sessionOptions) has(key string) bool {
+	for _, opt := range o {
+		if opt.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (o sessionOptions) String() string {
+	r := make([]string, len(o))
+	for i, opt := range o {
+		r[i] = opt.String()
+	}
+	return strings.Join(r, " ")
 }
 
 func (f *I2PConfig) Sam() string {
@@ -68,27 +133,17 @@ func (f *I2PConfig) Sam() string {
 	if f.SamPort != "" {
 		port = f.SamPort
 	}
-	log.WithFields(logrus.Fields{
-		"host": host,
-		"port": port,
-	}).Debug("SAM address constructed")
+	defaultLog().Debug("SAM address constructed", "host", host, "port", port)
 	return host + ":" + port
 }
 
 func (f *I2PConfig) SetSAMAddress(addr string) {
-	hp := strings.Split(addr, ":")
-	if len(hp) == 1 {
-		f.SamHost = hp[0]
-	} else if len(hp) == 2 {
-		f.SamPort = hp[1]
-		f.SamHost = hp[0]
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		f.SamHost, f.SamPort = host, port
+	} else if addr != "" {
+		f.SamHost = addr
 	}
-	f.SamPort = "7656"
-	f.SamHost = "127.0.0.1"
-	log.WithFields(logrus.Fields{
-		"host": f.SamHost,
-		"port": f.SamPort,
-	}).Debug("SAM address set")
+	defaultLog().Debug("SAM address set", "host", f.SamHost, "port", f.SamPort)
 }
 
 func (f *I2PConfig) ID() string {
@@ -98,239 +153,319 @@ func (f *I2PConfig) ID() string {
 			b[i] = "abcdefghijklmnopqrstuvwxyz"[rand.Intn(len("abcdefghijklmnopqrstuvwxyz"))]
 		}
 		f.TunName = string(b)
-		log.WithField("TunName", f.TunName).Debug("Generated random tunnel name")
+		defaultLog().Debug("Generated random tunnel name", "TunName", f.TunName)
 	}
 	return " ID=" + f.TunName + " "
 }
 
 func (f *I2PConfig) Leasesetsettings() (string, string, string) {
-	var r, s, t string
-	if f.LeaseSetKey != "" {
-		r = " i2cp.leaseSetKey=" + f.LeaseSetKey + " "
-	}
-	if f.LeaseSetPrivateKey != "" {
-		s = " i2cp.leaseSetPrivateKey=" + f.LeaseSetPrivateKey + " "
-	}
-	if f.LeaseSetPrivateSigningKey != "" {
-		t = " i2cp.leaseSetPrivateSigningKey=" + f.LeaseSetPrivateSigningKey + " "
-	}
-	log.WithFields(logrus.Fields{
-		"leaseSetKey":               r,
-		"leaseSetPrivateKey":        s,
-		"leaseSetPrivateSigningKey": t,
-	}).Debug("Lease set settings constructed")
-	return r, s, t
+	var r, s, t sessionOptions
+	r.add("i2cp.leaseSetKey", f.LeaseSetKey)
+	s.add("i2cp.leaseSetPrivateKey", f.LeaseSetPrivateKey)
+	t.add("i2cp.leaseSetPrivateSigningKey", f.LeaseSetPrivateSigningKey)
+	defaultLog().Debug("Lease set settings constructed", "leaseSetKey", f.LeaseSetKey != "", "leaseSetPrivateKey", f.LeaseSetPrivateKey != "", "leaseSetPrivateSigningKey", f.LeaseSetPrivateSigningKey != "")
+	return r.String(), s.String(), t.String()
 }
 
 func (f *I2PConfig) FromPort() string {
 	if f.samMax() < 3.1 {
-		log.Debug("SAM version < 3.1, FromPort not applicable")
+		defaultLog().Debug("SAM version < 3.1, FromPort not applicable")
 		return ""
 	}
 	if f.Fromport != "0" {
-		log.WithField("fromPort", f.Fromport).Debug("FromPort set")
+		defaultLog().Debug("FromPort set", "fromPort", f.Fromport)
 		return " FROM_PORT=" + f.Fromport + " "
 	}
-	log.Debug("FromPort not set")
+	defaultLog().Debug("FromPort not set")
 	return ""
 }
 
 func (f *I2PConfig) ToPort() string {
 	if f.samMax() < 3.1 {
-		log.Debug("SAM version < 3.1, ToPort not applicable")
+		defaultLog().Debug("SAM version < 3.1, ToPort not applicable")
 		return ""
 	}
 	if f.Toport != "0" {
-		log.WithField("toPort", f.Toport).Debug("ToPort set")
+		defaultLog().Debug("ToPort set", "toPort", f.Toport)
 		return " TO_PORT=" + f.Toport + " "
 	}
-	log.Debug("ToPort not set")
+	defaultLog().Debug("ToPort not set")
 	return ""
 }
 
 func (f *I2PConfig) SessionStyle() string {
 	if f.Style != "" {
-		log.WithField("style", f.Style).Debug("Session style set")
+		defaultLog().Debug("Session style set", "style", f.Style)
 		return " STYLE=" + f.Style + " "
 	}
-	log.Debug("Using default STREAM style")
+	defaultLog().Debug("Using default STREAM style")
 	return " STYLE=STREAM "
 }
 
 func (f *I2PConfig) samMax() float64 {
-	i, err := strconv.Atoi(f.SamMax)
+	v, err := strconv.ParseFloat(f.MaxSAM(), 64)
 	if err != nil {
-		log.WithError(err).Warn("Failed to parse SamMax, using default 3.1")
+		defaultLog().Warn("Failed to parse SamMax, using default 3.1", "error", err)
 		return 3.1
 	}
-	log.WithField("samMax", float64(i)).Debug("SAM max version parsed")
-	return float64(i)
+	defaultLog().Debug("SAM max version parsed", "samMax", v)
+	return v
 }
 
 func (f *I2PConfig) MinSAM() string {
 	if f.SamMin == "" {
-		log.Debug("Using default MinSAM: 3.0")
+		defaultLog().Debug("Using default MinSAM: 3.0")
 		return "3.0"
 	}
-	log.WithField("minSAM", f.SamMin).Debug("MinSAM set")
+	defaultLog().Debug("MinSAM set", "minSAM", f.SamMin)
 	return f.SamMin
 }
 
 func (f *I2PConfig) MaxSAM() string {
 	if f.SamMax == "" {
-		log.Debug("Using default MaxSAM: 3.1")
+		defaultLog().Debug("Using default MaxSAM: 3.1")
 		return "3.1"
 	}
-	log.WithField("maxSAM", f.SamMax).Debug("MaxSAM set")
+	defaultLog().Debug("MaxSAM set", "maxSAM", f.SamMax)
 	return f.SamMax
 }
 
 func (f *I2PConfig) DestinationKey() string {
-	if &f.DestinationKeys != nil {
-		log.WithField("destinationKey", f.DestinationKeys.String()).Debug("Destination key set")
+	if f.DestinationKeys.String() != "" {
+		defaultLog().Debug("Destination keys set", "addr", f.DestinationKeys.Addr().Base32())
 		return " DESTINATION=" + f.DestinationKeys.String() + " "
 	}
-	log.Debug("Using TRANSIENT destination")
+	defaultLog().Debug("Using TRANSIENT destination")
 	return " DESTINATION=TRANSIENT "
 }
 
 func (f *I2PConfig) SignatureType() string {
 	if f.samMax() < 3.1 {
-		log.Debug("SAM version < 3.1, SignatureType not applicable")
+		defaultLog().Debug("SAM version < 3.1, SignatureType not applicable")
 		return ""
 	}
-	if f.SigType != "" {
-		log.WithField("sigType", f.SigType).Debug("Signature type set")
-		return " SIGNATURE_TYPE=" + f.SigType + " "
+	if f.SigType != nil {
+		defaultLog().Debug("Signature type set", "sigType", *f.SigType)
+		return " " + f.SigType.Option() + " "
 	}
-	log.Debug("Signature type not set")
+	defaultLog().Debug("Signature type not set")
 	return ""
 }
 
 func (f *I2PConfig) EncryptLease() string {
 	if f.EncryptLeaseSet == "true" {
-		log.Debug("Lease set encryption enabled")
-		return " i2cp.encryptLeaseSet=true "
+		defaultLog().Debug("Lease set encryption enabled")
+		return "i2cp.encryptLeaseSet=true"
 	}
-	log.Debug("Lease set encryption not enabled")
+	defaultLog().Debug("Lease set encryption not enabled")
 	return ""
 }
 
 func (f *I2PConfig) Reliability() string {
 	if f.MessageReliability != "" {
-		log.WithField("reliability", f.MessageReliability).Debug("Message reliability set")
-		return " i2cp.messageReliability=" + f.MessageReliability + " "
+		defaultLog().Debug("Message reliability set", "reliability", f.MessageReliability)
+		return SessionOption{"i2cp.messageReliability", f.MessageReliability}.String()
 	}
-	log.Debug("Message reliability not set")
+	defaultLog().Debug("Message reliability not set")
 	return ""
 }
 
+func (f *I2PConfig) reduceOptions() (o sessionOptions) {
+	if f.ReduceIdle == "true" {
+		o.add("i2cp.reduceOnIdle", "true")
+		o.add("i2cp.reduceIdleTime", f.ReduceIdleTime)
+		o.add("i2cp.reduceQuantity", f.ReduceIdleQuantity)
+	}
+	return
+}
+
 func (f *I2PConfig) Reduce() string {
 	if f.ReduceIdle == "true" {
-		log.WithFields(logrus.Fields{
-			"reduceIdle":         f.ReduceIdle,
-			"reduceIdleTime":     f.ReduceIdleTime,
-			"reduceIdleQuantity": f.ReduceIdleQuantity,
-		}).Debug("Reduce idle settings applied")
-		return "i2cp.reduceOnIdle=" + f.ReduceIdle + "i2cp.reduceIdleTime=" + f.ReduceIdleTime + "i2cp.reduceQuantity=" + f.ReduceIdleQuantity
-	}
-	log.Debug("Reduce idle settings not applied")
+		defaultLog().Debug("Reduce idle settings applied", "reduceIdle", f.ReduceIdle, "reduceIdleTime", f.ReduceIdleTime, "reduceIdleQuantity", f.ReduceIdleQuantity)
+		return f.reduceOptions().String()
+	}
+	defaultLog().Debug("Reduce idle settings not applied")
 	return ""
 }
 
+func (f *I2PConfig) closeOptions() (o sessionOptions) {
+	if f.CloseIdle == "true" {
+		o.add("i2cp.closeOnIdle", "true")
+		o.add("i2cp.closeIdleTime", f.CloseIdleTime)
+	}
+	return
+}
+
 func (f *I2PConfig) Close() string {
 	if f.CloseIdle == "true" {
-		log.WithFields(logrus.Fields{
-			"closeIdle":     f.CloseIdle,
-			"closeIdleTime": f.CloseIdleTime,
-		}).Debug("Close idle settings applied")
-		return "i2cp.closeOnIdle=" + f.CloseIdle + "i2cp.closeIdleTime=" + f.CloseIdleTime
+		defaultLog().Debug("Close idle settings applied", "closeIdle", f.CloseIdle, "closeIdleTime", f.CloseIdleTime)
+		return f.closeOptions().String()
 	}
-	log.Debug("Close idle settings not applied")
+	defaultLog().Debug("Close idle settings not applied")
 	return ""
 }
 
-func (f *I2PConfig) DoZero() string {
-	r := ""
+func (f *I2PConfig) zeroOptions() (o sessionOptions) {
 	if f.InAllowZeroHop == "true" {
-		r += " inbound.allowZeroHop=" + f.InAllowZeroHop + " "
+		o.add("inbound.allowZeroHop", "true")
 	}
 	if f.OutAllowZeroHop == "true" {
-		r += " outbound.allowZeroHop= " + f.OutAllowZeroHop + " "
+		o.add("outbound.allowZeroHop", "true")
 	}
 	if f.FastRecieve == "true" {
-		r += " " + f.FastRecieve + " "
+		o.add("i2cp.fastReceive", "true")
 	}
-	log.WithField("zeroHopSettings", r).Debug("Zero hop settings applied")
+	return
+}
+
+func (f *I2PConfig) DoZero() string {
+	r := f.zeroOptions().String()
+	defaultLog().Debug("Zero hop settings applied", "zeroHopSettings", r)
 	return r
 }
+
+// SessionOptions returns the I2CP and streaming options of the
+// configuration, in the order they are sent to the bridge. Options whose
+// value is empty are left out.
+func (f *I2PConfig) SessionOptions() []SessionOption {
+	var o sessionOptions
+	o.add("inbound.length", f.InLength)
+	o.add("outbound.length", f.OutLength)
+	o.add("inbound.lengthVariance", f.InVariance)
+	o.add("outbound.lengthVariance", f.OutVariance)
+	o.add("inbound.backupQuantity", f.InBackupQuantity)
+	o.add("outbound.backupQuantity", f.OutBackupQuantity)
+	o.add("inbound.quantity", f.InQuantity)
+	o.add("outbound.quantity", f.OutQuantity)
+	o = append(o, f.zeroOptions()...)
+	o.add("i2cp.gzip", f.UseCompression)
+	o = append(o, f.reduceOptions()...)
+	o = append(o, f.closeOptions()...)
+	o.add("i2cp.messageReliability", f.MessageReliability)
+	if f.EncryptLeaseSet == "true" {
+		o.add("i2cp.encryptLeaseSet", "true")
+	}
+	o.add("i2cp.leaseSetKey", f.LeaseSetKey)
+	o.add("i2cp.leaseSetPrivateKey", f.LeaseSetPrivateKey)
+	o.add("i2cp.leaseSetPrivateSigningKey", f.LeaseSetPrivateSigningKey)
+	o = append(o, f.leaseSetAuthOptions()...)
+	o = append(o, f.accessListOptions()...)
+	o.add("i2cp.leaseSetEncType", f.leaseSetEncType())
+	o = append(o, f.streamingOptions()...)
+	keys := make([]string, 0, len(f.ExtraOptions))
+	for k := range f.ExtraOptions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// options with a field of their own take precedence
+		if !o.has(k) {
+			o.add(k, f.ExtraOptions[k])
+		}
+	}
+	return o
+}
+
+// streamingFields are the streaming library options, in the order they are
+// emitted.
+func (f *I2PConfig) streamingFields() []configField {
+	return []configField{
+		{"i2p.streaming.connectDelay", &f.StreamConnectDelay},
+		{"i2p.streaming.maxWindowSize", &f.MaxWindowSize},
+		{"i2p.streaming.initialWindowSize", &f.InitialWindowSize},
+		{"i2p.streaming.maxConnsPerMinute", &f.MaxConnsPerMinute},
+		{"i2p.streaming.maxConnsPerHour", &f.MaxConnsPerHour},
+		{"i2p.streaming.maxConnsPerDay", &f.MaxConnsPerDay},
+		{"i2p.streaming.maxConcurrentStreams", &f.MaxConcurrentStreams},
+		{"i2p.streaming.answerPings", &f.AnswerPings},
+		{"i2p.streaming.profile", &f.StreamProfile},
+		{"i2p.streaming.inactivityTimeout", &f.InactivityTimeout},
+		{"i2p.streaming.inactivityAction", &f.InactivityAction},
+		{"i2p.streaming.enforceProtocol", &f.EnforceProtocol},
+	}
+}
+
+func (f *I2PConfig) streamingOptions() (o sessionOptions) {
+	for _, c := range f.streamingFields() {
+		o.add(c.key, *c.ptr)
+	}
+	return
+}
+
+// Print returns the session options as key=value strings.
 func (f *I2PConfig) Print() []string {
-	lsk, lspk, lspsk := f.Leasesetsettings()
-	return []string{
-		//f.targetForPort443(),
-		"inbound.length=" + f.InLength,
-		"outbound.length=" + f.OutLength,
-		"inbound.lengthVariance=" + f.InVariance,
-		"outbound.lengthVariance=" + f.OutVariance,
-		"inbound.backupQuantity=" + f.InBackupQuantity,
-		"outbound.backupQuantity=" + f.OutBackupQuantity,
-		"inbound.quantity=" + f.InQuantity,
-		"outbound.quantity=" + f.OutQuantity,
-		f.DoZero(),
-		//"i2cp.fastRecieve=" + f.FastRecieve,
-		"i2cp.gzip=" + f.UseCompression,
-		f.Reduce(),
-		f.Close(),
-		f.Reliability(),
-		f.EncryptLease(),
-		lsk, lspk, lspsk,
-		f.Accesslisttype(),
-		f.Accesslist(),
-		f.LeaseSetEncryptionType(),
+	opts := f.SessionOptions()
+	r := make([]string, len(opts))
+	for i, o := range opts {
+		r[i] = o.String()
 	}
+	return r
+}
+
+func (f *I2PConfig) accessListOptions() (o sessionOptions) {
+	switch f.AccessListType {
+	case "whitelist":
+		o.add("i2cp.enableAccessList", "true")
+	case "blacklist":
+		o.add("i2cp.enableBlackList", "true")
+	default:
+		return
+	}
+	o.add("i2cp.accessList", strings.Join(f.AccessList, ","))
+	return
 }
 
 func (f *I2PConfig) Accesslisttype() string {
 	if f.AccessListType == "whitelist" {
-		log.Debug("Access list type set to whitelist")
+		defaultLog().Debug("Access list type set to whitelist")
 		return "i2cp.enableAccessList=true"
 	} else if f.AccessListType == "blacklist" {
-		log.Debug("Access list type set to blacklist")
+		defaultLog().Debug("Access list type set to blacklist")
 		return "i2cp.enableBlackList=true"
 	} else if f.AccessListType == "none" {
-		log.Debug("Access list type set to none")
+		defaultLog().Debug("Access list type set to none")
 		return ""
 	}
-	log.Debug("Access list type not set")
+	defaultLog().Debug("Access list type not set")
 	return ""
 }
 
 func (f *I2PConfig) Accesslist() string {
 	if f.AccessListType != "" && len(f.AccessList) > 0 {
-		r := ""
-		for _, s := range f.AccessList {
-			r += s + ","
-		}
-		log.WithField("accessList", r).Debug("Access list generated")
-		return "i2cp.accessList=" + strings.TrimSuffix(r, ",")
+		r := strings.Join(f.AccessList, ",")
+		defaultLog().Debug("Access list generated", "accessList", r)
+		return "i2cp.accessList=" + r
 	}
-	log.Debug("Access list not set")
+	defaultLog().Debug("Access list not set")
 	return ""
 }
 
-func (f *I2PConfig) LeaseSetEncryptionType() string {
+// leaseSetEncType returns the encryption types of the leaseset, or "" if
+// LeaseSetEncryption is not a list of numbers, in which case the option is
+// left to the router.
+func (f *I2PConfig) leaseSetEncType() string {
 	if f.LeaseSetEncryption == "" {
-		log.Debug("Using default lease set encryption type: 4,0")
-		return "i2cp.leaseSetEncType=4,0"
+		defaultLog().Debug("Using default lease set encryption type: 4,0")
+		return "4,0"
 	}
 	for _, s := range strings.Split(f.LeaseSetEncryption, ",") {
 		if _, err := strconv.Atoi(s); err != nil {
-			log.WithField("invalidType", s).Panic("Invalid encrypted leaseSet type")
-			//panic("Invalid encrypted leaseSet type: " + s)
+			defaultLog().Warn("Invalid encrypted leaseSet type, leaving i2cp.leaseSetEncType out", "invalidType", s)
+			return ""
 		}
 	}
-	log.WithField("leaseSetEncType", f.LeaseSetEncryption).Debug("Lease set encryption type set")
-	return "i2cp.leaseSetEncType=" + f.LeaseSetEncryption
+	defaultLog().Debug("Lease set encryption type set", "leaseSetEncType", f.LeaseSetEncryption)
+	return f.LeaseSetEncryption
+}
+
+// LeaseSetEncryptionType returns the i2cp.leaseSetEncType option, or "" if
+// LeaseSetEncryption is invalid.
+func (f *I2PConfig) LeaseSetEncryptionType() string {
+	t := f.leaseSetEncType()
+	if t == "" {
+		return ""
+	}
+	return "i2cp.leaseSetEncType=" + t
 }
 
 func NewConfig(opts ...func(*I2PConfig) error) (*I2PConfig, error) {
@@ -0,0 +1,197 @@
+package sam3
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func Test_PrintGolden(t *testing.T) {
+	const enc = "i2cp.leaseSetEncType=4,0"
+	zero := make([]byte, 32)
+	for _, c := range []struct {
+		name string
+		opts []func(*SAMEmit) error
+		want string
+	}{
+		{"empty", nil, enc},
+		{"SetInLength", []func(*SAMEmit) error{SetInLength(2)}, "inbound.length=2 " + enc},
+		{"SetOutLength", []func(*SAMEmit) error{SetOutLength(4)}, "outbound.length=4 " + enc},
+		{"SetInVariance", []func(*SAMEmit) error{SetInVariance(-1)}, "inbound.lengthVariance=-1 " + enc},
+		{"SetOutVariance", []func(*SAMEmit) error{SetOutVariance(2)}, "outbound.lengthVariance=2 " + enc},
+		{"SetInQuantity", []func(*SAMEmit) error{SetInQuantity(3)}, "inbound.quantity=3 " + enc},
+		{"SetOutQuantity", []func(*SAMEmit) error{SetOutQuantity(5)}, "outbound.quantity=5 " + enc},
+		{"SetInBackups", []func(*SAMEmit) error{SetInBackups(1)}, "inbound.backupQuantity=1 " + enc},
+		{"SetOutBackups", []func(*SAMEmit) error{SetOutBackups(2)}, "outbound.backupQuantity=2 " + enc},
+		{"SetEncrypt", []func(*SAMEmit) error{SetEncrypt(true)}, "i2cp.encryptLeaseSet=true " + enc},
+		{"SetEncrypt off", []func(*SAMEmit) error{SetEncrypt(false)}, enc},
+		{"SetLeaseSetKey", []func(*SAMEmit) error{SetLeaseSetKey("lsk")}, "i2cp.leaseSetKey=lsk " + enc},
+		{"SetLeaseSetPrivateKey", []func(*SAMEmit) error{SetLeaseSetPrivateKey("lspk")}, "i2cp.leaseSetPrivateKey=lspk " + enc},
+		{"SetLeaseSetPrivateSigningKey", []func(*SAMEmit) error{SetLeaseSetPrivateSigningKey("lspsk")}, "i2cp.leaseSetPrivateSigningKey=lspsk " + enc},
+		{"SetLeaseSetSecret", []func(*SAMEmit) error{SetLeaseSetSecret("s3cret")}, "i2cp.leaseSetType=5 i2cp.leaseSetSecret=s3cret " + enc},
+		{"SetLeaseSetAuthType", []func(*SAMEmit) error{SetLeaseSetAuthType(LeaseSetAuthPSK), SetLeaseSetClients(LeaseSetClient{"a", zero})},
+			"i2cp.leaseSetType=5 i2cp.leaseSetAuthType=2 i2cp.leaseSetClient.psk.0=a:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA= " + enc},
+		{"SetMessageReliability", []func(*SAMEmit) error{SetMessageReliability("BestEffort")}, "i2cp.messageReliability=BestEffort " + enc},
+		{"SetAllowZeroIn", []func(*SAMEmit) error{SetAllowZeroIn(true)}, "inbound.allowZeroHop=true " + enc},
+		{"SetAllowZeroOut", []func(*SAMEmit) error{SetAllowZeroOut(true)}, "outbound.allowZeroHop=true " + enc},
+		{"SetAllowZero off", []func(*SAMEmit) error{SetAllowZeroIn(false), SetAllowZeroOut(false)}, enc},
+		{"SetCompress", []func(*SAMEmit) error{SetCompress(true)}, "i2cp.gzip=true " + enc},
+		{"SetCompress off", []func(*SAMEmit) error{SetCompress(false)}, "i2cp.gzip=false " + enc},
+		{"SetFastRecieve", []func(*SAMEmit) error{SetFastRecieve(true)}, "i2cp.fastReceive=true " + enc},
+		{"SetReduceIdle", []func(*SAMEmit) error{SetReduceIdle(true)}, "i2cp.reduceOnIdle=true " + enc},
+		{"SetReduceIdleTime", []func(*SAMEmit) error{SetReduceIdle(true), SetReduceIdleTime(10), SetReduceIdleQuantity(2)},
+			"i2cp.reduceOnIdle=true i2cp.reduceIdleTime=600000 i2cp.reduceQuantity=2 " + enc},
+		{"SetReduceIdleTimeMs", []func(*SAMEmit) error{SetReduceIdle(true), SetReduceIdleTimeMs(400000)},
+			"i2cp.reduceOnIdle=true i2cp.reduceIdleTime=400000 " + enc},
+		{"SetReduceIdle off", []func(*SAMEmit) error{SetReduceIdle(false), SetReduceIdleQuantity(2)}, enc},
+		{"SetCloseIdle", []func(*SAMEmit) error{SetCloseIdle(true)}, "i2cp.closeOnIdle=true " + enc},
+		{"SetCloseIdleTime", []func(*SAMEmit) error{SetCloseIdle(true), SetCloseIdleTime(10)},
+			"i2cp.closeOnIdle=true i2cp.closeIdleTime=600000 " + enc},
+		{"SetCloseIdleTimeMs", []func(*SAMEmit) error{SetCloseIdle(true), SetCloseIdleTimeMs(500000)},
+			"i2cp.closeOnIdle=true i2cp.closeIdleTime=500000 " + enc},
+		{"SetAccessList whitelist", []func(*SAMEmit) error{SetAccessListType("whitelist"), SetAccessList([]string{"a", "b"})},
+			"i2cp.enableAccessList=true i2cp.accessList=a,b " + enc},
+		{"SetAccessList blacklist", []func(*SAMEmit) error{SetAccessListType("blacklist"), SetAccessList([]string{"a"})},
+			"i2cp.enableBlackList=true i2cp.accessList=a " + enc},
+		{"SetAccessList none", []func(*SAMEmit) error{SetAccessListType("none"), SetAccessList([]string{"a"})}, enc},
+		{"SetStreamConnectDelay", []func(*SAMEmit) error{SetStreamConnectDelay(1000)}, enc + " i2p.streaming.connectDelay=1000"},
+		{"SetMaxWindowSize", []func(*SAMEmit) error{SetMaxWindowSize(64)}, enc + " i2p.streaming.maxWindowSize=64"},
+		{"SetInitialWindowSize", []func(*SAMEmit) error{SetInitialWindowSize(12)}, enc + " i2p.streaming.initialWindowSize=12"},
+		{"SetMaxConns", []func(*SAMEmit) error{SetMaxConnsPerMinute(5), SetMaxConnsPerHour(50), SetMaxConnsPerDay(0)},
+			enc + " i2p.streaming.maxConnsPerMinute=5 i2p.streaming.maxConnsPerHour=50 i2p.streaming.maxConnsPerDay=0"},
+		{"SetMaxConcurrentStreams", []func(*SAMEmit) error{SetMaxConcurrentStreams(-1)}, enc + " i2p.streaming.maxConcurrentStreams=-1"},
+		{"SetAnswerPings", []func(*SAMEmit) error{SetAnswerPings(false)}, enc + " i2p.streaming.answerPings=false"},
+		{"SetStreamProfile", []func(*SAMEmit) error{SetStreamProfile(StreamProfileInteractive)}, enc + " i2p.streaming.profile=2"},
+		{"SetInactivity", []func(*SAMEmit) error{SetInactivityTimeout(60000), SetInactivityAction(InactivityActionDisconnect)},
+			enc + " i2p.streaming.inactivityTimeout=60000 i2p.streaming.inactivityAction=1"},
+		{"SetEnforceProtocol", []func(*SAMEmit) error{SetEnforceProtocol(true)}, enc + " i2p.streaming.enforceProtocol=true"},
+	} {
+		e, err := NewEmit(c.opts...)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := e.OptStr(); got != c.want {
+			t.Errorf("%s:\n got %q\nwant %q", c.name, got, c.want)
+		}
+	}
+}
+
+func Test_StreamingOptionErrors(t *testing.T) {
+	for name, opt := range map[string]func(*SAMEmit) error{
+		"connectDelay":         SetStreamConnectDelay(-2),
+		"maxWindowSize":        SetMaxWindowSize(129),
+		"initialWindowSize":    SetInitialWindowSize(0),
+		"maxConnsPerMinute":    SetMaxConnsPerMinute(-1),
+		"maxConcurrentStreams": SetMaxConcurrentStreams(-2),
+		"profile":              SetStreamProfile(3),
+		"inactivityTimeout":    SetInactivityTimeout(-1),
+		"inactivityAction":     SetInactivityAction(3),
+	} {
+		if _, err := NewEmit(opt); err == nil {
+			t.Errorf("%s: invalid value accepted", name)
+		}
+	}
+	e, err := NewEmit(SetMaxWindowSize(64), SetStreamProfile(StreamProfileBulk), SetAnswerPings(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateOptions(e.Print()); err != nil {
+		t.Errorf("streaming options do not validate: %v", err)
+	}
+}
+
+func Test_NewConfigGolden(t *testing.T) {
+	conf, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "inbound.length=3 outbound.length=3 inbound.lengthVariance=1 outbound.lengthVariance=1 " +
+		"inbound.backupQuantity=3 outbound.backupQuantity=3 inbound.quantity=2 outbound.quantity=2 " +
+		"i2cp.gzip=true i2cp.messageReliability=none i2cp.leaseSetEncType=4,0"
+	if got := strings.Join(conf.Print(), " "); got != want {
+		t.Errorf("\n got %q\nwant %q", got, want)
+	}
+}
+
+func Test_InvalidLeaseSetEncType(t *testing.T) {
+	conf, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.ReadTunnelConfig(strings.NewReader("option.i2cp.leaseSetEncType=4,x\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(conf.Print(), " "); strings.Contains(got, "leaseSetEncType") {
+		t.Errorf("invalid i2cp.leaseSetEncType printed: %q", got)
+	}
+	if got := conf.LeaseSetEncryptionType(); got != "" {
+		t.Errorf("LeaseSetEncryptionType() = %q", got)
+	}
+}
+
+func Test_EmitSessionSettings(t *testing.T) {
+	e, err := NewEmit(SetType("DATAGRAM"), SetSAMAddress("10.0.0.1:7777"), SetName("tun"), SetSigType(Sig_EdDSA_SHA512_Ed25519))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.SessionStyle(); got != " STYLE=DATAGRAM " {
+		t.Errorf("SessionStyle() = %q", got)
+	}
+	if got := e.Sam(); got != "10.0.0.1:7777" {
+		t.Errorf("Sam() = %q", got)
+	}
+	if got := e.ID(); got != " ID=tun " {
+		t.Errorf("ID() = %q", got)
+	}
+	if got := e.SignatureType(); got != " SIGNATURE_TYPE=7 " {
+		t.Errorf("SignatureType() = %q", got)
+	}
+	if got := e.DestinationKey(); got != " DESTINATION=TRANSIENT " {
+		t.Errorf("DestinationKey() = %q", got)
+	}
+	e, _ = NewEmit(SetSAMHost("sam.example"), SetSAMPort("7000"))
+	if got := e.Sam(); got != "sam.example:7000" {
+		t.Errorf("Sam() = %q", got)
+	}
+	for name, opt := range map[string]func(*SAMEmit) error{
+		"SetType":         SetType("BOGUS"),
+		"SetSAMAddress":   SetSAMAddress("a:b:c"),
+		"SetSAMPort":      SetSAMPort("99999"),
+		"SetSigType":      SetSigType(SigType(42)),
+		"SetInLength":     SetInLength(7),
+		"SetOutQuantity":  SetOutQuantity(0),
+		"SetInVariance":   SetInVariance(7),
+		"SetOutBackups":   SetOutBackups(6),
+		"SetReduceIdle":   SetReduceIdleTime(1),
+		"SetCloseIdle":    SetCloseIdleTimeMs(1),
+		"SetAccessList":   SetAccessListType("greylist"),
+		"SetLeaseSetAuth": SetLeaseSetAuthType(LeaseSetAuthType(3)),
+	} {
+		if _, err := NewEmit(opt); err == nil {
+			t.Errorf("%s accepted an invalid value", name)
+		}
+	}
+}
+
+func Test_SetSAMAddress(t *testing.T) {
+	var conf I2PConfig
+	conf.SetSAMAddress("192.168.1.2:7000")
+	if got := conf.Sam(); got != "192.168.1.2:7000" {
+		t.Errorf("Sam() = %q", got)
+	}
+	b := samtest.New(t)
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("addrTun", samtest.Keys(t), []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.samAddr != b.Addr() {
+		t.Errorf("session uses bridge %s, want %s", ss.samAddr, b.Addr())
+	}
+}
@@ -0,0 +1,328 @@
+package sam3
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// configField ties a string field of I2PConfig to its key in config files.
+type configField struct {
+	key string
+	ptr *string
+}
+
+// topFields are the settings which are not session options.
+func (f *I2PConfig) topFields() []configField {
+	return []configField{
+		{"name", &f.TunName},
+		{"type", &f.TunType},
+		{"style", &f.Style},
+		{"samHost", &f.SamHost},
+		{"samPort", &f.SamPort},
+		{"samMin", &f.SamMin},
+		{"samMax", &f.SamMax},
+		{"fromPort", &f.Fromport},
+		{"toPort", &f.Toport},
+	}
+}
+
+// optionFields are the session options stored in string fields.
+func (f *I2PConfig) optionFields() []configField {
+	fields := []configField{
+		{"inbound.length", &f.InLength},
+		{"outbound.length", &f.OutLength},
+		{"inbound.lengthVariance", &f.InVariance},
+		{"outbound.lengthVariance", &f.OutVariance},
+		{"inbound.quantity", &f.InQuantity},
+		{"outbound.quantity", &f.OutQuantity},
+		{"inbound.backupQuantity", &f.InBackupQuantity},
+		{"outbound.backupQuantity", &f.OutBackupQuantity},
+		{"inbound.allowZeroHop", &f.InAllowZeroHop},
+		{"outbound.allowZeroHop", &f.OutAllowZeroHop},
+		{"i2cp.fastReceive", &f.FastRecieve},
+		{"i2cp.gzip", &f.UseCompression},
+		{"i2cp.messageReliability", &f.MessageReliability},
+		{"i2cp.closeOnIdle", &f.CloseIdle},
+		{"i2cp.closeIdleTime", &f.CloseIdleTime},
+		{"i2cp.reduceOnIdle", &f.ReduceIdle},
+		{"i2cp.reduceIdleTime", &f.ReduceIdleTime},
+		{"i2cp.reduceQuantity", &f.ReduceIdleQuantity},
+		{"i2cp.encryptLeaseSet", &f.EncryptLeaseSet},
+		{"i2cp.leaseSetKey", &f.LeaseSetKey},
+		{"i2cp.leaseSetPrivateKey", &f.LeaseSetPrivateKey},
+		{"i2cp.leaseSetPrivateSigningKey", &f.LeaseSetPrivateSigningKey},
+		{"i2cp.leaseSetEncType", &f.LeaseSetEncryption},
+		{"i2cp.leaseSetSecret", &f.LeaseSetSecret},
+	}
+	return append(fields, f.streamingFields()...)
+}
+
+// ReadTunnelConfig reads settings in the i2ptunnel config format on top of
+// the configuration. Both the i2ptunnel.config form, with keys such as
+// tunnel.0.option.inbound.length, and the form of files in
+// i2ptunnel.config.d, without the tunnel.N. prefix, are understood, as are
+// ini files with a [name] section and bare option keys. Only the first
+// tunnel of a file is read. Session options without a field of their own are
+// kept in ExtraOptions, other settings such as targetHost in Tunnel.
+func (f *I2PConfig) ReadTunnelConfig(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	var prefix string
+	sections, n := 0, 0
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			if sections++; sections > 1 {
+				defaultLog().Debug("Ignoring further tunnels in config")
+				break
+			}
+			f.TunName = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := splitPair(line)
+		if !ok {
+			return fmt.Errorf("config line %d: expected key=value", n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if strings.HasPrefix(key, "tunnel.") {
+			i := strings.IndexByte(key[len("tunnel."):], '.')
+			if i < 0 {
+				return fmt.Errorf("config line %d: malformed key %s", n, key)
+			}
+			p := key[:len("tunnel.")+i+1]
+			if prefix == "" {
+				prefix = p
+			} else if p != prefix {
+				continue
+			}
+			key = key[len(p):]
+		}
+		if err := f.setConfigKey(key, value); err != nil {
+			return fmt.Errorf("config line %d: %w", n, err)
+		}
+	}
+	return s.Err()
+}
+
+func (f *I2PConfig) setConfigKey(key, value string) error {
+	if strings.HasPrefix(key, "option.") {
+		return f.setOption(key[len("option."):], value)
+	}
+	for _, c := range f.topFields() {
+		if strings.EqualFold(c.key, key) {
+			*c.ptr = value
+			return nil
+		}
+	}
+	if strings.EqualFold(key, "signatureType") {
+		return f.setOption("i2cp.destination.sigType", value)
+	}
+	if strings.HasPrefix(key, "inbound.") || strings.HasPrefix(key, "outbound.") ||
+		strings.HasPrefix(key, "i2cp.") || strings.HasPrefix(key, "i2p.") {
+		return f.setOption(key, value)
+	}
+	if f.Tunnel == nil {
+		f.Tunnel = make(map[string]string)
+	}
+	f.Tunnel[key] = value
+	return nil
+}
+
+// setOption sets the session option key, in a field if it has one.
+func (f *I2PConfig) setOption(key, value string) error {
+	for _, c := range f.optionFields() {
+		if c.key == key {
+			*c.ptr = value
+			return nil
+		}
+	}
+	switch {
+	case key == "i2cp.destination.sigType":
+		st, err := ParseSigType(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		f.SigType = &st
+	case key == "i2cp.leaseSetAuthType":
+		t, err := strconv.Atoi(value)
+		if err != nil || t < int(LeaseSetAuthNone) || t > int(LeaseSetAuthPSK) {
+			return fmt.Errorf("%s: invalid auth type %q", key, value)
+		}
+		f.LeaseSetAuthType = LeaseSetAuthType(t)
+	case key == "i2cp.enableAccessList":
+		if value == "true" {
+			f.AccessListType = "whitelist"
+		}
+	case key == "i2cp.enableBlackList":
+		if value == "true" {
+			f.AccessListType = "blacklist"
+		}
+	case key == "i2cp.accessList":
+		f.AccessList = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	case strings.HasPrefix(key, "i2cp.leaseSetClient.dh.") || strings.HasPrefix(key, "i2cp.leaseSetClient.psk."):
+		c, err := parseLeaseSetClient(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if f.LeaseSetAuthType == LeaseSetAuthNone {
+			f.LeaseSetAuthType = LeaseSetAuthPSK
+			if strings.Contains(key, ".dh.") {
+				f.LeaseSetAuthType = LeaseSetAuthDH
+			}
+		}
+		return f.AddLeaseSetClient(c)
+	default:
+		if f.ExtraOptions == nil {
+			f.ExtraOptions = make(map[string]string)
+		}
+		f.ExtraOptions[key] = value
+	}
+	return nil
+}
+
+// WriteTunnelConfig writes the configuration in the format of the files in
+// i2ptunnel.config.d, which ReadTunnelConfig reads back.
+func (f *I2PConfig) WriteTunnelConfig(w io.Writer) error {
+	var b bytes.Buffer
+	for _, c := range f.topFields() {
+		if *c.ptr != "" {
+			fmt.Fprintf(&b, "%s=%s\n", c.key, *c.ptr)
+		}
+	}
+	keys := make([]string, 0, len(f.Tunnel))
+	for k := range f.Tunnel {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, f.Tunnel[k])
+	}
+	if f.SigType != nil {
+		fmt.Fprintf(&b, "option.i2cp.destination.sigType=%s\n", *f.SigType)
+	}
+	for _, o := range f.SessionOptions() {
+		fmt.Fprintf(&b, "option.%s=%s\n", o.Key, o.Value)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// LoadFile reads the configuration from path on top of the current one.
+// Files ending in .json are read as JSON, anything else in the i2ptunnel
+// format.
+func (f *I2PConfig) LoadFile(path string) error {
+	defaultLog().Debug("Loading config file", "path", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, f)
+	} else {
+		err = f.ReadTunnelConfig(bytes.NewReader(data))
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing config %s: %w", path, err)
+	}
+	return nil
+}
+
+// SaveFile writes the configuration to path, as JSON if it ends in .json and
+// in the i2ptunnel format otherwise. The file may contain leaseset keys, so
+// it is only readable by its owner.
+func (f *I2PConfig) SaveFile(path string) error {
+	var b bytes.Buffer
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return err
+		}
+		b.Write(append(data, '\n'))
+	} else if err := f.WriteTunnelConfig(&b); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0600); err != nil {
+		return fmt.Errorf("error writing config: %w", err)
+	}
+	defaultLog().Debug("Saved config file", "path", path)
+	return nil
+}
+
+// EnvName returns the environment variable ApplyEnv reads for a config key:
+// SAM3_ followed by the key without any i2cp. prefix, with dots and camel
+// case turned into underscores, e.g. SAM3_INBOUND_LENGTH for inbound.length,
+// SAM3_REDUCE_ON_IDLE for i2cp.reduceOnIdle and SAM3_SAM_HOST for samHost.
+func EnvName(key string) string {
+	var b strings.Builder
+	b.WriteString("SAM3_")
+	for _, r := range strings.TrimPrefix(key, "i2cp.") {
+		switch {
+		case r == '.':
+			b.WriteByte('_')
+		case unicode.IsUpper(r):
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// ApplyEnv overrides the configuration with environment variables. Every
+// setting understood by ReadTunnelConfig has one, named by EnvName:
+//
+//	SAM3_NAME, SAM3_TYPE, SAM3_STYLE, SAM3_SAM_HOST, SAM3_SAM_PORT,
+//	SAM3_SAM_MIN, SAM3_SAM_MAX, SAM3_FROM_PORT, SAM3_TO_PORT,
+//	SAM3_INBOUND_LENGTH, SAM3_OUTBOUND_LENGTH, SAM3_INBOUND_QUANTITY, ...,
+//	SAM3_GZIP, SAM3_REDUCE_ON_IDLE, SAM3_CLOSE_IDLE_TIME, ...,
+//	SAM3_SIGNATURE_TYPE, SAM3_LEASE_SET_AUTH_TYPE, SAM3_ACCESS_LIST_TYPE,
+//	SAM3_ACCESS_LIST
+//
+// Variables which are unset leave the configuration alone.
+func (f *I2PConfig) ApplyEnv() error {
+	for _, c := range append(f.topFields(), f.optionFields()...) {
+		if v, ok := os.LookupEnv(EnvName(c.key)); ok {
+			defaultLog().Debug("Config set from environment", "variable", EnvName(c.key), "key", c.key)
+			*c.ptr = v
+		}
+	}
+	for env, key := range map[string]string{
+		"SAM3_SIGNATURE_TYPE":      "i2cp.destination.sigType",
+		"SAM3_LEASE_SET_AUTH_TYPE": "i2cp.leaseSetAuthType",
+		"SAM3_ACCESS_LIST":         "i2cp.accessList",
+	} {
+		if v, ok := os.LookupEnv(env); ok {
+			if err := f.setOption(key, v); err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	if v, ok := os.LookupEnv("SAM3_ACCESS_LIST_TYPE"); ok {
+		f.AccessListType = v
+	}
+	return nil
+}
+
+// SetConfigFile is a NewConfig option which loads the file at path, see
+// LoadFile, and then applies the environment overlay, see ApplyEnv.
+func SetConfigFile(path string) func(*I2PConfig) error {
+	return func(f *I2PConfig) error {
+		if err := f.LoadFile(path); err != nil {
+			return err
+		}
+		return f.ApplyEnv()
+	}
+}
@@ -0,0 +1,186 @@
+package sam3
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTunnelConfig = `# i2ptunnel.config
+tunnel.0.name=eepsite
+tunnel.0.type=httpserver
+tunnel.0.targetHost=127.0.0.1
+tunnel.0.targetPort=8080
+tunnel.0.option.inbound.length=2
+tunnel.0.option.outbound.quantity=4
+tunnel.0.option.i2cp.reduceOnIdle=true
+tunnel.0.option.i2cp.destination.sigType=EdDSA_SHA512_Ed25519
+tunnel.0.option.i2cp.enableBlackList=true
+tunnel.0.option.i2cp.accessList=aaaa,bbbb
+tunnel.0.option.outbound.nickname=eepsite
+tunnel.1.name=other
+tunnel.1.option.inbound.length=0
+`
+
+func Test_ReadTunnelConfig(t *testing.T) {
+	conf, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.ReadTunnelConfig(strings.NewReader(testTunnelConfig)); err != nil {
+		t.Fatal(err)
+	}
+	if conf.TunName != "eepsite" || conf.TunType != "httpserver" || conf.InLength != "2" || conf.OutQuantity != "4" ||
+		conf.ReduceIdle != "true" || conf.SignatureType() != " SIGNATURE_TYPE=7 " || conf.AccessListType != "blacklist" ||
+		!reflect.DeepEqual(conf.AccessList, []string{"aaaa", "bbbb"}) {
+		t.Errorf("unexpected config %+v", conf)
+	}
+	if conf.Tunnel["targetPort"] != "8080" || conf.ExtraOptions["outbound.nickname"] != "eepsite" {
+		t.Errorf("Tunnel = %v, ExtraOptions = %v", conf.Tunnel, conf.ExtraOptions)
+	}
+	if conf.OutLength != "3" {
+		t.Error("defaults were not kept")
+	}
+
+	// ini files name the tunnel in a section and may leave out option.
+	var ini I2PConfig
+	if err := ini.ReadTunnelConfig(strings.NewReader("[site]\ntype=server\ninbound.length=1\n[next]\ninbound.length=3\n")); err != nil {
+		t.Fatal(err)
+	}
+	if ini.TunName != "site" || ini.TunType != "server" || ini.InLength != "1" {
+		t.Errorf("ini config %+v", ini)
+	}
+	if err := ini.ReadTunnelConfig(strings.NewReader("option.i2cp.destination.sigType=bogus\n")); err == nil {
+		t.Error("accepted an invalid signature type")
+	}
+}
+
+// testLeaseSetClientConfig is an encrypted server tunnel in the form the
+// i2ptunnel edit page writes it: the client name is plain text, only the
+// key is base64.
+const testLeaseSetClientConfig = `tunnel.2.name=private
+tunnel.2.type=httpserver
+tunnel.2.option.i2cp.leaseSetType=5
+tunnel.2.option.i2cp.leaseSetAuthType=1
+tunnel.2.option.i2cp.leaseSetClient.dh.0=client0:ZBhZAJnYu3w6a0K5WYk80-w1Egt1xshH35kNBugmX2k=
+`
+
+func Test_ReadTunnelConfigLeaseSetClient(t *testing.T) {
+	var conf I2PConfig
+	if err := conf.ReadTunnelConfig(strings.NewReader(testLeaseSetClientConfig)); err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.LeaseSetClients) != 1 || conf.LeaseSetClients[0].Name != "client0" || conf.LeaseSetAuthType != LeaseSetAuthDH {
+		t.Fatalf("clients %+v, auth type %d", conf.LeaseSetClients, conf.LeaseSetAuthType)
+	}
+	want := "i2cp.leaseSetType=5 i2cp.leaseSetAuthType=1 i2cp.leaseSetClient.dh.0=client0:ZBhZAJnYu3w6a0K5WYk80-w1Egt1xshH35kNBugmX2k="
+	if got := conf.LeaseSetAuth(); got != want {
+		t.Errorf("LeaseSetAuth() = %q, want %q", got, want)
+	}
+	if err := checkLeaseSetClient("client0:ZBhZAJnYu3w6a0K5WYk80-w1Egt1xshH35kNBugmX2k="); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_ConfigFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	orig, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orig.ReadTunnelConfig(strings.NewReader(testTunnelConfig)); err != nil {
+		t.Fatal(err)
+	}
+	client, _, _ := GenerateLeaseSetClient("alice", LeaseSetAuthDH)
+	orig.LeaseSetAuthType = LeaseSetAuthDH
+	orig.AddLeaseSetClient(client)
+
+	for _, name := range []string{"tunnel.config", "tunnel.json"} {
+		path := filepath.Join(dir, name)
+		if err := orig.SaveFile(path); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := NewConfig(SetConfigFile(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Join(loaded.Print(), " "), strings.Join(orig.Print(), " "); got != want {
+			t.Errorf("%s:\n got %s\nwant %s", name, got, want)
+		}
+		if loaded.SignatureType() != orig.SignatureType() || loaded.TunName != orig.TunName || loaded.Tunnel["targetHost"] != "127.0.0.1" {
+			t.Errorf("%s: settings lost: %+v", name, loaded)
+		}
+	}
+	var b bytes.Buffer
+	orig.WriteTunnelConfig(&b)
+	if !strings.Contains(b.String(), "option.i2cp.destination.sigType=EdDSA_SHA512_Ed25519\n") {
+		t.Errorf("WriteTunnelConfig:\n%s", b.String())
+	}
+}
+
+func Test_ConfigFileDSASigType(t *testing.T) {
+	dir := t.TempDir()
+	orig, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orig.ReadTunnelConfig(strings.NewReader("option.i2cp.destination.sigType=DSA_SHA1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if orig.SignatureType() != " SIGNATURE_TYPE=0 " {
+		t.Fatalf("DSA_SHA1 not set: %q", orig.SignatureType())
+	}
+	for _, name := range []string{"tunnel.config", "tunnel.json"} {
+		path := filepath.Join(dir, name)
+		if err := orig.SaveFile(path); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := NewConfig(SetConfigFile(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.SigType == nil || *loaded.SigType != Sig_DSA_SHA1 {
+			t.Errorf("%s: DSA_SHA1 lost", name)
+		}
+	}
+	unset, _ := NewConfig()
+	data, err := json.Marshal(unset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "signatureType") {
+		t.Errorf("unset signature type written: %s", data)
+	}
+}
+
+func Test_ConfigEnv(t *testing.T) {
+	for k, v := range map[string]string{
+		"SAM3_INBOUND_LENGTH":   "1",
+		"SAM3_SAM_HOST":         "10.1.1.1",
+		"SAM3_REDUCE_ON_IDLE":   "true",
+		"SAM3_SIGNATURE_TYPE":   "7",
+		"SAM3_ACCESS_LIST_TYPE": "whitelist",
+		"SAM3_ACCESS_LIST":      "x,y",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	if EnvName("inbound.lengthVariance") != "SAM3_INBOUND_LENGTH_VARIANCE" {
+		t.Errorf("EnvName = %s", EnvName("inbound.lengthVariance"))
+	}
+	conf, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.InLength != "1" || conf.SamHost != "10.1.1.1" || conf.ReduceIdle != "true" || conf.SignatureType() != " SIGNATURE_TYPE=7 " ||
+		conf.AccessListType != "whitelist" || len(conf.AccessList) != 2 || conf.OutLength != "3" {
+		t.Errorf("unexpected config %+v", conf)
+	}
+}
@@ -3,7 +3,6 @@ package sam3
 import (
 	"bytes"
 	"errors"
-	"github.com/sirupsen/logrus"
 	"net"
 	"strconv"
 	"time"
@@ -23,104 +22,93 @@ type DatagramSession struct {
 	keys       i2pkeys.I2PKeys  // i2p destination keys
 	rUDPAddr   *net.UDPAddr     // the SAM bridge UDP-port
 	remoteAddr *i2pkeys.I2PAddr // optional remote I2P address
+	resolver   Resolver         // optional, see SetResolver
+	logger     Logger           // nil for the default, see SetLogger
+	metrics    Metrics          // nil for the default, see SetMetrics
 }
 
 // Creates a new datagram session. udpPort is the UDP port SAM is listening on,
 // and if you set it to zero, it will use SAMs standard UDP port.
 func (s *SAM) NewDatagramSession(id string, keys i2pkeys.I2PKeys, options []string, udpPort int) (*DatagramSession, error) {
-	log.WithFields(logrus.Fields{
-		"id":      id,
-		"udpPort": udpPort,
-	}).Debug("Creating new DatagramSession")
+	s.log().Debug("Creating new DatagramSession", "id", id, "udpPort", udpPort)
 
 	if udpPort > 65335 || udpPort < 0 {
-		log.WithField("udpPort", udpPort).Error("Invalid UDP port")
+		s.log().Error("Invalid UDP port", "udpPort", udpPort)
 		return nil, errors.New("udpPort needs to be in the intervall 0-65335")
 	}
 	if udpPort == 0 {
 		udpPort = 7655
-		log.Debug("Using default UDP port 7655")
+		s.log().Debug("Using default UDP port 7655")
 	}
 	lhost, _, err := SplitHostPort(s.conn.LocalAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to split local host port")
+		s.log().Error("Failed to split local host port", "error", err)
 		s.Close()
 		return nil, err
 	}
 	lUDPAddr, err := net.ResolveUDPAddr("udp4", lhost+":0")
 	if err != nil {
-		log.WithError(err).Error("Failed to resolve local UDP address")
+		s.log().Error("Failed to resolve local UDP address", "error", err)
 		return nil, err
 	}
 	udpconn, err := net.ListenUDP("udp4", lUDPAddr)
 	if err != nil {
-		log.WithError(err).Error("Failed to listen on UDP")
+		s.log().Error("Failed to listen on UDP", "error", err)
 		return nil, err
 	}
 	rhost, _, err := SplitHostPort(s.conn.RemoteAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to split remote host port")
+		s.log().Error("Failed to split remote host port", "error", err)
 		s.Close()
 		return nil, err
 	}
 	rUDPAddr, err := net.ResolveUDPAddr("udp4", rhost+":"+strconv.Itoa(udpPort))
 	if err != nil {
-		log.WithError(err).Error("Failed to resolve remote UDP address")
+		s.log().Error("Failed to resolve remote UDP address", "error", err)
 		return nil, err
 	}
 	_, lport, err := net.SplitHostPort(udpconn.LocalAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to get local port")
+		s.log().Error("Failed to get local port", "error", err)
 		s.Close()
 		return nil, err
 	}
 	conn, err := s.newGenericSession("DATAGRAM", id, keys, options, []string{" PORT=" + lport})
 	if err != nil {
-		log.WithError(err).Error("Failed to create generic session")
+		s.log().Error("Failed to create generic session", "error", err)
 		return nil, err
 	}
 
-	log.WithField("id", id).Info("DatagramSession created successfully")
-	return &DatagramSession{s.address, id, conn, udpconn, keys, rUDPAddr, nil}, nil
+	s.log().Info("DatagramSession created successfully", "id", id)
+	return &DatagramSession{s.address, id, conn, udpconn, keys, rUDPAddr, nil, nil, s.logger, s.metrics}, nil
 }
 
 func (s *DatagramSession) B32() string {
-	b32 := s.keys.Addr().Base32()
-	log.WithField("b32", b32).Debug("Generated B32 address")
-	return b32
+	return s.keys.Addr().Base32()
 }
 
 func (s *DatagramSession) Dial(net string, addr string) (*DatagramSession, error) {
-	log.WithFields(logrus.Fields{
-		"net":  net,
-		"addr": addr,
-	}).Debug("Dialing address")
+	s.log().Debug("Dialing address", "net", net, "addr", addr)
 	netaddr, err := s.Lookup(addr)
 	if err != nil {
-		log.WithError(err).Error("Lookup failed")
+		s.log().Error("Lookup failed", "error", err)
 		return nil, err
 	}
 	return s.DialI2PRemote(net, netaddr)
 }
 
 func (s *DatagramSession) DialRemote(net, addr string) (net.PacketConn, error) {
-	log.WithFields(logrus.Fields{
-		"net":  net,
-		"addr": addr,
-	}).Debug("Dialing remote address")
+	s.log().Debug("Dialing remote address", "net", net, "addr", addr)
 	netaddr, err := s.Lookup(addr)
 	if err != nil {
-		log.WithError(err).Error("Lookup failed")
+		s.log().Error("Lookup failed", "error", err)
 		return nil, err
 	}
 	return s.DialI2PRemote(net, netaddr)
 }
 
 func (s *DatagramSession) DialI2PRemote(net string, addr net.Addr) (*DatagramSession, error) {
-	log.WithFields(logrus.Fields{
-		"net":  net,
-		"addr": addr,
-	}).Debug("Dialing I2P remote address")
+	s.log().Debug("Dialing I2P remote address", "net", net, "addr", addr)
 	switch addr.(type) {
 	case *i2pkeys.I2PAddr:
 		s.remoteAddr = addr.(*i2pkeys.I2PAddr)
@@ -132,7 +120,6 @@ func (s *DatagramSession) DialI2PRemote(net string, addr net.Addr) (*DatagramSes
 }
 
 func (s *DatagramSession) RemoteAddr() net.Addr {
-	log.WithField("remoteAddr", s.remoteAddr).Debug("Getting remote address")
 	return s.remoteAddr
 }
 
@@ -140,7 +127,6 @@ func (s *DatagramSession) RemoteAddr() net.Addr {
 // the number of bytes read, from what address it was sent, or an error.
 // implements net.PacketConn
 func (s *DatagramSession) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
-	log.Debug("Reading datagram")
 	// extra bytes to read the remote address of incomming datagram
 	buf := make([]byte, len(b)+4096)
 
@@ -149,42 +135,38 @@ func (s *DatagramSession) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
 		var saddr *net.UDPAddr
 		n, saddr, err = s.udpconn.ReadFromUDP(buf)
 		if err != nil {
-			log.WithError(err).Error("Failed to read from UDP")
 			return 0, i2pkeys.I2PAddr(""), err
 		}
-		if bytes.Equal(saddr.IP, s.rUDPAddr.IP) {
+		if !saddr.IP.Equal(s.rUDPAddr.IP) {
 			continue
 		}
 		break
 	}
-	i := bytes.IndexByte(buf, byte('\n'))
-	if i > 4096 || i > n {
-		log.Error("Could not parse incoming message remote address")
+	i := bytes.IndexByte(buf[:n], byte('\n'))
+	if i < 0 || i > 4096 || i > n {
 		return 0, i2pkeys.I2PAddr(""), errors.New("Could not parse incomming message remote address.")
 	}
-	raddr, err := i2pkeys.NewI2PAddrFromString(string(buf[:i]))
+	// SAM 3.2 and later put FROM_PORT and TO_PORT after the destination
+	raddr, err := i2pkeys.NewI2PAddrFromString(string(bytes.SplitN(buf[:i], []byte(" "), 2)[0]))
 	if err != nil {
-		log.WithError(err).Error("Could not parse incoming message remote address")
 		return 0, i2pkeys.I2PAddr(""), errors.New("Could not parse incomming message remote address: " + err.Error())
 	}
+	s.stats().Packet(s.id, "DATAGRAM", PacketReceived, n-(i+1))
 	// shift out the incomming address to contain only the data received
 	if (n - i + 1) > len(b) {
 		copy(b, buf[i+1:i+1+len(b)])
 		return n - (i + 1), raddr, errors.New("Datagram did not fit into your buffer.")
 	} else {
 		copy(b, buf[i+1:n])
-		log.WithField("bytesRead", n-(i+1)).Debug("Datagram read successfully")
 		return n - (i + 1), raddr, nil
 	}
 }
 
 func (s *DatagramSession) Accept() (net.Conn, error) {
-	log.Debug("Accept called on DatagramSession")
 	return s, nil
 }
 
 func (s *DatagramSession) Read(b []byte) (n int, err error) {
-	log.Debug("Reading from DatagramSession")
 	rint, _, rerr := s.ReadFrom(b)
 	return rint, rerr
 }
@@ -193,46 +175,38 @@ func (s *DatagramSession) Read(b []byte) (n int, err error) {
 // writing, maximum size is 31 kilobyte, but this may change in the future.
 // Implements net.PacketConn.
 func (s *DatagramSession) WriteTo(b []byte, addr net.Addr) (n int, err error) {
-	log.WithFields(logrus.Fields{
-		"addr":        addr,
-		"datagramLen": len(b),
-	}).Debug("Writing datagram")
-	header := []byte("3.1 " + s.id + " " + addr.String() + "\n")
+	header := []byte(samEmit.DatagramHeader("3.1", s.id, addr.String()))
 	msg := append(header, b...)
 	n, err = s.udpconn.WriteToUDP(msg, s.rUDPAddr)
-	if err != nil {
-		log.WithError(err).Error("Failed to write to UDP")
-	} else {
-		log.WithField("bytesWritten", n).Debug("Datagram written successfully")
+	if err == nil {
+		s.stats().Packet(s.id, "DATAGRAM", PacketSent, len(b))
 	}
 	return n, err
 }
 
 func (s *DatagramSession) Write(b []byte) (int, error) {
-	log.WithField("dataLen", len(b)).Debug("Writing to DatagramSession")
 	return s.WriteTo(b, s.remoteAddr)
 }
 
 // Closes the DatagramSession. Implements net.PacketConn
 func (s *DatagramSession) Close() error {
-	log.Debug("Closing DatagramSession")
+	s.log().Debug("Closing DatagramSession")
+	s.stats().SessionClosed(s.id, "DATAGRAM")
 	err := s.conn.Close()
 	err2 := s.udpconn.Close()
 	if err != nil {
-		log.WithError(err).Error("Failed to close connection")
+		s.log().Error("Failed to close connection", "error", err)
 		return err
 	}
 	if err2 != nil {
-		log.WithError(err2).Error("Failed to close UDP connection")
+		s.log().Error("Failed to close UDP connection", "error", err2)
 	}
 	return err2
 }
 
 // Returns the I2P destination of the DatagramSession.
 func (s *DatagramSession) LocalI2PAddr() i2pkeys.I2PAddr {
-	addr := s.keys.Addr()
-	log.WithField("localI2PAddr", addr).Debug("Getting local I2P address")
-	return addr
+	return s.keys.Addr()
 }
 
 // Implements net.PacketConn
@@ -244,15 +218,20 @@ func (s *DatagramSession) Addr() net.Addr {
 	return s.LocalI2PAddr()
 }
 
+// SetResolver sets the resolver used by Lookup and Dial. By default lookups
+// from all sessions on the same SAM bridge share one cached SAMResolver.
+func (s *DatagramSession) SetResolver(r Resolver) {
+	s.resolver = r
+}
+
 func (s *DatagramSession) Lookup(name string) (a net.Addr, err error) {
-	log.WithField("name", name).Debug("Looking up address")
-	var sam *SAM
-	sam, err = NewSAM(s.samAddr)
+	s.log().Debug("Looking up address", "name", name)
+	var r Resolver
+	r, err = sessionResolver(s.resolver, s.samAddr)
 	if err == nil {
-		defer sam.Close()
-		a, err = sam.Lookup(name)
+		a, err = sessionResolve(r, name, s.stats(), s.id, "DATAGRAM")
 	}
-	log.WithField("address", a).Debug("Lookup successful")
+	s.log().Debug("Lookup successful", "address", a)
 	return
 }
 
@@ -260,23 +239,19 @@ func (s *DatagramSession) Lookup(name string) (a net.Addr, err error) {
 // net.PacketConn and does the same thing. Setting write deadlines for datagrams
 // is seldom done.
 func (s *DatagramSession) SetDeadline(t time.Time) error {
-	log.WithField("deadline", t).Debug("Setting deadline")
 	return s.udpconn.SetDeadline(t)
 }
 
 // Sets read deadline for the DatagramSession. Implements net.PacketConn
 func (s *DatagramSession) SetReadDeadline(t time.Time) error {
-	log.WithField("readDeadline", t).Debug("Setting read deadline")
 	return s.udpconn.SetReadDeadline(t)
 }
 
 // Sets the write deadline for the DatagramSession. Implements net.Packetconn.
 func (s *DatagramSession) SetWriteDeadline(t time.Time) error {
-	log.WithField("writeDeadline", t).Debug("Setting write deadline")
 	return s.udpconn.SetWriteDeadline(t)
 }
 
 func (s *DatagramSession) SetWriteBuffer(bytes int) error {
-	log.WithField("bytes", bytes).Debug("Setting write buffer")
 	return s.udpconn.SetWriteBuffer(bytes)
 }
@@ -2,8 +2,11 @@ package sam3
 
 import (
 	"fmt"
+	"net"
 	"testing"
 	"time"
+
+	"github.com/go-i2p/sam3/internal/samtest"
 )
 
 func Test_DatagramServerClient(t *testing.T) {
@@ -180,3 +183,52 @@ func ExampleMiniDatagramSession() {
 	// Output:
 	//Got message: Hello myself!
 }
+
+// udpPeers returns the UDP socket of a session, one of the bridge at the
+// same IP and one of a stranger at another local IP.
+func udpPeers(t *testing.T) (session, bridge, stranger *net.UDPConn) {
+	t.Helper()
+	listen := func(ip string) *net.UDPConn {
+		c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(ip)})
+		if err != nil {
+			t.Skipf("cannot listen on %s: %v", ip, err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	return listen("127.0.0.1"), listen("127.0.0.1"), listen("127.0.0.2")
+}
+
+func Test_DatagramSourceFilter(t *testing.T) {
+	conn, bridge, stranger := udpPeers(t)
+	s := &DatagramSession{udpconn: conn, rUDPAddr: bridge.LocalAddr().(*net.UDPAddr)}
+	from := samtest.Keys(t).Addr()
+	to := conn.LocalAddr()
+	// SAM 3.2 and later add the ports to the header
+	stranger.WriteTo([]byte(samtest.Keys(t).Addr().Base64()+" FROM_PORT=0 TO_PORT=0\nforged"), to)
+	bridge.WriteTo([]byte(from.Base64()+" FROM_PORT=1 TO_PORT=2\nhello"), to)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, addr, err := s.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" || addr.String() != from.String() {
+		t.Errorf("read %q from %s, want the datagram of the bridge", buf[:n], addr)
+	}
+}
+
+func Test_RawSourceFilter(t *testing.T) {
+	conn, bridge, stranger := udpPeers(t)
+	s := &RawSession{udpconn: conn, rUDPAddr: bridge.LocalAddr().(*net.UDPAddr)}
+	stranger.WriteTo([]byte("forged"), conn.LocalAddr())
+	bridge.WriteTo([]byte("hello"), conn.LocalAddr())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, err := s.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("read %q, %v, want the datagram of the bridge", buf[:n], err)
+	}
+}
@@ -0,0 +1,359 @@
+package sam3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+// The names of the checks Diagnose runs, in the order it runs them.
+const (
+	CheckTCP      = "tcp connect"
+	CheckHello    = "hello"
+	CheckDest     = "dest generate"
+	CheckStream   = "stream session"
+	CheckDatagram = "datagram loopback"
+	CheckPrimary  = "primary session"
+	CheckLookup   = "naming lookup"
+)
+
+// ErrSkipped is the error of a check which was not run because a check it
+// depends on failed.
+var ErrSkipped = errors.New("skipped")
+
+// Check is the outcome of one probe of Diagnose.
+type Check struct {
+	Name string
+	OK   bool
+	// Detail is what the check found out, such as the SAM version
+	Detail string
+	// Err says why the check failed, ErrSkipped if it was not run
+	Err      error
+	Duration time.Duration
+}
+
+// DiagnoseReport is the outcome of Diagnose.
+type DiagnoseReport struct {
+	Address string
+	// Version is the highest SAM version the bridge speaks, empty if the
+	// HELLO failed
+	Version string
+	Checks  []Check
+}
+
+// OK reports whether every check passed.
+func (r *DiagnoseReport) OK() bool {
+	for _, c := range r.Checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return len(r.Checks) > 0
+}
+
+// Check returns the check called name, or nil.
+func (r *DiagnoseReport) Check(name string) *Check {
+	for i := range r.Checks {
+		if r.Checks[i].Name == name {
+			return &r.Checks[i]
+		}
+	}
+	return nil
+}
+
+// String formats the report as a table, one check per line.
+func (r *DiagnoseReport) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "SAM bridge at %s\n", r.Address)
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	for _, c := range r.Checks {
+		status, text := "ok", c.Detail
+		switch {
+		case errors.Is(c.Err, ErrSkipped):
+			status, text = "skip", ""
+		case !c.OK:
+			status, text = "FAIL", c.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", status, c.Name, c.Duration.Round(time.Millisecond), text)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// DiagnoseConfig is what Diagnose can be told by its options.
+type DiagnoseConfig struct {
+	// LookupName is looked up by the naming lookup check
+	LookupName string
+	// UDPPort is the bridge's datagram port
+	UDPPort int
+	// LoopbackTimeout is how long the datagram loopback check waits for its
+	// datagram to come back
+	LoopbackTimeout time.Duration
+}
+
+// SetDiagnoseLookupName sets the name the naming lookup check looks up,
+// "i2p-projekt.i2p" by default, which every router's addressbook knows.
+func SetDiagnoseLookupName(name string) func(*DiagnoseConfig) error {
+	return func(c *DiagnoseConfig) error {
+		if name == "" {
+			return errors.New("empty lookup name")
+		}
+		c.LookupName = name
+		return nil
+	}
+}
+
+// SetDiagnoseUDPPort sets the UDP port of the bridge the datagram loopback
+// check sends to, 7655 by default.
+func SetDiagnoseUDPPort(port int) func(*DiagnoseConfig) error {
+	return func(c *DiagnoseConfig) error {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("invalid UDP port %d", port)
+		}
+		c.UDPPort = port
+		return nil
+	}
+}
+
+// SetDiagnoseLoopbackTimeout sets how long the datagram loopback check waits
+// for its datagram, 30 seconds by default.
+func SetDiagnoseLoopbackTimeout(d time.Duration) func(*DiagnoseConfig) error {
+	return func(c *DiagnoseConfig) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid loopback timeout %s", d)
+		}
+		c.LoopbackTimeout = d
+		return nil
+	}
+}
+
+// Diagnose probes the SAM bridge at addr to find out why the library fails
+// to use it: whether it listens at all, which SAM versions it speaks,
+// whether it generates destinations and builds tunnels for sessions, whether
+// datagrams make it through its UDP port, whether it supports PRIMARY
+// sessions and whether it looks up names. Checks which depend on a failed
+// check are skipped. The error is only about the options; the outcome of
+// the checks, failed or not, is in the report.
+//
+// Session checks wait for tunnels to be built, which can take a minute on
+// a router which just started. When ctx is done, the running check fails
+// and the rest are skipped.
+func Diagnose(ctx context.Context, addr string, opts ...func(*DiagnoseConfig) error) (*DiagnoseReport, error) {
+	cfg := DiagnoseConfig{
+		LookupName:      "i2p-projekt.i2p",
+		UDPPort:         7655,
+		LoopbackTimeout: 30 * time.Second,
+	}
+	for _, o := range opts {
+		if err := o(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	d := &diagnosis{ctx: ctx, cfg: cfg, report: &DiagnoseReport{Address: addr}}
+	// every session check has its own ID, so a session the bridge has not
+	// dropped yet does not make the next check fail with DUPLICATED_ID
+	id := "diagnose-" + RandString()
+
+	tcp := d.run(CheckTCP, true, func() (string, error) {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return "", fmt.Errorf("%w; is the SAM bridge enabled?", err)
+		}
+		conn.Close()
+		return "listening", nil
+	})
+	hello := d.run(CheckHello, tcp, d.hello)
+	dest := d.run(CheckDest, hello, func() (string, error) {
+		return d.withSAM(func(sam *SAM) (string, error) {
+			keys, err := sam.NewKeys(Sig_DEFAULT)
+			if err != nil {
+				return "", err
+			}
+			return keys.Addr().Base32(), nil
+		})
+	})
+	d.run(CheckStream, dest, func() (string, error) {
+		return d.withSAM(func(sam *SAM) (string, error) {
+			keys, err := sam.NewKeys(Sig_DEFAULT)
+			if err != nil {
+				return "", err
+			}
+			s, err := sam.NewStreamSession(id+"-stream", keys, nil)
+			if err != nil {
+				return "", fmt.Errorf("%w; the router may not be building tunnels", err)
+			}
+			s.Close()
+			return "tunnels built", nil
+		})
+	})
+	d.run(CheckDatagram, dest, func() (string, error) {
+		return d.withSAM(func(sam *SAM) (string, error) {
+			return d.loopback(sam, id+"-datagram")
+		})
+	})
+	d.run(CheckPrimary, dest, func() (string, error) {
+		return d.withSAM(func(sam *SAM) (string, error) {
+			keys, err := sam.NewKeys(Sig_DEFAULT)
+			if err != nil {
+				return "", err
+			}
+			p, err := sam.NewPrimarySession(id+"-primary", keys, nil)
+			if err != nil {
+				return "", d.needs33("PRIMARY", err)
+			}
+			defer p.Close()
+			if _, err := p.NewStreamSubSession(id + "-primary-sub"); err != nil {
+				return "", d.needs33("SESSION ADD", err)
+			}
+			return "PRIMARY and SESSION ADD supported", nil
+		})
+	})
+	d.run(CheckLookup, hello, func() (string, error) {
+		return d.withSAM(func(sam *SAM) (string, error) {
+			dest, err := sam.Lookup(cfg.LookupName)
+			if err != nil {
+				return "", err
+			}
+			return cfg.LookupName + " is " + dest.Base32(), nil
+		})
+	})
+	defaultLog().Debug("Diagnosed SAM bridge", "address", addr, "ok", d.report.OK())
+	return d.report, nil
+}
+
+type diagnosis struct {
+	ctx    context.Context
+	cfg    DiagnoseConfig
+	report *DiagnoseReport
+}
+
+// run runs probe as the check called name if ready, and records the
+// outcome. It reports whether the check passed.
+func (d *diagnosis) run(name string, ready bool, probe func() (string, error)) bool {
+	c := Check{Name: name}
+	if !ready || d.ctx.Err() != nil {
+		c.Err = ErrSkipped
+		d.report.Checks = append(d.report.Checks, c)
+		return false
+	}
+	start := time.Now()
+	c.Detail, c.Err = probe()
+	c.Duration = time.Since(start)
+	c.OK = c.Err == nil
+	if c.Err != nil && d.ctx.Err() != nil {
+		c.Err = fmt.Errorf("%w: %v", d.ctx.Err(), c.Err)
+	}
+	d.report.Checks = append(d.report.Checks, c)
+	return c.OK
+}
+
+// withSAM runs fn with a new SAM, which is closed when fn returns or the
+// context is done, so that fn does not block past it.
+func (d *diagnosis) withSAM(fn func(*SAM) (string, error)) (string, error) {
+	sam, err := NewSAM(d.report.Address)
+	if err != nil {
+		return "", err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-d.ctx.Done():
+		case <-done:
+		}
+		sam.Close()
+	}()
+	return fn(sam)
+}
+
+// hello asks for any SAM 3 version and records the one agreed on.
+func (d *diagnosis) hello() (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(d.ctx, "tcp", d.report.Address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := d.ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	e, err := NewEmit(SetSAMMin("3.0"), SetSAMMax("3.3"))
+	if err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(e.HelloBytes()); err != nil {
+		return "", err
+	}
+	reply, err := readReplyLine(conn)
+	if err != nil {
+		return "", fmt.Errorf("no HELLO REPLY: %w; is this a SAM bridge?", err)
+	}
+	if !strings.HasPrefix(reply, "HELLO REPLY ") {
+		return "", fmt.Errorf("unexpected reply %q; is this a SAM bridge?", strings.TrimSpace(reply))
+	}
+	switch result := replyValue(reply, "RESULT"); result {
+	case "OK":
+	case "NOVERSION":
+		return "", errors.New("the bridge speaks no SAM version from 3.0 to 3.3")
+	default:
+		return "", fmt.Errorf("HELLO failed: %s %s", result, replyValue(reply, "MESSAGE"))
+	}
+	d.report.Version = replyValue(reply, "VERSION")
+	if d.report.Version == "" {
+		d.report.Version = "3.0"
+	}
+	return "SAM " + d.report.Version, nil
+}
+
+// loopback sends a datagram to a session's own destination and waits for it
+// to come back through the bridge's UDP port.
+func (d *diagnosis) loopback(sam *SAM, id string) (string, error) {
+	keys, err := sam.NewKeys(Sig_DEFAULT)
+	if err != nil {
+		return "", err
+	}
+	s, err := sam.NewDatagramSession(id, keys, nil, d.cfg.UDPPort)
+	if err != nil {
+		return "", fmt.Errorf("%w; the router may not be building tunnels", err)
+	}
+	defer s.Close()
+	deadline := time.Now().Add(d.cfg.LoopbackTimeout)
+	if dl, ok := d.ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	s.SetReadDeadline(deadline)
+	token := "diagnose " + RandString() + RandString()
+	if _, err := s.WriteTo([]byte(token), keys.Addr()); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 512)
+	for {
+		n, from, err := s.ReadFrom(buf)
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			return "", fmt.Errorf("the datagram did not come back; is UDP port %d of the bridge reachable?", d.cfg.UDPPort)
+		}
+		if err != nil {
+			return "", err
+		}
+		if string(buf[:n]) == token && from.(i2pkeys.I2PAddr) == keys.Addr() {
+			return "datagram came back", nil
+		}
+	}
+}
+
+// needs33 explains the failure of a command which needs SAM 3.3.
+func (d *diagnosis) needs33(cmd string, err error) error {
+	if d.report.Version != "" && d.report.Version < "3.3" {
+		return fmt.Errorf("%w; %s needs SAM 3.3, the bridge speaks %s", err, cmd, d.report.Version)
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package sam3
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func Test_Diagnose(t *testing.T) {
+	b := samtest.New(t)
+	dest := samtest.Keys(t).Addr()
+	b.Handle("NAMING LOOKUP", samtest.Reply(func(line string) string {
+		return "NAMING REPLY RESULT=OK NAME=" + samtest.Arg(line, "NAME") + " VALUE=" + dest.Base64() + "\n"
+	}))
+	report, err := Diagnose(context.Background(), b.Addr(),
+		SetDiagnoseLookupName("known.i2p"),
+		SetDiagnoseLoopbackTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != "3.3" {
+		t.Errorf("version %q", report.Version)
+	}
+	for _, name := range []string{CheckTCP, CheckHello, CheckDest, CheckStream, CheckPrimary, CheckLookup} {
+		if c := report.Check(name); c == nil || !c.OK {
+			t.Errorf("%s: %+v", name, c)
+		}
+	}
+	// the fake bridge does not relay datagrams
+	if c := report.Check(CheckDatagram); c == nil || c.OK || !strings.Contains(c.Err.Error(), "UDP port 7655") {
+		t.Errorf("datagram loopback: %+v", c)
+	}
+	if report.OK() {
+		t.Error("report OK with a failed check")
+	}
+	if c := report.Check(CheckLookup); c.Detail != "known.i2p is "+dest.Base32() {
+		t.Errorf("lookup detail %q", c.Detail)
+	}
+	ids := map[string]bool{}
+	for _, cmd := range b.Commands() {
+		if strings.HasPrefix(cmd, "SESSION CREATE") {
+			ids[samtest.Arg(cmd, "ID")] = true
+		}
+	}
+	if len(ids) != 3 {
+		t.Errorf("session IDs %v, want one per check", ids)
+	}
+	if !strings.Contains(b.Command("SESSION ADD"), "STYLE=STREAM") {
+		t.Errorf("SESSION ADD %q", b.Command("SESSION ADD"))
+	}
+	if s := report.String(); !strings.Contains(s, "FAIL  datagram loopback") || !strings.Contains(s, "ok    hello") {
+		t.Errorf("report:\n%s", s)
+	}
+}
+
+func Test_DiagnoseUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	report, err := Diagnose(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := report.Check(CheckTCP); c.OK || !strings.Contains(c.Err.Error(), "SAM bridge enabled") {
+		t.Errorf("tcp connect: %+v", c)
+	}
+	for _, c := range report.Checks[1:] {
+		if !errors.Is(c.Err, ErrSkipped) {
+			t.Errorf("%s not skipped: %v", c.Name, c.Err)
+		}
+	}
+	if len(report.Checks) != 7 {
+		t.Errorf("%d checks", len(report.Checks))
+	}
+}
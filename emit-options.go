@@ -2,7 +2,6 @@ package sam3
 
 import (
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"strconv"
 	"strings"
 )
@@ -15,18 +14,18 @@ func SetType(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if s == "STREAM" {
 			c.Style = s
-			log.WithField("style", s).Debug("Set session style")
+			defaultLog().Debug("Set session style", "style", s)
 			return nil
 		} else if s == "DATAGRAM" {
 			c.Style = s
-			log.WithField("style", s).Debug("Set session style")
+			defaultLog().Debug("Set session style", "style", s)
 			return nil
 		} else if s == "RAW" {
 			c.Style = s
-			log.WithField("style", s).Debug("Set session style")
+			defaultLog().Debug("Set session style", "style", s)
 			return nil
 		}
-		log.WithField("style", s).Error("Invalid session style")
+		defaultLog().Error("Invalid session style", "style", s)
 		return fmt.Errorf("Invalid session STYLE=%s, must be STREAM, DATAGRAM, or RAW", s)
 	}
 }
@@ -36,17 +35,14 @@ func SetSAMAddress(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		sp := strings.Split(s, ":")
 		if len(sp) > 2 {
-			log.WithField("address", s).Error("Invalid SAM address")
+			defaultLog().Error("Invalid SAM address", "address", s)
 			return fmt.Errorf("Invalid address string: %s", sp)
 		}
 		if len(sp) == 2 {
 			c.I2PConfig.SamPort = sp[1]
 		}
 		c.I2PConfig.SamHost = sp[0]
-		log.WithFields(logrus.Fields{
-			"host": c.I2PConfig.SamHost,
-			"port": c.I2PConfig.SamPort,
-		}).Debug("Set SAM address")
+		defaultLog().Debug("Set SAM address", "host", c.I2PConfig.SamHost, "port", c.I2PConfig.SamPort)
 		return nil
 	}
 }
@@ -55,7 +51,7 @@ func SetSAMAddress(s string) func(*SAMEmit) error {
 func SetSAMHost(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		c.I2PConfig.SamHost = s
-		log.WithField("host", s).Debug("Set SAM host")
+		defaultLog().Debug("Set SAM host", "host", s)
 		return nil
 	}
 }
@@ -65,24 +61,63 @@ func SetSAMPort(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		port, err := strconv.Atoi(s)
 		if err != nil {
-			log.WithField("port", s).Error("Invalid SAM port: non-number")
+			defaultLog().Error("Invalid SAM port: non-number", "port", s)
 			return fmt.Errorf("Invalid SAM Port %s; non-number", s)
 		}
 		if port < 65536 && port > -1 {
 			c.I2PConfig.SamPort = s
-			log.WithField("port", s).Debug("Set SAM port")
+			defaultLog().Debug("Set SAM port", "port", s)
 			return nil
 		}
-		log.WithField("port", port).Error("Invalid SAM port")
+		defaultLog().Error("Invalid SAM port", "port", port)
 		return fmt.Errorf("Invalid port")
 	}
 }
 
+// SetSAMMin sets the lowest SAM version HELLO asks for, 3.0 by default
+func SetSAMMin(v string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if _, err := strconv.ParseFloat(v, 64); err != nil || !strings.HasPrefix(v, "3.") {
+			defaultLog().Error("Invalid SAM version", "version", v)
+			return fmt.Errorf("Invalid SAM version %s", v)
+		}
+		c.I2PConfig.SamMin = v
+		defaultLog().Debug("Set minimum SAM version", "version", v)
+		return nil
+	}
+}
+
+// SetSAMMax sets the highest SAM version HELLO asks for, 3.1 by default
+func SetSAMMax(v string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if _, err := strconv.ParseFloat(v, 64); err != nil || !strings.HasPrefix(v, "3.") {
+			defaultLog().Error("Invalid SAM version", "version", v)
+			return fmt.Errorf("Invalid SAM version %s", v)
+		}
+		c.I2PConfig.SamMax = v
+		defaultLog().Debug("Set maximum SAM version", "version", v)
+		return nil
+	}
+}
+
 // SetName sets the host of the SAMEmit's SAM bridge
 func SetName(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		c.I2PConfig.TunName = s
-		log.WithField("name", s).Debug("Set tunnel name")
+		defaultLog().Debug("Set tunnel name", "name", s)
+		return nil
+	}
+}
+
+// SetSigType sets the signature type used for new destinations
+func SetSigType(sig SigType) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if !sig.Valid() {
+			defaultLog().Error("Invalid signature type", "sigType", sig)
+			return fmt.Errorf("Invalid signature type %s", sig)
+		}
+		c.I2PConfig.SigType = &sig
+		defaultLog().Debug("Set signature type", "sigType", sig)
 		return nil
 	}
 }
@@ -92,10 +127,10 @@ func SetInLength(u int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if u < 7 && u >= 0 {
 			c.I2PConfig.InLength = strconv.Itoa(u)
-			log.WithField("inLength", u).Debug("Set inbound tunnel length")
+			defaultLog().Debug("Set inbound tunnel length", "inLength", u)
 			return nil
 		}
-		log.WithField("inLength", u).Error("Invalid inbound tunnel length")
+		defaultLog().Error("Invalid inbound tunnel length", "inLength", u)
 		return fmt.Errorf("Invalid inbound tunnel length")
 	}
 }
@@ -105,10 +140,10 @@ func SetOutLength(u int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if u < 7 && u >= 0 {
 			c.I2PConfig.OutLength = strconv.Itoa(u)
-			log.WithField("outLength", u).Debug("Set outbound tunnel length")
+			defaultLog().Debug("Set outbound tunnel length", "outLength", u)
 			return nil
 		}
-		log.WithField("outLength", u).Error("Invalid outbound tunnel length")
+		defaultLog().Error("Invalid outbound tunnel length", "outLength", u)
 		return fmt.Errorf("Invalid outbound tunnel length")
 	}
 }
@@ -118,10 +153,10 @@ func SetInVariance(i int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if i < 7 && i > -7 {
 			c.I2PConfig.InVariance = strconv.Itoa(i)
-			log.WithField("inVariance", i).Debug("Set inbound tunnel variance")
+			defaultLog().Debug("Set inbound tunnel variance", "inVariance", i)
 			return nil
 		}
-		log.WithField("inVariance", i).Error("Invalid inbound tunnel variance")
+		defaultLog().Error("Invalid inbound tunnel variance", "inVariance", i)
 		return fmt.Errorf("Invalid inbound tunnel length")
 	}
 }
@@ -131,10 +166,10 @@ func SetOutVariance(i int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if i < 7 && i > -7 {
 			c.I2PConfig.OutVariance = strconv.Itoa(i)
-			log.WithField("outVariance", i).Debug("Set outbound tunnel variance")
+			defaultLog().Debug("Set outbound tunnel variance", "outVariance", i)
 			return nil
 		}
-		log.WithField("outVariance", i).Error("Invalid outbound tunnel variance")
+		defaultLog().Error("Invalid outbound tunnel variance", "outVariance", i)
 		return fmt.Errorf("Invalid outbound tunnel variance")
 	}
 }
@@ -144,10 +179,10 @@ func SetInQuantity(u int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if u <= 16 && u > 0 {
 			c.I2PConfig.InQuantity = strconv.Itoa(u)
-			log.WithField("inQuantity", u).Debug("Set inbound tunnel quantity")
+			defaultLog().Debug("Set inbound tunnel quantity", "inQuantity", u)
 			return nil
 		}
-		log.WithField("inQuantity", u).Error("Invalid inbound tunnel quantity")
+		defaultLog().Error("Invalid inbound tunnel quantity", "inQuantity", u)
 		return fmt.Errorf("Invalid inbound tunnel quantity")
 	}
 }
@@ -157,10 +192,10 @@ func SetOutQuantity(u int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if u <= 16 && u > 0 {
 			c.I2PConfig.OutQuantity = strconv.Itoa(u)
-			log.WithField("outQuantity", u).Debug("Set outbound tunnel quantity")
+			defaultLog().Debug("Set outbound tunnel quantity", "outQuantity", u)
 			return nil
 		}
-		log.WithField("outQuantity", u).Error("Invalid outbound tunnel quantity")
+		defaultLog().Error("Invalid outbound tunnel quantity", "outQuantity", u)
 		return fmt.Errorf("Invalid outbound tunnel quantity")
 	}
 }
@@ -170,10 +205,10 @@ func SetInBackups(u int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if u < 6 && u >= 0 {
 			c.I2PConfig.InBackupQuantity = strconv.Itoa(u)
-			log.WithField("inBackups", u).Debug("Set inbound tunnel backups")
+			defaultLog().Debug("Set inbound tunnel backups", "inBackups", u)
 			return nil
 		}
-		log.WithField("inBackups", u).Error("Invalid inbound tunnel backup quantity")
+		defaultLog().Error("Invalid inbound tunnel backup quantity", "inBackups", u)
 		return fmt.Errorf("Invalid inbound tunnel backup quantity")
 	}
 }
@@ -183,10 +218,10 @@ func SetOutBackups(u int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if u < 6 && u >= 0 {
 			c.I2PConfig.OutBackupQuantity = strconv.Itoa(u)
-			log.WithField("outBackups", u).Debug("Set outbound tunnel backups")
+			defaultLog().Debug("Set outbound tunnel backups", "outBackups", u)
 			return nil
 		}
-		log.WithField("outBackups", u).Error("Invalid outbound tunnel backup quantity")
+		defaultLog().Error("Invalid outbound tunnel backup quantity", "outBackups", u)
 		return fmt.Errorf("Invalid outbound tunnel backup quantity")
 	}
 }
@@ -199,7 +234,7 @@ func SetEncrypt(b bool) func(*SAMEmit) error {
 			return nil
 		}
 		c.I2PConfig.EncryptLeaseSet = "false"
-		log.WithField("encrypt", b).Debug("Set lease set encryption")
+		defaultLog().Debug("Set lease set encryption", "encrypt", b)
 		return nil
 	}
 }
@@ -208,7 +243,7 @@ func SetEncrypt(b bool) func(*SAMEmit) error {
 func SetLeaseSetKey(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		c.I2PConfig.LeaseSetKey = s
-		log.WithField("leaseSetKey", s).Debug("Set lease set key")
+		defaultLog().Debug("Set lease set key")
 		return nil
 	}
 }
@@ -217,7 +252,7 @@ func SetLeaseSetKey(s string) func(*SAMEmit) error {
 func SetLeaseSetPrivateKey(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		c.I2PConfig.LeaseSetPrivateKey = s
-		log.WithField("leaseSetPrivateKey", s).Debug("Set lease set private key")
+		defaultLog().Debug("Set lease set private key")
 		return nil
 	}
 }
@@ -226,7 +261,44 @@ func SetLeaseSetPrivateKey(s string) func(*SAMEmit) error {
 func SetLeaseSetPrivateSigningKey(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		c.I2PConfig.LeaseSetPrivateSigningKey = s
-		log.WithField("leaseSetPrivateSigningKey", s).Debug("Set lease set private signing key")
+		defaultLog().Debug("Set lease set private signing key")
+		return nil
+	}
+}
+
+// SetLeaseSetSecret sets the password clients need to look up the encrypted
+// leaseset
+func SetLeaseSetSecret(s string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		c.I2PConfig.LeaseSetSecret = s
+		defaultLog().Debug("Set lease set secret")
+		return nil
+	}
+}
+
+// SetLeaseSetAuthType sets the per-client authorisation scheme of the
+// encrypted leaseset
+func SetLeaseSetAuthType(t LeaseSetAuthType) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if t < LeaseSetAuthNone || t > LeaseSetAuthPSK {
+			defaultLog().Error("Invalid lease set auth type", "authType", t)
+			return fmt.Errorf("Invalid lease set auth type %d", t)
+		}
+		c.I2PConfig.LeaseSetAuthType = t
+		defaultLog().Debug("Set lease set auth type", "authType", t)
+		return nil
+	}
+}
+
+// SetLeaseSetClients authorises clients to look up the encrypted leaseset
+func SetLeaseSetClients(clients ...LeaseSetClient) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		for _, client := range clients {
+			if err := c.I2PConfig.AddLeaseSetClient(client); err != nil {
+				defaultLog().Error("Invalid lease set client", "error", err)
+				return err
+			}
+		}
 		return nil
 	}
 }
@@ -235,7 +307,7 @@ func SetLeaseSetPrivateSigningKey(s string) func(*SAMEmit) error {
 func SetMessageReliability(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		c.I2PConfig.MessageReliability = s
-		log.WithField("messageReliability", s).Debug("Set message reliability")
+		defaultLog().Debug("Set message reliability", "messageReliability", s)
 		return nil
 	}
 }
@@ -248,7 +320,7 @@ func SetAllowZeroIn(b bool) func(*SAMEmit) error {
 			return nil
 		}
 		c.I2PConfig.InAllowZeroHop = "false"
-		log.WithField("allowZeroIn", b).Debug("Set allow zero-hop inbound")
+		defaultLog().Debug("Set allow zero-hop inbound", "allowZeroIn", b)
 		return nil
 	}
 }
@@ -261,7 +333,7 @@ func SetAllowZeroOut(b bool) func(*SAMEmit) error {
 			return nil
 		}
 		c.I2PConfig.OutAllowZeroHop = "false"
-		log.WithField("allowZeroOut", b).Debug("Set allow zero-hop outbound")
+		defaultLog().Debug("Set allow zero-hop outbound", "allowZeroOut", b)
 		return nil
 	}
 }
@@ -274,7 +346,7 @@ func SetCompress(b bool) func(*SAMEmit) error {
 			return nil
 		}
 		c.I2PConfig.UseCompression = "false"
-		log.WithField("compress", b).Debug("Set compression")
+		defaultLog().Debug("Set compression", "compress", b)
 		return nil
 	}
 }
@@ -287,7 +359,7 @@ func SetFastRecieve(b bool) func(*SAMEmit) error {
 			return nil
 		}
 		c.I2PConfig.FastRecieve = "false"
-		log.WithField("fastReceive", b).Debug("Set fast receive")
+		defaultLog().Debug("Set fast receive", "fastReceive", b)
 		return nil
 	}
 }
@@ -300,7 +372,7 @@ func SetReduceIdle(b bool) func(*SAMEmit) error {
 			return nil
 		}
 		c.I2PConfig.ReduceIdle = "false"
-		log.WithField("reduceIdle", b).Debug("Set reduce idle")
+		defaultLog().Debug("Set reduce idle", "reduceIdle", b)
 		return nil
 	}
 }
@@ -312,10 +384,10 @@ func SetReduceIdleTime(u int) func(*SAMEmit) error {
 		if u >= 6 {
 			idleTime := strconv.Itoa((u * 60) * 1000)
 			c.I2PConfig.ReduceIdleTime = idleTime
-			log.WithField("reduceIdleTime", idleTime).Debug("Set reduce idle time")
+			defaultLog().Debug("Set reduce idle time", "reduceIdleTime", idleTime)
 			return nil
 		}
-		log.WithField("minutes", u).Error("Invalid reduce idle timeout")
+		defaultLog().Error("Invalid reduce idle timeout", "minutes", u)
 		return fmt.Errorf("Invalid reduce idle timeout(Measured in minutes) %v", u)
 	}
 }
@@ -326,10 +398,10 @@ func SetReduceIdleTimeMs(u int) func(*SAMEmit) error {
 		c.I2PConfig.ReduceIdleTime = "300000"
 		if u >= 300000 {
 			c.I2PConfig.ReduceIdleTime = strconv.Itoa(u)
-			log.WithField("reduceIdleTimeMs", u).Debug("Set reduce idle time in milliseconds")
+			defaultLog().Debug("Set reduce idle time in milliseconds", "reduceIdleTimeMs", u)
 			return nil
 		}
-		log.WithField("milliseconds", u).Error("Invalid reduce idle timeout")
+		defaultLog().Error("Invalid reduce idle timeout", "milliseconds", u)
 		return fmt.Errorf("Invalid reduce idle timeout(Measured in milliseconds) %v", u)
 	}
 }
@@ -339,10 +411,10 @@ func SetReduceIdleQuantity(u int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if u < 5 {
 			c.I2PConfig.ReduceIdleQuantity = strconv.Itoa(u)
-			log.WithField("reduceIdleQuantity", u).Debug("Set reduce idle quantity")
+			defaultLog().Debug("Set reduce idle quantity", "reduceIdleQuantity", u)
 			return nil
 		}
-		log.WithField("quantity", u).Error("Invalid reduce tunnel quantity")
+		defaultLog().Error("Invalid reduce tunnel quantity", "quantity", u)
 		return fmt.Errorf("Invalid reduce tunnel quantity")
 	}
 }
@@ -366,13 +438,10 @@ func SetCloseIdleTime(u int) func(*SAMEmit) error {
 		if u >= 6 {
 			idleTime := strconv.Itoa((u * 60) * 1000)
 			c.I2PConfig.CloseIdleTime = idleTime
-			log.WithFields(logrus.Fields{
-				"minutes":      u,
-				"milliseconds": idleTime,
-			}).Debug("Set close idle time")
+			defaultLog().Debug("Set close idle time", "minutes", u, "milliseconds", idleTime)
 			return nil
 		}
-		log.WithField("minutes", u).Error("Invalid close idle timeout")
+		defaultLog().Error("Invalid close idle timeout", "minutes", u)
 		return fmt.Errorf("Invalid close idle timeout(Measured in minutes) %v", u)
 	}
 }
@@ -383,7 +452,7 @@ func SetCloseIdleTimeMs(u int) func(*SAMEmit) error {
 		c.I2PConfig.CloseIdleTime = "300000"
 		if u >= 300000 {
 			c.I2PConfig.CloseIdleTime = strconv.Itoa(u)
-			log.WithField("closeIdleTimeMs", u).Debug("Set close idle time in milliseconds")
+			defaultLog().Debug("Set close idle time in milliseconds", "closeIdleTimeMs", u)
 			return nil
 		}
 		return fmt.Errorf("Invalid close idle timeout(Measured in milliseconds) %v", u)
@@ -395,19 +464,19 @@ func SetAccessListType(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
 		if s == "whitelist" {
 			c.I2PConfig.AccessListType = "whitelist"
-			log.Debug("Set access list type to whitelist")
+			defaultLog().Debug("Set access list type to whitelist")
 			return nil
 		} else if s == "blacklist" {
 			c.I2PConfig.AccessListType = "blacklist"
-			log.Debug("Set access list type to blacklist")
+			defaultLog().Debug("Set access list type to blacklist")
 			return nil
 		} else if s == "none" {
 			c.I2PConfig.AccessListType = ""
-			log.Debug("Set access list type to none")
+			defaultLog().Debug("Set access list type to none")
 			return nil
 		} else if s == "" {
 			c.I2PConfig.AccessListType = ""
-			log.Debug("Set access list type to none")
+			defaultLog().Debug("Set access list type to none")
 			return nil
 		}
 		return fmt.Errorf("Invalid Access list type(whitelist, blacklist, none)")
@@ -421,10 +490,179 @@ func SetAccessList(s []string) func(*SAMEmit) error {
 			for _, a := range s {
 				c.I2PConfig.AccessList = append(c.I2PConfig.AccessList, a)
 			}
-			log.WithField("accessList", s).Debug("Set access list")
+			defaultLog().Debug("Set access list", "accessList", s)
 			return nil
 		}
-		log.Debug("No access list set (empty list provided)")
+		defaultLog().Debug("No access list set (empty list provided)")
+		return nil
+	}
+}
+
+// StreamProfile is the streaming library's i2p.streaming.profile.
+type StreamProfile int
+
+const (
+	StreamProfileBulk        StreamProfile = 1 // maximise throughput, the default
+	StreamProfileInteractive StreamProfile = 2 // minimise latency
+)
+
+// InactivityAction is what the streaming library does when a stream has
+// been idle for the inactivity timeout, i2p.streaming.inactivityAction.
+type InactivityAction int
+
+const (
+	InactivityActionNone       InactivityAction = 0
+	InactivityActionDisconnect InactivityAction = 1
+	InactivityActionSend       InactivityAction = 2 // send a keepalive, the default
+)
+
+// SetStreamConnectDelay sets how many milliseconds the streaming library
+// waits for data to send with the SYN of a new stream, or -1 to send the SYN
+// at once.
+func SetStreamConnectDelay(ms int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if ms < -1 {
+			defaultLog().Error("Invalid connect delay", "connectDelay", ms)
+			return fmt.Errorf("invalid connect delay %d, must be -1 or more", ms)
+		}
+		c.I2PConfig.StreamConnectDelay = strconv.Itoa(ms)
+		defaultLog().Debug("Set connect delay", "connectDelay", ms)
+		return nil
+	}
+}
+
+// SetMaxWindowSize sets the largest number of unacknowledged messages a
+// stream may have in flight, 1 to 128.
+func SetMaxWindowSize(n int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if n < 1 || n > 128 {
+			defaultLog().Error("Invalid max window size", "maxWindowSize", n)
+			return fmt.Errorf("invalid max window size %d, must be 1 to 128", n)
+		}
+		c.I2PConfig.MaxWindowSize = strconv.Itoa(n)
+		defaultLog().Debug("Set max window size", "maxWindowSize", n)
+		return nil
+	}
+}
+
+// SetInitialWindowSize sets the window size new streams start with, 1 to
+// 128.
+func SetInitialWindowSize(n int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if n < 1 || n > 128 {
+			defaultLog().Error("Invalid initial window size", "initialWindowSize", n)
+			return fmt.Errorf("invalid initial window size %d, must be 1 to 128", n)
+		}
+		c.I2PConfig.InitialWindowSize = strconv.Itoa(n)
+		defaultLog().Debug("Set initial window size", "initialWindowSize", n)
+		return nil
+	}
+}
+
+func connLimit(name string, n int, field *string) error {
+	if n < 0 {
+		defaultLog().Error("Invalid connection limit", name, n)
+		return fmt.Errorf("invalid %s %d, must be 0 (unlimited) or more", name, n)
+	}
+	*field = strconv.Itoa(n)
+	defaultLog().Debug("Set connection limit", name, n)
+	return nil
+}
+
+// SetMaxConnsPerMinute limits the incoming streams accepted from a single
+// peer per minute. 0 means no limit.
+func SetMaxConnsPerMinute(n int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		return connLimit("maxConnsPerMinute", n, &c.I2PConfig.MaxConnsPerMinute)
+	}
+}
+
+// SetMaxConnsPerHour limits the incoming streams accepted from a single peer
+// per hour. 0 means no limit.
+func SetMaxConnsPerHour(n int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		return connLimit("maxConnsPerHour", n, &c.I2PConfig.MaxConnsPerHour)
+	}
+}
+
+// SetMaxConnsPerDay limits the incoming streams accepted from a single peer
+// per day. 0 means no limit.
+func SetMaxConnsPerDay(n int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		return connLimit("maxConnsPerDay", n, &c.I2PConfig.MaxConnsPerDay)
+	}
+}
+
+// SetMaxConcurrentStreams limits the streams open at once, in and out
+// together, or -1 for no limit.
+func SetMaxConcurrentStreams(n int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if n < -1 {
+			defaultLog().Error("Invalid concurrent stream limit", "maxConcurrentStreams", n)
+			return fmt.Errorf("invalid max concurrent streams %d, must be -1 (unlimited) or more", n)
+		}
+		c.I2PConfig.MaxConcurrentStreams = strconv.Itoa(n)
+		defaultLog().Debug("Set max concurrent streams", "maxConcurrentStreams", n)
+		return nil
+	}
+}
+
+// SetAnswerPings tells the streaming library whether to answer pings.
+func SetAnswerPings(b bool) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		c.I2PConfig.AnswerPings = strconv.FormatBool(b)
+		defaultLog().Debug("Set answer pings", "answerPings", b)
+		return nil
+	}
+}
+
+// SetStreamProfile sets the streaming profile, bulk or interactive.
+func SetStreamProfile(p StreamProfile) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if p != StreamProfileBulk && p != StreamProfileInteractive {
+			defaultLog().Error("Invalid streaming profile", "profile", p)
+			return fmt.Errorf("invalid streaming profile %d", p)
+		}
+		c.I2PConfig.StreamProfile = strconv.Itoa(int(p))
+		defaultLog().Debug("Set streaming profile", "profile", p)
+		return nil
+	}
+}
+
+// SetInactivityTimeout sets how many milliseconds a stream may be idle
+// before the inactivity action is taken.
+func SetInactivityTimeout(ms int) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if ms < 0 {
+			defaultLog().Error("Invalid inactivity timeout", "inactivityTimeout", ms)
+			return fmt.Errorf("invalid inactivity timeout %d", ms)
+		}
+		c.I2PConfig.InactivityTimeout = strconv.Itoa(ms)
+		defaultLog().Debug("Set inactivity timeout", "inactivityTimeout", ms)
+		return nil
+	}
+}
+
+// SetInactivityAction sets what is done with streams which reach the
+// inactivity timeout.
+func SetInactivityAction(a InactivityAction) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if a < InactivityActionNone || a > InactivityActionSend {
+			defaultLog().Error("Invalid inactivity action", "inactivityAction", a)
+			return fmt.Errorf("invalid inactivity action %d", a)
+		}
+		c.I2PConfig.InactivityAction = strconv.Itoa(int(a))
+		defaultLog().Debug("Set inactivity action", "inactivityAction", a)
+		return nil
+	}
+}
+
+// SetEnforceProtocol tells the streaming library whether to refuse
+// connections which are not addressed to the streaming protocol.
+func SetEnforceProtocol(b bool) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		c.I2PConfig.EnforceProtocol = strconv.FormatBool(b)
+		defaultLog().Debug("Set enforce protocol", "enforceProtocol", b)
 		return nil
 	}
 }
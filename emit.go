@@ -1,25 +1,80 @@
 package sam3
 
 import (
-	"fmt"
-	"github.com/sirupsen/logrus"
 	"net"
+	"strconv"
 	"strings"
 )
 
+// SAMEmit builds the commands sent to the SAM bridge. Every command this
+// package sends is built here, so values are quoted the same way everywhere.
+// The methods without arguments take what they need from the I2PConfig, the
+// others only from their arguments.
 type SAMEmit struct {
 	I2PConfig
 }
 
+// samCommand accumulates the words of a SAM command line.
+type samCommand struct {
+	b strings.Builder
+}
+
+func newCommand(words ...string) *samCommand {
+	c := &samCommand{}
+	c.b.WriteString(strings.Join(words, " "))
+	return c
+}
+
+// param adds KEY=value, quoting the value if needed.
+func (c *samCommand) param(key, value string) *samCommand {
+	c.b.WriteString(" " + SessionOption{key, value}.String())
+	return c
+}
+
+// optParam adds KEY=value unless value is empty.
+func (c *samCommand) optParam(key, value string) *samCommand {
+	if value != "" {
+		c.param(key, value)
+	}
+	return c
+}
+
+// port adds a FROM_PORT or TO_PORT unless it is the default port 0.
+func (c *samCommand) port(key, port string) *samCommand {
+	if port != "" && port != "0" {
+		c.param(key, port)
+	}
+	return c
+}
+
+// raw adds options which are already rendered as key=value words, such as
+// those returned by I2PConfig.Print.
+func (c *samCommand) raw(options ...string) *samCommand {
+	for _, o := range options {
+		if o = strings.TrimSpace(o); o != "" {
+			c.b.WriteString(" " + o)
+		}
+	}
+	return c
+}
+
+func (c *samCommand) String() string {
+	return c.b.String() + "\n"
+}
+
+func silent(s bool) string {
+	return strconv.FormatBool(s)
+}
+
 func (e *SAMEmit) OptStr() string {
 	optStr := strings.Join(e.I2PConfig.Print(), " ")
-	log.WithField("optStr", optStr).Debug("Generated option string")
+	defaultLog().Debug("Generated option string", "optStr", redact(optStr))
 	return optStr
 }
 
 func (e *SAMEmit) Hello() string {
-	hello := fmt.Sprintf("HELLO VERSION MIN=%s MAX=%s \n", e.I2PConfig.MinSAM(), e.I2PConfig.MaxSAM())
-	log.WithField("hello", hello).Debug("Generated HELLO command")
+	hello := newCommand("HELLO VERSION").param("MIN", e.I2PConfig.MinSAM()).param("MAX", e.I2PConfig.MaxSAM()).String()
+	defaultLog().Debug("Generated HELLO command", "hello", hello)
 	return hello
 }
 
@@ -27,9 +82,20 @@ func (e *SAMEmit) HelloBytes() []byte {
 	return []byte(e.Hello())
 }
 
+// HelloAuth is Hello with the credentials for a SAM 3.2 bridge which has
+// authorisation enabled.
+func (e *SAMEmit) HelloAuth(user, password string) string {
+	return newCommand("HELLO VERSION").param("MIN", e.I2PConfig.MinSAM()).param("MAX", e.I2PConfig.MaxSAM()).
+		param("USER", user).param("PASSWORD", password).String()
+}
+
 func (e *SAMEmit) GenerateDestination() string {
-	dest := fmt.Sprintf("DEST GENERATE %s \n", e.I2PConfig.SignatureType())
-	log.WithField("destination", dest).Debug("Generated DEST GENERATE command")
+	c := newCommand("DEST GENERATE")
+	if e.I2PConfig.samMax() >= 3.1 && e.I2PConfig.SigType != nil {
+		c.raw(e.I2PConfig.SigType.Option())
+	}
+	dest := c.String()
+	defaultLog().Debug("Generated DEST GENERATE command", "destination", dest)
 	return dest
 }
 
@@ -37,9 +103,15 @@ func (e *SAMEmit) GenerateDestinationBytes() []byte {
 	return []byte(e.GenerateDestination())
 }
 
-func (e *SAMEmit) Lookup(name string) string {
-	lookup := fmt.Sprintf("NAMING LOOKUP NAME=%s \n", name)
-	log.WithField("lookup", lookup).Debug("Generated NAMING LOOKUP command")
+// Lookup returns a NAMING LOOKUP for name. params are extra arguments, such
+// as OPTIONS=true.
+func (e *SAMEmit) Lookup(name string, params ...SessionOption) string {
+	c := newCommand("NAMING LOOKUP").param("NAME", name)
+	for _, p := range params {
+		c.param(p.Key, p.Value)
+	}
+	lookup := c.String()
+	defaultLog().Debug("Generated NAMING LOOKUP command", "name", name)
 	return lookup
 }
 
@@ -47,36 +119,69 @@ func (e *SAMEmit) LookupBytes(name string) []byte {
 	return []byte(e.Lookup(name))
 }
 
+// Create returns the SESSION CREATE for the I2PConfig.
 func (e *SAMEmit) Create() string {
-	create := fmt.Sprintf(
-		//             //1 2 3 4 5 6 7
-		"SESSION CREATE %s%s%s%s%s%s%s \n",
-		e.I2PConfig.SessionStyle(),   //1
-		e.I2PConfig.FromPort(),       //2
-		e.I2PConfig.ToPort(),         //3
-		e.I2PConfig.ID(),             //4
-		e.I2PConfig.DestinationKey(), //5
-		e.I2PConfig.SignatureType(),  //6
-		e.OptStr(),                   //7
-	)
-	log.WithField("create", create).Debug("Generated SESSION CREATE command")
+	style := e.I2PConfig.Style
+	if style == "" {
+		style = "STREAM"
+	}
+	from, to := e.I2PConfig.Fromport, e.I2PConfig.Toport
+	if e.I2PConfig.samMax() < 3.1 {
+		from, to = "", ""
+	}
+	dest := "TRANSIENT"
+	if e.I2PConfig.DestinationKeys.String() != "" {
+		dest = e.I2PConfig.DestinationKeys.String()
+	}
+	e.I2PConfig.ID() // names the tunnel if it has no name yet
+	sigType, withSig := Sig_DSA_SHA1, e.I2PConfig.samMax() >= 3.1 && e.I2PConfig.SigType != nil
+	if withSig {
+		sigType = *e.I2PConfig.SigType
+	}
+	create := e.createSession(style, e.I2PConfig.TunName, from, to, dest, sigType, withSig, e.I2PConfig.Print()...)
+	defaultLog().Debug("Generated SESSION CREATE command", "id", e.I2PConfig.TunName)
 	return create
 }
 
 func (e *SAMEmit) CreateBytes() []byte {
-	fmt.Println("sam command: " + e.Create())
 	return []byte(e.Create())
 }
 
+// CreateSession returns a SESSION CREATE. destination is the private keys or
+// TRANSIENT, options are rendered key=value words. The signature type is
+// always sent, DSA_SHA1 included.
+func (e *SAMEmit) CreateSession(style, id, from, to, destination string, sigType SigType, options ...string) string {
+	return e.createSession(style, id, from, to, destination, sigType, true, options...)
+}
+
+// createSession is CreateSession, leaving out the signature type unless
+// withSig is set.
+func (e *SAMEmit) createSession(style, id, from, to, destination string, sigType SigType, withSig bool, options ...string) string {
+	c := newCommand("SESSION CREATE").param("STYLE", style).port("FROM_PORT", from).port("TO_PORT", to).
+		param("ID", id).param("DESTINATION", destination)
+	if withSig {
+		c.raw(sigType.Option())
+	}
+	return c.raw(options...).String()
+}
+
+// AddSession returns a SESSION ADD, which adds a subsession to a primary
+// session.
+func (e *SAMEmit) AddSession(style, id, from, to string, options ...string) string {
+	return newCommand("SESSION ADD").param("STYLE", style).param("ID", id).
+		port("FROM_PORT", from).port("TO_PORT", to).raw(options...).String()
+}
+
+// RemoveSession returns a SESSION REMOVE for a subsession.
+func (e *SAMEmit) RemoveSession(id string) string {
+	return newCommand("SESSION REMOVE").param("ID", id).String()
+}
+
+// Connect returns a STREAM CONNECT to dest from the I2PConfig's session.
 func (e *SAMEmit) Connect(dest string) string {
-	connect := fmt.Sprintf(
-		"STREAM CONNECT ID=%s %s %s DESTINATION=%s \n",
-		e.I2PConfig.ID(),
-		e.I2PConfig.FromPort(),
-		e.I2PConfig.ToPort(),
-		dest,
-	)
-	log.WithField("connect", connect).Debug("Generated STREAM CONNECT command")
+	e.I2PConfig.ID()
+	connect := e.StreamConnect(e.I2PConfig.TunName, e.I2PConfig.Fromport, e.I2PConfig.Toport, dest, false)
+	defaultLog().Debug("Generated STREAM CONNECT command", "dest", dest)
 	return connect
 }
 
@@ -84,14 +189,17 @@ func (e *SAMEmit) ConnectBytes(dest string) []byte {
 	return []byte(e.Connect(dest))
 }
 
+// StreamConnect returns a STREAM CONNECT from session id to dest.
+func (e *SAMEmit) StreamConnect(id, from, to, dest string, quiet bool) string {
+	return newCommand("STREAM CONNECT").param("ID", id).port("FROM_PORT", from).port("TO_PORT", to).
+		param("DESTINATION", dest).param("SILENT", silent(quiet)).String()
+}
+
+// Accept returns a STREAM ACCEPT for the I2PConfig's session.
 func (e *SAMEmit) Accept() string {
-	accept := fmt.Sprintf(
-		"STREAM ACCEPT ID=%s %s %s",
-		e.I2PConfig.ID(),
-		e.I2PConfig.FromPort(),
-		e.I2PConfig.ToPort(),
-	)
-	log.WithField("accept", accept).Debug("Generated STREAM ACCEPT command")
+	e.I2PConfig.ID()
+	accept := e.StreamAccept(e.I2PConfig.TunName, false)
+	defaultLog().Debug("Generated STREAM ACCEPT command", "id", e.I2PConfig.TunName)
 	return accept
 }
 
@@ -99,15 +207,56 @@ func (e *SAMEmit) AcceptBytes() []byte {
 	return []byte(e.Accept())
 }
 
+// StreamAccept returns a STREAM ACCEPT for session id.
+func (e *SAMEmit) StreamAccept(id string, quiet bool) string {
+	return newCommand("STREAM ACCEPT").param("ID", id).param("SILENT", silent(quiet)).String()
+}
+
+// StreamForward returns a STREAM FORWARD, which asks the bridge to connect
+// to host:port for every incoming stream of session id. host may be empty
+// for the address the command came from.
+func (e *SAMEmit) StreamForward(id, host, port string, quiet bool) string {
+	return newCommand("STREAM FORWARD").param("ID", id).param("PORT", port).optParam("HOST", host).
+		param("SILENT", silent(quiet)).String()
+}
+
+// DatagramSend returns the DATAGRAM SEND line which precedes size bytes of
+// datagram sent over the bridge connection.
+func (e *SAMEmit) DatagramSend(dest string, size int, from, to string) string {
+	return newCommand("DATAGRAM SEND").param("DESTINATION", dest).param("SIZE", strconv.Itoa(size)).
+		port("FROM_PORT", from).port("TO_PORT", to).String()
+}
+
+// DatagramHeader returns the header line of a datagram sent to the bridge's
+// UDP port: the SAM version, the session id and the destination.
+func (e *SAMEmit) DatagramHeader(version, id, dest string) string {
+	return newCommand(version, id, dest).String()
+}
+
+// Ping returns a PING, which the bridge answers with a PONG echoing text.
+func (e *SAMEmit) Ping(text string) string {
+	return newCommand("PING").raw(text).String()
+}
+
+// Auth returns an AUTH command of SAM 3.2: action is ENABLE, DISABLE, ADD
+// or REMOVE. user and password are left out when empty.
+func (e *SAMEmit) Auth(action, user, password string) string {
+	return newCommand("AUTH", action).optParam("USER", user).optParam("PASSWORD", password).String()
+}
+
+// samEmit builds commands for code which has no SAMEmit of its own. Only
+// methods which do not read the I2PConfig may be used on it.
+var samEmit SAMEmit
+
 func NewEmit(opts ...func(*SAMEmit) error) (*SAMEmit, error) {
 	var emit SAMEmit
 	for _, o := range opts {
 		if err := o(&emit); err != nil {
-			log.WithError(err).Error("Failed to apply option")
+			defaultLog().Error("Failed to apply option", "error", err)
 			return nil, err
 		}
 	}
-	log.Debug("New SAMEmit instance created")
+	defaultLog().Debug("New SAMEmit instance created")
 	return &emit, nil
 }
 
@@ -116,7 +265,7 @@ func IgnorePortError(err error) error {
 		return nil
 	}
 	if strings.Contains(err.Error(), "missing port in address") {
-		log.Debug("Ignoring 'missing port in address' error")
+		defaultLog().Debug("Ignoring 'missing port in address' error")
 		err = nil
 	}
 	return err
@@ -126,14 +275,11 @@ func SplitHostPort(hostport string) (string, string, error) {
 	host, port, err := net.SplitHostPort(hostport)
 	if err != nil {
 		if IgnorePortError(err) == nil {
-			log.WithField("host", hostport).Debug("Using full string as host, port set to 0")
+			defaultLog().Debug("Using full string as host, port set to 0", "host", hostport)
 			host = hostport
 			port = "0"
 		}
 	}
-	log.WithFields(logrus.Fields{
-		"host": host,
-		"port": port,
-	}).Debug("Split host and port")
+	defaultLog().Debug("Split host and port", "host", host, "port", port)
 	return host, port, nil
 }
@@ -0,0 +1,91 @@
+package sam3
+
+import (
+	"testing"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func Test_EmitCommands(t *testing.T) {
+	e, err := NewEmit(SetName("tun"), SetSigType(Sig_EdDSA_SHA512_Ed25519))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ got, want string }{
+		{e.Hello(), "HELLO VERSION MIN=3.0 MAX=3.1\n"},
+		{e.HelloAuth("me", "pass word"), "HELLO VERSION MIN=3.0 MAX=3.1 USER=me PASSWORD=\"pass word\"\n"},
+		{e.GenerateDestination(), "DEST GENERATE SIGNATURE_TYPE=7\n"},
+		{e.Lookup("a.i2p"), "NAMING LOOKUP NAME=a.i2p\n"},
+		{e.Lookup("a.i2p", SessionOption{"OPTIONS", "true"}, SessionOption{"SECRET", `a "b"`}),
+			`NAMING LOOKUP NAME=a.i2p OPTIONS=true SECRET="a \"b\""` + "\n"},
+		{e.CreateSession("STREAM", "tun", "0", "80", "TRANSIENT", Sig_EdDSA_SHA512_Ed25519, "inbound.length=1", "", "i2cp.leaseSetSecret=\"x y\""),
+			"SESSION CREATE STYLE=STREAM TO_PORT=80 ID=tun DESTINATION=TRANSIENT SIGNATURE_TYPE=7 inbound.length=1 i2cp.leaseSetSecret=\"x y\"\n"},
+		{e.CreateSession("RAW", "r", "", "", "TRANSIENT", Sig_DSA_SHA1, "PORT=7655"),
+			"SESSION CREATE STYLE=RAW ID=r DESTINATION=TRANSIENT SIGNATURE_TYPE=0 PORT=7655\n"},
+		{e.AddSession("DATAGRAM", "sub", "5", "0", "PORT=7655"), "SESSION ADD STYLE=DATAGRAM ID=sub FROM_PORT=5 PORT=7655\n"},
+		{e.RemoveSession("sub"), "SESSION REMOVE ID=sub\n"},
+		{e.StreamConnect("tun", "1", "2", "dest", false), "STREAM CONNECT ID=tun FROM_PORT=1 TO_PORT=2 DESTINATION=dest SILENT=false\n"},
+		{e.Connect("dest"), "STREAM CONNECT ID=tun DESTINATION=dest SILENT=false\n"},
+		{e.StreamAccept("tun", true), "STREAM ACCEPT ID=tun SILENT=true\n"},
+		{e.Accept(), "STREAM ACCEPT ID=tun SILENT=false\n"},
+		{e.StreamForward("tun", "", "8080", false), "STREAM FORWARD ID=tun PORT=8080 SILENT=false\n"},
+		{e.DatagramSend("dest", 42, "0", "9"), "DATAGRAM SEND DESTINATION=dest SIZE=42 TO_PORT=9\n"},
+		{e.DatagramHeader("3.1", "tun", "dest"), "3.1 tun dest\n"},
+		{e.Ping("hi"), "PING hi\n"},
+		{e.Auth("ADD", "me", "pw"), "AUTH ADD USER=me PASSWORD=pw\n"},
+		{e.Auth("ENABLE", "", ""), "AUTH ENABLE\n"},
+	} {
+		if c.got != c.want {
+			t.Errorf("got  %q\nwant %q", c.got, c.want)
+		}
+	}
+	create := e.Create()
+	if samtest.Arg(create, "STYLE") != "STREAM" || samtest.Arg(create, "ID") != "tun" ||
+		samtest.Arg(create, "DESTINATION") != "TRANSIENT" || samtest.Arg(create, "SIGNATURE_TYPE") != "7" {
+		t.Errorf("Create() = %q", create)
+	}
+}
+
+func Test_EmitSAMVersion(t *testing.T) {
+	e, err := NewEmit(SetSAMMin("3.1"), SetSAMMax("3.3"), SetSigType(Sig_EdDSA_SHA512_Ed25519))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Hello(); got != "HELLO VERSION MIN=3.1 MAX=3.3\n" {
+		t.Errorf("Hello() = %q", got)
+	}
+	if got := e.GenerateDestination(); got != "DEST GENERATE SIGNATURE_TYPE=7\n" {
+		t.Errorf("GenerateDestination() = %q", got)
+	}
+	for _, v := range []string{"", "three", "2.0"} {
+		if _, err := NewEmit(SetSAMMax(v)); err == nil {
+			t.Errorf("SetSAMMax(%q) accepted", v)
+		}
+	}
+}
+
+func Test_EmitSigTypeUnset(t *testing.T) {
+	e, err := NewEmit(SetName("tun"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SigType != nil {
+		t.Error("signature type set by default")
+	}
+	if got := e.GenerateDestination(); got != "DEST GENERATE\n" {
+		t.Errorf("GenerateDestination() = %q", got)
+	}
+	if got := samtest.Arg(e.Create(), "SIGNATURE_TYPE"); got != "" {
+		t.Errorf("Create() sent SIGNATURE_TYPE=%s", got)
+	}
+
+	// DSA_SHA1 is the zero value of SigType, but once set it is sent
+	dsa := Sig_DSA_SHA1
+	e.SigType = &dsa
+	if got := e.GenerateDestination(); got != "DEST GENERATE SIGNATURE_TYPE=0\n" {
+		t.Errorf("GenerateDestination() = %q", got)
+	}
+	if got := samtest.Arg(e.Create(), "SIGNATURE_TYPE"); got != "0" {
+		t.Errorf("Create() sent SIGNATURE_TYPE=%q", got)
+	}
+}
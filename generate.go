@@ -0,0 +1,321 @@
+package sam3
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+// DestGenerator generates destinations in bulk. It opens several connections
+// to the SAM bridge and keeps a number of DEST GENERATE requests in flight on
+// each of them, so generating many destinations, or searching for one with a
+// particular base32 address, is not limited by a single round-trip at a time.
+type DestGenerator struct {
+	address  string
+	workers  int
+	depth    int
+	count    int
+	sigType  SigType
+	match    func(b32 string) bool
+	progress func(GenerateProgress)
+	interval time.Duration
+}
+
+// GeneratedDest is a single result of a DestGenerator. If Err is set the
+// worker which produced it has stopped.
+type GeneratedDest struct {
+	Keys    i2pkeys.I2PKeys
+	Base32  string
+	Attempt uint64
+	Err     error
+}
+
+// GenerateProgress is passed to the progress callback of a DestGenerator.
+type GenerateProgress struct {
+	Attempts uint64
+	Matches  uint64
+	Elapsed  time.Duration
+}
+
+// Rate returns the number of destinations generated per second.
+func (p GenerateProgress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Attempts) / p.Elapsed.Seconds()
+}
+
+// NewDestGenerator creates a generator which talks to the SAM bridge at
+// address. By default it uses 4 connections with 4 requests in flight each,
+// generates Ed25519 destinations and stops after one result.
+func NewDestGenerator(address string, opts ...func(*DestGenerator) error) (*DestGenerator, error) {
+	g := &DestGenerator{
+		address:  address,
+		workers:  4,
+		depth:    4,
+		count:    1,
+		sigType:  Sig_DEFAULT,
+		interval: time.Second,
+	}
+	for _, o := range opts {
+		if err := o(g); err != nil {
+			defaultLog().Error("Failed to apply generator option", "error", err)
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// SetGeneratorWorkers sets the number of SAM connections used concurrently
+func SetGeneratorWorkers(n int) func(*DestGenerator) error {
+	return func(g *DestGenerator) error {
+		if n < 1 {
+			return fmt.Errorf("Invalid generator worker count %d", n)
+		}
+		g.workers = n
+		return nil
+	}
+}
+
+// SetGeneratorDepth sets the number of DEST GENERATE requests kept in flight
+// on each SAM connection
+func SetGeneratorDepth(n int) func(*DestGenerator) error {
+	return func(g *DestGenerator) error {
+		if n < 1 {
+			return fmt.Errorf("Invalid generator pipeline depth %d", n)
+		}
+		g.depth = n
+		return nil
+	}
+}
+
+// SetGeneratorCount sets the number of destinations to deliver before
+// stopping. Zero means keep going until the context is cancelled.
+func SetGeneratorCount(n int) func(*DestGenerator) error {
+	return func(g *DestGenerator) error {
+		if n < 0 {
+			return fmt.Errorf("Invalid generator count %d", n)
+		}
+		g.count = n
+		return nil
+	}
+}
+
+// SetGeneratorSigType sets the signature type of the generated destinations
+func SetGeneratorSigType(sig SigType) func(*DestGenerator) error {
+	return func(g *DestGenerator) error {
+		if !sig.Valid() {
+			return fmt.Errorf("Invalid signature type %s", sig)
+		}
+		g.sigType = sig
+		return nil
+	}
+}
+
+// SetGeneratorPrefix only delivers destinations whose base32 address starts
+// with prefix
+func SetGeneratorPrefix(prefix string) func(*DestGenerator) error {
+	return func(g *DestGenerator) error {
+		prefix = strings.ToLower(prefix)
+		if len(prefix) > 52 {
+			return fmt.Errorf("Invalid base32 prefix %q: too long", prefix)
+		}
+		for _, c := range prefix {
+			if !(c >= 'a' && c <= 'z') && !(c >= '2' && c <= '7') {
+				return fmt.Errorf("Invalid base32 prefix %q: %q can not occur in a base32 address", prefix, c)
+			}
+		}
+		g.match = func(b32 string) bool {
+			return strings.HasPrefix(b32, prefix)
+		}
+		return nil
+	}
+}
+
+// SetGeneratorRegexp only delivers destinations whose full base32 address,
+// including the .b32.i2p suffix, matches expr
+func SetGeneratorRegexp(expr string) func(*DestGenerator) error {
+	return func(g *DestGenerator) error {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("Invalid base32 expression: %w", err)
+		}
+		g.match = re.MatchString
+		return nil
+	}
+}
+
+// SetGeneratorProgress calls fn every interval while the generator runs
+func SetGeneratorProgress(interval time.Duration, fn func(GenerateProgress)) func(*DestGenerator) error {
+	return func(g *DestGenerator) error {
+		if interval <= 0 {
+			return fmt.Errorf("Invalid progress interval %s", interval)
+		}
+		g.interval = interval
+		g.progress = fn
+		return nil
+	}
+}
+
+// Generate starts the generator. Results are delivered on the returned
+// channel, which is closed when the requested number of destinations has been
+// found, ctx is cancelled, or every worker has failed.
+func (g *DestGenerator) Generate(ctx context.Context) <-chan GeneratedDest {
+	defaultLog().Debug("Starting destination generator", "address", g.address, "workers", g.workers, "depth", g.depth, "count", g.count, "sigType", g.sigType)
+	out := make(chan GeneratedDest)
+	ctx, cancel := context.WithCancel(ctx)
+	var attempts, matches uint64
+	start := time.Now()
+
+	// deliver hands a result to the caller and reports whether the worker
+	// should keep going
+	var mu sync.Mutex
+	deliver := func(d GeneratedDest) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() != nil {
+			return false
+		}
+		if d.Err == nil {
+			n := atomic.AddUint64(&matches, 1)
+			if g.count > 0 && n > uint64(g.count) {
+				return false
+			}
+			defer func() {
+				if g.count > 0 && n == uint64(g.count) {
+					cancel()
+				}
+			}()
+		}
+		select {
+		case out <- d:
+			return d.Err == nil
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var workers sync.WaitGroup
+	for i := 0; i < g.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			g.work(ctx, &attempts, deliver)
+		}()
+	}
+	report := func() {
+		g.progress(GenerateProgress{atomic.LoadUint64(&attempts), atomic.LoadUint64(&matches), time.Since(start)})
+	}
+	reporterDone := make(chan struct{})
+	if g.progress != nil {
+		go func() {
+			defer close(reporterDone)
+			t := time.NewTicker(g.interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					report()
+				case <-ctx.Done():
+					report()
+					return
+				}
+			}
+		}()
+	} else {
+		close(reporterDone)
+	}
+	go func() {
+		workers.Wait()
+		cancel()
+		<-reporterDone
+		close(out)
+		defaultLog().Debug("Destination generator finished", "attempts", atomic.LoadUint64(&attempts))
+	}()
+	return out
+}
+
+// First runs the generator until it finds one destination and returns it.
+func (g *DestGenerator) First(ctx context.Context) (i2pkeys.I2PKeys, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var lastErr error
+	for d := range g.Generate(ctx) {
+		if d.Err != nil {
+			lastErr = d.Err
+			continue
+		}
+		return d.Keys, nil
+	}
+	if lastErr != nil {
+		return i2pkeys.I2PKeys{}, lastErr
+	}
+	if err := ctx.Err(); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	return i2pkeys.I2PKeys{}, fmt.Errorf("no destination generated")
+}
+
+// work runs one pipelined DEST GENERATE loop on its own SAM connection.
+func (g *DestGenerator) work(ctx context.Context, attempts *uint64, deliver func(GeneratedDest) bool) {
+	sam, err := NewSAM(g.address)
+	if err != nil {
+		deliver(GeneratedDest{Err: err})
+		return
+	}
+	defer sam.Close()
+	// unblock the reader when the generator is stopped
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sam.conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	emit := sam.Config
+	emit.I2PConfig.SigType = &g.sigType
+	cmd := emit.GenerateDestinationBytes()
+	rd := bufio.NewReader(sam.conn)
+	inflight := 0
+	for {
+		for ; inflight < g.depth; inflight++ {
+			if _, err := sam.conn.Write(cmd); err != nil {
+				if ctx.Err() == nil {
+					deliver(GeneratedDest{Err: fmt.Errorf("error with writing in SAM: %w", err)})
+				}
+				return
+			}
+		}
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			if ctx.Err() == nil {
+				deliver(GeneratedDest{Err: fmt.Errorf("error with reading in SAM: %w", err)})
+			}
+			return
+		}
+		inflight--
+		n := atomic.AddUint64(attempts, 1)
+		keys, err := parseDestReply(line)
+		if err != nil {
+			deliver(GeneratedDest{Err: err})
+			return
+		}
+		b32 := keys.Addr().Base32()
+		if g.match != nil && !g.match(b32) {
+			continue
+		}
+		if !deliver(GeneratedDest{Keys: keys, Base32: b32, Attempt: n}) {
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package sam3
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func Test_NewKeysFakeBridge(t *testing.T) {
+	b := samtest.New(t)
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	keys, err := sam.NewKeys(Sig_EdDSA_SHA512_Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd := b.Command("DEST GENERATE"); !strings.Contains(cmd, "SIGNATURE_TYPE=7") {
+		t.Errorf("DEST GENERATE command %q does not request Ed25519", cmd)
+	}
+	if st, err := KeysSigType(keys); err != nil || st != Sig_EdDSA_SHA512_Ed25519 {
+		t.Errorf("KeysSigType = %s, %v", st, err)
+	}
+	if _, err := sam.NewKeys(SigType(42)); err == nil {
+		t.Error("NewKeys accepted an unknown signature type")
+	}
+}
+
+func Test_DestGeneratorBulk(t *testing.T) {
+	b := samtest.New(t)
+	var reports int32
+	g, err := NewDestGenerator(b.Addr(),
+		SetGeneratorWorkers(3),
+		SetGeneratorDepth(5),
+		SetGeneratorCount(20),
+		SetGeneratorProgress(time.Millisecond, func(GenerateProgress) { atomic.AddInt32(&reports, 1) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for d := range g.Generate(context.Background()) {
+		if d.Err != nil {
+			t.Fatal(d.Err)
+		}
+		if seen[d.Base32] {
+			t.Errorf("duplicate destination %s", d.Base32)
+		}
+		seen[d.Base32] = true
+	}
+	if len(seen) != 20 {
+		t.Errorf("got %d destinations, want 20", len(seen))
+	}
+	if atomic.LoadInt32(&reports) == 0 {
+		t.Error("progress callback was never called")
+	}
+}
+
+func Test_DestGeneratorPrefix(t *testing.T) {
+	b := samtest.New(t)
+	g, err := NewDestGenerator(b.Addr(), SetGeneratorPrefix("A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	keys, err := g.First(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b32 := keys.Addr().Base32(); !strings.HasPrefix(b32, "a") {
+		t.Errorf("%s does not start with the requested prefix", b32)
+	}
+	if _, err := NewDestGenerator(b.Addr(), SetGeneratorPrefix("i2p0")); err == nil {
+		t.Error("accepted a prefix which can not occur in a base32 address")
+	}
+}
+
+func Test_DestGeneratorCancel(t *testing.T) {
+	b := samtest.New(t)
+	g, err := NewDestGenerator(b.Addr(), SetGeneratorCount(0), SetGeneratorRegexp("^$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := g.First(ctx); err != context.DeadlineExceeded {
+		t.Errorf("First() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -1,8 +1,9 @@
 module github.com/go-i2p/sam3
 
-go 1.12
+go 1.20
 
 require (
+	filippo.io/edwards25519 v1.1.1
 	github.com/go-i2p/i2pkeys v0.0.0-20241108200332-e4f5ccdff8c4
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/sys v0.27.0 // indirect
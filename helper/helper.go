@@ -1,8 +1,8 @@
 package sam
 
 import (
-	"io/ioutil"
-	"log"
+	"errors"
+	"fmt"
 	"net"
 	"os"
 
@@ -13,114 +13,285 @@ import (
 // HEY! If you're looking at this, there's a good chance that `github.com/go-i2p/onramp`
 // is a better fit! Check it out.
 
-func NetListener(name, samaddr, keyspath string) (net.Listener, error) {
-	return I2PListener(name, sam3.SAMDefaultAddr(samaddr), keyspath)
+// The helpers never exit the program, every failure is returned as an error.
+// A session owns the SAM connection it was created on: closing the session
+// closes it, and it is closed again if creating the session fails.
+
+// dial connects to the SAM bridge.
+func (c *Config) dial() (*sam3.SAM, error) {
+	addr := sam3.SAMDefaultAddr(c.SAMAddr)
+	sam, err := sam3.NewSAM(addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SAM at %s: %w", addr, err)
+	}
+	sam.SetLogger(c.Logger)
+	return sam, nil
 }
 
-// I2PListener is a convenience function which takes a SAM tunnel name, a SAM address and a filename.
-// If the file contains I2P keys, it will create a service using that address. If the file does not
-// exist, keys will be generated and stored in that file.
-func I2PListener(name, samaddr, keyspath string) (*sam3.StreamListener, error) {
-	log.Printf("Starting and registering I2P service, please wait a couple of minutes...")
-	listener, err := I2PStreamSession(name, sam3.SAMDefaultAddr(samaddr), keyspath)
+func (c *Config) keyFile() string {
+	return c.KeysPath + ".i2p.private"
+}
+
+// errNoSAM is returned by keys when it would have to generate keys but was
+// given no SAM.
+var errNoSAM = errors.New("no SAM connection to generate I2P keys on")
+
+// log returns the Logger, the library's default one if none was set.
+func (c *Config) log() sam3.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return sam3.DefaultLogger()
+}
+
+// keys loads the keys from KeysPath, or generates them on sam and stores
+// them there if the file does not exist yet. sam may be nil if the keys
+// only need to be loaded.
+func (c *Config) keys(sam *sam3.SAM) (i2pkeys.I2PKeys, error) {
+	if c.KeysPath != "" {
+		f, err := os.Open(c.keyFile())
+		if err == nil {
+			defer f.Close()
+			keys, err := i2pkeys.LoadKeysIncompat(f)
+			if err != nil {
+				return i2pkeys.I2PKeys{}, fmt.Errorf("loading I2P keys from %s: %w", c.keyFile(), err)
+			}
+			return keys, nil
+		}
+		if !os.IsNotExist(err) {
+			return i2pkeys.I2PKeys{}, fmt.Errorf("opening I2P keyfile: %w", err)
+		}
+	}
+	if sam == nil {
+		return i2pkeys.I2PKeys{}, errNoSAM
+	}
+	keys, err := sam.NewKeys(c.SigType)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("generating I2P keys: %w", err)
+	}
+	if c.KeysPath == "" {
+		return keys, nil
+	}
+	f, err := os.OpenFile(c.keyFile(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("creating I2P keyfile: %w", err)
+	}
+	if err := i2pkeys.StoreKeysIncompat(keys, f); err != nil {
+		f.Close()
+		os.Remove(c.keyFile())
+		return i2pkeys.I2PKeys{}, fmt.Errorf("storing I2P keys: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("storing I2P keys: %w", err)
+	}
+	c.log().Info("Stored new I2P keys", "file", c.keyFile())
+	return keys, nil
+}
+
+// prepare connects to SAM and gets the keys. The SAM connection is closed
+// if that fails; if creating the session on it fails the caller closes it.
+func (c *Config) prepare(name string) (*sam3.SAM, i2pkeys.I2PKeys, error) {
+	c.log().Info("Starting and registering I2P session", "name", name)
+	sam, err := c.dial()
+	if err != nil {
+		return nil, i2pkeys.I2PKeys{}, err
+	}
+	keys, err := c.keys(sam)
+	if err != nil {
+		sam.Close()
+		return nil, i2pkeys.I2PKeys{}, err
+	}
+	return sam, keys, nil
+}
+
+// sigType is the signature type to create a session with keys with.
+func (c *Config) sigType(keys i2pkeys.I2PKeys) sam3.SigType {
+	if st, err := sam3.KeysSigType(keys); err == nil {
+		return st
+	}
+	return c.SigType
+}
+
+func port(p string) string {
+	if p == "" {
+		return "0"
+	}
+	return p
+}
+
+// NewStreamSession creates a stream session called name.
+func NewStreamSession(name string, opts ...func(*Config) error) (*sam3.StreamSession, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.streamSession(name)
+}
+
+func (c *Config) streamSession(name string) (*sam3.StreamSession, error) {
+	sam, keys, err := c.prepare(name)
 	if err != nil {
 		return nil, err
 	}
-	if keyspath != "" {
-		err = ioutil.WriteFile(keyspath+".i2p.public.txt", []byte(listener.Keys().Addr().Base32()), 0644)
+	s, err := sam.NewStreamSessionWithSignatureAndPorts(name, port(c.FromPort), port(c.ToPort), keys, c.Options, c.sigType(keys))
+	if err != nil {
+		sam.Close()
+		return nil, fmt.Errorf("creating stream session %s: %w", name, err)
+	}
+	return s, nil
+}
+
+// NewStreamListener creates a stream session called name and listens on it.
+// If keys are kept in a file, the b32 address is written next to it, to
+// keyspath.i2p.public.txt. Closing the listener closes the session.
+func NewStreamListener(name string, opts ...func(*Config) error) (*sam3.StreamListener, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	session, err := c.streamSession(name)
+	if err != nil {
+		return nil, err
+	}
+	if c.KeysPath != "" {
+		err := os.WriteFile(c.KeysPath+".i2p.public.txt", []byte(session.Keys().Addr().Base32()), 0644)
 		if err != nil {
-			log.Fatalf("error storing I2P base32 address in adjacent text file, %s", err)
+			session.Close()
+			return nil, fmt.Errorf("storing I2P base32 address: %w", err)
 		}
 	}
-	log.Printf("Listening on: %s", listener.Addr().Base32())
-	return listener.Listen()
+	c.log().Info("Listening", "addr", session.Addr().Base32())
+	l, err := session.Listen()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return l, nil
 }
 
-// I2PStreamSession is a convenience function which returns a sam3.StreamSession instead
-// of a sam3.StreamListener. It also takes care of setting a persisitent key on behalf
-// of the user.
-func I2PStreamSession(name, samaddr, keyspath string) (*sam3.StreamSession, error) {
-	log.Printf("Starting and registering I2P session...")
-	sam, err := sam3.NewSAM(sam3.SAMDefaultAddr(samaddr))
+// NewDatagramSession creates a repliable datagram session called name.
+func NewDatagramSession(name string, opts ...func(*Config) error) (*sam3.DatagramSession, error) {
+	c, err := newConfig(opts)
 	if err != nil {
-		log.Fatalf("error connecting to SAM to %s: %s", sam3.SAMDefaultAddr(samaddr), err)
+		return nil, err
 	}
-	keys, err := GenerateOrLoadKeys(keyspath, sam)
+	sam, keys, err := c.prepare(name)
 	if err != nil {
 		return nil, err
 	}
-	stream, err := sam.NewStreamSession(name, *keys, sam3.Options_Medium)
-	return stream, err
+	options := append([]string(nil), c.Options...)
+	if port(c.FromPort) != "0" {
+		options = append(options, "FROM_PORT="+c.FromPort)
+	}
+	if port(c.ToPort) != "0" {
+		options = append(options, "TO_PORT="+c.ToPort)
+	}
+	s, err := sam.NewDatagramSession(name, keys, options, c.UDPPort)
+	if err != nil {
+		sam.Close()
+		return nil, fmt.Errorf("creating datagram session %s: %w", name, err)
+	}
+	return s, nil
 }
 
-// I2PDataGramsession is a convenience function which returns a sam3.DatagramSession.
-// It also takes care of setting a persisitent key on behalf of the user.
-func I2PDatagramSession(name, samaddr, keyspath string) (*sam3.DatagramSession, error) {
-	log.Printf("Starting and registering I2P session...")
-	sam, err := sam3.NewSAM(sam3.SAMDefaultAddr(samaddr))
+// NewPrimarySession creates a primary session called name, to which stream
+// and datagram subsessions can be added.
+func NewPrimarySession(name string, opts ...func(*Config) error) (*sam3.PrimarySession, error) {
+	c, err := newConfig(opts)
 	if err != nil {
-		log.Fatalf("error connecting to SAM to %s: %s", sam3.SAMDefaultAddr(samaddr), err)
+		return nil, err
 	}
-	keys, err := GenerateOrLoadKeys(keyspath, sam)
+	sam, keys, err := c.prepare(name)
 	if err != nil {
 		return nil, err
 	}
-	gram, err := sam.NewDatagramSession(name, *keys, sam3.Options_Medium, 0)
-	return gram, err
+	s, err := sam.NewPrimarySessionWithSignature(name, keys, c.Options, c.sigType(keys))
+	if err != nil {
+		sam.Close()
+		return nil, fmt.Errorf("creating primary session %s: %w", name, err)
+	}
+	return s, nil
 }
 
-// I2PPrimarySession is a convenience function which returns a sam3.PrimarySession.
-// It also takes care of setting a persisitent key on behalf of the user.
-func I2PPrimarySession(name, samaddr, keyspath string) (*sam3.PrimarySession, error) {
-	log.Printf("Starting and registering I2P session...")
-	sam, err := sam3.NewSAM(sam3.SAMDefaultAddr(samaddr))
+// LoadOrGenerateKeys loads the keys kept at the configured keys path. If
+// there are none yet, it connects to SAM, generates them, stores them and
+// closes the connection again.
+func LoadOrGenerateKeys(opts ...func(*Config) error) (i2pkeys.I2PKeys, error) {
+	c, err := newConfig(opts)
 	if err != nil {
-		log.Fatalf("error connecting to SAM to %s: %s", sam3.SAMDefaultAddr(samaddr), err)
+		return i2pkeys.I2PKeys{}, err
 	}
-	keys, err := GenerateOrLoadKeys(keyspath, sam)
+	if c.KeysPath != "" {
+		if keys, err := c.keys(nil); !errors.Is(err, errNoSAM) {
+			return keys, err
+		}
+	}
+	sam, err := c.dial()
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	defer sam.Close()
+	return c.keys(sam)
+}
+
+func legacy(samaddr, keyspath string) []func(*Config) error {
+	return []func(*Config) error{SetSAMAddress(samaddr), SetKeysPath(keyspath)}
+}
+
+func NetListener(name, samaddr, keyspath string) (net.Listener, error) {
+	l, err := I2PListener(name, sam3.SAMDefaultAddr(samaddr), keyspath)
 	if err != nil {
 		return nil, err
 	}
-	gram, err := sam.NewPrimarySession(name, *keys, sam3.Options_Medium)
-	return gram, err
+	return l, nil
+}
+
+// I2PListener is a convenience function which takes a SAM tunnel name, a SAM address and a filename.
+// If the file contains I2P keys, it will create a service using that address. If the file does not
+// exist, keys will be generated and stored in that file.
+func I2PListener(name, samaddr, keyspath string) (*sam3.StreamListener, error) {
+	return NewStreamListener(name, legacy(samaddr, keyspath)...)
+}
+
+// I2PStreamSession is a convenience function which returns a sam3.StreamSession instead
+// of a sam3.StreamListener. It also takes care of setting a persisitent key on behalf
+// of the user.
+func I2PStreamSession(name, samaddr, keyspath string) (*sam3.StreamSession, error) {
+	return NewStreamSession(name, legacy(samaddr, keyspath)...)
+}
+
+// I2PDataGramsession is a convenience function which returns a sam3.DatagramSession.
+// It also takes care of setting a persisitent key on behalf of the user.
+func I2PDatagramSession(name, samaddr, keyspath string) (*sam3.DatagramSession, error) {
+	return NewDatagramSession(name, legacy(samaddr, keyspath)...)
+}
+
+// I2PPrimarySession is a convenience function which returns a sam3.PrimarySession.
+// It also takes care of setting a persisitent key on behalf of the user.
+func I2PPrimarySession(name, samaddr, keyspath string) (*sam3.PrimarySession, error) {
+	return NewPrimarySession(name, legacy(samaddr, keyspath)...)
 }
 
 // GenerateOrLoadKeys is a convenience function which takes a filename and a SAM session.
-// if the SAM session is nil, a new one will be created with the defaults.
+// if the SAM session is nil, a new one will be created with the defaults and closed again.
 // The keyspath must be the path to a place to store I2P keys. The keyspath will be suffixed with
 // .i2p.private for the private keys, and public.txt for the b32 addresses.
 // If the keyspath.i2p.private file does not exist, keys will be generated and stored in that file.
 // if the keyspath.i2p.private does exist, keys will be loaded from that location and returned
 func GenerateOrLoadKeys(keyspath string, sam *sam3.SAM) (keys *i2pkeys.I2PKeys, err error) {
+	var k i2pkeys.I2PKeys
 	if sam == nil {
-		sam, err = sam3.NewSAM(sam3.SAMDefaultAddr("127.0.0.1:7656"))
-		if err != nil {
-			return nil, err
-		}
-	}
-	if _, err := os.Stat(keyspath + ".i2p.private"); os.IsNotExist(err) {
-		f, err := os.Create(keyspath + ".i2p.private")
-		if err != nil {
-			log.Fatalf("unable to open I2P keyfile for writing: %s", err)
-		}
-		defer f.Close()
-		tkeys, err := sam.NewKeys()
-		if err != nil {
-			log.Fatalf("unable to generate I2P Keys, %s", err)
-		}
-		keys = &tkeys
-		err = i2pkeys.StoreKeysIncompat(*keys, f)
-		if err != nil {
-			log.Fatalf("unable to save newly generated I2P Keys, %s", err)
-		}
+		k, err = LoadOrGenerateKeys(SetKeysPath(keyspath))
 	} else {
-		tkeys, err := i2pkeys.LoadKeys(keyspath + ".i2p.private")
-		if err != nil {
-			log.Fatalf("unable to load I2P Keys: %e", err)
+		var c *Config
+		if c, err = newConfig([]func(*Config) error{SetKeysPath(keyspath)}); err == nil {
+			k, err = c.keys(sam)
 		}
-		keys = &tkeys
 	}
-	return keys, nil
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
 }
 
 // GenerateKeys is a shorter version of GenerateOrLoadKeys which generates keys and stores them in a file.
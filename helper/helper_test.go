@@ -0,0 +1,199 @@
+package sam
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+// deadAddr returns an address nothing listens on.
+func deadAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func Test_HelperErrors(t *testing.T) {
+	addr := deadAddr(t)
+	// these used to exit the program
+	if _, err := NewStreamSession("t", SetSAMAddress(addr), SetLogger(nil)); err == nil || !strings.Contains(err.Error(), addr) {
+		t.Errorf("stream session: %v", err)
+	}
+	if _, err := I2PDatagramSession("t", addr, ""); err == nil {
+		t.Error("datagram session without SAM succeeded")
+	}
+	if l, err := NetListener("t", addr, ""); err == nil || l != nil {
+		t.Errorf("NetListener = %v, %v", l, err)
+	}
+	if _, err := LoadOrGenerateKeys(SetSAMAddress(addr), SetKeysPath(filepath.Join(t.TempDir(), "k"))); err == nil {
+		t.Error("generating keys without SAM succeeded")
+	}
+	for name, o := range map[string]func(*Config) error{
+		"ports":   SetPorts(-1, 80),
+		"udp":     SetUDPPort(70000),
+		"sigtype": SetSigType(sam3.SigType(99)),
+	} {
+		if _, err := NewStreamSession("t", SetSAMAddress(addr), o); err == nil || strings.Contains(err.Error(), addr) {
+			t.Errorf("%s: option error not reported: %v", name, err)
+		}
+	}
+}
+
+// waitOpen waits for the bridge to have n connections open.
+func waitOpen(t *testing.T, b *samtest.Bridge, n int) {
+	t.Helper()
+	for i := 0; i < 100 && b.Open() != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := b.Open(); got != n {
+		t.Errorf("%d connections open, want %d", got, n)
+	}
+}
+
+func Test_HelperConnections(t *testing.T) {
+	b := samtest.New(t)
+	dir := t.TempDir()
+	opts := func(more ...func(*Config) error) []func(*Config) error {
+		return append([]func(*Config) error{SetSAMAddress(b.Addr()), SetUDPPort(b.UDPPort()), SetLogger(nil)}, more...)
+	}
+	closers := map[string]func() (interface{ Close() error }, error){
+		"stream": func() (interface{ Close() error }, error) {
+			return NewStreamSession("stream", opts()...)
+		},
+		"listener": func() (interface{ Close() error }, error) {
+			return NewStreamListener("listener", opts(SetKeysPath(filepath.Join(dir, "listener")))...)
+		},
+		"datagram": func() (interface{ Close() error }, error) {
+			return NewDatagramSession("datagram", opts()...)
+		},
+		"primary": func() (interface{ Close() error }, error) {
+			return NewPrimarySession("primary", opts()...)
+		},
+	}
+	// a session keeps its connection until it is closed
+	for name, create := range closers {
+		s, err := create()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		waitOpen(t, b, 1)
+		s.Close()
+		waitOpen(t, b, 0)
+	}
+	pub, err := os.ReadFile(filepath.Join(dir, "listener.i2p.public.txt"))
+	if err != nil || !strings.HasSuffix(string(pub), ".b32.i2p") {
+		t.Errorf("public address %q %v", pub, err)
+	}
+
+	// failing to store the keys closes the connection
+	if _, err := NewStreamSession("nokeys", opts(SetKeysPath(filepath.Join(dir, "missing", "k")))...); err == nil {
+		t.Error("keys stored in a missing directory")
+	}
+	waitOpen(t, b, 0)
+
+	// and so does failing to create the session
+	b.Handle("SESSION CREATE", samtest.Reply(func(string) string {
+		return "SESSION STATUS RESULT=DUPLICATED_ID\n"
+	}))
+	for name, create := range closers {
+		if _, err := create(); err == nil {
+			t.Errorf("%s: created with a duplicated ID", name)
+		}
+		waitOpen(t, b, 0)
+	}
+}
+
+func Test_HelperKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service")
+	keys := testKeys(t)
+	f, err := os.Create(path + ".i2p.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i2pkeys.StoreKeysIncompat(keys, f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	// existing keys are loaded without a SAM bridge
+	got, err := LoadOrGenerateKeys(SetKeysPath(path), SetSAMAddress(deadAddr(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Addr() != keys.Addr() {
+		t.Error("loaded different keys")
+	}
+	if err := os.WriteFile(path+".i2p.private", []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateOrLoadKeys(path, nil); err == nil {
+		t.Error("garbage keyfile accepted")
+	}
+	// keys are only generated with a SAM
+	c := &Config{KeysPath: filepath.Join(t.TempDir(), "missing")}
+	if _, err := c.keys(nil); !errors.Is(err, errNoSAM) {
+		t.Errorf("keys without a file or SAM: %v", err)
+	}
+}
+
+// recordLogger is a sam3.Logger which keeps the messages it is given.
+type recordLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordLogger) record(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, msg)
+}
+
+func (l *recordLogger) Debug(msg string, _ ...interface{}) { l.record(msg) }
+func (l *recordLogger) Info(msg string, _ ...interface{})  { l.record(msg) }
+func (l *recordLogger) Warn(msg string, _ ...interface{})  { l.record(msg) }
+func (l *recordLogger) Error(msg string, _ ...interface{}) { l.record(msg) }
+
+func Test_HelperLogger(t *testing.T) {
+	b := samtest.New(t)
+	l := new(recordLogger)
+	s, err := NewStreamSession("logged", SetSAMAddress(b.Addr()), SetLogger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	got := strings.Join(l.msgs, "\n")
+	// the helper's own progress and that of the session it opened
+	for _, want := range []string{"Starting and registering I2P session", "Closing StreamSession"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not logged in:\n%s", want, got)
+		}
+	}
+}
+
+// testKeys returns random keys with a null certificate, enough to be stored
+// and loaded.
+func testKeys(t *testing.T) i2pkeys.I2PKeys {
+	enc := base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+	b := make([]byte, 387+256+20)
+	if _, err := rand.Read(b[:384]); err != nil {
+		t.Fatal(err)
+	}
+	dest := enc.EncodeToString(b[:387])
+	return i2pkeys.NewKeys(i2pkeys.I2PAddr(dest), enc.EncodeToString(b))
+}
@@ -0,0 +1,111 @@
+package sam
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-i2p/sam3"
+)
+
+// Config holds the settings of the helper functions. It is built from the
+// functional options, callers do not usually touch it.
+type Config struct {
+	// SAMAddr is the address of the SAM bridge, 127.0.0.1:7656 if empty
+	SAMAddr string
+	// KeysPath is where keys are kept, suffixed with .i2p.private. If it
+	// is empty the session gets a new destination every time.
+	KeysPath string
+	// Options are the I2CP and streaming options of the session
+	Options []string
+	// SigType is the signature type of generated keys. Keys loaded from
+	// KeysPath keep their own.
+	SigType sam3.SigType
+	// FromPort and ToPort are the ports of stream and datagram sessions
+	FromPort, ToPort string
+	// UDPPort is the local port datagram sessions receive on, 0 for any
+	UDPPort int
+	// Logger is what the helpers, and the SAM connections and sessions
+	// they open, log to; nil for the library's default logger
+	Logger sam3.Logger
+}
+
+func newConfig(opts []func(*Config) error) (*Config, error) {
+	c := &Config{
+		Options: sam3.Options_Medium,
+		SigType: sam3.Sig_EdDSA_SHA512_Ed25519,
+	}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// SetSAMAddress sets the address of the SAM bridge.
+func SetSAMAddress(addr string) func(*Config) error {
+	return func(c *Config) error {
+		c.SAMAddr = addr
+		return nil
+	}
+}
+
+// SetKeysPath sets where keys are loaded from, or stored if they are
+// generated.
+func SetKeysPath(path string) func(*Config) error {
+	return func(c *Config) error {
+		c.KeysPath = path
+		return nil
+	}
+}
+
+// SetTunnelOptions sets the session options, sam3.Options_Medium by default.
+func SetTunnelOptions(opts []string) func(*Config) error {
+	return func(c *Config) error {
+		c.Options = opts
+		return nil
+	}
+}
+
+// SetSigType sets the signature type of generated keys, Ed25519 by default.
+func SetSigType(st sam3.SigType) func(*Config) error {
+	return func(c *Config) error {
+		if !st.Valid() {
+			return fmt.Errorf("invalid signature type %s", st)
+		}
+		c.SigType = st
+		return nil
+	}
+}
+
+// SetPorts sets the FROM_PORT and TO_PORT of stream and datagram sessions.
+func SetPorts(from, to int) func(*Config) error {
+	return func(c *Config) error {
+		if from < 0 || from > 65535 || to < 0 || to > 65535 {
+			return fmt.Errorf("invalid ports %d and %d", from, to)
+		}
+		c.FromPort, c.ToPort = strconv.Itoa(from), strconv.Itoa(to)
+		return nil
+	}
+}
+
+// SetUDPPort sets the local port datagram sessions receive datagrams on.
+func SetUDPPort(port int) func(*Config) error {
+	return func(c *Config) error {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("invalid UDP port %d", port)
+		}
+		c.UDPPort = port
+		return nil
+	}
+}
+
+// SetLogger sets the logger of the helpers and of the SAM connections and
+// sessions they open. nil, the default, means the library's default logger,
+// see sam3.SetDefaultLogger.
+func SetLogger(l sam3.Logger) func(*Config) error {
+	return func(c *Config) error {
+		c.Logger = l
+		return nil
+	}
+}
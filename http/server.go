@@ -0,0 +1,123 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+)
+
+// The headers a Server sets to tell handlers where a request came from, the
+// same ones i2ptunnel's HTTP server tunnels set.
+const (
+	HeaderDestHash = "X-I2P-DestHash"
+	HeaderDestB32  = "X-I2P-DestB32"
+	HeaderDestB64  = "X-I2P-DestB64"
+)
+
+var i2pB64enc = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+type remoteKey struct{}
+
+// RemoteAddr returns the destination the request with context ctx came
+// from, if it is served by a Server.
+func RemoteAddr(ctx context.Context) (i2pkeys.I2PAddr, bool) {
+	addr, ok := ctx.Value(remoteKey{}).(i2pkeys.I2PAddr)
+	return addr, ok
+}
+
+// Server serves HTTP on a StreamListener.
+type Server struct {
+	listener *sam3.StreamListener
+	srv      *http.Server
+	headers  bool
+}
+
+// SetDestHeaders makes the server set X-I2P-DestHash, X-I2P-DestB32 and
+// X-I2P-DestB64 on every request. Clients cannot forge them either way:
+// they are always removed from incoming requests.
+func SetDestHeaders(on bool) func(*Server) error {
+	return func(s *Server) error {
+		s.headers = on
+		return nil
+	}
+}
+
+// SetHTTPServer sets the http.Server used for its timeouts, limits and
+// error log. Its Handler and ConnContext are replaced.
+func SetHTTPServer(srv *http.Server) func(*Server) error {
+	return func(s *Server) error {
+		if srv == nil {
+			return errors.New("nil http.Server")
+		}
+		s.srv = srv
+		return nil
+	}
+}
+
+// NewServer returns a Server handling requests to l with h.
+func NewServer(l *sam3.StreamListener, h http.Handler, opts ...func(*Server) error) (*Server, error) {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	s := &Server{listener: l, srv: &http.Server{}}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	s.srv.Handler = s.wrap(h)
+	s.srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if addr, ok := c.RemoteAddr().(i2pkeys.I2PAddr); ok {
+			return context.WithValue(ctx, remoteKey{}, addr)
+		}
+		return ctx
+	}
+	return s, nil
+}
+
+func (s *Server) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(HeaderDestHash)
+		r.Header.Del(HeaderDestB32)
+		r.Header.Del(HeaderDestB64)
+		if addr, ok := RemoteAddr(r.Context()); ok && s.headers {
+			hash := addr.DestHash()
+			r.Header.Set(HeaderDestHash, i2pB64enc.EncodeToString(hash[:]))
+			r.Header.Set(HeaderDestB32, addr.Base32())
+			r.Header.Set(HeaderDestB64, addr.Base64())
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Addr is the destination the server is reachable at.
+func (s *Server) Addr() i2pkeys.I2PAddr {
+	return s.listener.Addr().(i2pkeys.I2PAddr)
+}
+
+// Serve accepts streams until the server is shut down, when it returns
+// http.ErrServerClosed.
+func (s *Server) Serve() error {
+	return s.srv.Serve(s.listener)
+}
+
+// Shutdown stops accepting streams, waits for active requests to finish
+// or ctx to be done, and closes the listener's session.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	if cerr := s.listener.Close(); err == nil && cerr != nil && !errors.Is(cerr, net.ErrClosed) {
+		err = fmt.Errorf("closing session: %w", cerr)
+	}
+	return err
+}
+
+// Close closes the listener, its session and all connections immediately.
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func Test_Server(t *testing.T) {
+	b := samtest.New(t)
+	l, err := newSession(t, b).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, _ := RemoteAddr(r.Context())
+		io.WriteString(w, addr.Base32()+" "+r.Header.Get(HeaderDestB32)+" "+r.Header.Get(HeaderDestB64))
+	}), SetDestHeaders(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve() }()
+
+	client := samtest.Keys(t).Addr()
+	conn, err := b.Connect(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: site.i2p\r\nX-I2P-DestB32: forged.b32.i2p\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := client.Base32() + " " + client.Base32() + " " + client.Base64(); string(body) != want {
+		t.Errorf("got %q", body)
+	}
+
+	// shutting down aborts the pending STREAM ACCEPT
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("Serve: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Serve did not return")
+	}
+}
@@ -0,0 +1,230 @@
+// Package http carries net/http requests over I2P streams.
+//
+// A Transport sends requests to .i2p and .b32.i2p hosts through a sam3
+// StreamSession. Names are resolved through the session when dialing, the
+// port of the URL becomes the TO_PORT of the stream, and idle connections are
+// pooled per host and port. The URL is left as it is, so TLS is checked
+// against the name the request was made for. Requests to any other host
+// fail with ErrClearnet unless an outproxy is set, so nothing leaks past I2P
+// by accident. Proxy environment variables are ignored.
+//
+// A Server serves HTTP on a StreamListener and tells handlers which
+// destination each request came from.
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+)
+
+// ErrClearnet is returned for requests to hosts outside I2P when no
+// outproxy is set.
+var ErrClearnet = errors.New("refusing to send a request for a non-I2P host without an outproxy")
+
+// Transport is an http.RoundTripper which dials over I2P. It is safe for
+// concurrent use.
+type Transport struct {
+	session         *sam3.StreamSession
+	outproxy        *url.URL
+	maxConnsPerDest int
+	idleConnTimeout time.Duration
+
+	i2p *http.Transport
+	out *http.Transport
+
+	mu    sync.Mutex
+	dests map[string]i2pkeys.I2PAddr // b32 to full destination
+}
+
+// SetOutproxy sends requests for hosts outside I2P to the HTTP proxy at the
+// I2P address addr, for example "exit.stormycloud.i2p:80".
+func SetOutproxy(addr string) func(*Transport) error {
+	return func(t *Transport) error {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid outproxy %q: %w", addr, err)
+		}
+		if !IsI2P(host) {
+			return fmt.Errorf("invalid outproxy %q: not an I2P host", addr)
+		}
+		t.outproxy = &url.URL{Scheme: "http", Host: addr}
+		return nil
+	}
+}
+
+// SetMaxConnsPerDest limits the connections to each host and port, counting
+// those in use and idle ones. 0, the default, means no limit.
+func SetMaxConnsPerDest(n int) func(*Transport) error {
+	return func(t *Transport) error {
+		if n < 0 {
+			return fmt.Errorf("invalid connection limit %d", n)
+		}
+		t.maxConnsPerDest = n
+		return nil
+	}
+}
+
+// SetIdleConnTimeout sets how long idle connections are kept for reuse,
+// 90 seconds by default. 0 keeps them until the server closes them.
+func SetIdleConnTimeout(d time.Duration) func(*Transport) error {
+	return func(t *Transport) error {
+		if d < 0 {
+			return fmt.Errorf("invalid idle timeout %s", d)
+		}
+		t.idleConnTimeout = d
+		return nil
+	}
+}
+
+// New returns a Transport dialing over session. The session stays owned by
+// the caller.
+func New(session *sam3.StreamSession, opts ...func(*Transport) error) (*Transport, error) {
+	t := &Transport{
+		session:         session,
+		idleConnTimeout: 90 * time.Second,
+		dests:           make(map[string]i2pkeys.I2PAddr),
+	}
+	for _, o := range opts {
+		if err := o(t); err != nil {
+			return nil, err
+		}
+	}
+	t.i2p = t.transport()
+	if t.outproxy != nil {
+		t.out = t.transport()
+		t.out.Proxy = http.ProxyURL(t.outproxy)
+	}
+	return t, nil
+}
+
+// NewPrimary returns a Transport dialing over a new stream subsession of
+// primary, which is closed along with the primary session.
+func NewPrimary(primary *sam3.PrimarySession, opts ...func(*Transport) error) (*Transport, error) {
+	session, err := primary.NewStreamSubSessionWithPorts(primary.ID()+"-http", "0", "0")
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP stream subsession: %w", err)
+	}
+	return New(session, opts...)
+}
+
+// NewClient returns an http.Client using a Transport on session.
+func NewClient(session *sam3.StreamSession, opts ...func(*Transport) error) (*http.Client, error) {
+	t, err := New(session, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+func (t *Transport) transport() *http.Transport {
+	return &http.Transport{
+		Proxy:               nil,
+		DialContext:         t.dial,
+		MaxConnsPerHost:     t.maxConnsPerDest,
+		IdleConnTimeout:     t.idleConnTimeout,
+		TLSHandshakeTimeout: time.Minute,
+	}
+}
+
+// IsI2P reports whether host is an I2P hostname.
+func IsI2P(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".i2p")
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	if !IsI2P(host) {
+		if t.out == nil {
+			closeBody(req)
+			return nil, fmt.Errorf("%w: %s", ErrClearnet, host)
+		}
+		return t.out.RoundTrip(req)
+	}
+	// fail early for unknown names; dial finds them in the session's cache
+	if _, err := t.resolve(host); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	return t.i2p.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the pooled connections which are not in use.
+func (t *Transport) CloseIdleConnections() {
+	t.i2p.CloseIdleConnections()
+	if t.out != nil {
+		t.out.CloseIdleConnections()
+	}
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// resolve looks host up through the session and remembers the destination
+// of its b32 address, which never changes.
+func (t *Transport) resolve(host string) (i2pkeys.I2PAddr, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	t.mu.Lock()
+	addr, ok := t.dests[host]
+	t.mu.Unlock()
+	if ok {
+		return addr, nil
+	}
+	addr, err := t.session.Lookup(host)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", host, err)
+	}
+	t.mu.Lock()
+	t.dests[addr.Base32()] = addr
+	t.mu.Unlock()
+	return addr, nil
+}
+
+// dial opens a stream to the destination of the host of addr, whose port is
+// used as TO_PORT.
+func (t *Transport) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := t.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		conn *sam3.SAMConn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := t.session.DialI2PPort(dest, port)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, fmt.Errorf("dialing %s: %w", addr, r.err)
+		}
+		return r.conn, nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
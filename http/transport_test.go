@@ -0,0 +1,155 @@
+package http
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/sam3"
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func newSession(t *testing.T, b *samtest.Bridge) *sam3.StreamSession {
+	t.Helper()
+	sam, err := sam3.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sam.Close() })
+	s, err := sam.NewStreamSession("httpTest", samtest.Keys(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func get(t *testing.T, c *http.Client, u string) string {
+	t.Helper()
+	resp, err := c.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func count(b *samtest.Bridge, prefix string) int {
+	n := 0
+	for _, c := range b.Commands() {
+		if strings.HasPrefix(c, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func Test_Transport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+r.URL.Path)
+	}))
+	defer srv.Close()
+	b := samtest.New(t)
+	site := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", site)
+	b.Forward(site, srv.Listener.Addr().String())
+
+	c, err := NewClient(newSession(t, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t, c, "http://site.i2p:8080/a"); got != "site.i2p:8080/a" {
+		t.Errorf("got %q", got)
+	}
+	if port := samtest.Arg(b.Command("STREAM CONNECT"), "TO_PORT"); port != "8080" {
+		t.Errorf("TO_PORT = %q", port)
+	}
+	// the same name reuses the idle connection
+	if got := get(t, c, "http://site.i2p:8080/b"); got != "site.i2p:8080/b" {
+		t.Errorf("got %q", got)
+	}
+	if n := count(b, "STREAM CONNECT"); n != 1 {
+		t.Errorf("%d streams for one name", n)
+	}
+	if got := get(t, c, "http://"+site.Base32()+":8080/c"); got != site.Base32()+":8080/c" {
+		t.Errorf("got %q", got)
+	}
+
+	if _, err := c.Get("http://example.com/"); !errors.Is(err, ErrClearnet) {
+		t.Errorf("clearnet request: %v", err)
+	}
+	if _, err := c.Get("http://missing.i2p/"); err == nil {
+		t.Error("unknown name resolved")
+	}
+}
+
+func Test_TransportTLS(t *testing.T) {
+	var serverName string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+r.URL.Path)
+	}))
+	srv.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		serverName = hello.ServerName
+		return nil, nil
+	}}
+	srv.StartTLS()
+	defer srv.Close()
+	b := samtest.New(t)
+	site := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", site)
+	b.Forward(site, srv.Listener.Addr().String())
+
+	tr, err := New(newSession(t, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: tr}
+	// the test certificate is for example.com, not site.i2p
+	tr.i2p.TLSClientConfig = &tls.Config{RootCAs: srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	var cerr *tls.CertificateVerificationError
+	if _, err := c.Get("https://site.i2p/"); !errors.As(err, &cerr) {
+		t.Errorf("certificate for another name accepted: %v", err)
+	}
+	if serverName != "site.i2p" {
+		t.Errorf("SNI %q, want site.i2p", serverName)
+	}
+	tr.i2p.TLSClientConfig.InsecureSkipVerify = true
+	if got := get(t, c, "https://site.i2p/a"); got != "site.i2p/a" {
+		t.Errorf("got %q", got)
+	}
+	if port := samtest.Arg(b.Command("STREAM CONNECT"), "TO_PORT"); port != "443" {
+		t.Errorf("TO_PORT = %q", port)
+	}
+}
+
+func Test_TransportOutproxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "proxied "+r.URL.String())
+	}))
+	defer proxy.Close()
+	b := samtest.New(t)
+	out := samtest.Keys(t).Addr()
+	b.AddName("outproxy.i2p", out)
+	b.Forward(out, proxy.Listener.Addr().String())
+
+	if _, err := New(nil, SetOutproxy("example.com:80")); err == nil {
+		t.Error("clearnet outproxy accepted")
+	}
+	c, err := NewClient(newSession(t, b), SetOutproxy("outproxy.i2p:4444"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t, c, "http://example.com/x"); got != "proxied http://example.com/x" {
+		t.Errorf("got %q", got)
+	}
+	if port := samtest.Arg(b.Command("STREAM CONNECT"), "TO_PORT"); port != "4444" {
+		t.Errorf("TO_PORT = %q", port)
+	}
+}
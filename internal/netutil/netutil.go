@@ -0,0 +1,82 @@
+// Package netutil holds the connection plumbing shared by the stream
+// listener, the SOCKS server and the HTTP proxy.
+package netutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ErrRefused is returned by Connect when the proxy answers with anything but
+// 200.
+var ErrRefused = errors.New("proxy refused the tunnel")
+
+// BufferedConn reads what Reader buffered, for example past a reply which
+// was parsed from Conn, before reading from Conn again.
+type BufferedConn struct {
+	net.Conn
+	Reader *bufio.Reader
+}
+
+func (c *BufferedConn) Read(b []byte) (int, error) {
+	return c.Reader.Read(b)
+}
+
+// CloseWrite closes the writing side of Conn, if it has one.
+func (c *BufferedConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+// CloseWrite closes the writing side of c, or fails if c cannot be half
+// closed.
+func CloseWrite(c net.Conn) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return fmt.Errorf("%T cannot be half closed", c)
+}
+
+// Join copies between a and b in both directions and closes both when
+// neither has more to send. When one side is done sending, the other is
+// closed for writing only, so a peer which half closes still gets its
+// reply. Sides which cannot be half closed are closed completely.
+func Join(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		if _, err := io.Copy(dst, src); err != nil || CloseWrite(dst) != nil {
+			a.Close()
+			b.Close()
+		}
+		done <- struct{}{}
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+	<-done
+	<-done
+	a.Close()
+	b.Close()
+}
+
+// Connect asks the HTTP proxy at the other end of c for a tunnel to target,
+// a host and port. It closes c if the proxy does not open one.
+func Connect(c net.Conn, target string) (net.Conn, error) {
+	if _, err := fmt.Fprintf(c, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target); err != nil {
+		c.Close()
+		return nil, err
+	}
+	rd := bufio.NewReader(c)
+	resp, err := http.ReadResponse(rd, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		c.Close()
+		return nil, fmt.Errorf("%w to %s: %s", ErrRefused, target, resp.Status)
+	}
+	return &BufferedConn{c, rd}, nil
+}
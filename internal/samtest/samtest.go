@@ -0,0 +1,399 @@
+// Package samtest is an in-process SAM bridge for the tests of the packages
+// built on sam3, which run without an I2P router.
+package samtest
+
+import (
+	"bufio"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+// Handler answers a command line on conn. It returns true if it took the
+// connection over, which stops the bridge reading further commands from it.
+type Handler func(line string, conn net.Conn) (hijacked bool)
+
+// Reply makes a Handler of fn, which returns the reply to a command line,
+// or "" to send none.
+func Reply(fn func(line string) string) Handler {
+	return func(line string, conn net.Conn) bool {
+		io.WriteString(conn, fn(line))
+		return false
+	}
+}
+
+// Bridge is a minimal SAM bridge. It answers HELLO, DEST GENERATE, SESSION
+// CREATE and ADD, NAMING LOOKUP for names added with AddName, STREAM CONNECT
+// to destinations added with Forward, and STREAM ACCEPT for streams started
+// with Connect. Other commands get I2P_ERROR unless a Handler is set.
+type Bridge struct {
+	t        testing.TB
+	ln       net.Listener
+	mu       sync.Mutex
+	commands []string
+	handlers map[string]Handler
+	names    map[string]i2pkeys.I2PAddr
+	forwards map[i2pkeys.I2PAddr]string
+	accepts  chan net.Conn
+	udp      *net.UDPConn
+	ports    map[string]string // datagram session ID to its forwarding port
+	sent     chan Datagram
+	open     int
+}
+
+// Datagram is a datagram a session sent through the bridge.
+type Datagram struct {
+	ID, Dest string
+	Payload  []byte
+}
+
+// New starts a bridge which is stopped when the test ends.
+func New(t testing.TB) *Bridge {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Bridge{
+		udp:      udp,
+		ports:    make(map[string]string),
+		sent:     make(chan Datagram, 64),
+		t:        t,
+		ln:       ln,
+		handlers: make(map[string]Handler),
+		names:    make(map[string]i2pkeys.I2PAddr),
+		forwards: make(map[i2pkeys.I2PAddr]string),
+		accepts:  make(chan net.Conn, 16),
+	}
+	b.Handle("DEST GENERATE", func(line string, conn net.Conn) bool {
+		keys := Keys(t)
+		io.WriteString(conn, "DEST REPLY PUB="+keys.Addr().Base64()+" PRIV="+keys.String()+"\n")
+		return false
+	})
+	b.Handle("SESSION CREATE", func(line string, conn net.Conn) bool {
+		b.session(line)
+		io.WriteString(conn, "SESSION STATUS RESULT=OK DESTINATION="+Arg(line, "DESTINATION")+"\n")
+		return false
+	})
+	b.Handle("SESSION ADD", func(line string, conn net.Conn) bool {
+		b.session(line)
+		io.WriteString(conn, "SESSION STATUS RESULT=OK\n")
+		return false
+	})
+	b.Handle("NAMING LOOKUP", b.lookup)
+	b.Handle("STREAM CONNECT", b.connect)
+	b.Handle("STREAM ACCEPT", func(line string, conn net.Conn) bool {
+		b.accepts <- conn
+		return true
+	})
+	go b.serve()
+	go b.serveUDP()
+	t.Cleanup(func() {
+		ln.Close()
+		udp.Close()
+	})
+	return b
+}
+
+// UDPPort is the port the bridge takes datagrams from sessions on.
+func (b *Bridge) UDPPort() int {
+	return b.udp.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Sent returns the next datagram a session sent, waiting up to 5 seconds.
+func (b *Bridge) Sent() (Datagram, error) {
+	select {
+	case d := <-b.sent:
+		return d, nil
+	case <-time.After(5 * time.Second):
+		return Datagram{}, io.ErrNoProgress
+	}
+}
+
+// Deliver sends payload from the destination from to the datagram session
+// called id, the way the bridge forwards datagrams arriving from I2P.
+func (b *Bridge) Deliver(id string, from i2pkeys.I2PAddr, payload []byte) error {
+	b.mu.Lock()
+	port, ok := b.ports[id]
+	b.mu.Unlock()
+	if !ok {
+		return io.ErrClosedPipe
+	}
+	to, err := net.ResolveUDPAddr("udp4", "127.0.0.1:"+port)
+	if err != nil {
+		return err
+	}
+	msg := append([]byte(from.Base64()+" FROM_PORT=0 TO_PORT=0\n"), payload...)
+	_, err = b.udp.WriteToUDP(msg, to)
+	return err
+}
+
+// session remembers the forwarding port of datagram and raw sessions.
+func (b *Bridge) session(line string) {
+	if port := Arg(line, "PORT"); port != "" {
+		b.mu.Lock()
+		b.ports[Arg(line, "ID")] = port
+		b.mu.Unlock()
+	}
+}
+
+func (b *Bridge) serveUDP() {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := b.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		i := strings.IndexByte(string(buf[:n]), '\n')
+		if i < 0 {
+			continue
+		}
+		words := strings.Fields(string(buf[:i]))
+		if len(words) < 3 {
+			continue
+		}
+		b.sent <- Datagram{ID: words[1], Dest: words[2], Payload: append([]byte(nil), buf[i+1:n]...)}
+	}
+}
+
+// Addr is the address of the bridge.
+func (b *Bridge) Addr() string {
+	return b.ln.Addr().String()
+}
+
+// Handle sets the handler for commands starting with cmd, such as
+// "STREAM CONNECT".
+func (b *Bridge) Handle(cmd string, fn Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[cmd] = fn
+}
+
+// AddName makes NAMING LOOKUP resolve name, and the b32 address of addr, to
+// addr.
+func (b *Bridge) AddName(name string, addr i2pkeys.I2PAddr) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.names[name] = addr
+	b.names[addr.Base32()] = addr
+}
+
+// Forward makes STREAM CONNECT to dest connect to the TCP address addr.
+func (b *Bridge) Forward(dest i2pkeys.I2PAddr, addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.forwards[dest] = addr
+}
+
+// Connect starts an incoming stream from the destination from, which the
+// next STREAM ACCEPT receives. It returns the bridge's end of the stream.
+func (b *Bridge) Connect(from i2pkeys.I2PAddr) (net.Conn, error) {
+	select {
+	case conn := <-b.accepts:
+		_, err := io.WriteString(conn, "STREAM STATUS RESULT=OK\n"+from.Base64()+" FROM_PORT=0 TO_PORT=0\n")
+		return conn, err
+	case <-time.After(5 * time.Second):
+		return nil, io.ErrNoProgress
+	}
+}
+
+// Commands returns every command line received so far, except HELLO.
+func (b *Bridge) Commands() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.commands...)
+}
+
+// Command returns the last command line starting with prefix.
+func (b *Bridge) Command(prefix string) string {
+	cmds := b.Commands()
+	for i := len(cmds) - 1; i >= 0; i-- {
+		if strings.HasPrefix(cmds[i], prefix) {
+			return cmds[i]
+		}
+	}
+	return ""
+}
+
+func (b *Bridge) lookup(line string, conn net.Conn) bool {
+	name := Arg(line, "NAME")
+	b.mu.Lock()
+	addr, ok := b.names[name]
+	b.mu.Unlock()
+	if !ok {
+		io.WriteString(conn, "NAMING REPLY RESULT=KEY_NOT_FOUND NAME="+name+"\n")
+		return false
+	}
+	io.WriteString(conn, "NAMING REPLY RESULT=OK NAME="+name+" VALUE="+addr.Base64()+"\n")
+	return false
+}
+
+func (b *Bridge) connect(line string, conn net.Conn) bool {
+	b.mu.Lock()
+	target, ok := b.forwards[i2pkeys.I2PAddr(Arg(line, "DESTINATION"))]
+	b.mu.Unlock()
+	if !ok {
+		io.WriteString(conn, "STREAM STATUS RESULT=CANT_REACH_PEER\n")
+		return false
+	}
+	out, err := net.Dial("tcp", target)
+	if err != nil {
+		io.WriteString(conn, "STREAM STATUS RESULT=CANT_REACH_PEER\n")
+		return false
+	}
+	io.WriteString(conn, "STREAM STATUS RESULT=OK\n")
+	// half closes are passed on, like streams do
+	go func() {
+		io.Copy(out, conn)
+		out.(*net.TCPConn).CloseWrite()
+	}()
+	go func() {
+		io.Copy(conn, out)
+		conn.Close()
+		out.Close()
+	}()
+	return true
+}
+
+func (b *Bridge) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		go b.serveConn(conn)
+	}
+}
+
+// Open returns the number of connections the bridge reads commands from,
+// those which are neither closed nor taken over by a Handler.
+func (b *Bridge) Open() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+func (b *Bridge) serveConn(conn net.Conn) {
+	b.mu.Lock()
+	b.open++
+	b.mu.Unlock()
+	hijacked := false
+	defer func() {
+		b.mu.Lock()
+		b.open--
+		b.mu.Unlock()
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+	// read byte by byte so nothing after a command line is buffered away
+	// from a handler which takes the connection over
+	rd := bufio.NewReaderSize(oneByteReader{conn}, 16)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "HELLO VERSION") {
+			io.WriteString(conn, "HELLO REPLY RESULT=OK VERSION=3.3\n")
+			continue
+		}
+		if line == "PING" || strings.HasPrefix(line, "PING ") {
+			io.WriteString(conn, "PONG"+strings.TrimPrefix(line, "PING")+"\n")
+			continue
+		}
+		words := strings.Fields(line)
+		if len(words) < 2 {
+			continue
+		}
+		b.mu.Lock()
+		b.commands = append(b.commands, line)
+		fn := b.handlers[words[0]+" "+words[1]]
+		b.mu.Unlock()
+		if fn == nil {
+			io.WriteString(conn, words[0]+" STATUS RESULT=I2P_ERROR MESSAGE=\"not implemented\"\n")
+			continue
+		}
+		if hijacked = fn(line, conn); hijacked {
+			return
+		}
+	}
+}
+
+type oneByteReader struct{ r io.Reader }
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return o.r.Read(p)
+}
+
+// Arg returns the value of KEY=value in a command line, unquoted.
+func Arg(line, key string) string {
+	for _, w := range tokenize(line) {
+		if i := strings.IndexByte(w, '='); i >= 0 && w[:i] == key {
+			return w[i+1:]
+		}
+	}
+	return ""
+}
+
+func tokenize(line string) []string {
+	var words []string
+	var cur strings.Builder
+	quoted, escaped, in := false, false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted, in = !quoted, true
+		case r == ' ' && !quoted:
+			if in {
+				words = append(words, cur.String())
+				cur.Reset()
+				in = false
+			}
+		default:
+			cur.WriteRune(r)
+			in = true
+		}
+	}
+	if in {
+		words = append(words, cur.String())
+	}
+	return words
+}
+
+// Keys returns random keys with an Ed25519 key certificate. They are not
+// usable for signing but look like real keys to the library.
+func Keys(t testing.TB) i2pkeys.I2PKeys {
+	dest := make([]byte, 384)
+	if _, err := rand.Read(dest); err != nil {
+		t.Fatal(err)
+	}
+	dest = append(dest, 5, 0, 4, 0, 7, 0, 4)
+	addr, err := i2pkeys.NewI2PAddrFromBytes(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := make([]byte, 256+64)
+	rand.Read(priv)
+	both, _ := i2pkeys.NewI2PAddrFromBytes(append(dest, priv...))
+	return i2pkeys.NewKeys(addr, both.Base64())
+}
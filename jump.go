@@ -0,0 +1,320 @@
+package sam3
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+var i2pB64enc = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+// DefaultJumpServices are well known jump services. %s is replaced by the
+// name being looked up.
+var DefaultJumpServices = []string{
+	"http://stats.i2p/cgi-bin/jump.cgi?a=%s",
+	"http://inr.i2p/jump/%s",
+	"http://notbob.i2p/cgi-bin/jump.cgi?q=%s",
+}
+
+// ErrBadHostSignature is returned when a jump service returned an entry whose
+// signature does not match its destination.
+var ErrBadHostSignature = errors.New("host entry signature does not verify")
+
+// JumpResolver looks up names which the router does not know by asking jump
+// services over HTTP, the way a user would follow an addresshelper link.
+// Accepted answers are appended to a local addressbook in hosts.txt format,
+// which is consulted before any jump service is asked again.
+type JumpResolver struct {
+	// Client is used to talk to the jump services. NewJumpResolver sets it
+	// up to dial through a StreamSession.
+	Client *http.Client
+
+	services []string
+	book     string
+	hosts    *HostsFileResolver
+
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+// NewJumpResolver creates a jump service resolver which dials through
+// session and stores accepted destinations in the hosts.txt-format file at
+// book. If no services are given, DefaultJumpServices are used.
+func NewJumpResolver(session *StreamSession, book string, services ...string) (*JumpResolver, error) {
+	if len(services) == 0 {
+		services = DefaultJumpServices
+	}
+	for _, s := range services {
+		if !strings.Contains(s, "%s") {
+			return nil, fmt.Errorf("jump service %q has no %%s for the name", s)
+		}
+	}
+	j := &JumpResolver{
+		services: services,
+		book:     book,
+		active:   make(map[string]bool),
+	}
+	if session != nil {
+		j.Client = &http.Client{
+			Transport: &http.Transport{Dial: session.Dial},
+			Timeout:   2 * time.Minute,
+		}
+	}
+	if book != "" {
+		var err error
+		if j.hosts, err = NewHostsFileResolver(book); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// Resolve returns the destination for name from the local addressbook, or
+// asks each jump service in turn.
+func (j *JumpResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
+	name = strings.ToLower(name)
+	if j.hosts != nil {
+		if addr, err := j.hosts.Resolve(name); err == nil {
+			return addr, nil
+		}
+	}
+	if !strings.HasSuffix(name, ".i2p") || strings.HasSuffix(name, ".b32.i2p") || j.Client == nil {
+		return i2pkeys.I2PAddr(""), notFound(name)
+	}
+	// the session used to reach the jump services may resolve through this
+	// resolver, so refuse to look up a name while already looking it up
+	j.mu.Lock()
+	if j.active[name] || j.isService(name) {
+		j.mu.Unlock()
+		return i2pkeys.I2PAddr(""), notFound(name)
+	}
+	j.active[name] = true
+	j.mu.Unlock()
+	defer func() {
+		j.mu.Lock()
+		delete(j.active, name)
+		j.mu.Unlock()
+	}()
+
+	var err error = notFound(name)
+	for _, service := range j.services {
+		var addr i2pkeys.I2PAddr
+		addr, err = j.jump(service, name)
+		if err != nil {
+			defaultLog().Debug("Jump service lookup failed", "error", err, "service", service, "name", name)
+			continue
+		}
+		if err := j.store(name, addr); err != nil {
+			defaultLog().Warn("Failed to store jump service result", "error", err)
+		}
+		return addr, nil
+	}
+	return i2pkeys.I2PAddr(""), err
+}
+
+func (j *JumpResolver) isService(name string) bool {
+	for _, s := range j.services {
+		if u, err := url.Parse(fmt.Sprintf(s, "x")); err == nil && strings.EqualFold(u.Hostname(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// jump asks one service for name. Services either redirect to an
+// addresshelper link or answer with a page containing one or a hosts.txt
+// line.
+func (j *JumpResolver) jump(service, name string) (i2pkeys.I2PAddr, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf(service, url.QueryEscape(name)), nil)
+	if err != nil {
+		return "", err
+	}
+	client := *j.Client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if loc := resp.Header.Get("Location"); loc != "" {
+		if addr, err := addressHelper(loc, name); err == nil {
+			return addr, nil
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("jump service: %s", resp.Status)
+	}
+	return scanJumpPage(io.LimitReader(resp.Body, 1<<20), name)
+}
+
+// addressHelper extracts the destination from an addresshelper link for name.
+func addressHelper(link, name string) (i2pkeys.I2PAddr, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(u.Hostname(), name) {
+		return "", fmt.Errorf("addresshelper is for %s, not %s", u.Hostname(), name)
+	}
+	dest := u.Query().Get("i2paddresshelper")
+	if dest == "" {
+		return "", errors.New("no i2paddresshelper in link")
+	}
+	return i2pkeys.NewI2PAddrFromString(dest)
+}
+
+// scanJumpPage looks for a hosts.txt line or an addresshelper link for name
+// in a jump service response. Signed hosts.txt lines must verify.
+func scanJumpPage(r io.Reader, name string) (i2pkeys.I2PAddr, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), 64*1024)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(strings.ToLower(line), name+"=") {
+			return VerifyHostEntry(line, name)
+		}
+		if i := strings.Index(line, "i2paddresshelper="); i >= 0 {
+			start := strings.LastIndexAny(line[:i], "\"'> ") + 1
+			end := strings.IndexAny(line[i:], "\"'< ")
+			if end < 0 {
+				end = len(line) - i
+			}
+			link := strings.Replace(line[start:i+end], "&amp;", "&", -1)
+			if addr, err := addressHelper(link, name); err == nil {
+				return addr, nil
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", notFound(name)
+}
+
+// VerifyHostEntry parses a hosts.txt line for name, in the plain name=dest
+// form or the signed name=dest#!key=value#!sig=... form of subscription
+// feeds. If the entry is signed the signature is checked against the
+// destination's own signing key, and ErrBadHostSignature returned if it does
+// not match.
+func VerifyHostEntry(line, name string) (i2pkeys.I2PAddr, error) {
+	parts := strings.Split(strings.TrimSpace(line), "#!")
+	host, dest, ok := splitPair(parts[0])
+	if !ok || !strings.EqualFold(host, name) {
+		return "", fmt.Errorf("host entry is not for %s", name)
+	}
+	addr, err := i2pkeys.NewI2PAddrFromString(dest)
+	if err != nil {
+		return "", err
+	}
+	props := make(map[string]string)
+	for _, p := range parts[1:] {
+		if k, v, ok := splitPair(p); ok {
+			props[k] = v
+		}
+	}
+	sig, signed := props["sig"]
+	if !signed {
+		return addr, nil
+	}
+	delete(props, "sig")
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	msg := parts[0]
+	for _, k := range keys {
+		msg += "#!" + k + "=" + props[k]
+	}
+	sigBytes, err := i2pB64enc.DecodeString(sig)
+	if err != nil {
+		return "", ErrBadHostSignature
+	}
+	if err := verifyDestSignature(addr, []byte(msg), sigBytes); err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+// verifyDestSignature checks sig over msg with the signing key of addr.
+func verifyDestSignature(addr i2pkeys.I2PAddr, msg, sig []byte) error {
+	st, err := AddrSigType(addr)
+	if err != nil {
+		return err
+	}
+	b, _ := addr.ToBytes()
+	spkEnd := destPublicKeyLen + destSigningKeyLen
+	var h hash.Hash
+	var curve elliptic.Curve
+	var keyLen int
+	switch st {
+	case Sig_EdDSA_SHA512_Ed25519:
+		if !ed25519.Verify(ed25519.PublicKey(b[spkEnd-ed25519.PublicKeySize:spkEnd]), msg, sig) {
+			return ErrBadHostSignature
+		}
+		return nil
+	case Sig_ECDSA_SHA256_P256:
+		h, curve, keyLen = sha256.New(), elliptic.P256(), 64
+	case Sig_ECDSA_SHA384_P384:
+		h, curve, keyLen = sha512.New384(), elliptic.P384(), 96
+	default:
+		return fmt.Errorf("can not verify host entries signed with %s", st)
+	}
+	if len(sig) != keyLen {
+		return ErrBadHostSignature
+	}
+	spk := b[spkEnd-keyLen : spkEnd]
+	pub := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(spk[:keyLen/2]),
+		Y:     new(big.Int).SetBytes(spk[keyLen/2:]),
+	}
+	h.Write(msg)
+	r, s := new(big.Int).SetBytes(sig[:keyLen/2]), new(big.Int).SetBytes(sig[keyLen/2:])
+	if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+		return ErrBadHostSignature
+	}
+	return nil
+}
+
+// store appends an accepted destination to the local addressbook.
+func (j *JumpResolver) store(name string, addr i2pkeys.I2PAddr) error {
+	if j.book == "" {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f, err := os.OpenFile(j.book, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s=%s\n", name, addr.Base64()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	defaultLog().Debug("Stored jump service result in addressbook", "name", name)
+	return j.hosts.Reload()
+}
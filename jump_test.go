@@ -0,0 +1,101 @@
+package sam3
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/i2pkeys"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+// signedDestination returns a destination carrying the Ed25519 public key of
+// the returned private key.
+func signedDestination(t *testing.T) (i2pkeys.I2PAddr, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, destPublicKeyLen+destSigningKeyLen)
+	rand.Read(b)
+	copy(b[len(b)-ed25519.PublicKeySize:], pub)
+	b = append(b, certTypeKey, 0, 4, 0, byte(Sig_EdDSA_SHA512_Ed25519), 0, 4)
+	addr, err := i2pkeys.NewI2PAddrFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr, priv
+}
+
+func Test_VerifyHostEntry(t *testing.T) {
+	addr, priv := signedDestination(t)
+	signed := "signed.i2p=" + addr.Base64() + "#!date=1500000000#!action=adddest"
+	// properties are signed in key order
+	msg := "signed.i2p=" + addr.Base64() + "#!action=adddest#!date=1500000000"
+	sig := i2pB64enc.EncodeToString(ed25519.Sign(priv, []byte(msg)))
+
+	if got, err := VerifyHostEntry(signed+"#!sig="+sig, "signed.i2p"); err != nil || got != addr {
+		t.Errorf("valid signature: %v", err)
+	}
+	if _, err := VerifyHostEntry(signed+"#!sig="+sig, "other.i2p"); err == nil {
+		t.Error("accepted an entry for another name")
+	}
+	other, _ := signedDestination(t)
+	forged := "signed.i2p=" + other.Base64() + "#!date=1500000000#!action=adddest#!sig=" + sig
+	if _, err := VerifyHostEntry(forged, "signed.i2p"); err != ErrBadHostSignature {
+		t.Errorf("forged entry: %v", err)
+	}
+	if got, err := VerifyHostEntry("plain.i2p="+addr.Base64(), "plain.i2p"); err != nil || got != addr {
+		t.Errorf("unsigned entry: %v", err)
+	}
+}
+
+func Test_JumpResolver(t *testing.T) {
+	redirected, signed := samtest.Keys(t).Addr(), samtest.Keys(t).Addr()
+	var jumps int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jumps++
+		switch name := strings.TrimPrefix(r.URL.Path, "/jump/"); name {
+		case "redirect.i2p":
+			http.Redirect(w, r, "http://redirect.i2p/?i2paddresshelper="+redirected.Base64(), http.StatusFound)
+		case "page.i2p":
+			fmt.Fprintf(w, "<html><a href=\"http://page.i2p/?i2paddresshelper=%s\">page.i2p</a></html>\n", signed.Base64())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	book := filepath.Join(dir, "jump.txt")
+
+	j, err := NewJumpResolver(nil, book, srv.URL+"/jump/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Client = srv.Client()
+	for name, want := range map[string]i2pkeys.I2PAddr{"redirect.i2p": redirected, "page.i2p": signed} {
+		for i := 0; i < 2; i++ {
+			if addr, err := j.Resolve(name); err != nil || addr != want {
+				t.Errorf("Resolve(%s) = %s, %v", name, addr.Base32(), err)
+			}
+		}
+	}
+	if _, err := j.Resolve("missing.i2p"); err == nil {
+		t.Error("resolved a name the jump service does not know")
+	}
+	if jumps != 3 {
+		t.Errorf("%d requests to the jump service, want 3", jumps)
+	}
+	// accepted names are in the addressbook for other resolvers
+	h, _ := NewHostsFileResolver(book)
+	if addr, err := h.Resolve("redirect.i2p"); err != nil || addr != redirected {
+		t.Errorf("addressbook entry: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package sam3
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// leaseSetTypeEncrypted is the i2cp.leaseSetType of an encrypted LS2.
+const leaseSetTypeEncrypted = "5"
+
+// LeaseSetClient is a client authorised to look up an encrypted leaseset.
+// Key is the client's X25519 public key for LeaseSetAuthDH, or the key shared
+// with the client for LeaseSetAuthPSK.
+type LeaseSetClient struct {
+	Name string `json:"name" yaml:"name"`
+	Key  []byte `json:"key" yaml:"key"`
+}
+
+// GenerateLeaseSetClient creates the keys for a new client of an encrypted
+// leaseset. The LeaseSetClient goes into the server's configuration, the
+// B33Credentials are handed to the client, who enters them into its router.
+func GenerateLeaseSetClient(name string, authType LeaseSetAuthType) (LeaseSetClient, B33Credentials, error) {
+	if err := validClientName(name); err != nil {
+		return LeaseSetClient{}, B33Credentials{}, err
+	}
+	creds := B33Credentials{AuthType: authType}
+	switch authType {
+	case LeaseSetAuthDH:
+		priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return LeaseSetClient{}, B33Credentials{}, err
+		}
+		creds.PrivateKey = priv.Bytes()
+		defaultLog().Debug("Generated DH leaseset client", "client", name)
+		return LeaseSetClient{Name: name, Key: priv.PublicKey().Bytes()}, creds, nil
+	case LeaseSetAuthPSK:
+		psk := make([]byte, 32)
+		if _, err := rand.Read(psk); err != nil {
+			return LeaseSetClient{}, B33Credentials{}, err
+		}
+		creds.PrivateKey = psk
+		defaultLog().Debug("Generated PSK leaseset client", "client", name)
+		return LeaseSetClient{Name: name, Key: append([]byte(nil), psk...)}, creds, nil
+	}
+	return LeaseSetClient{}, B33Credentials{}, fmt.Errorf("invalid leaseset auth type %d", authType)
+}
+
+// validClientName checks that name can be written as the name part of an
+// i2cp.leaseSetClient option, name:b64key.
+func validClientName(name string) error {
+	if name == "" {
+		return fmt.Errorf("leaseset client needs a name")
+	}
+	if strings.ContainsAny(name, ":\r\n") {
+		return fmt.Errorf("leaseset client name %q contains a colon or newline", name)
+	}
+	return nil
+}
+
+// parseLeaseSetClient parses the value of an i2cp.leaseSetClient option,
+// the plain client name and its base64 key.
+func parseLeaseSetClient(v string) (LeaseSetClient, error) {
+	i := strings.LastIndexByte(v, ':')
+	if i < 0 {
+		return LeaseSetClient{}, fmt.Errorf("must be name:b64key")
+	}
+	k, err := i2pB64enc.DecodeString(v[i+1:])
+	if err != nil || len(k) != 32 {
+		return LeaseSetClient{}, fmt.Errorf("key must be 32 bytes of base64")
+	}
+	c := LeaseSetClient{Name: v[:i], Key: k}
+	return c, validClientName(c.Name)
+}
+
+// AddLeaseSetClient authorises a client to look up the leaseset, replacing
+// any client of the same name. It takes effect for sessions created
+// afterwards.
+func (f *I2PConfig) AddLeaseSetClient(c LeaseSetClient) error {
+	if err := validClientName(c.Name); err != nil {
+		return err
+	}
+	if len(c.Key) != 32 {
+		return fmt.Errorf("leaseset client %s: key must be 32 bytes, not %d", c.Name, len(c.Key))
+	}
+	f.RevokeLeaseSetClient(c.Name)
+	f.LeaseSetClients = append(f.LeaseSetClients, c)
+	defaultLog().Debug("Added leaseset client", "client", c.Name)
+	return nil
+}
+
+// RevokeLeaseSetClient removes the client called name and reports whether it
+// was present.
+func (f *I2PConfig) RevokeLeaseSetClient(name string) bool {
+	for i, c := range f.LeaseSetClients {
+		if c.Name == name {
+			f.LeaseSetClients = append(f.LeaseSetClients[:i], f.LeaseSetClients[i+1:]...)
+			defaultLog().Debug("Revoked leaseset client", "client", name)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *I2PConfig) leaseSetAuthOptions() (o sessionOptions) {
+	if f.LeaseSetAuthType == LeaseSetAuthNone && f.LeaseSetSecret == "" {
+		return
+	}
+	o.add("i2cp.leaseSetType", leaseSetTypeEncrypted)
+	o.add("i2cp.leaseSetSecret", f.LeaseSetSecret)
+	if f.LeaseSetAuthType != LeaseSetAuthNone {
+		o.add("i2cp.leaseSetAuthType", strconv.Itoa(int(f.LeaseSetAuthType)))
+		kind := "dh"
+		if f.LeaseSetAuthType == LeaseSetAuthPSK {
+			kind = "psk"
+		}
+		for i, c := range f.LeaseSetClients {
+			o.add(fmt.Sprintf("i2cp.leaseSetClient.%s.%d", kind, i),
+				c.Name+":"+i2pB64enc.EncodeToString(c.Key))
+		}
+	}
+	return
+}
+
+// LeaseSetAuth returns the options for an encrypted LS2 with a lookup
+// password and per-client authorisation, or "" if neither is configured.
+func (f *I2PConfig) LeaseSetAuth() string {
+	o := f.leaseSetAuthOptions()
+	if len(o) == 0 {
+		defaultLog().Debug("Leaseset authorisation not set")
+		return ""
+	}
+	defaultLog().Debug("Leaseset authorisation set", "authType", f.LeaseSetAuthType, "clients", len(f.LeaseSetClients))
+	return o.String()
+}
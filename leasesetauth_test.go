@@ -0,0 +1,89 @@
+package sam3
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func Test_LeaseSetAuthSession(t *testing.T) {
+	alice, aliceCreds, err := GenerateLeaseSetClient("alice", LeaseSetAuthDH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _, err := GenerateLeaseSetClient("bob", LeaseSetAuthDH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emit, err := NewEmit(SetLeaseSetSecret("pass word"), SetLeaseSetAuthType(LeaseSetAuthDH), SetLeaseSetClients(alice, bob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !emit.RevokeLeaseSetClient("bob") || emit.RevokeLeaseSetClient("bob") {
+		t.Error("RevokeLeaseSetClient did not remove bob exactly once")
+	}
+
+	b := samtest.New(t)
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if _, err := sam.NewStreamSession("authTun", samtest.Keys(t), emit.Print()); err != nil {
+		t.Fatal(err)
+	}
+	cmd := b.Command("SESSION CREATE")
+	for key, want := range map[string]string{
+		"i2cp.leaseSetType":     "5",
+		"i2cp.leaseSetAuthType": "1",
+		"i2cp.leaseSetSecret":   "pass word",
+	} {
+		if got := samtest.Arg(cmd, key); got != want {
+			t.Errorf("%s=%q, want %q", key, got, want)
+		}
+	}
+	if strings.Contains(cmd, "i2cp.leaseSetClient.dh.1") {
+		t.Error("revoked client was emitted")
+	}
+	parts := strings.SplitN(samtest.Arg(cmd, "i2cp.leaseSetClient.dh.0"), ":", 2)
+	if len(parts) != 2 {
+		t.Fatalf("no client in %q", cmd)
+	}
+	name := parts[0]
+	key, _ := i2pB64enc.DecodeString(parts[1])
+	// the key the server got belongs to the private key the client got
+	priv, err := ecdh.X25519().NewPrivateKey(aliceCreds.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "alice" || !bytes.Equal(key, priv.PublicKey().Bytes()) {
+		t.Errorf("client %q with key %x does not match", name, key)
+	}
+}
+
+func Test_LeaseSetAuthPSK(t *testing.T) {
+	c, creds, err := GenerateLeaseSetClient("carol", LeaseSetAuthPSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Key, creds.PrivateKey) || creds.AuthType != LeaseSetAuthPSK {
+		t.Error("PSK client and credentials differ")
+	}
+	var conf I2PConfig
+	conf.LeaseSetAuthType = LeaseSetAuthPSK
+	if err := conf.AddLeaseSetClient(c); err != nil {
+		t.Fatal(err)
+	}
+	if opts := conf.LeaseSetAuth(); !strings.Contains(opts, "i2cp.leaseSetClient.psk.0=") {
+		t.Errorf("LeaseSetAuth() = %q", opts)
+	}
+	if err := conf.AddLeaseSetClient(LeaseSetClient{Name: "short", Key: []byte{1}}); err == nil {
+		t.Error("accepted a client with a short key")
+	}
+	if _, _, err := GenerateLeaseSetClient("dave", LeaseSetAuthNone); err == nil {
+		t.Error("generated a client without an auth type")
+	}
+}
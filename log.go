@@ -1,16 +1,30 @@
 package sam3
 
 import (
-	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
 	"strings"
 	"sync"
+
+	"github.com/sirupsen/logrus"
 )
 
+// Logger is what a SAM and its sessions log to. The arguments after msg are
+// alternating keys and values, as with log/slog, so a *slog.Logger is a
+// Logger; NewLogrusLogger adapts logrus. Private keys are never logged.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
 var (
 	log  *logrus.Logger
 	once sync.Once
+
+	defaultMu     sync.RWMutex
+	defaultLogger Logger
 )
 
 func InitializeSAM3Logger() {
@@ -37,14 +51,175 @@ func InitializeSAM3Logger() {
 	})
 }
 
-// GetSAM3Logger returns the initialized logger
+// GetSAM3Logger returns the initialized logger, initializing it on first
+// use.
 func GetSAM3Logger() *logrus.Logger {
-	if log == nil {
-		InitializeSAM3Logger()
-	}
+	InitializeSAM3Logger()
 	return log
 }
 
-func init() {
-	InitializeSAM3Logger()
+// SetDefaultLogger sets the logger of SAMs and sessions which were not
+// given one with SetLogger, and of everything not tied to a SAM, such as
+// option parsing. nil restores the logrus logger configured by DEBUG_I2P.
+func SetDefaultLogger(l Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// DefaultLogger returns the logger set with SetDefaultLogger, or the logrus
+// logger configured by DEBUG_I2P if none was set.
+func DefaultLogger() Logger {
+	return defaultLog()
+}
+
+// defaultLog returns the logger set with SetDefaultLogger.
+func defaultLog() Logger {
+	defaultMu.RLock()
+	l := defaultLogger
+	defaultMu.RUnlock()
+	if l == nil {
+		return logrusLogger{GetSAM3Logger()}
+	}
+	return l
+}
+
+// NewLogrusLogger returns a Logger which logs to l, the key/value pairs
+// becoming fields. A value under the key "error" is logged with WithError.
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	return logrusLogger{l}
+}
+
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+func (l logrusLogger) entry(keyvals []interface{}) logrus.FieldLogger {
+	if len(keyvals) == 0 {
+		return l.l
+	}
+	fields := make(logrus.Fields, len(keyvals)/2+1)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, ok := keyvals[i].(string)
+		if !ok || i+1 == len(keyvals) {
+			// like slog, keep what does not pair up
+			fields["!BADKEY"] = keyvals[i]
+			i--
+			continue
+		}
+		if key == "error" {
+			if err, ok := keyvals[i+1].(error); ok {
+				fields[logrus.ErrorKey] = err
+				continue
+			}
+		}
+		fields[key] = keyvals[i+1]
+	}
+	return l.l.WithFields(fields)
+}
+
+func (l logrusLogger) Debug(msg string, keyvals ...interface{}) { l.entry(keyvals).Debug(msg) }
+func (l logrusLogger) Info(msg string, keyvals ...interface{})  { l.entry(keyvals).Info(msg) }
+func (l logrusLogger) Warn(msg string, keyvals ...interface{})  { l.entry(keyvals).Warn(msg) }
+func (l logrusLogger) Error(msg string, keyvals ...interface{}) { l.entry(keyvals).Error(msg) }
+
+// SetLogger sets the logger of sam and of the sessions created with it
+// afterwards. nil means the default logger, see SetDefaultLogger.
+func (sam *SAM) SetLogger(l Logger) {
+	sam.logger = l
+}
+
+func (sam *SAM) log() Logger {
+	if sam == nil || sam.logger == nil {
+		return defaultLog()
+	}
+	return sam.logger
+}
+
+// SetLogger sets the logger of the session. nil means the default logger.
+func (s *StreamSession) SetLogger(l Logger) {
+	s.logger = l
+}
+
+func (s *StreamSession) log() Logger {
+	if s == nil || s.logger == nil {
+		return defaultLog()
+	}
+	return s.logger
+}
+
+// SetLogger sets the logger of the session. nil means the default logger.
+func (s *DatagramSession) SetLogger(l Logger) {
+	s.logger = l
+}
+
+func (s *DatagramSession) log() Logger {
+	if s == nil || s.logger == nil {
+		return defaultLog()
+	}
+	return s.logger
+}
+
+// SetLogger sets the logger of the session. nil means the default logger.
+func (s *RawSession) SetLogger(l Logger) {
+	s.logger = l
+}
+
+func (s *RawSession) log() Logger {
+	if s == nil || s.logger == nil {
+		return defaultLog()
+	}
+	return s.logger
+}
+
+// SetLogger sets the logger of the session and of the subsessions created
+// afterwards. nil means the default logger.
+func (s *PrimarySession) SetLogger(l Logger) {
+	s.logger = l
+}
+
+func (s *PrimarySession) log() Logger {
+	if s == nil || s.logger == nil {
+		return defaultLog()
+	}
+	return s.logger
+}
+
+// the listener logs with the logger of its session
+func (l *StreamListener) log() Logger {
+	return l.session.log()
+}
+
+// secretKeys are parts of the keys, in lower case, of words in SAM lines and
+// options whose values are never logged.
+var secretKeys = []string{"destination", "priv", "key", "secret", "password"}
+
+// redact replaces the values of private keys, secrets and passwords in a
+// SAM command, reply or option list with "[redacted]", leaving a
+// DESTINATION=TRANSIENT as is. Quoted values come out unquoted.
+func redact(s string) string {
+	words := tokenizeReply(strings.TrimSpace(s))
+	for i, w := range words {
+		j := strings.IndexByte(w, '=')
+		if j < 0 || w[j+1:] == "TRANSIENT" {
+			continue
+		}
+		key := strings.ToLower(w[:j])
+		for _, k := range secretKeys {
+			if strings.Contains(key, k) {
+				words[i] = w[:j+1] + "[redacted]"
+				break
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// redactOptions is redact for a list of options.
+func redactOptions(options []string) []string {
+	out := make([]string, len(options))
+	for i, o := range options {
+		out[i] = redact(o)
+	}
+	return out
 }
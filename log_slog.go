@@ -0,0 +1,20 @@
+//go:build go1.21
+
+package sam3
+
+// log/slog came with Go 1.21, while the module still builds with 1.20, which
+// is why this file has a build constraint.
+
+import "log/slog"
+
+// a *slog.Logger logs key/value pairs just like Logger wants them
+var _ Logger = (*slog.Logger)(nil)
+
+// NewSlogLogger returns a Logger which logs to l, or to slog.Default() if l
+// is nil.
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
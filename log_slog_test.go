@@ -0,0 +1,20 @@
+//go:build go1.21
+
+package sam3
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func Test_SlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	SetDefaultLogger(NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	defer SetDefaultLogger(nil)
+	SetInLength(9)(&SAMEmit{})
+	if !strings.Contains(buf.String(), `level=ERROR msg="Invalid inbound tunnel length" inLength=9`) {
+		t.Errorf("logged %q", buf.String())
+	}
+}
@@ -0,0 +1,98 @@
+package sam3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+// recordLogger is a Logger which keeps what is logged, formatted.
+type recordLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *recordLogger) record(level, msg string, keyvals []interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, fmt.Sprint(append([]interface{}{level, msg}, keyvals...)...))
+}
+
+func (r *recordLogger) Debug(msg string, keyvals ...interface{}) { r.record("DEBUG", msg, keyvals) }
+func (r *recordLogger) Info(msg string, keyvals ...interface{})  { r.record("INFO", msg, keyvals) }
+func (r *recordLogger) Warn(msg string, keyvals ...interface{})  { r.record("WARN", msg, keyvals) }
+func (r *recordLogger) Error(msg string, keyvals ...interface{}) { r.record("ERROR", msg, keyvals) }
+
+func (r *recordLogger) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.lines, "\n")
+}
+
+func Test_SAMLogger(t *testing.T) {
+	b := samtest.New(t)
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	rec := new(recordLogger)
+	sam.SetLogger(rec)
+	keys := samtest.Keys(t)
+	s, err := sam.NewStreamSession("logged", keys, []string{"i2cp.leaseSetPrivateKey=secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.log() != Logger(rec) {
+		t.Error("the session does not log to the logger of its SAM")
+	}
+	logged := rec.String()
+	if !strings.Contains(logged, "Sending SESSION CREATE message") {
+		t.Fatalf("nothing logged:\n%s", logged)
+	}
+	priv := keys.String()
+	if strings.Contains(logged, priv[:64]) || strings.Contains(logged, "=secret") {
+		t.Errorf("secrets logged:\n%s", logged)
+	}
+
+	other := new(recordLogger)
+	s.SetLogger(other)
+	s.SetDeadline(s.Deadline)
+	if other.String() == "" {
+		t.Error("SetLogger on the session had no effect")
+	}
+}
+
+func Test_Redact(t *testing.T) {
+	for in, want := range map[string]string{
+		"SESSION CREATE STYLE=STREAM ID=a DESTINATION=abc~ SIGNATURE_TYPE=7 i2cp.leaseSetPrivateKey=xyz": "SESSION CREATE STYLE=STREAM ID=a DESTINATION=[redacted] SIGNATURE_TYPE=7 i2cp.leaseSetPrivateKey=[redacted]",
+		"SESSION CREATE STYLE=STREAM ID=a DESTINATION=TRANSIENT":                                         "SESSION CREATE STYLE=STREAM ID=a DESTINATION=TRANSIENT",
+		"DEST REPLY PUB=pub PRIV=priv\n":                                                                 "DEST REPLY PUB=pub PRIV=[redacted]",
+		`HELLO VERSION MIN=3.0 MAX=3.3 USER=me PASSWORD="pass word"`:                                     "HELLO VERSION MIN=3.0 MAX=3.3 USER=me PASSWORD=[redacted]",
+		"i2cp.leaseSetSecret=s inbound.length=2":                                                         "i2cp.leaseSetSecret=[redacted] inbound.length=2",
+	} {
+		if got := redact(in); got != want {
+			t.Errorf("redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func Test_LogrusLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.SetLevel(logrus.DebugLevel)
+	l.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true, DisableColors: true})
+	NewLogrusLogger(l).Warn("failed", "id", "x", "error", errors.New("boom"), "dangling")
+	if got := buf.String(); got != "level=warning msg=failed !BADKEY=dangling error=boom id=x\n" {
+		t.Errorf("logged %q", got)
+	}
+}
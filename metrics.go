@@ -0,0 +1,150 @@
+package sam3
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics is told what a SAM and its sessions do, so that it can be counted;
+// the prometheus sub-package exports it as Prometheus metrics. Every call
+// names the session it is about by its id and style, STREAM, DATAGRAM, RAW
+// or PRIMARY, which older routers call MASTER. Packet is called for every
+// datagram, so implementations must be cheap, and all of them safe for
+// concurrent use.
+type Metrics interface {
+	// SessionCreated is called for every SESSION CREATE and SESSION ADD
+	// sent, with the RESULT of the bridge's reply: OK if the session was
+	// created, ResultNoReply if the bridge did not answer.
+	SessionCreated(id, style, result string)
+	// Dialed is called when a StreamSession's STREAM CONNECT is done, with
+	// how long it took and the RESULT of the reply.
+	Dialed(id, style string, d time.Duration, result string)
+	// Accepted is called for every stream a StreamListener returns.
+	Accepted(id, style string)
+	// Resolved is called for every name looked up by a SAMResolver, also
+	// one in a ChainResolver, with whether it was answered from the cache.
+	// Lookups not made through a session have an empty id and style.
+	Resolved(id, style string, cached bool)
+	// Packet is called for every datagram a DatagramSession or RawSession
+	// sends or receives, with the size of its payload.
+	Packet(id, style string, dir Direction, size int)
+	// SessionClosed is called when a session is closed, so that what was
+	// counted about its id can be forgotten. It may be called more than
+	// once for a session.
+	SessionClosed(id, style string)
+}
+
+// Direction tells Metrics.Packet whether a datagram was sent or received.
+type Direction string
+
+const (
+	PacketSent     Direction = "sent"
+	PacketReceived Direction = "received"
+)
+
+// ResultNoReply is the result reported to Metrics for commands to which the
+// bridge did not reply.
+const ResultNoReply = "NO_REPLY"
+
+var (
+	metricsMu      sync.RWMutex
+	defaultMetrics Metrics
+)
+
+// SetDefaultMetrics sets the Metrics of SAMs and sessions which were not
+// given one with SetMetrics. nil, the default, counts nothing.
+func SetDefaultMetrics(m Metrics) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	defaultMetrics = m
+}
+
+func defaultMetricsOrNone() Metrics {
+	metricsMu.RLock()
+	m := defaultMetrics
+	metricsMu.RUnlock()
+	if m == nil {
+		return noMetrics{}
+	}
+	return m
+}
+
+// noMetrics counts nothing.
+type noMetrics struct{}
+
+func (noMetrics) SessionCreated(id, style, result string)                 {}
+func (noMetrics) Dialed(id, style string, d time.Duration, result string) {}
+func (noMetrics) Accepted(id, style string)                               {}
+func (noMetrics) Resolved(id, style string, cached bool)                  {}
+func (noMetrics) Packet(id, style string, dir Direction, size int)        {}
+func (noMetrics) SessionClosed(id, style string)                          {}
+
+// SetMetrics sets the Metrics of sam and of the sessions created with it
+// afterwards. nil means the default, see SetDefaultMetrics.
+func (sam *SAM) SetMetrics(m Metrics) {
+	sam.metrics = m
+}
+
+func (sam *SAM) stats() Metrics {
+	if sam == nil || sam.metrics == nil {
+		return defaultMetricsOrNone()
+	}
+	return sam.metrics
+}
+
+// SetMetrics sets the Metrics of the session. nil means the default.
+func (s *StreamSession) SetMetrics(m Metrics) {
+	s.metrics = m
+}
+
+func (s *StreamSession) stats() Metrics {
+	if s == nil || s.metrics == nil {
+		return defaultMetricsOrNone()
+	}
+	return s.metrics
+}
+
+// SetMetrics sets the Metrics of the session. nil means the default.
+func (s *DatagramSession) SetMetrics(m Metrics) {
+	s.metrics = m
+}
+
+func (s *DatagramSession) stats() Metrics {
+	if s == nil || s.metrics == nil {
+		return defaultMetricsOrNone()
+	}
+	return s.metrics
+}
+
+// SetMetrics sets the Metrics of the session. nil means the default.
+func (s *RawSession) SetMetrics(m Metrics) {
+	s.metrics = m
+}
+
+func (s *RawSession) stats() Metrics {
+	if s == nil || s.metrics == nil {
+		return defaultMetricsOrNone()
+	}
+	return s.metrics
+}
+
+// SetMetrics sets the Metrics of the session and of the subsessions created
+// afterwards. nil means the default.
+func (s *PrimarySession) SetMetrics(m Metrics) {
+	s.metrics = m
+}
+
+func (s *PrimarySession) stats() Metrics {
+	if s == nil || s.metrics == nil {
+		return defaultMetricsOrNone()
+	}
+	return s.metrics
+}
+
+// replyResult returns the RESULT of a reply for Metrics.
+func replyResult(reply string) string {
+	if r := replyValue(reply, "RESULT"); r != "" {
+		return r
+	}
+	return ResultNoReply
+}
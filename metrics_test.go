@@ -0,0 +1,155 @@
+package sam3
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+// recordMetrics is a Metrics which keeps what it is told, formatted.
+type recordMetrics struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordMetrics) record(args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, strings.TrimSpace(fmt.Sprintln(args...)))
+}
+
+func (r *recordMetrics) SessionCreated(id, style, result string) {
+	r.record("created", id, style, result)
+}
+
+func (r *recordMetrics) Dialed(id, style string, d time.Duration, result string) {
+	r.record("dialed", id, style, result)
+}
+
+func (r *recordMetrics) Accepted(id, style string) {
+	r.record("accepted", id, style)
+}
+
+func (r *recordMetrics) Resolved(id, style string, cached bool) {
+	r.record("resolved", id, style, cached)
+}
+
+func (r *recordMetrics) Packet(id, style string, dir Direction, size int) {
+	r.record("packet", id, style, dir, size)
+}
+
+func (r *recordMetrics) SessionClosed(id, style string) {
+	r.record("closed", id, style)
+}
+
+func (r *recordMetrics) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.events, "\n")
+}
+
+func Test_Metrics(t *testing.T) {
+	b := samtest.New(t)
+	remote := samtest.Keys(t).Addr()
+	// the bridge can not reach peer.i2p, as nothing is forwarded to it
+	b.AddName("peer.i2p", remote)
+	b.Handle("STREAM ACCEPT", samtest.Reply(func(string) string {
+		return "STREAM STATUS RESULT=OK\n" + remote.Base64() + " FROM_PORT=0 TO_PORT=0\n"
+	}))
+	rec := new(recordMetrics)
+	newSAM := func() *SAM {
+		sam, err := NewSAM(b.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sam.Close() })
+		sam.SetMetrics(rec)
+		return sam
+	}
+
+	s, err := newSAM().NewStreamSession("counted", samtest.Keys(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Dial("tcp", "peer.i2p"); err == nil {
+		t.Error("dial did not fail")
+	}
+	s.Lookup("peer.i2p")
+	l, err := s.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	s.Close()
+
+	b.Handle("SESSION CREATE", samtest.Reply(func(string) string {
+		return "SESSION STATUS RESULT=DUPLICATED_ID\n"
+	}))
+	if _, err := newSAM().NewStreamSession("counted", samtest.Keys(t), nil); err == nil {
+		t.Error("duplicate session created")
+	}
+
+	got := "\n" + rec.String() + "\n"
+	for _, want := range []string{
+		"created counted STREAM OK",
+		"resolved counted STREAM false",
+		"dialed counted STREAM CANT_REACH_PEER",
+		"resolved counted STREAM true",
+		"accepted counted STREAM",
+		"created counted STREAM DUPLICATED_ID",
+		"closed counted STREAM",
+	} {
+		if !strings.Contains(got, "\n"+want+"\n") {
+			t.Errorf("%q not counted in:\n%s", want, got)
+		}
+	}
+}
+
+func Test_DatagramMetrics(t *testing.T) {
+	b := samtest.New(t)
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	rec := new(recordMetrics)
+	sam.SetMetrics(rec)
+	keys := samtest.Keys(t)
+	s, err := sam.NewDatagramSession("dgram", keys, nil, udp.LocalAddr().(*net.UDPAddr).Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.WriteTo([]byte("hello"), keys.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := udp.WriteToUDP([]byte(keys.Addr().Base64()+"\nhello, world"), s.udpconn.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := s.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	want := "created dgram DATAGRAM OK\npacket dgram DATAGRAM sent 5\npacket dgram DATAGRAM received 12\nclosed dgram DATAGRAM"
+	if got := rec.String(); got != want {
+		t.Errorf("counted\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,339 @@
+package sam3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+// AcceptPolicy decides which incoming streams a StreamListener hands to the
+// application. See StreamListener.SetPolicy.
+type AcceptPolicy interface {
+	// Allow is called with the remote destination of every incoming
+	// stream. If it returns an error the stream is closed and the listener
+	// waits for the next one.
+	Allow(remote i2pkeys.I2PAddr) error
+	// Done is called once when a stream which was allowed is closed.
+	Done(remote i2pkeys.I2PAddr)
+}
+
+// Reasons a ConnPolicy rejects a stream. The errors returned by Allow wrap
+// one of them.
+var (
+	ErrAccessDenied   = errors.New("destination not allowed by access list")
+	ErrTooManyStreams = errors.New("too many concurrent streams from destination")
+	ErrRateLimited    = errors.New("accept rate exceeded")
+	ErrBanned         = errors.New("destination is banned")
+)
+
+// PolicyHooks are called by a ConnPolicy as it makes decisions, for metrics
+// or logging. Any of them may be nil. They are called without the policy's
+// lock held.
+type PolicyHooks struct {
+	OnAccept func(remote i2pkeys.I2PAddr)
+	OnReject func(remote i2pkeys.I2PAddr, reason error)
+	OnBan    func(remote i2pkeys.I2PAddr, until time.Time)
+	OnClose  func(remote i2pkeys.I2PAddr)
+}
+
+// ConnPolicy is an AcceptPolicy for public services. It enforces, in this
+// order, an access list, temporary bans, a cap on concurrent streams per
+// remote destination, and token bucket limits on the accept rate per
+// destination and overall. Destinations which keep hitting the limits are
+// banned for a while. The zero value allows everything; the fields must not
+// be changed once the policy is in use, the access list and bans may be.
+type ConnPolicy struct {
+	// MaxPerDest caps the open streams per remote destination, 0 for no cap
+	MaxPerDest int
+	// Rate and Burst limit the accepts per second per destination; Rate 0
+	// means no limit. Burst defaults to 1.
+	Rate  float64
+	Burst int
+	// GlobalRate and GlobalBurst limit the accepts per second from all
+	// destinations together
+	GlobalRate  float64
+	GlobalBurst int
+	// BanAfter bans a destination for BanDuration once it has been
+	// rejected this many times in a row by the limits above. 0 disables
+	// automatic bans.
+	BanAfter    int
+	BanDuration time.Duration
+	Hooks       PolicyHooks
+
+	mu        sync.Mutex
+	listType  string          // "whitelist", "blacklist" or ""
+	list      map[string]bool // b32 addresses
+	dests     map[string]*destState
+	bans      map[string]time.Time
+	global    tokenBucket
+	lastSweep time.Time
+	now       func() time.Time // for tests
+}
+
+// destIdle is how long a destination without open streams is remembered
+// after it was last seen.
+const destIdle = 10 * time.Minute
+
+type destState struct {
+	active   int
+	strikes  int
+	bucket   tokenBucket
+	lastSeen time.Time
+}
+
+// tokenBucket holds up to burst tokens and gains rate tokens per second.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refund returns a token taken at the last take.
+func (b *tokenBucket) refund() {
+	b.tokens++
+}
+
+// full reports whether the bucket would be full at now, so forgetting it
+// changes nothing.
+func (b *tokenBucket) full(now time.Time, rate float64, burst int) bool {
+	if burst < 1 {
+		burst = 1
+	}
+	return b.last.IsZero() || rate == 0 || b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst)
+}
+
+func (p *ConnPolicy) time() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// policyKey returns the b32 address of an access list entry, which may be a
+// .b32.i2p address, a base64 destination or the base64 destination hash
+// used by i2cp.accessList.
+func policyKey(entry string) (string, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.HasSuffix(strings.ToLower(entry), ".b32.i2p") && len(entry) == b32Len+len(".b32.i2p") {
+		return strings.ToLower(entry), nil
+	}
+	if b, err := i2pB64enc.DecodeString(entry); err == nil && len(b) == 32 {
+		h, err := i2pkeys.DestHashFromBytes(b)
+		if err != nil {
+			return "", err
+		}
+		return h.String(), nil
+	}
+	addr, err := i2pkeys.NewI2PAddrFromString(entry)
+	if err != nil {
+		return "", fmt.Errorf("invalid access list entry %q", entry)
+	}
+	return addr.Base32(), nil
+}
+
+// SetAccessList replaces the access list. listType is "whitelist",
+// "blacklist" or "none" as for SetAccessListType; entries are .b32.i2p
+// addresses, base64 destinations or base64 destination hashes. It can be
+// called at any time, unlike i2cp.accessList which is fixed when the session
+// is created.
+func (p *ConnPolicy) SetAccessList(listType string, entries []string) error {
+	switch listType {
+	case "whitelist", "blacklist":
+	case "none", "":
+		listType = ""
+	default:
+		return fmt.Errorf("Invalid Access list type(whitelist, blacklist, none)")
+	}
+	list := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		k, err := policyKey(e)
+		if err != nil {
+			return err
+		}
+		list[k] = true
+	}
+	p.mu.Lock()
+	p.listType, p.list = listType, list
+	p.mu.Unlock()
+	defaultLog().Debug("Updated policy access list", "type", listType, "entries", len(list))
+	return nil
+}
+
+// SetAccessListFromConfig loads the access list of an I2PConfig, so that
+// the policy enforces the same list as the router and can be updated later.
+func (p *ConnPolicy) SetAccessListFromConfig(f *I2PConfig) error {
+	return p.SetAccessList(f.AccessListType, f.AccessList)
+}
+
+// Ban rejects streams from remote until d has passed, for instance after the
+// application detected abuse. Open streams are not affected.
+func (p *ConnPolicy) Ban(remote i2pkeys.I2PAddr, d time.Duration) {
+	until := p.time().Add(d)
+	p.mu.Lock()
+	p.ban(remote.Base32(), until)
+	p.mu.Unlock()
+	p.banned(remote, until)
+}
+
+// Unban lifts a ban on remote.
+func (p *ConnPolicy) Unban(remote i2pkeys.I2PAddr) {
+	p.mu.Lock()
+	delete(p.bans, remote.Base32())
+	p.mu.Unlock()
+}
+
+func (p *ConnPolicy) ban(key string, until time.Time) {
+	if p.bans == nil {
+		p.bans = make(map[string]time.Time)
+	}
+	p.bans[key] = until
+}
+
+func (p *ConnPolicy) banned(remote i2pkeys.I2PAddr, until time.Time) {
+	defaultLog().Warn("Banned destination", "remote", remote.Base32(), "until", until)
+	if p.Hooks.OnBan != nil {
+		p.Hooks.OnBan(remote, until)
+	}
+}
+
+// Active returns the number of open streams from remote.
+func (p *ConnPolicy) Active(remote i2pkeys.I2PAddr) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d := p.dests[remote.Base32()]; d != nil {
+		return d.active
+	}
+	return 0
+}
+
+// Allow implements AcceptPolicy.
+func (p *ConnPolicy) Allow(remote i2pkeys.I2PAddr) error {
+	key := remote.Base32()
+	now := p.time()
+	p.mu.Lock()
+	banUntil, err := p.allow(key, now)
+	p.mu.Unlock()
+
+	if !banUntil.IsZero() {
+		p.banned(remote, banUntil)
+	}
+	if err != nil {
+		defaultLog().Debug("Rejected incoming stream", "remote", key, "reason", err)
+		if p.Hooks.OnReject != nil {
+			p.Hooks.OnReject(remote, err)
+		}
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if p.Hooks.OnAccept != nil {
+		p.Hooks.OnAccept(remote)
+	}
+	return nil
+}
+
+// allow makes the decision for Allow with p.mu held. If the destination is
+// banned by this call, banUntil is set.
+func (p *ConnPolicy) allow(key string, now time.Time) (banUntil time.Time, err error) {
+	p.sweep(now)
+	switch {
+	case p.listType == "whitelist" && !p.list[key]:
+		return time.Time{}, ErrAccessDenied
+	case p.listType == "blacklist" && p.list[key]:
+		return time.Time{}, ErrAccessDenied
+	}
+	if until, ok := p.bans[key]; ok {
+		if now.Before(until) {
+			return time.Time{}, ErrBanned
+		}
+		delete(p.bans, key)
+	}
+	if p.dests == nil {
+		p.dests = make(map[string]*destState)
+	}
+	d := p.dests[key]
+	if d == nil {
+		d = &destState{}
+		p.dests[key] = d
+	}
+	d.lastSeen = now
+	switch {
+	case p.MaxPerDest > 0 && d.active >= p.MaxPerDest:
+		err = ErrTooManyStreams
+	case p.Rate > 0 && !d.bucket.take(now, p.Rate, p.Burst):
+		err = ErrRateLimited
+	case p.GlobalRate > 0 && !p.global.take(now, p.GlobalRate, p.GlobalBurst):
+		// not the destination's fault, so no strike, and the stream it
+		// did not get must not count against its own rate
+		if p.Rate > 0 {
+			d.bucket.refund()
+		}
+		return time.Time{}, ErrRateLimited
+	}
+	if err == nil {
+		d.strikes = 0
+		d.active++
+		return time.Time{}, nil
+	}
+	d.strikes++
+	if p.BanAfter > 0 && d.strikes >= p.BanAfter && p.BanDuration > 0 {
+		d.strikes = 0
+		banUntil = now.Add(p.BanDuration)
+		p.ban(key, banUntil)
+	}
+	return banUntil, err
+}
+
+// Done implements AcceptPolicy.
+func (p *ConnPolicy) Done(remote i2pkeys.I2PAddr) {
+	p.mu.Lock()
+	if d := p.dests[remote.Base32()]; d != nil && d.active > 0 {
+		d.active--
+	}
+	p.mu.Unlock()
+	if p.Hooks.OnClose != nil {
+		p.Hooks.OnClose(remote)
+	}
+}
+
+// sweep forgets, at most once a minute, destinations without open streams
+// whose state has returned to the initial one or which have not been seen
+// for destIdle, and expired bans. Bans are kept in p.bans, so forgetting a
+// destination's strikes does not lift one.
+func (p *ConnPolicy) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < time.Minute {
+		return
+	}
+	p.lastSweep = now
+	for k, d := range p.dests {
+		if d.active == 0 && (d.strikes == 0 && d.bucket.full(now, p.Rate, p.Burst) || now.Sub(d.lastSeen) >= destIdle) {
+			delete(p.dests, k)
+		}
+	}
+	for k, until := range p.bans {
+		if !now.Before(until) {
+			delete(p.bans, k)
+		}
+	}
+}
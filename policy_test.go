@@ -0,0 +1,194 @@
+package sam3
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func Test_ConnPolicy(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var bans []time.Time
+	p := &ConnPolicy{
+		MaxPerDest:  2,
+		Rate:        1,
+		Burst:       2,
+		BanAfter:    2,
+		BanDuration: time.Minute,
+		Hooks:       PolicyHooks{OnBan: func(_ i2pkeys.I2PAddr, until time.Time) { bans = append(bans, until) }},
+		now:         func() time.Time { return now },
+	}
+	a, b := samtest.Keys(t).Addr(), samtest.Keys(t).Addr()
+
+	// concurrent cap
+	for i := 0; i < 2; i++ {
+		if err := p.Allow(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Allow(a); !errors.Is(err, ErrTooManyStreams) {
+		t.Fatalf("third stream: %v", err)
+	}
+	p.Done(a)
+	// the burst of 2 is used up although a stream was closed
+	if err := p.Allow(a); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("rate: %v", err)
+	}
+	// second strike in a row bans
+	if len(bans) != 1 || !bans[0].Equal(now.Add(time.Minute)) {
+		t.Fatalf("bans = %v", bans)
+	}
+	now = now.Add(30 * time.Second)
+	p.Done(a)
+	if err := p.Allow(a); !errors.Is(err, ErrBanned) {
+		t.Fatalf("banned: %v", err)
+	}
+	if err := p.Allow(b); err != nil {
+		t.Fatalf("other destination: %v", err)
+	}
+	now = now.Add(31 * time.Second)
+	if err := p.Allow(a); err != nil {
+		t.Fatalf("after ban: %v", err)
+	}
+
+	// access lists can be changed while in use
+	if err := p.SetAccessList("whitelist", []string{b.Base32()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Allow(a); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("whitelist: %v", err)
+	}
+	if err := p.SetAccessList("blacklist", []string{i2pB64enc.EncodeToString(hashOf(b))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Allow(b); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("blacklist by hash: %v", err)
+	}
+	if err := p.SetAccessList("bogus", nil); err == nil {
+		t.Error("invalid list type accepted")
+	}
+
+	// idle destinations are forgotten, those with open streams are not
+	p.SetAccessList("none", nil)
+	p.Done(a)
+	now = now.Add(time.Hour)
+	if err := p.Allow(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.dests[a.Base32()]; ok || p.Active(b) != 2 {
+		t.Errorf("after sweep: a remembered %v, b active %d", ok, p.Active(b))
+	}
+}
+
+func Test_ConnPolicyGlobalRate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := &ConnPolicy{
+		Rate:        0.1,
+		Burst:       1,
+		GlobalRate:  1,
+		GlobalBurst: 1,
+		now:         func() time.Time { return now },
+	}
+	a, b := samtest.Keys(t).Addr(), samtest.Keys(t).Addr()
+	if err := p.Allow(a); err != nil {
+		t.Fatal(err)
+	}
+	// b is turned away by the global limit without using up its own token
+	if err := p.Allow(b); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("global rate: %v", err)
+	}
+	now = now.Add(time.Second)
+	if err := p.Allow(b); err != nil {
+		t.Fatalf("own token spent by the global limit: %v", err)
+	}
+}
+
+func Test_ConnPolicyForgetsIdle(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := &ConnPolicy{BanAfter: 3, BanDuration: time.Hour, now: func() time.Time { return now }}
+	a, b := samtest.Keys(t).Addr(), samtest.Keys(t).Addr()
+	// a leaves a strike behind and b keeps a stream open
+	p.MaxPerDest = 1
+	if err := p.Allow(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Allow(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Allow(a); !errors.Is(err, ErrTooManyStreams) {
+		t.Fatalf("second stream: %v", err)
+	}
+	p.Done(a)
+	now = now.Add(time.Minute)
+	p.Allow(b)
+	if _, ok := p.dests[a.Base32()]; !ok {
+		t.Fatal("destination with strikes forgotten before destIdle")
+	}
+	now = now.Add(destIdle)
+	p.Allow(b)
+	if _, ok := p.dests[a.Base32()]; ok {
+		t.Error("idle destination with strikes not forgotten")
+	}
+	if _, ok := p.dests[b.Base32()]; !ok {
+		t.Error("destination with an open stream forgotten")
+	}
+}
+
+func hashOf(a i2pkeys.I2PAddr) []byte {
+	h := a.DestHash()
+	return h[:]
+}
+
+func Test_StreamListenerPolicy(t *testing.T) {
+	a, b := samtest.Keys(t).Addr(), samtest.Keys(t).Addr()
+	remotes := []i2pkeys.I2PAddr{a, a, b}
+	var mu sync.Mutex
+	br := samtest.New(t)
+	br.Handle("STREAM ACCEPT", samtest.Reply(func(string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(remotes) == 0 {
+			return "STREAM STATUS RESULT=I2P_ERROR\n"
+		}
+		r := remotes[0]
+		remotes = remotes[1:]
+		return "STREAM STATUS RESULT=OK\n" + r.Base64() + " FROM_PORT=0 TO_PORT=0\n"
+	}))
+	sam, err := NewSAM(br.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("policyTun", samtest.Keys(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ss.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closed []i2pkeys.I2PAddr
+	policy := &ConnPolicy{MaxPerDest: 1, Hooks: PolicyHooks{OnClose: func(r i2pkeys.I2PAddr) { closed = append(closed, r) }}}
+	l.SetPolicy(policy)
+
+	c1, err := l.AcceptI2P()
+	if err != nil || c1.RemoteAddr() != a {
+		t.Fatalf("first accept: %v %v", c1, err)
+	}
+	// the second stream from a is rejected, so b comes next
+	c2, err := l.AcceptI2P()
+	if err != nil || c2.RemoteAddr() != b {
+		t.Fatalf("second accept: %v", err)
+	}
+	c1.Close()
+	c1.Close()
+	if policy.Active(a) != 0 || len(closed) != 1 {
+		t.Errorf("close not reported once: active %d, closed %d", policy.Active(a), len(closed))
+	}
+	c2.Close()
+}
@@ -3,7 +3,6 @@ package sam3
 import (
 	"errors"
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"math/rand"
 	"net"
 	"strconv"
@@ -22,7 +21,7 @@ func randport() string {
 	r := rand.New(s)
 	p := r.Intn(55534) + 10000
 	port := strconv.Itoa(p)
-	log.WithField("port", port).Debug("Generated random port")
+	defaultLog().Debug("Generated random port", "port", port)
 	return strconv.Itoa(p)
 }
 
@@ -34,10 +33,16 @@ type PrimarySession struct {
 	keys     i2pkeys.I2PKeys // i2p destination keys
 	Timeout  time.Duration
 	Deadline time.Time
-	sigType  string
+	sigType  SigType
 	Config   SAMEmit
 	stsess   map[string]*StreamSession
 	dgsess   map[string]*DatagramSession
+	resolver Resolver // optional, see SetResolver
+	logger   Logger   // nil for the default, see SetLogger
+	metrics  Metrics  // nil for the default, see SetMetrics
+	style    string   // PRIMARY or MASTER, as created
+
+	validation ValidationMode // that of the SAM, for SESSION ADD
 	//	from     string
 	//	to       string
 }
@@ -50,7 +55,7 @@ func (ss *PrimarySession) To() string {
 	return "0"
 }
 
-func (ss *PrimarySession) SignatureType() string {
+func (ss *PrimarySession) SignatureType() SigType {
 	return ss.sigType
 }
 
@@ -60,6 +65,7 @@ func (ss *PrimarySession) ID() string {
 }
 
 func (ss *PrimarySession) Close() error {
+	ss.stats().SessionClosed(ss.id, ss.style)
 	return ss.conn.Close()
 }
 
@@ -79,7 +85,7 @@ func (ss *PrimarySession) Keys() i2pkeys.I2PKeys {
 }
 
 func (sam *PrimarySession) Dial(network, addr string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"network": network, "addr": addr}).Debug("Dial() called")
+	sam.log().Debug("Dial() called", "network", network, "addr", addr)
 	if network == "udp" || network == "udp4" || network == "udp6" {
 		//return sam.DialUDPI2P(network, network+addr[0:4], addr)
 		return sam.DialUDPI2P(network, network+addr[0:4], addr)
@@ -88,19 +94,19 @@ func (sam *PrimarySession) Dial(network, addr string) (net.Conn, error) {
 		//return sam.DialTCPI2P(network, network+addr[0:4], addr)
 		return sam.DialTCPI2P(network, network+addr[0:4], addr)
 	}
-	log.WithField("network", network).Error("Invalid network type")
+	sam.log().Error("Invalid network type", "network", network)
 	return nil, fmt.Errorf("Error: Must specify a valid network type")
 }
 
 // DialTCP implements x/dialer
 func (sam *PrimarySession) DialTCP(network string, laddr, raddr net.Addr) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"network": network, "laddr": laddr, "raddr": raddr}).Debug("DialTCP() called")
+	sam.log().Debug("DialTCP() called", "network", network, "laddr", laddr, "raddr", raddr)
 	ts, ok := sam.stsess[network+raddr.String()[0:4]]
 	var err error
 	if !ok {
 		ts, err = sam.NewUniqueStreamSubSession(network + raddr.String()[0:4])
 		if err != nil {
-			log.WithError(err).Error("Failed to create new unique stream sub-session")
+			sam.log().Error("Failed to create new unique stream sub-session", "error", err)
 			return nil, err
 		}
 		sam.stsess[network+raddr.String()[0:4]] = ts
@@ -110,13 +116,13 @@ func (sam *PrimarySession) DialTCP(network string, laddr, raddr net.Addr) (net.C
 }
 
 func (sam *PrimarySession) DialTCPI2P(network string, laddr, raddr string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"network": network, "laddr": laddr, "raddr": raddr}).Debug("DialTCPI2P() called")
+	sam.log().Debug("DialTCPI2P() called", "network", network, "laddr", laddr, "raddr", raddr)
 	ts, ok := sam.stsess[network+raddr[0:4]]
 	var err error
 	if !ok {
 		ts, err = sam.NewUniqueStreamSubSession(network + laddr)
 		if err != nil {
-			log.WithError(err).Error("Failed to create new unique stream sub-session")
+			sam.log().Error("Failed to create new unique stream sub-session", "error", err)
 			return nil, err
 		}
 		sam.stsess[network+raddr[0:4]] = ts
@@ -127,13 +133,13 @@ func (sam *PrimarySession) DialTCPI2P(network string, laddr, raddr string) (net.
 
 // DialUDP implements x/dialer
 func (sam *PrimarySession) DialUDP(network string, laddr, raddr net.Addr) (net.PacketConn, error) {
-	log.WithFields(logrus.Fields{"network": network, "laddr": laddr, "raddr": raddr}).Debug("DialUDP() called")
+	sam.log().Debug("DialUDP() called", "network", network, "laddr", laddr, "raddr", raddr)
 	ds, ok := sam.dgsess[network+raddr.String()[0:4]]
 	var err error
 	if !ok {
 		ds, err = sam.NewDatagramSubSession(network+raddr.String()[0:4], 0)
 		if err != nil {
-			log.WithError(err).Error("Failed to create new datagram sub-session")
+			sam.log().Error("Failed to create new datagram sub-session", "error", err)
 			return nil, err
 		}
 		sam.dgsess[network+raddr.String()[0:4]] = ds
@@ -143,13 +149,13 @@ func (sam *PrimarySession) DialUDP(network string, laddr, raddr net.Addr) (net.P
 }
 
 func (sam *PrimarySession) DialUDPI2P(network, laddr, raddr string) (*DatagramSession, error) {
-	log.WithFields(logrus.Fields{"network": network, "laddr": laddr, "raddr": raddr}).Debug("DialUDPI2P() called")
+	sam.log().Debug("DialUDPI2P() called", "network", network, "laddr", laddr, "raddr", raddr)
 	ds, ok := sam.dgsess[network+raddr[0:4]]
 	var err error
 	if !ok {
 		ds, err = sam.NewDatagramSubSession(network+laddr, 0)
 		if err != nil {
-			log.WithError(err).Error("Failed to create new datagram sub-session")
+			sam.log().Error("Failed to create new datagram sub-session", "error", err)
 			return nil, err
 		}
 		sam.dgsess[network+raddr[0:4]] = ds
@@ -158,76 +164,77 @@ func (sam *PrimarySession) DialUDPI2P(network, laddr, raddr string) (*DatagramSe
 	return ds.Dial(network, raddr)
 }
 
+// SetResolver sets the resolver used by Lookup and Resolve, and by
+// subsessions created afterwards. By default lookups from all sessions on the
+// same SAM bridge share one cached SAMResolver.
+func (s *PrimarySession) SetResolver(r Resolver) {
+	s.resolver = r
+}
+
 func (s *PrimarySession) Lookup(name string) (a net.Addr, err error) {
-	log.WithField("name", name).Debug("Lookup() called")
-	var sam *SAM
+	s.log().Debug("Lookup() called", "name", name)
+	var r Resolver
 	name = strings.Split(name, ":")[0]
-	sam, err = NewSAM(s.samAddr)
+	r, err = sessionResolver(s.resolver, s.samAddr)
 	if err == nil {
-		log.WithField("addr", a).Debug("Lookup successful")
-		defer sam.Close()
-		a, err = sam.Lookup(name)
+		a, err = sessionResolve(r, name, s.stats(), s.id, PrimarySessionSwitch)
+	}
+	if err != nil {
+		s.log().Error("Lookup failed", "error", err)
+		return
 	}
-	log.WithError(err).Error("Lookup failed")
+	s.log().Debug("Lookup successful", "addr", a)
 	return
 }
 
 func (sam *PrimarySession) Resolve(network, addr string) (net.Addr, error) {
-	log.WithFields(logrus.Fields{"network": network, "addr": addr}).Debug("Resolve() called")
+	sam.log().Debug("Resolve() called", "network", network, "addr", addr)
 	return sam.Lookup(addr)
 }
 
 func (sam *PrimarySession) ResolveTCPAddr(network, dest string) (net.Addr, error) {
-	log.WithFields(logrus.Fields{"network": network, "dest": dest}).Debug("ResolveTCPAddr() called")
+	sam.log().Debug("ResolveTCPAddr() called", "network", network, "dest", dest)
 	return sam.Lookup(dest)
 }
 
 func (sam *PrimarySession) ResolveUDPAddr(network, dest string) (net.Addr, error) {
-	log.WithFields(logrus.Fields{"network": network, "dest": dest}).Debug("ResolveUDPAddr() called")
+	sam.log().Debug("ResolveUDPAddr() called", "network", network, "dest", dest)
 	return sam.Lookup(dest)
 }
 
 // Creates a new PrimarySession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *SAM) NewPrimarySession(id string, keys i2pkeys.I2PKeys, options []string) (*PrimarySession, error) {
-	log.WithFields(logrus.Fields{"id": id, "options": options}).Debug("NewPrimarySession() called")
+	sam.log().Debug("NewPrimarySession() called", "id", id, "options", redactOptions(options))
 	return sam.newPrimarySession(PrimarySessionSwitch, id, keys, options)
 }
 
 func (sam *SAM) newPrimarySession(primarySessionSwitch string, id string, keys i2pkeys.I2PKeys, options []string) (*PrimarySession, error) {
-	log.WithFields(logrus.Fields{
-		"primarySessionSwitch": primarySessionSwitch,
-		"id":                   id,
-		"options":              options,
-	}).Debug("newPrimarySession() called")
+	sam.log().Debug("newPrimarySession() called", "primarySessionSwitch", primarySessionSwitch, "id", id, "options", redactOptions(options))
 
 	conn, err := sam.newGenericSession(primarySessionSwitch, id, keys, options, []string{})
 	if err != nil {
-		log.WithError(err).Error("Failed to create new generic session")
+		sam.log().Error("Failed to create new generic session", "error", err)
 		return nil, err
 	}
 	ssesss := make(map[string]*StreamSession)
 	dsesss := make(map[string]*DatagramSession)
-	return &PrimarySession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), Sig_NONE, sam.Config, ssesss, dsesss}, nil
+	return &PrimarySession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), keysSigType(keys), sam.Config, ssesss, dsesss, nil, sam.logger, sam.metrics, primarySessionSwitch, sam.validation}, nil
 }
 
 // Creates a new PrimarySession with the I2CP- and PRIMARYinglib options as
 // specified. See the I2P documentation for a full list of options.
-func (sam *SAM) NewPrimarySessionWithSignature(id string, keys i2pkeys.I2PKeys, options []string, sigType string) (*PrimarySession, error) {
-	log.WithFields(logrus.Fields{
-		"id":      id,
-		"options": options,
-		"sigType": sigType,
-	}).Debug("NewPrimarySessionWithSignature() called")
+func (sam *SAM) NewPrimarySessionWithSignature(id string, keys i2pkeys.I2PKeys, options []string, sigType SigType) (*PrimarySession, error) {
+	sam.log().Debug("NewPrimarySessionWithSignature() called", "id", id, "options", redactOptions(options), "sigType", sigType)
 
 	conn, err := sam.newGenericSessionWithSignature(PrimarySessionSwitch, id, keys, sigType, options, []string{})
 	if err != nil {
-		log.WithError(err).Error("Failed to create new generic session with signature")
+		sam.log().Error("Failed to create new generic session with signature", "error", err)
 		return nil, err
 	}
 	ssesss := make(map[string]*StreamSession)
 	dsesss := make(map[string]*DatagramSession)
-	return &PrimarySession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), sigType, sam.Config, ssesss, dsesss}, nil
+	return &PrimarySession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), sigType, sam.Config, ssesss, dsesss, nil, sam.logger, sam.metrics, PrimarySessionSwitch, sam.validation}, nil
 }
 
 // Creates a new session with the style of either "STREAM", "DATAGRAM" or "RAW",
@@ -236,12 +243,12 @@ func (sam *SAM) NewPrimarySessionWithSignature(id string, keys i2pkeys.I2PKeys,
 // setting extra to something else than []string{}.
 // This sam3 instance is now a session
 func (sam *PrimarySession) newGenericSubSession(style, id string, extras []string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"style": style, "id": id, "extras": extras}).Debug("newGenericSubSession called")
+	sam.log().Debug("newGenericSubSession called", "style", style, "id", id, "extras", extras)
 	return sam.newGenericSubSessionWithSignature(style, id, extras)
 }
 
 func (sam *PrimarySession) newGenericSubSessionWithSignature(style, id string, extras []string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"style": style, "id": id, "extras": extras}).Debug("newGenericSubSessionWithSignature called")
+	sam.log().Debug("newGenericSubSessionWithSignature called", "style", style, "id", id, "extras", extras)
 	return sam.newGenericSubSessionWithSignatureAndPorts(style, id, "0", "0", extras)
 }
 
@@ -251,30 +258,27 @@ func (sam *PrimarySession) newGenericSubSessionWithSignature(style, id string, e
 // setting extra to something else than []string{}.
 // This sam3 instance is now a session
 func (sam *PrimarySession) newGenericSubSessionWithSignatureAndPorts(style, id, from, to string, extras []string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"style": style, "id": id, "from": from, "to": to, "extras": extras}).Debug("newGenericSubSessionWithSignatureAndPorts called")
+	sam.log().Debug("newGenericSubSessionWithSignatureAndPorts called", "style", style, "id", id, "from", from, "to", to, "extras", extras)
 
-	conn := sam.conn
-	fp := ""
-	tp := ""
-	if from != "0" && from != "" {
-		fp = " FROM_PORT=" + from
+	if err := sam.checkOptions(extras); err != nil {
+		return nil, fmt.Errorf("subsession %s: %w", id, err)
 	}
-	if to != "0" && to != "" {
-		tp = " TO_PORT=" + to
-	}
-	scmsg := []byte("SESSION ADD STYLE=" + style + " ID=" + id + fp + tp + " " + strings.Join(extras, " ") + "\n")
+	conn := sam.conn
+	scmsg := []byte(sam.Config.AddSession(style, id, from, to, extras...))
 
-	log.WithField("message", string(scmsg)).Debug("Sending SESSION ADD message")
+	sam.log().Debug("Sending SESSION ADD message", "message", redact(string(scmsg)))
 
 	for m, i := 0, 0; m != len(scmsg); i++ {
 		if i == 15 {
 			conn.Close()
-			log.Error("Writing to SAM failed after 15 attempts")
+			sam.log().Error("Writing to SAM failed after 15 attempts")
+			sam.stats().SessionCreated(id, style, ResultNoReply)
 			return nil, errors.New("writing to SAM failed")
 		}
 		n, err := conn.Write(scmsg[m:])
 		if err != nil {
-			log.WithError(err).Error("Failed to write to SAM connection")
+			sam.log().Error("Failed to write to SAM connection", "error", err)
+			sam.stats().SessionCreated(id, style, ResultNoReply)
 			conn.Close()
 			return nil, err
 		}
@@ -283,38 +287,40 @@ func (sam *PrimarySession) newGenericSubSessionWithSignatureAndPorts(style, id,
 	buf := make([]byte, 4096)
 	n, err := conn.Read(buf)
 	if err != nil {
-		log.WithError(err).Error("Failed to read from SAM connection")
+		sam.log().Error("Failed to read from SAM connection", "error", err)
+		sam.stats().SessionCreated(id, style, ResultNoReply)
 		conn.Close()
 		return nil, err
 	}
 	text := string(buf[:n])
-	log.WithField("response", text).Debug("Received response from SAM")
+	sam.stats().SessionCreated(id, style, replyResult(text))
+	sam.log().Debug("Received response from SAM", "response", redact(text))
 	//log.Println("SAM:", text)
 	if strings.HasPrefix(text, session_ADDOK) {
 		//if sam.keys.String() != text[len(session_ADDOK):len(text)-1] {
 		//conn.Close()
 		//return nil, errors.New("SAMv3 created a tunnel with keys other than the ones we asked it for")
 		//}
-		log.Debug("Session added successfully")
+		sam.log().Debug("Session added successfully")
 		return conn, nil //&StreamSession{id, conn, keys, nil, sync.RWMutex{}, nil}, nil
 	} else if text == session_DUPLICATE_ID {
-		log.Error("Duplicate tunnel name")
+		sam.log().Error("Duplicate tunnel name")
 		conn.Close()
 		return nil, errors.New("Duplicate tunnel name")
 	} else if text == session_DUPLICATE_DEST {
-		log.Error("Duplicate destination")
+		sam.log().Error("Duplicate destination")
 		conn.Close()
 		return nil, errors.New("Duplicate destination")
 	} else if text == session_INVALID_KEY {
-		log.Error("Invalid key - Primary Session")
+		sam.log().Error("Invalid key - Primary Session")
 		conn.Close()
 		return nil, errors.New("Invalid key - Primary Session")
 	} else if strings.HasPrefix(text, session_I2P_ERROR) {
-		log.WithField("error", text[len(session_I2P_ERROR):]).Error("I2P error")
+		sam.log().Error("I2P error", "error", text[len(session_I2P_ERROR):])
 		conn.Close()
 		return nil, errors.New("I2P error " + text[len(session_I2P_ERROR):])
 	} else {
-		log.WithField("reply", text).Error("Unable to parse SAMv3 reply")
+		sam.log().Error("Unable to parse SAMv3 reply", "reply", redact(text))
 		conn.Close()
 		return nil, errors.New("Unable to parse SAMv3 reply: " + text)
 	}
@@ -323,40 +329,40 @@ func (sam *PrimarySession) newGenericSubSessionWithSignatureAndPorts(style, id,
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *PrimarySession) NewStreamSubSession(id string) (*StreamSession, error) {
-	log.WithField("id", id).Debug("NewStreamSubSession called")
+	sam.log().Debug("NewStreamSubSession called", "id", id)
 	conn, err := sam.newGenericSubSession("STREAM", id, []string{})
 	if err != nil {
-		log.WithError(err).Error("Failed to create new generic sub-session")
+		sam.log().Error("Failed to create new generic sub-session", "error", err)
 		return nil, err
 	}
-	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, sam.keys, time.Duration(600 * time.Second), time.Now(), Sig_NONE, "0", "0"}, nil
+	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, sam.keys, time.Duration(600 * time.Second), time.Now(), sam.sigType, "0", "0", sam.resolver, sam.logger, sam.metrics}, nil
 }
 
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *PrimarySession) NewUniqueStreamSubSession(id string) (*StreamSession, error) {
-	log.WithField("id", id).Debug("NewUniqueStreamSubSession called")
+	sam.log().Debug("NewUniqueStreamSubSession called", "id", id)
 	conn, err := sam.newGenericSubSession("STREAM", id, []string{})
 	if err != nil {
-		log.WithError(err).Error("Failed to create new generic sub-session")
+		sam.log().Error("Failed to create new generic sub-session", "error", err)
 		return nil, err
 	}
 	fromPort, toPort := randport(), randport()
-	log.WithFields(logrus.Fields{"fromPort": fromPort, "toPort": toPort}).Debug("Generated random ports")
+	sam.log().Debug("Generated random ports", "fromPort", fromPort, "toPort", toPort)
 	//return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, sam.keys, time.Duration(600 * time.Second), time.Now(), Sig_NONE, randport(), randport()}, nil
-	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, sam.keys, time.Duration(600 * time.Second), time.Now(), Sig_NONE, fromPort, toPort}, nil
+	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, sam.keys, time.Duration(600 * time.Second), time.Now(), sam.sigType, fromPort, toPort, sam.resolver, sam.logger, sam.metrics}, nil
 }
 
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *PrimarySession) NewStreamSubSessionWithPorts(id, from, to string) (*StreamSession, error) {
-	log.WithFields(logrus.Fields{"id": id, "from": from, "to": to}).Debug("NewStreamSubSessionWithPorts called")
+	sam.log().Debug("NewStreamSubSessionWithPorts called", "id", id, "from", from, "to", to)
 	conn, err := sam.newGenericSubSessionWithSignatureAndPorts("STREAM", id, from, to, []string{})
 	if err != nil {
-		log.WithError(err).Error("Failed to create new generic sub-session with signature and ports")
+		sam.log().Error("Failed to create new generic sub-session with signature and ports", "error", err)
 		return nil, err
 	}
-	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, sam.keys, time.Duration(600 * time.Second), time.Now(), Sig_NONE, from, to}, nil
+	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, sam.keys, time.Duration(600 * time.Second), time.Now(), sam.sigType, from, to, sam.resolver, sam.logger, sam.metrics}, nil
 }
 
 /*
@@ -372,111 +378,111 @@ func (s *PrimarySession) I2PListener(name string) (*StreamListener, error) {
 // Creates a new datagram session. udpPort is the UDP port SAM is listening on,
 // and if you set it to zero, it will use SAMs standard UDP port.
 func (s *PrimarySession) NewDatagramSubSession(id string, udpPort int) (*DatagramSession, error) {
-	log.WithFields(logrus.Fields{"id": id, "udpPort": udpPort}).Debug("NewDatagramSubSession called")
+	s.log().Debug("NewDatagramSubSession called", "id", id, "udpPort", udpPort)
 	if udpPort > 65335 || udpPort < 0 {
-		log.WithField("udpPort", udpPort).Error("Invalid UDP port")
+		s.log().Error("Invalid UDP port", "udpPort", udpPort)
 		return nil, errors.New("udpPort needs to be in the intervall 0-65335")
 	}
 	if udpPort == 0 {
 		udpPort = 7655
-		log.Debug("Using default UDP port 7655")
+		s.log().Debug("Using default UDP port 7655")
 	}
 	lhost, _, err := SplitHostPort(s.conn.LocalAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to split local host port")
+		s.log().Error("Failed to split local host port", "error", err)
 		s.Close()
 		return nil, err
 	}
 	lUDPAddr, err := net.ResolveUDPAddr("udp4", lhost+":0")
 	if err != nil {
-		log.WithError(err).Error("Failed to resolve local UDP address")
+		s.log().Error("Failed to resolve local UDP address", "error", err)
 		return nil, err
 	}
 	udpconn, err := net.ListenUDP("udp4", lUDPAddr)
 	if err != nil {
-		log.WithError(err).Error("Failed to listen on UDP")
+		s.log().Error("Failed to listen on UDP", "error", err)
 		return nil, err
 	}
 	rhost, _, err := SplitHostPort(s.conn.RemoteAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to split remote host port")
+		s.log().Error("Failed to split remote host port", "error", err)
 		s.Close()
 		return nil, err
 	}
 	rUDPAddr, err := net.ResolveUDPAddr("udp4", rhost+":"+strconv.Itoa(udpPort))
 	if err != nil {
-		log.WithError(err).Error("Failed to resolve remote UDP address")
+		s.log().Error("Failed to resolve remote UDP address", "error", err)
 		return nil, err
 	}
 	_, lport, err := net.SplitHostPort(udpconn.LocalAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to get local port")
+		s.log().Error("Failed to get local port", "error", err)
 		s.Close()
 		return nil, err
 	}
 	conn, err := s.newGenericSubSession("DATAGRAM", id, []string{"PORT=" + lport})
 	if err != nil {
-		log.WithError(err).Error("Failed to create new generic sub-session")
+		s.log().Error("Failed to create new generic sub-session", "error", err)
 		return nil, err
 	}
 
-	log.WithFields(logrus.Fields{"id": id, "localPort": lport}).Debug("Created new datagram sub-session")
-	return &DatagramSession{s.Config.I2PConfig.Sam(), id, conn, udpconn, s.keys, rUDPAddr, nil}, nil
+	s.log().Debug("Created new datagram sub-session", "id", id, "localPort", lport)
+	return &DatagramSession{s.Config.I2PConfig.Sam(), id, conn, udpconn, s.keys, rUDPAddr, nil, s.resolver, s.logger, s.metrics}, nil
 }
 
 // Creates a new raw session. udpPort is the UDP port SAM is listening on,
 // and if you set it to zero, it will use SAMs standard UDP port.
 func (s *PrimarySession) NewRawSubSession(id string, udpPort int) (*RawSession, error) {
-	log.WithFields(logrus.Fields{"id": id, "udpPort": udpPort}).Debug("NewRawSubSession called")
+	s.log().Debug("NewRawSubSession called", "id", id, "udpPort", udpPort)
 
 	if udpPort > 65335 || udpPort < 0 {
-		log.WithField("udpPort", udpPort).Error("Invalid UDP port")
+		s.log().Error("Invalid UDP port", "udpPort", udpPort)
 		return nil, errors.New("udpPort needs to be in the intervall 0-65335")
 	}
 	if udpPort == 0 {
 		udpPort = 7655
-		log.Debug("Using default UDP port 7655")
+		s.log().Debug("Using default UDP port 7655")
 	}
 	lhost, _, err := SplitHostPort(s.conn.LocalAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to split local host port")
+		s.log().Error("Failed to split local host port", "error", err)
 		s.Close()
 		return nil, err
 	}
 	lUDPAddr, err := net.ResolveUDPAddr("udp4", lhost+":0")
 	if err != nil {
-		log.WithError(err).Error("Failed to resolve local UDP address")
+		s.log().Error("Failed to resolve local UDP address", "error", err)
 		return nil, err
 	}
 	udpconn, err := net.ListenUDP("udp4", lUDPAddr)
 	if err != nil {
-		log.WithError(err).Error("Failed to listen on UDP")
+		s.log().Error("Failed to listen on UDP", "error", err)
 		return nil, err
 	}
 	rhost, _, err := SplitHostPort(s.conn.RemoteAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to split remote host port")
+		s.log().Error("Failed to split remote host port", "error", err)
 		s.Close()
 		return nil, err
 	}
 	rUDPAddr, err := net.ResolveUDPAddr("udp4", rhost+":"+strconv.Itoa(udpPort))
 	if err != nil {
-		log.WithError(err).Error("Failed to resolve remote UDP address")
+		s.log().Error("Failed to resolve remote UDP address", "error", err)
 		return nil, err
 	}
 	_, lport, err := net.SplitHostPort(udpconn.LocalAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to get local port")
+		s.log().Error("Failed to get local port", "error", err)
 		s.Close()
 		return nil, err
 	}
 	//	conn, err := s.newGenericSubSession("RAW", id, s.keys, options, []string{"PORT=" + lport})
 	conn, err := s.newGenericSubSession("RAW", id, []string{"PORT=" + lport})
 	if err != nil {
-		log.WithError(err).Error("Failed to create new generic sub-session")
+		s.log().Error("Failed to create new generic sub-session", "error", err)
 		return nil, err
 	}
 
-	log.WithFields(logrus.Fields{"id": id, "localPort": lport}).Debug("Created new raw sub-session")
-	return &RawSession{s.Config.I2PConfig.Sam(), id, conn, udpconn, s.keys, rUDPAddr}, nil
+	s.log().Debug("Created new raw sub-session", "id", id, "localPort", lport)
+	return &RawSession{s.Config.I2PConfig.Sam(), id, conn, udpconn, s.keys, rUDPAddr, s.logger, s.metrics}, nil
 }
@@ -0,0 +1,30 @@
+module github.com/go-i2p/sam3/prometheus
+
+go 1.23.0
+
+// v0.34.0 is the first sam3 release with Metrics.SessionClosed. Tag the root
+// module with it before tagging this one.
+require (
+	github.com/go-i2p/sam3 v0.34.0
+	github.com/prometheus/client_golang v1.23.2
+)
+
+require (
+	filippo.io/edwards25519 v1.1.1 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/go-i2p/i2pkeys v0.0.0-20241108200332-e4f5ccdff8c4 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.66.1 // indirect
+	github.com/prometheus/procfs v0.16.1 // indirect
+	github.com/sirupsen/logrus v1.9.3 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	google.golang.org/protobuf v1.36.8 // indirect
+)
+
+// Within the repository, build against the sam3 next to this module.
+replace github.com/go-i2p/sam3 => ../
@@ -0,0 +1,161 @@
+// Package prometheus exports what SAMs and their sessions do as Prometheus
+// metrics. A Metrics is both a sam3.Metrics, to be given to SetMetrics or
+// SetDefaultMetrics, and a prometheus.Collector, to be registered:
+//
+//	m, err := prometheus.New()
+//	...
+//	registry.MustRegister(m)
+//	sam.SetMetrics(m)
+//
+// Every metric is labelled with the id and style of the session it is
+// about, and the series of an id are deleted when its session is closed, so
+// that tunnels named at random do not pile up. These are the names with the
+// default namespace:
+//
+//	sam3_sessions_created_total{id,style,result}   SESSION CREATE and ADD by RESULT
+//	sam3_dial_duration_seconds{id,style,result}    STREAM CONNECT latency by RESULT
+//	sam3_accepts_total{id,style}                   streams accepted
+//	sam3_resolver_cache_hits_total{id,style}       lookups answered from the cache
+//	sam3_resolver_cache_misses_total{id,style}     lookups sent to the bridge
+//	sam3_packets_total{id,style,direction}         datagrams sent and received
+//	sam3_packet_bytes_total{id,style,direction}    their payload bytes
+//
+// The package is a module of its own, so that sam3 does not depend on the
+// Prometheus client.
+package prometheus
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-i2p/sam3"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics counts what it is told as sam3.Metrics in Prometheus metrics.
+type Metrics struct {
+	namespace   string
+	dialBuckets []float64
+
+	sessions *stdprometheus.CounterVec
+	dials    *stdprometheus.HistogramVec
+	accepts  *stdprometheus.CounterVec
+	hits     *stdprometheus.CounterVec
+	misses   *stdprometheus.CounterVec
+	packets  *stdprometheus.CounterVec
+	bytes    *stdprometheus.CounterVec
+}
+
+var _ sam3.Metrics = (*Metrics)(nil)
+var _ stdprometheus.Collector = (*Metrics)(nil)
+
+// SetNamespace sets the prefix of the metric names, "sam3" by default.
+func SetNamespace(namespace string) func(*Metrics) error {
+	return func(m *Metrics) error {
+		m.namespace = namespace
+		return nil
+	}
+}
+
+// SetDialBuckets sets the buckets of the dial latency histogram, in
+// seconds. Building a path to a destination takes seconds, so the default
+// buckets range from a quarter of a second to two minutes.
+func SetDialBuckets(buckets []float64) func(*Metrics) error {
+	return func(m *Metrics) error {
+		if len(buckets) == 0 {
+			return errors.New("no dial buckets")
+		}
+		m.dialBuckets = buckets
+		return nil
+	}
+}
+
+// New creates the metrics. They still need to be registered.
+func New(opts ...func(*Metrics) error) (*Metrics, error) {
+	m := &Metrics{
+		namespace:   "sam3",
+		dialBuckets: stdprometheus.ExponentialBuckets(0.25, 2, 10),
+	}
+	for _, o := range opts {
+		if err := o(m); err != nil {
+			return nil, err
+		}
+	}
+	counter := func(name, help string, labels ...string) *stdprometheus.CounterVec {
+		return stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+			Namespace: m.namespace,
+			Name:      name,
+			Help:      help,
+		}, append([]string{"id", "style"}, labels...))
+	}
+	m.sessions = counter("sessions_created_total", "SESSION CREATE and SESSION ADD commands by the RESULT of the reply.", "result")
+	m.dials = stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+		Namespace: m.namespace,
+		Name:      "dial_duration_seconds",
+		Help:      "How long STREAM CONNECT took, by the RESULT of the reply.",
+		Buckets:   m.dialBuckets,
+	}, []string{"id", "style", "result"})
+	m.accepts = counter("accepts_total", "Streams accepted.")
+	m.hits = counter("resolver_cache_hits_total", "Name lookups answered from the resolver cache.")
+	m.misses = counter("resolver_cache_misses_total", "Name lookups not answered from the resolver cache.")
+	m.packets = counter("packets_total", "Datagrams sent and received.", "direction")
+	m.bytes = counter("packet_bytes_total", "Payload bytes of the datagrams sent and received.", "direction")
+	return m, nil
+}
+
+func (m *Metrics) collectors() []stdprometheus.Collector {
+	return []stdprometheus.Collector{m.sessions, m.dials, m.accepts, m.hits, m.misses, m.packets, m.bytes}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *stdprometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- stdprometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// SessionCreated implements sam3.Metrics.
+func (m *Metrics) SessionCreated(id, style, result string) {
+	m.sessions.WithLabelValues(id, style, result).Inc()
+}
+
+// Dialed implements sam3.Metrics.
+func (m *Metrics) Dialed(id, style string, d time.Duration, result string) {
+	m.dials.WithLabelValues(id, style, result).Observe(d.Seconds())
+}
+
+// Accepted implements sam3.Metrics.
+func (m *Metrics) Accepted(id, style string) {
+	m.accepts.WithLabelValues(id, style).Inc()
+}
+
+// Resolved implements sam3.Metrics.
+func (m *Metrics) Resolved(id, style string, cached bool) {
+	if cached {
+		m.hits.WithLabelValues(id, style).Inc()
+	} else {
+		m.misses.WithLabelValues(id, style).Inc()
+	}
+}
+
+// SessionClosed implements sam3.Metrics, deleting the series of id.
+func (m *Metrics) SessionClosed(id, style string) {
+	labels := stdprometheus.Labels{"id": id}
+	m.dials.DeletePartialMatch(labels)
+	for _, c := range []*stdprometheus.CounterVec{m.sessions, m.accepts, m.hits, m.misses, m.packets, m.bytes} {
+		c.DeletePartialMatch(labels)
+	}
+}
+
+// Packet implements sam3.Metrics.
+func (m *Metrics) Packet(id, style string, dir sam3.Direction, size int) {
+	m.packets.WithLabelValues(id, style, string(dir)).Inc()
+	m.bytes.WithLabelValues(id, style, string(dir)).Add(float64(size))
+}
@@ -0,0 +1,106 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/sam3"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_Metrics(t *testing.T) {
+	m, err := New(SetDialBuckets([]float64{1, 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := stdprometheus.NewPedanticRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatal(err)
+	}
+	m.SessionCreated("a", "STREAM", "OK")
+	m.SessionCreated("a", "STREAM", "DUPLICATED_ID")
+	m.SessionCreated("a", "STREAM", "DUPLICATED_ID")
+	m.Dialed("a", "STREAM", 2*time.Second, "OK")
+	m.Accepted("a", "STREAM")
+	m.Resolved("a", "STREAM", true)
+	m.Resolved("a", "STREAM", false)
+	m.Packet("d", "DATAGRAM", sam3.PacketSent, 100)
+	m.Packet("d", "DATAGRAM", sam3.PacketSent, 20)
+	m.Packet("d", "DATAGRAM", sam3.PacketReceived, 7)
+
+	want := `
+# HELP sam3_accepts_total Streams accepted.
+# TYPE sam3_accepts_total counter
+sam3_accepts_total{id="a",style="STREAM"} 1
+# HELP sam3_dial_duration_seconds How long STREAM CONNECT took, by the RESULT of the reply.
+# TYPE sam3_dial_duration_seconds histogram
+sam3_dial_duration_seconds_bucket{id="a",result="OK",style="STREAM",le="1"} 0
+sam3_dial_duration_seconds_bucket{id="a",result="OK",style="STREAM",le="10"} 1
+sam3_dial_duration_seconds_bucket{id="a",result="OK",style="STREAM",le="+Inf"} 1
+sam3_dial_duration_seconds_sum{id="a",result="OK",style="STREAM"} 2
+sam3_dial_duration_seconds_count{id="a",result="OK",style="STREAM"} 1
+# HELP sam3_packet_bytes_total Payload bytes of the datagrams sent and received.
+# TYPE sam3_packet_bytes_total counter
+sam3_packet_bytes_total{direction="received",id="d",style="DATAGRAM"} 7
+sam3_packet_bytes_total{direction="sent",id="d",style="DATAGRAM"} 120
+# HELP sam3_packets_total Datagrams sent and received.
+# TYPE sam3_packets_total counter
+sam3_packets_total{direction="received",id="d",style="DATAGRAM"} 1
+sam3_packets_total{direction="sent",id="d",style="DATAGRAM"} 2
+# HELP sam3_resolver_cache_hits_total Name lookups answered from the resolver cache.
+# TYPE sam3_resolver_cache_hits_total counter
+sam3_resolver_cache_hits_total{id="a",style="STREAM"} 1
+# HELP sam3_resolver_cache_misses_total Name lookups not answered from the resolver cache.
+# TYPE sam3_resolver_cache_misses_total counter
+sam3_resolver_cache_misses_total{id="a",style="STREAM"} 1
+# HELP sam3_sessions_created_total SESSION CREATE and SESSION ADD commands by the RESULT of the reply.
+# TYPE sam3_sessions_created_total counter
+sam3_sessions_created_total{id="a",result="DUPLICATED_ID",style="STREAM"} 2
+sam3_sessions_created_total{id="a",result="OK",style="STREAM"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_SessionClosed(t *testing.T) {
+	m, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := stdprometheus.NewRegistry()
+	reg.MustRegister(m)
+	m.SessionCreated("a", "STREAM", "OK")
+	m.Dialed("a", "STREAM", time.Second, "OK")
+	m.Packet("a", "DATAGRAM", sam3.PacketSent, 1)
+	m.SessionCreated("b", "STREAM", "OK")
+	m.SessionClosed("a", "STREAM")
+	m.SessionClosed("a", "STREAM")
+
+	want := `
+# HELP sam3_sessions_created_total SESSION CREATE and SESSION ADD commands by the RESULT of the reply.
+# TYPE sam3_sessions_created_total counter
+sam3_sessions_created_total{id="b",result="OK",style="STREAM"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_Namespace(t *testing.T) {
+	m, err := New(SetNamespace("i2p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Accepted("a", "STREAM")
+	reg := stdprometheus.NewRegistry()
+	reg.MustRegister(m)
+	if n, err := testutil.GatherAndCount(reg, "i2p_accepts_total"); err != nil || n != 1 {
+		t.Errorf("%d i2p_accepts_total, %v", n, err)
+	}
+	if _, err := New(SetDialBuckets(nil)); err == nil {
+		t.Error("no error for empty dial buckets")
+	}
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/go-i2p/sam3"
+)
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Text}}</p>
+{{- if .Jumps}}
+<p>If you know the site exists, a jump service may know its address:</p>
+<ul>
+{{- range .Jumps}}
+<li><a href="{{.}}">{{.}}</a></li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+type pageData struct {
+	Title, Text string
+	Jumps       []string
+}
+
+func errorPage(w http.ResponseWriter, status int, title, text string) {
+	render(w, status, pageData{Title: title, Text: text})
+}
+
+// notFound is the page for a name the router does not know.
+func notFound(w http.ResponseWriter, host string) {
+	d := pageData{
+		Title: "Unknown I2P site",
+		Text:  fmt.Sprintf("The router's addressbook has no entry for %s.", host),
+	}
+	for _, svc := range sam3.DefaultJumpServices {
+		d.Jumps = append(d.Jumps, fmt.Sprintf(svc, url.QueryEscape(host)))
+	}
+	render(w, http.StatusNotFound, d)
+}
+
+func render(w http.ResponseWriter, status int, d pageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	page.Execute(w, d)
+}
@@ -0,0 +1,208 @@
+// Package proxy is an HTTP proxy which sends requests for .i2p hosts over a
+// sam3 StreamSession, like the HTTP client tunnel of i2ptunnel.
+//
+// Plain requests are forwarded in origin form, CONNECT requests are
+// tunnelled over a stream to the port asked for. Headers which identify the
+// user, User-Agent, Referer and Accept-Language, are removed. Names which
+// cannot be resolved get an error page with links to jump services. Requests
+// for hosts outside I2P are refused unless an outproxy is set.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-i2p/sam3"
+	samhttp "github.com/go-i2p/sam3/http"
+	"github.com/go-i2p/sam3/internal/netutil"
+)
+
+// identifying are the request headers removed before forwarding.
+var identifying = []string{"User-Agent", "Referer", "Accept-Language"}
+
+// hopByHop are the headers which only concern one connection.
+var hopByHop = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// Proxy is an http.Handler serving proxy requests.
+type Proxy struct {
+	session   *sam3.StreamSession
+	transport *samhttp.Transport
+	outproxy  string
+	opts      []func(*samhttp.Transport) error
+}
+
+// SetOutproxy forwards requests for hosts outside I2P to the HTTP proxy at
+// the I2P address addr, for example "exit.stormycloud.i2p:80". It has to
+// support CONNECT for HTTPS.
+func SetOutproxy(addr string) func(*Proxy) error {
+	return func(p *Proxy) error {
+		p.outproxy = addr
+		p.opts = append(p.opts, samhttp.SetOutproxy(addr))
+		return nil
+	}
+}
+
+// SetTransportOptions passes options to the Transport plain requests are
+// sent with, such as samhttp.SetMaxConnsPerDest.
+func SetTransportOptions(opts ...func(*samhttp.Transport) error) func(*Proxy) error {
+	return func(p *Proxy) error {
+		p.opts = append(p.opts, opts...)
+		return nil
+	}
+}
+
+// New returns a proxy sending requests over session.
+func New(session *sam3.StreamSession, opts ...func(*Proxy) error) (*Proxy, error) {
+	p := &Proxy{session: session}
+	for _, o := range opts {
+		if err := o(p); err != nil {
+			return nil, err
+		}
+	}
+	t, err := samhttp.New(session, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.transport = t
+	return p, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.connect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() || r.URL.Host == "" {
+		errorPage(w, http.StatusBadRequest, "Not a proxy request",
+			"This is an I2P HTTP proxy. Configure it as the proxy of your browser or tool, rather than visiting it.")
+		return
+	}
+	if r.URL.Scheme != "http" {
+		errorPage(w, http.StatusBadRequest, "Unsupported scheme",
+			"The proxy forwards http URLs. Use CONNECT for https.")
+		return
+	}
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	removeHeaders(out.Header)
+	for _, h := range identifying {
+		out.Header.Del(h)
+	}
+	// an empty User-Agent keeps net/http from sending its own
+	out.Header.Set("User-Agent", "")
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		p.fail(w, r.URL.Hostname(), err)
+		return
+	}
+	defer resp.Body.Close()
+	removeHeaders(resp.Header)
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// removeHeaders removes the hop-by-hop headers, including those listed in
+// Connection.
+func removeHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHop {
+		h.Del(name)
+	}
+}
+
+// fail writes the error page for err, which happened on a request for host.
+func (p *Proxy) fail(w http.ResponseWriter, host string, err error) {
+	var rerr *sam3.ResolveError
+	switch {
+	case errors.As(err, &rerr) && rerr.NotFound():
+		notFound(w, host)
+	case errors.As(err, &rerr):
+		errorPage(w, http.StatusBadGateway, "Lookup failed",
+			fmt.Sprintf("The router could not look up %s: %s.", host, rerr.Result))
+	case errors.Is(err, samhttp.ErrClearnet):
+		errorPage(w, http.StatusForbidden, "Outproxy disabled",
+			fmt.Sprintf("%s is not an I2P site, and no outproxy is configured to reach sites outside I2P.", host))
+	default:
+		errorPage(w, http.StatusGatewayTimeout, "Destination unreachable",
+			fmt.Sprintf("%s could not be reached: %v. It may be offline, or its tunnels are not built yet.", host, err))
+	}
+}
+
+// connect tunnels a CONNECT request over a stream.
+func (p *Proxy) connect(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		errorPage(w, http.StatusBadRequest, "Bad CONNECT request", "CONNECT needs a host and a port.")
+		return
+	}
+	var remote net.Conn
+	if samhttp.IsI2P(host) {
+		remote, err = p.dial(host, port)
+	} else if p.outproxy != "" {
+		remote, err = p.viaOutproxy(r.Host)
+	} else {
+		err = samhttp.ErrClearnet
+	}
+	if err != nil {
+		p.fail(w, host, err)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		remote.Close()
+		errorPage(w, http.StatusInternalServerError, "CONNECT not supported", "The server cannot hand over the connection.")
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		remote.Close()
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		remote.Close()
+		conn.Close()
+		return
+	}
+	netutil.Join(&netutil.BufferedConn{Conn: conn, Reader: rw.Reader}, remote)
+}
+
+// dial opens a stream to port of host.
+func (p *Proxy) dial(host, port string) (net.Conn, error) {
+	addr, err := p.session.Lookup(host)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", host, err)
+	}
+	c, err := p.session.DialI2PPort(addr, port)
+	if err != nil {
+		return nil, fmt.Errorf("dialing %s: %w", host, err)
+	}
+	return c, nil
+}
+
+// viaOutproxy opens a tunnel to target through the outproxy.
+func (p *Proxy) viaOutproxy(target string) (net.Conn, error) {
+	host, port, _ := net.SplitHostPort(p.outproxy)
+	c, err := p.dial(host, port)
+	if err != nil {
+		return nil, err
+	}
+	if c, err = netutil.Connect(c, target); err != nil {
+		return nil, fmt.Errorf("outproxy: %w", err)
+	}
+	return c, nil
+}
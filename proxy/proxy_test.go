@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/sam3"
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func newProxy(t *testing.T, b *samtest.Bridge, opts ...func(*Proxy) error) *url.URL {
+	t.Helper()
+	sam, err := sam3.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sam.Close() })
+	session, err := sam.NewStreamSession("proxyTest", samtest.Keys(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(session, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return u
+}
+
+func Test_Proxy(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Join([]string{r.Host, r.RequestURI, r.UserAgent(), r.Referer(), r.Header.Get("Accept-Language"), r.Header.Get("Accept")}, "|"))
+	}))
+	defer site.Close()
+	b := samtest.New(t)
+	dest := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", dest)
+	b.Forward(dest, site.Listener.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(newProxy(t, b))}}
+
+	req, _ := http.NewRequest("GET", "http://site.i2p/path?q=1", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
+	req.Header.Set("Referer", "http://site.i2p/")
+	req.Header.Set("Accept-Language", "de-CH")
+	req.Header.Set("Accept", "text/html")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := "site.i2p|/path?q=1||||text/html"; string(body) != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+
+	resp, err = client.Get("http://missing.i2p/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(string(body), "inr.i2p/jump/missing.i2p") {
+		t.Errorf("unknown name: %d %s", resp.StatusCode, body)
+	}
+
+	resp, err = client.Get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("clearnet: %d", resp.StatusCode)
+	}
+}
+
+func Test_ProxyConnect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	b := samtest.New(t)
+	dest := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", dest)
+	b.Forward(dest, echo.Addr().String())
+	proxy := newProxy(t, b)
+
+	c, err := net.Dial("tcp", proxy.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "CONNECT site.i2p:443 HTTP/1.1\r\nHost: site.i2p:443\r\n\r\n")
+	rd := bufio.NewReader(c)
+	resp, err := http.ReadResponse(rd, &http.Request{Method: http.MethodConnect})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT: %v %v", resp, err)
+	}
+	if port := samtest.Arg(b.Command("STREAM CONNECT"), "TO_PORT"); port != "443" {
+		t.Errorf("TO_PORT = %q", port)
+	}
+	io.WriteString(c, "tls?")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(rd, buf); err != nil || string(buf) != "tls?" {
+		t.Errorf("echo %q %v", buf, err)
+	}
+	// closing the sending side leaves the reply coming
+	io.WriteString(c, "last")
+	c.(*net.TCPConn).CloseWrite()
+	if rest, err := io.ReadAll(rd); err != nil || string(rest) != "last" {
+		t.Errorf("after half close %q %v", rest, err)
+	}
+}
@@ -0,0 +1,62 @@
+package sam3
+
+import (
+	"strings"
+)
+
+// tokenizeReply splits a reply line from the SAM bridge into its words.
+// Values may be quoted, as in MESSAGE="Duplicate destination", in which case
+// the quotes are removed and backslash escapes inside them are honoured.
+func tokenizeReply(line string) []string {
+	var (
+		words   []string
+		cur     strings.Builder
+		inQuote bool
+		escaped bool
+		inWord  bool
+	)
+	for _, r := range strings.TrimRight(line, "\r\n") {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case inQuote && r == '\\':
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+			inWord = true
+		case !inQuote && (r == ' ' || r == '\t'):
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words
+}
+
+// splitPair splits a KEY=value word. Words without '=' are returned with ok
+// set to false.
+func splitPair(word string) (key, value string, ok bool) {
+	i := strings.IndexByte(word, '=')
+	if i < 0 {
+		return word, "", false
+	}
+	return word[:i], word[i+1:], true
+}
+
+// quoteValue quotes a value for a SAM command if it contains spaces, quotes
+// or backslashes, escaping quotes and backslashes inside it.
+func quoteValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\"\\") {
+		return v
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
@@ -1,86 +1,542 @@
 package sam3
 
 import (
-	"bufio"
-	"bytes"
+	"container/list"
 	"errors"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-i2p/i2pkeys"
 )
 
+// Defaults for the SAMResolver cache.
+const (
+	DefaultResolverCacheSize   = 512
+	DefaultResolverTTL         = 10 * time.Minute
+	DefaultResolverNegativeTTL = 30 * time.Second
+)
+
+// SAMResolver resolves names with NAMING LOOKUP over a persistent SAM
+// connection. Results are kept in a bounded LRU cache, failed lookups are
+// remembered for a shorter time, and concurrent lookups of the same name are
+// collapsed into a single request to the bridge.
 type SAMResolver struct {
 	*SAM
+	// address of the bridge, set when the resolver owns its connection and
+	// may redial it
+	address string
+
+	// connMu serializes requests on the SAM connection. The connection is
+	// only replaced with both connMu and mu held.
+	connMu      sync.Mutex
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	idleGen     int  // counts idle timers, so that a stale one does nothing
+	closed      bool // under mu
+
+	mu          sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[string]*list.Element
+	lru         *list.List
+	inflight    map[string]*resolveCall
+	hits        uint64
+	misses      uint64
+}
+
+type resolveEntry struct {
+	name    string
+	addr    i2pkeys.I2PAddr
+	err     error
+	expires time.Time
+}
+
+type resolveCall struct {
+	done chan struct{}
+	addr i2pkeys.I2PAddr
+	err  error
+}
+
+// ResolveError is returned by SAMResolver when the bridge answered the lookup
+// but could not resolve the name.
+type ResolveError struct {
+	Name    string
+	Result  string // RESULT= value of the NAMING REPLY, e.g. KEY_NOT_FOUND
+	Message string // MESSAGE= value of the NAMING REPLY, if any
+}
+
+func (e *ResolveError) Error() string {
+	var msg string
+	switch e.Result {
+	case "INVALID_KEY":
+		msg = "Invalid key - resolver."
+	case "KEY_NOT_FOUND":
+		msg = "Unable to resolve " + e.Name
+	default:
+		msg = "Unable to resolve " + e.Name + ": " + e.Result
+	}
+	if e.Message != "" {
+		msg += " " + e.Message
+	}
+	return msg
+}
+
+// NotFound reports whether the name is unknown to the bridge.
+func (e *ResolveError) NotFound() bool {
+	return e.Result == "KEY_NOT_FOUND"
 }
 
-func NewSAMResolver(parent *SAM) (*SAMResolver, error) {
-	log.Debug("Creating new SAMResolver from existing SAM instance")
-	var s SAMResolver
+func NewSAMResolver(parent *SAM, opts ...func(*SAMResolver) error) (*SAMResolver, error) {
+	defaultLog().Debug("Creating new SAMResolver from existing SAM instance")
+	s := newSAMResolver()
 	s.SAM = parent
-	return &s, nil
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
-func NewFullSAMResolver(address string) (*SAMResolver, error) {
-	log.WithField("address", address).Debug("Creating new full SAMResolver")
-	var s SAMResolver
+func NewFullSAMResolver(address string, opts ...func(*SAMResolver) error) (*SAMResolver, error) {
+	defaultLog().Debug("Creating new full SAMResolver", "address", address)
+	s := newSAMResolver()
+	s.address = address
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
 	var err error
 	s.SAM, err = NewSAM(address)
 	if err != nil {
-		log.WithError(err).Error("Failed to create new SAM instance")
+		defaultLog().Error("Failed to create new SAM instance", "error", err)
 		return nil, err
 	}
-	return &s, nil
+	s.connMu.Lock()
+	s.startIdleTimer()
+	s.connMu.Unlock()
+	return s, nil
+}
+
+func newSAMResolver() *SAMResolver {
+	return &SAMResolver{
+		size:        DefaultResolverCacheSize,
+		ttl:         DefaultResolverTTL,
+		negativeTTL: DefaultResolverNegativeTTL,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		inflight:    make(map[string]*resolveCall),
+	}
+}
+
+// SetResolverCacheSize sets the maximum number of names kept in the cache.
+// Zero disables caching.
+func SetResolverCacheSize(n int) func(*SAMResolver) error {
+	return func(s *SAMResolver) error {
+		if n < 0 {
+			return errors.New("Invalid resolver cache size")
+		}
+		s.size = n
+		return nil
+	}
+}
+
+// SetResolverTTL sets how long successful lookups are cached
+func SetResolverTTL(d time.Duration) func(*SAMResolver) error {
+	return func(s *SAMResolver) error {
+		if d < 0 {
+			return errors.New("Invalid resolver TTL")
+		}
+		s.ttl = d
+		return nil
+	}
+}
+
+// SetResolverNegativeTTL sets how long names which could not be resolved are
+// cached. Zero disables negative caching.
+func SetResolverNegativeTTL(d time.Duration) func(*SAMResolver) error {
+	return func(s *SAMResolver) error {
+		if d < 0 {
+			return errors.New("Invalid resolver negative TTL")
+		}
+		s.negativeTTL = d
+		return nil
+	}
+}
+
+// SetResolverIdleTimeout makes a resolver which owns its connection close it
+// after d without lookups. The next lookup which is not answered from the
+// cache dials the bridge again. Zero, the default, keeps it open.
+func SetResolverIdleTimeout(d time.Duration) func(*SAMResolver) error {
+	return func(s *SAMResolver) error {
+		if d < 0 {
+			return errors.New("Invalid resolver idle timeout")
+		}
+		s.idleTimeout = d
+		return nil
+	}
+}
+
+// Close closes the resolver's connection to the bridge. A resolver created
+// with NewSAMResolver closes the connection of its SAM.
+func (sam *SAMResolver) Close() error {
+	sam.mu.Lock()
+	defer sam.mu.Unlock()
+	sam.closed = true
+	if sam.idleTimer != nil {
+		sam.idleTimer.Stop()
+	}
+	if sam.SAM.conn == nil {
+		return nil
+	}
+	return sam.SAM.conn.Close()
 }
 
 // Performs a lookup, probably this order: 1) routers known addresses, cached
 // addresses, 3) by asking peers in the I2P network.
 func (sam *SAMResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
-	log.WithField("name", name).Debug("Resolving name")
+	addr, hit, err := sam.resolve(name)
+	sam.stats().Resolved("", "", hit)
+	return addr, err
+}
 
-	if _, err := sam.conn.Write([]byte("NAMING LOOKUP NAME=" + name + "\r\n")); err != nil {
-		log.WithError(err).Error("Failed to write to SAM connection")
-		sam.Close()
-		return i2pkeys.I2PAddr(""), err
+// resolveReport is Resolve, telling report instead of the resolver's own
+// Metrics whether name was answered from the cache.
+func (sam *SAMResolver) resolveReport(name string, report func(cached bool)) (i2pkeys.I2PAddr, error) {
+	addr, hit, err := sam.resolve(name)
+	report(hit)
+	return addr, err
+}
+
+// resolve is Resolve, also reporting whether name was answered from the
+// cache.
+func (sam *SAMResolver) resolve(name string) (i2pkeys.I2PAddr, bool, error) {
+	sam.log().Debug("Resolving name", "name", name)
+
+	sam.mu.Lock()
+	if addr, ok, err := sam.cached(name); ok {
+		sam.hits++
+		sam.mu.Unlock()
+		sam.log().Debug("Resolved name from cache", "name", name)
+		return addr, true, err
+	}
+	sam.misses++
+	if call, ok := sam.inflight[name]; ok {
+		sam.mu.Unlock()
+		<-call.done
+		return call.addr, false, call.err
+	}
+	call := &resolveCall{done: make(chan struct{})}
+	sam.inflight[name] = call
+	sam.mu.Unlock()
+
+	call.addr, call.err = sam.lookup(name)
+
+	sam.mu.Lock()
+	delete(sam.inflight, name)
+	sam.store(name, call.addr, call.err)
+	sam.mu.Unlock()
+	close(call.done)
+	return call.addr, false, call.err
+}
+
+// CacheStats returns the number of cache hits and misses so far.
+func (sam *SAMResolver) CacheStats() (hits, misses uint64) {
+	sam.mu.Lock()
+	defer sam.mu.Unlock()
+	return sam.hits, sam.misses
+}
+
+// Flush empties the cache.
+func (sam *SAMResolver) Flush() {
+	sam.mu.Lock()
+	defer sam.mu.Unlock()
+	sam.entries = make(map[string]*list.Element)
+	sam.lru.Init()
+}
+
+// cached returns the cached result for name. sam.mu must be held.
+func (sam *SAMResolver) cached(name string) (i2pkeys.I2PAddr, bool, error) {
+	el, ok := sam.entries[name]
+	if !ok {
+		return "", false, nil
+	}
+	e := el.Value.(*resolveEntry)
+	if time.Now().After(e.expires) {
+		sam.lru.Remove(el)
+		delete(sam.entries, name)
+		return "", false, nil
+	}
+	sam.lru.MoveToFront(el)
+	return e.addr, true, e.err
+}
+
+// store caches the result of a lookup. Only answers from the bridge are
+// cached, not connection errors. sam.mu must be held.
+func (sam *SAMResolver) store(name string, addr i2pkeys.I2PAddr, err error) {
+	ttl := sam.ttl
+	if err != nil {
+		var rerr *ResolveError
+		if !errors.As(err, &rerr) {
+			return
+		}
+		ttl = sam.negativeTTL
+	}
+	if sam.size == 0 || ttl == 0 {
+		return
+	}
+	if el, ok := sam.entries[name]; ok {
+		sam.lru.Remove(el)
+	}
+	sam.entries[name] = sam.lru.PushFront(&resolveEntry{name, addr, err, time.Now().Add(ttl)})
+	for sam.lru.Len() > sam.size {
+		el := sam.lru.Back()
+		sam.lru.Remove(el)
+		delete(sam.entries, el.Value.(*resolveEntry).name)
+	}
+}
+
+// LookupResult is the full answer to a NAMING LOOKUP with OPTIONS=true.
+type LookupResult struct {
+	Name string
+	Dest i2pkeys.I2PAddr
+	// Options holds the OPTION:key=value pairs of the reply, with the
+	// OPTION: prefix removed. Other key=value pairs not defined by the
+	// NAMING REPLY itself are included as well.
+	Options map[string]string
+	// Raw is the unparsed reply line
+	Raw string
+}
+
+// ResolveDetailed performs a NAMING LOOKUP with OPTIONS=true, which asks SAM
+// 3.3 bridges to also return the options of the destination's leaseset, such
+// as service records. Bridges which do not support OPTIONS return the
+// destination alone. Detailed lookups are not served from the cache, but a
+// successful one refreshes the cached destination.
+func (sam *SAMResolver) ResolveDetailed(name string) (*LookupResult, error) {
+	sam.log().Debug("Resolving name with options", "name", name)
+	reply, err := sam.request(sam.Config.Lookup(name, SessionOption{"OPTIONS", "true"}))
+	if err != nil {
+		return nil, err
+	}
+	res, err := parseNamingReply(name, reply)
+	err = b33Failure(name, err)
+	sam.mu.Lock()
+	if res != nil {
+		sam.store(name, res.Dest, err)
+	} else {
+		sam.store(name, "", err)
 	}
-	buf := make([]byte, 4096)
-	n, err := sam.conn.Read(buf)
+	sam.mu.Unlock()
+	return res, err
+}
+
+// lookup sends a NAMING LOOKUP to the bridge.
+func (sam *SAMResolver) lookup(name string) (i2pkeys.I2PAddr, error) {
+	reply, err := sam.request(sam.Config.Lookup(name))
 	if err != nil {
-		log.WithError(err).Error("Failed to read from SAM connection")
-		sam.Close()
 		return i2pkeys.I2PAddr(""), err
 	}
-	if n <= 13 || !strings.HasPrefix(string(buf[:n]), "NAMING REPLY ") {
-		log.Error("Failed to parse SAM response")
-		return i2pkeys.I2PAddr(""), errors.New("Failed to parse.")
+	res, err := parseNamingReply(name, reply)
+	if err != nil {
+		return i2pkeys.I2PAddr(""), b33Failure(name, err)
+	}
+	sam.log().Debug("Name resolved successfully", "addr", res.Dest)
+	return res.Dest, nil
+}
+
+// request writes cmd to the bridge and reads one reply line. If the resolver
+// owns its connection, a broken or idle-closed connection is redialed.
+func (sam *SAMResolver) request(cmd string) (string, error) {
+	sam.connMu.Lock()
+	defer sam.connMu.Unlock()
+	if sam.idleTimer != nil {
+		sam.idleTimer.Stop()
+	}
+	defer sam.startIdleTimer()
+	var reply string
+	err := errors.New("resolver connection is closed")
+	if sam.SAM.conn != nil {
+		reply, err = sam.requestConn(cmd)
+	}
+	if err != nil && sam.address != "" {
+		sam.log().Debug("Resolver connection failed, redialing", "error", err)
+		if derr := sam.redial(); derr != nil {
+			sam.log().Error("Failed to redial SAM for resolver", "error", derr)
+			return "", err
+		}
+		reply, err = sam.requestConn(cmd)
 	}
-	s := bufio.NewScanner(bytes.NewReader(buf[13:n]))
-	s.Split(bufio.ScanWords)
+	return reply, err
+}
 
-	errStr := ""
-	for s.Scan() {
-		text := s.Text()
-		log.WithField("text", text).Debug("Parsing SAM response token")
-		//log.Println("SAM3", text)
-		if text == "RESULT=OK" {
-			continue
-		} else if text == "RESULT=INVALID_KEY" {
-			errStr += "Invalid key - resolver."
-			log.Error("Invalid key in resolver")
-		} else if text == "RESULT=KEY_NOT_FOUND" {
-			errStr += "Unable to resolve " + name
-			log.WithField("name", name).Error("Unable to resolve name")
-		} else if text == "NAME="+name {
-			continue
-		} else if strings.HasPrefix(text, "VALUE=") {
-			addr := i2pkeys.I2PAddr(text[6:])
-			log.WithField("addr", addr).Debug("Name resolved successfully")
-			return i2pkeys.I2PAddr(text[6:]), nil
-		} else if strings.HasPrefix(text, "MESSAGE=") {
-			errStr += " " + text[8:]
-			log.WithField("message", text[8:]).Warn("Received message from SAM")
-		} else {
+// redial replaces the connection to the bridge. The SAM is kept, with its
+// logger and metrics. sam.connMu must be held.
+func (sam *SAMResolver) redial() error {
+	s, err := NewSAM(sam.address)
+	if err != nil {
+		return err
+	}
+	sam.mu.Lock()
+	defer sam.mu.Unlock()
+	if sam.closed {
+		s.conn.Close()
+		return errors.New("resolver is closed")
+	}
+	sam.SAM.conn = s.conn
+	return nil
+}
+
+// startIdleTimer closes the connection after the idle timeout, unless a
+// request comes first. sam.connMu must be held.
+func (sam *SAMResolver) startIdleTimer() {
+	if sam.idleTimeout == 0 || sam.address == "" {
+		return
+	}
+	sam.idleGen++
+	gen := sam.idleGen
+	sam.idleTimer = time.AfterFunc(sam.idleTimeout, func() { sam.closeIdle(gen) })
+}
+
+func (sam *SAMResolver) closeIdle(gen int) {
+	sam.connMu.Lock()
+	defer sam.connMu.Unlock()
+	if gen != sam.idleGen {
+		return
+	}
+	sam.mu.Lock()
+	defer sam.mu.Unlock()
+	if sam.SAM.conn != nil {
+		sam.log().Debug("Closing idle resolver connection")
+		sam.SAM.conn.Close()
+		sam.SAM.conn = nil
+	}
+}
+
+// requestConn does the request on the current connection, closing it if it
+// fails. sam.connMu must be held.
+func (sam *SAMResolver) requestConn(cmd string) (string, error) {
+	conn := sam.SAM.conn
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		sam.log().Error("Failed to write to SAM connection", "error", err)
+		conn.Close()
+		return "", err
+	}
+	reply, err := readReplyLine(conn)
+	if err != nil {
+		sam.log().Error("Failed to read from SAM connection", "error", err)
+		conn.Close()
+		return "", err
+	}
+	return reply, nil
+}
+
+// parseNamingReply parses a NAMING REPLY. Java I2P quotes values containing
+// spaces and prefixes leaseset options with OPTION:, i2pd may omit NAME= and
+// does not return options at all; all of these are accepted.
+func parseNamingReply(name, reply string) (*LookupResult, error) {
+	words := tokenizeReply(reply)
+	if len(words) < 2 || words[0] != "NAMING" || words[1] != "REPLY" {
+		defaultLog().Error("Failed to parse SAM response")
+		return nil, errors.New("Failed to parse.")
+	}
+	res := &LookupResult{Name: name, Options: make(map[string]string), Raw: reply}
+	rerr := &ResolveError{Name: name}
+	for _, word := range words[2:] {
+		defaultLog().Debug("Parsing SAM response token", "text", word)
+		key, value, ok := splitPair(word)
+		if !ok {
 			continue
 		}
+		switch {
+		case key == "RESULT":
+			if value != "OK" {
+				rerr.Result = value
+				defaultLog().Error("Unable to resolve name", "name", name, "result", value)
+			}
+		case key == "NAME":
+			res.Name = value
+		case key == "VALUE":
+			res.Dest = i2pkeys.I2PAddr(value)
+		case key == "MESSAGE":
+			rerr.Message = value
+			defaultLog().Warn("Received message from SAM", "message", value)
+		case strings.HasPrefix(strings.ToUpper(key), "OPTION:"):
+			res.Options[key[len("OPTION:"):]] = value
+		default:
+			res.Options[key] = value
+		}
+	}
+	if rerr.Result != "" || res.Dest == "" {
+		return nil, rerr
+	}
+	return res, nil
+}
+
+var sharedResolvers = struct {
+	sync.Mutex
+	m map[string]*SAMResolver
+}{m: make(map[string]*SAMResolver)}
+
+// sessionResolver returns r, or the shared resolver for address if r is nil.
+func sessionResolver(r Resolver, address string) (Resolver, error) {
+	if r != nil {
+		return r, nil
+	}
+	return sharedResolver(address)
+}
+
+// reportingResolver is a Resolver which tells report, for every lookup one
+// of its SAMResolvers makes, whether it was answered from the cache.
+type reportingResolver interface {
+	resolveReport(name string, report func(cached bool)) (i2pkeys.I2PAddr, error)
+}
+
+var (
+	_ reportingResolver = (*SAMResolver)(nil)
+	_ reportingResolver = (*ChainResolver)(nil)
+)
+
+// sessionResolve looks name up with r for the session id of style, telling m
+// whether a SAMResolver, on its own or in a chain, answered from its cache.
+func sessionResolve(r Resolver, name string, m Metrics, id, style string) (i2pkeys.I2PAddr, error) {
+	rr, ok := r.(reportingResolver)
+	if !ok {
+		return r.Resolve(name)
+	}
+	return rr.resolveReport(name, func(cached bool) { m.Resolved(id, style, cached) })
+}
+
+// sharedResolverIdleTimeout is how long the shared resolvers keep their
+// connection to the bridge open without lookups.
+const sharedResolverIdleTimeout = time.Minute
+
+// sharedResolver returns the resolver shared by all sessions on the bridge at
+// address, creating it on first use. It keeps its cache for the life of the
+// process, but closes its connection when idle. The bridge is dialed without
+// the lock held, so a slow bridge does not hold up sessions on other ones;
+// if two sessions race, the resolver stored first wins and the other is
+// closed.
+func sharedResolver(address string) (*SAMResolver, error) {
+	sharedResolvers.Lock()
+	r, ok := sharedResolvers.m[address]
+	sharedResolvers.Unlock()
+	if ok {
+		return r, nil
+	}
+	r, err := NewFullSAMResolver(address, SetResolverIdleTimeout(sharedResolverIdleTimeout))
+	if err != nil {
+		return nil, err
+	}
+	sharedResolvers.Lock()
+	defer sharedResolvers.Unlock()
+	if other, ok := sharedResolvers.m[address]; ok {
+		r.Close()
+		return other, nil
 	}
-	return i2pkeys.I2PAddr(""), errors.New(errStr)
+	sharedResolvers.m[address] = r
+	return r, nil
 }
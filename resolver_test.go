@@ -0,0 +1,297 @@
+package sam3
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+// namingBridge is a bridge which answers lookups of names from names,
+// counting them in lookups, after delay.
+func namingBridge(t *testing.T, names map[string]string, lookups *int32, delay time.Duration) *samtest.Bridge {
+	b := samtest.New(t)
+	b.Handle("NAMING LOOKUP", samtest.Reply(func(line string) string {
+		atomic.AddInt32(lookups, 1)
+		time.Sleep(delay)
+		name := samtest.Arg(line, "NAME")
+		if dest, ok := names[name]; ok {
+			return "NAMING REPLY RESULT=OK NAME=" + name + " VALUE=" + dest + "\n"
+		}
+		return "NAMING REPLY RESULT=KEY_NOT_FOUND NAME=" + name + "\n"
+	}))
+	return b
+}
+
+func Test_SAMResolverCache(t *testing.T) {
+	dest := samtest.Keys(t).Addr().Base64()
+	var lookups int32
+	b := namingBridge(t, map[string]string{"example.i2p": dest}, &lookups, 0)
+	r, err := NewFullSAMResolver(b.Addr(), SetResolverNegativeTTL(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i := 0; i < 3; i++ {
+		addr, err := r.Resolve("example.i2p")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.Base64() != dest {
+			t.Fatalf("Resolve returned %s", addr.Base32())
+		}
+	}
+	if n := atomic.LoadInt32(&lookups); n != 1 {
+		t.Errorf("%d lookups reached the bridge, want 1", n)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := r.Resolve("missing.i2p")
+		rerr, ok := err.(*ResolveError)
+		if !ok || !rerr.NotFound() {
+			t.Fatalf("Resolve(missing.i2p) = %v, want KEY_NOT_FOUND", err)
+		}
+	}
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Errorf("%d lookups reached the bridge, want 2", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	r.Resolve("missing.i2p")
+	if n := atomic.LoadInt32(&lookups); n != 3 {
+		t.Errorf("negative entry did not expire, %d lookups", n)
+	}
+	if hits, misses := r.CacheStats(); hits != 3 || misses != 3 {
+		t.Errorf("CacheStats() = %d, %d, want 3, 3", hits, misses)
+	}
+}
+
+func Test_SAMResolverEviction(t *testing.T) {
+	names := map[string]string{}
+	for _, n := range []string{"a.i2p", "b.i2p", "c.i2p"} {
+		names[n] = samtest.Keys(t).Addr().Base64()
+	}
+	var lookups int32
+	b := namingBridge(t, names, &lookups, 0)
+	r, err := NewFullSAMResolver(b.Addr(), SetResolverCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, n := range []string{"a.i2p", "b.i2p", "a.i2p", "c.i2p", "a.i2p", "b.i2p"} {
+		if _, err := r.Resolve(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// b is evicted by c because a was used more recently
+	if n := atomic.LoadInt32(&lookups); n != 4 {
+		t.Errorf("%d lookups reached the bridge, want 4", n)
+	}
+}
+
+func Test_SAMResolverCollapse(t *testing.T) {
+	dest := samtest.Keys(t).Addr().Base64()
+	var lookups int32
+	b := namingBridge(t, map[string]string{"slow.i2p": dest}, &lookups, 50*time.Millisecond)
+	r, err := NewFullSAMResolver(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if addr, err := r.Resolve("slow.i2p"); err != nil || addr.Base64() != dest {
+				t.Errorf("Resolve(slow.i2p) = %s, %v", addr.Base32(), err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&lookups); n != 1 {
+		t.Errorf("%d lookups reached the bridge, want 1", n)
+	}
+}
+
+func Test_SAMResolverRedial(t *testing.T) {
+	dest := samtest.Keys(t).Addr().Base64()
+	var lookups int32
+	b := namingBridge(t, map[string]string{"example.i2p": dest}, &lookups, 0)
+	r, err := NewFullSAMResolver(b.Addr(), SetResolverCacheSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := new(recordLogger)
+	r.SetLogger(rec)
+	r.conn.Close()
+	// lookups racing with the redial
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Resolve("example.i2p"); err != nil {
+				t.Errorf("Resolve after connection loss: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if !strings.Contains(rec.String(), "redialing") {
+		t.Errorf("the redialed resolver lost its logger:\n%s", rec)
+	}
+	r.Close()
+	if _, err := r.Resolve("example.i2p"); err == nil {
+		t.Error("closed resolver redialed")
+	}
+}
+
+func Test_SAMResolverIdle(t *testing.T) {
+	dest := samtest.Keys(t).Addr().Base64()
+	var lookups int32
+	b := namingBridge(t, map[string]string{"a.i2p": dest, "b.i2p": dest}, &lookups, 0)
+	r, err := NewFullSAMResolver(b.Addr(), SetResolverIdleTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Resolve("a.i2p"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.connMu.Lock()
+	idle := r.SAM.conn == nil
+	r.connMu.Unlock()
+	if !idle {
+		t.Error("idle connection was not closed")
+	}
+	if _, err := r.Resolve("b.i2p"); err != nil {
+		t.Fatalf("Resolve after idle close: %v", err)
+	}
+}
+
+func Test_SharedResolver(t *testing.T) {
+	// a bridge which never answers HELLO
+	slow, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := slow.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	b := samtest.New(t)
+	t.Cleanup(func() {
+		slow.Close()
+		select {
+		case c := <-accepted:
+			c.Close()
+		default:
+		}
+		sharedResolvers.Lock()
+		if r, ok := sharedResolvers.m[b.Addr()]; ok {
+			r.Close()
+			delete(sharedResolvers.m, b.Addr())
+		}
+		sharedResolvers.Unlock()
+	})
+	go sharedResolver(slow.Addr().String())
+	c := <-accepted
+	accepted <- c
+
+	var wg sync.WaitGroup
+	got := make([]*SAMResolver, 4)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, err := sharedResolver(b.Addr())
+			if err != nil {
+				t.Error(err)
+			}
+			got[i] = r
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("held up by the dial to another bridge")
+	}
+	for _, r := range got[1:] {
+		if r != got[0] {
+			t.Fatal("sessions on one bridge got different resolvers")
+		}
+	}
+}
+
+func Test_ParseNamingReply(t *testing.T) {
+	dest := samtest.Keys(t).Addr().Base64()
+	java := "NAMING REPLY RESULT=OK NAME=example.i2p VALUE=" + dest +
+		" OPTION:_smtp._tcp=\"1 86400 0 0 25 mail.example.i2p\" OPTION:i2cp.leaseSetEncType=4\n"
+	res, err := parseNamingReply("example.i2p", java)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Dest.Base64() != dest || res.Name != "example.i2p" || res.Raw != java {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if v := res.Options["_smtp._tcp"]; v != "1 86400 0 0 25 mail.example.i2p" {
+		t.Errorf("service record = %q", v)
+	}
+	if v := res.Options["i2cp.leaseSetEncType"]; v != "4" {
+		t.Errorf("i2cp.leaseSetEncType = %q", v)
+	}
+
+	i2pd := "NAMING REPLY RESULT=OK VALUE=" + dest + "\n"
+	if res, err := parseNamingReply("example.i2p", i2pd); err != nil || res.Dest.Base64() != dest || len(res.Options) != 0 {
+		t.Errorf("i2pd reply: %+v, %v", res, err)
+	}
+
+	_, err = parseNamingReply("nope.i2p", "NAMING REPLY RESULT=KEY_NOT_FOUND NAME=nope.i2p MESSAGE=\"not in addressbook\"\n")
+	rerr, ok := err.(*ResolveError)
+	if !ok || !rerr.NotFound() || rerr.Message != "not in addressbook" {
+		t.Errorf("KEY_NOT_FOUND reply: %v", err)
+	}
+	if _, err := parseNamingReply("x.i2p", "HELLO REPLY RESULT=OK\n"); err == nil {
+		t.Error("accepted a reply which is not a NAMING REPLY")
+	}
+}
+
+func Test_ResolveDetailed(t *testing.T) {
+	dest := samtest.Keys(t).Addr().Base64()
+	b := samtest.New(t)
+	b.Handle("NAMING LOOKUP", samtest.Reply(func(line string) string {
+		if samtest.Arg(line, "OPTIONS") != "true" {
+			return "NAMING REPLY RESULT=I2P_ERROR\n"
+		}
+		return "NAMING REPLY RESULT=OK NAME=example.i2p VALUE=" + dest + " OPTION:foo=bar\n"
+	}))
+	r, err := NewFullSAMResolver(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	res, err := r.ResolveDetailed("example.i2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Options["foo"] != "bar" {
+		t.Errorf("Options = %v", res.Options)
+	}
+	// the destination is now cached for plain lookups
+	if addr, err := r.Resolve("example.i2p"); err != nil || addr.Base64() != dest {
+		t.Errorf("Resolve after ResolveDetailed = %v", err)
+	}
+}
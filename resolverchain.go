@@ -0,0 +1,345 @@
+package sam3
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+// Resolver turns I2P host names into destinations. SAMResolver implements
+// it, and ChainResolver combines several of them.
+type Resolver interface {
+	Resolve(name string) (i2pkeys.I2PAddr, error)
+}
+
+var _ Resolver = (*SAMResolver)(nil)
+
+// notFound returns the error used by local resolvers for unknown names.
+func notFound(name string) error {
+	return &ResolveError{Name: name, Result: "KEY_NOT_FOUND"}
+}
+
+// ChainResolver asks each of its resolvers in turn and returns the first
+// destination found. A typical chain is a StaticResolver, a
+// HostsFileResolver, a SubscriptionResolver, a SAMResolver and finally a
+// JumpResolver.
+type ChainResolver struct {
+	resolvers []Resolver
+}
+
+// NewChainResolver creates a resolver which consults resolvers in order.
+func NewChainResolver(resolvers ...Resolver) *ChainResolver {
+	return &ChainResolver{resolvers: resolvers}
+}
+
+// Resolve returns the answer of the first resolver which knows name. If none
+// does, the error of the last resolver is returned.
+func (c *ChainResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
+	return c.resolveReport(name, nil)
+}
+
+// resolveReport is Resolve, passing report on to the resolvers in the chain
+// which take one. If report is nil they use their own Metrics.
+func (c *ChainResolver) resolveReport(name string, report func(cached bool)) (i2pkeys.I2PAddr, error) {
+	defaultLog().Debug("Resolving name through chain", "name", name)
+	err := notFound(name)
+	for _, r := range c.resolvers {
+		var addr i2pkeys.I2PAddr
+		if rr, ok := r.(reportingResolver); ok && report != nil {
+			addr, err = rr.resolveReport(name, report)
+		} else {
+			addr, err = r.Resolve(name)
+		}
+		if err == nil {
+			return addr, nil
+		}
+		defaultLog().Debug("Resolver in chain failed", "error", err, "name", name)
+	}
+	return i2pkeys.I2PAddr(""), err
+}
+
+// StaticResolver resolves names from a fixed map.
+type StaticResolver map[string]i2pkeys.I2PAddr
+
+func (s StaticResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
+	if addr, ok := s[strings.ToLower(name)]; ok {
+		return addr, nil
+	}
+	if addr, ok := s[name]; ok {
+		return addr, nil
+	}
+	return i2pkeys.I2PAddr(""), notFound(name)
+}
+
+// ParseHostsTxt reads an addressbook in hosts.txt format, one name=base64
+// entry per line. Comments, blank lines, malformed entries and the #!
+// metadata of signed subscription entries are skipped.
+func ParseHostsTxt(r io.Reader) (map[string]i2pkeys.I2PAddr, error) {
+	hosts := make(map[string]i2pkeys.I2PAddr)
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), 64*1024)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, dest, ok := splitPair(line)
+		if !ok || !strings.HasSuffix(name, ".i2p") {
+			continue
+		}
+		addr, err := i2pkeys.NewI2PAddrFromString(strings.TrimSpace(dest))
+		if err != nil {
+			defaultLog().Debug("Skipping invalid hosts.txt entry", "name", name)
+			continue
+		}
+		hosts[strings.ToLower(strings.TrimSpace(name))] = addr
+	}
+	return hosts, s.Err()
+}
+
+// DefaultHostsReloadInterval is how often a HostsFileResolver checks its files
+// for changes.
+const DefaultHostsReloadInterval = 5 * time.Second
+
+// HostsFileResolver resolves names from local files in hosts.txt format. The
+// files are re-read when their modification time changes, so entries can be
+// added without restarting. Earlier files take precedence over later ones.
+type HostsFileResolver struct {
+	// ReloadInterval is the minimum time between checks for modified files
+	ReloadInterval time.Duration
+
+	paths []string
+
+	mu      sync.Mutex
+	hosts   []map[string]i2pkeys.I2PAddr
+	mtimes  []time.Time
+	checked time.Time
+}
+
+// NewHostsFileResolver loads the hosts.txt-format files at paths. Files which
+// do not exist yet are treated as empty and picked up once they appear.
+func NewHostsFileResolver(paths ...string) (*HostsFileResolver, error) {
+	h := &HostsFileResolver{
+		ReloadInterval: DefaultHostsReloadInterval,
+		paths:          paths,
+		hosts:          make([]map[string]i2pkeys.I2PAddr, len(paths)),
+		mtimes:         make([]time.Time, len(paths)),
+	}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload re-reads every file whose modification time has changed.
+func (h *HostsFileResolver) Reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.reload()
+}
+
+func (h *HostsFileResolver) reload() error {
+	h.checked = time.Now()
+	for i, path := range h.paths {
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			h.hosts[i], h.mtimes[i] = nil, time.Time{}
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error reading hosts file: %w", err)
+		}
+		if h.hosts[i] != nil && fi.ModTime().Equal(h.mtimes[i]) {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error reading hosts file: %w", err)
+		}
+		hosts, err := ParseHostsTxt(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error parsing hosts file %s: %w", path, err)
+		}
+		defaultLog().Debug("Loaded hosts file", "path", path, "entries", len(hosts))
+		h.hosts[i], h.mtimes[i] = hosts, fi.ModTime()
+	}
+	return nil
+}
+
+func (h *HostsFileResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.checked) >= h.ReloadInterval {
+		if err := h.reload(); err != nil {
+			defaultLog().Warn("Failed to reload hosts files", "error", err)
+		}
+	}
+	name = strings.ToLower(name)
+	for _, hosts := range h.hosts {
+		if addr, ok := hosts[name]; ok {
+			return addr, nil
+		}
+	}
+	return i2pkeys.I2PAddr(""), notFound(name)
+}
+
+// DefaultSubscriptionInterval is how often a SubscriptionResolver fetches its
+// feeds.
+const DefaultSubscriptionInterval = 12 * time.Hour
+
+// DefaultSubscriptionMaxSize is the largest feed a SubscriptionResolver
+// accepts, well above the few megabytes of the biggest known ones.
+const DefaultSubscriptionMaxSize = 32 << 20
+
+// SubscriptionResolver resolves names from addressbook subscription feeds,
+// such as http://i2p-projekt.i2p/hosts.txt. Feeds are fetched with Client,
+// which usually dials through a StreamSession, and kept in memory. If CacheDir
+// is set they are also stored there, so names are available immediately after
+// a restart. Feeds larger than MaxSize bytes are rejected.
+type SubscriptionResolver struct {
+	Client   *http.Client
+	Interval time.Duration
+	CacheDir string
+	MaxSize  int64
+
+	urls []string
+
+	mu           sync.Mutex
+	hosts        []map[string]i2pkeys.I2PAddr
+	lastModified []string
+}
+
+// NewSubscriptionResolver creates a resolver for the feeds at urls. Feeds
+// cached in cacheDir, if any, are loaded immediately; call Update or Run to
+// fetch them.
+func NewSubscriptionResolver(client *http.Client, cacheDir string, urls ...string) (*SubscriptionResolver, error) {
+	if client == nil {
+		return nil, errors.New("subscription resolver needs an http.Client which can reach I2P")
+	}
+	s := &SubscriptionResolver{
+		Client:       client,
+		Interval:     DefaultSubscriptionInterval,
+		MaxSize:      DefaultSubscriptionMaxSize,
+		CacheDir:     cacheDir,
+		urls:         urls,
+		hosts:        make([]map[string]i2pkeys.I2PAddr, len(urls)),
+		lastModified: make([]string, len(urls)),
+	}
+	if cacheDir != "" {
+		for i, u := range urls {
+			f, err := os.Open(s.cachePath(u))
+			if err != nil {
+				continue
+			}
+			s.hosts[i], _ = ParseHostsTxt(f)
+			f.Close()
+		}
+	}
+	return s, nil
+}
+
+func (s *SubscriptionResolver) cachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(s.CacheDir, hex.EncodeToString(sum[:8])+".hosts.txt")
+}
+
+// Update fetches every feed which changed since the last fetch. Feeds which
+// fail to download keep their previous contents; the first error is returned.
+func (s *SubscriptionResolver) Update(ctx context.Context) error {
+	var first error
+	for i, u := range s.urls {
+		if err := s.fetch(ctx, i, u); err != nil {
+			defaultLog().Warn("Failed to fetch subscription", "error", err, "url", u)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func (s *SubscriptionResolver) fetch(ctx context.Context, i int, url string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	s.mu.Lock()
+	if s.lastModified[i] != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified[i])
+	}
+	s.mu.Unlock()
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("subscription %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, s.MaxSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > s.MaxSize {
+		return fmt.Errorf("subscription %s: larger than %d bytes", url, s.MaxSize)
+	}
+	hosts, err := ParseHostsTxt(strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	defaultLog().Debug("Fetched subscription", "url", url, "entries", len(hosts))
+	s.mu.Lock()
+	s.hosts[i] = hosts
+	s.lastModified[i] = resp.Header.Get("Last-Modified")
+	s.mu.Unlock()
+	if s.CacheDir != "" {
+		if err := os.WriteFile(s.cachePath(url), body, 0644); err != nil {
+			return fmt.Errorf("error caching subscription: %w", err)
+		}
+	}
+	return nil
+}
+
+// Run updates the feeds every Interval until ctx is cancelled.
+func (s *SubscriptionResolver) Run(ctx context.Context) {
+	for {
+		s.Update(ctx)
+		select {
+		case <-time.After(s.Interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *SubscriptionResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = strings.ToLower(name)
+	for _, hosts := range s.hosts {
+		if addr, ok := hosts[name]; ok {
+			return addr, nil
+		}
+	}
+	return i2pkeys.I2PAddr(""), notFound(name)
+}
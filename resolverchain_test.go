@@ -0,0 +1,153 @@
+package sam3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func Test_ParseHostsTxt(t *testing.T) {
+	a, b := samtest.Keys(t).Addr(), samtest.Keys(t).Addr()
+	in := "# comment\n\n" +
+		"a.i2p=" + a.Base64() + "\n" +
+		"B.i2p=" + b.Base64() + "#!sig=xyz\n" +
+		"broken.i2p=notbase64\n" +
+		"nonsense\n"
+	hosts, err := ParseHostsTxt(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts["a.i2p"] != a || hosts["b.i2p"] != b {
+		t.Errorf("ParseHostsTxt = %v", hosts)
+	}
+}
+
+func Test_HostsFileResolverReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.txt")
+	h, err := NewHostsFileResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ReloadInterval = 0
+	if _, err := h.Resolve("a.i2p"); err == nil {
+		t.Fatal("resolved a name from a file which does not exist")
+	}
+	a := samtest.Keys(t).Addr()
+	if err := os.WriteFile(path, []byte("a.i2p="+a.Base64()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if addr, err := h.Resolve("a.i2p"); err != nil || addr != a {
+		t.Fatalf("Resolve(a.i2p) = %s, %v", addr.Base32(), err)
+	}
+	b := samtest.Keys(t).Addr()
+	if err := os.WriteFile(path, []byte("a.i2p="+b.Base64()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if addr, err := h.Resolve("a.i2p"); err != nil || addr != b {
+		t.Errorf("file was not reloaded: %s, %v", addr.Base32(), err)
+	}
+}
+
+func Test_SubscriptionResolver(t *testing.T) {
+	a := samtest.Keys(t).Addr()
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if r.Header.Get("If-Modified-Since") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		fmt.Fprintf(w, "feed.i2p=%s\n", a.Base64())
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	s, err := NewSubscriptionResolver(srv.Client(), dir, srv.URL+"/hosts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Resolve("feed.i2p"); err == nil {
+		t.Fatal("resolved a name before fetching the feed")
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Update(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if addr, err := s.Resolve("feed.i2p"); err != nil || addr != a {
+		t.Errorf("Resolve(feed.i2p) = %s, %v", addr.Base32(), err)
+	}
+	// a new resolver starts from the cached copy of the feed
+	s2, _ := NewSubscriptionResolver(srv.Client(), dir, srv.URL+"/hosts.txt")
+	if addr, err := s2.Resolve("feed.i2p"); err != nil || addr != a {
+		t.Errorf("cached feed was not loaded: %s, %v", addr.Base32(), err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("%d fetches, want 2", n)
+	}
+
+	// a feed which is too large is rejected and the previous one kept
+	s.MaxSize = 10
+	s.lastModified[0] = ""
+	if err := s.Update(context.Background()); err == nil || !strings.Contains(err.Error(), "larger than 10 bytes") {
+		t.Errorf("oversized feed: %v", err)
+	}
+	if _, err := s.Resolve("feed.i2p"); err != nil {
+		t.Errorf("previous feed dropped: %v", err)
+	}
+}
+
+func Test_ChainResolverSession(t *testing.T) {
+	static, remote := samtest.Keys(t).Addr(), samtest.Keys(t).Addr()
+	var lookups int32
+	b := namingBridge(t, map[string]string{"remote.i2p": remote.Base64()}, &lookups, 0)
+	sr, err := NewFullSAMResolver(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sr.Close()
+	chain := NewChainResolver(StaticResolver{"private.i2p": static}, sr)
+
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	ss, err := sam.NewStreamSession("chainTun", samtest.Keys(t), []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.SetResolver(chain)
+	rec := new(recordMetrics)
+	ss.SetMetrics(rec)
+	for name, want := range map[string]i2pkeys.I2PAddr{"private.i2p": static, "remote.i2p": remote} {
+		if addr, err := ss.Lookup(name); err != nil || addr != want {
+			t.Errorf("Lookup(%s) = %s, %v", name, addr.Base32(), err)
+		}
+	}
+	if _, err := ss.Lookup("missing.i2p"); err == nil {
+		t.Error("resolved a name no resolver knows")
+	}
+	ss.Lookup("remote.i2p")
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Errorf("%d lookups reached the bridge, want 2", n)
+	}
+	// only the lookups of the SAMResolver in the chain are counted
+	want := "resolved chainTun STREAM false\nresolved chainTun STREAM false\nresolved chainTun STREAM true"
+	if got := rec.String(); got != want {
+		t.Errorf("metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
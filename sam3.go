@@ -3,15 +3,15 @@ package sam3
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"io"
 	"math/rand"
 	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-i2p/i2pkeys"
 
@@ -29,7 +29,13 @@ type SAM struct {
 	resolver *SAMResolver
 	Config   SAMEmit
 	keys     *i2pkeys.I2PKeys
-	sigType  int
+	sigType  SigType
+	// validation says what session constructors do with invalid options
+	validation ValidationMode
+	// version is the SAM version agreed on in the HELLO REPLY
+	version string
+	logger  Logger  // nil for the default, see SetLogger
+	metrics Metrics // nil for the default, see SetMetrics
 }
 
 const (
@@ -40,15 +46,6 @@ const (
 	session_I2P_ERROR      = "SESSION STATUS RESULT=I2P_ERROR MESSAGE="
 )
 
-const (
-	Sig_NONE                 = "SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"
-	Sig_DSA_SHA1             = "SIGNATURE_TYPE=DSA_SHA1"
-	Sig_ECDSA_SHA256_P256    = "SIGNATURE_TYPE=ECDSA_SHA256_P256"
-	Sig_ECDSA_SHA384_P384    = "SIGNATURE_TYPE=ECDSA_SHA384_P384"
-	Sig_ECDSA_SHA512_P521    = "SIGNATURE_TYPE=ECDSA_SHA512_P521"
-	Sig_EdDSA_SHA512_Ed25519 = "SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"
-)
-
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func RandString() string {
@@ -57,49 +54,51 @@ func RandString() string {
 	for i := range b {
 		b[i] = letters[rand.Intn(len(letters))]
 	}
-	log.WithField("randomString", string(b)).Debug("Generated random string")
+	defaultLog().Debug("Generated random string", "randomString", string(b))
 	return string(b)
 }
 
 // Creates a new controller for the I2P routers SAM bridge.
 func NewSAM(address string) (*SAM, error) {
-	log.WithField("address", address).Debug("Creating new SAM instance")
+	defaultLog().Debug("Creating new SAM instance", "address", address)
 	var s SAM
+	s.address = address
 	// TODO: clean this up
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
-		log.WithError(err).Error("Failed to dial SAM address")
+		defaultLog().Error("Failed to dial SAM address", "error", err)
 		return nil, fmt.Errorf("error dialing to address '%s': %w", address, err)
 	}
 	if _, err := conn.Write(s.Config.HelloBytes()); err != nil {
-		log.WithError(err).Error("Failed to write hello message")
+		defaultLog().Error("Failed to write hello message", "error", err)
 		conn.Close()
 		return nil, fmt.Errorf("error writing to address '%s': %w", address, err)
 	}
 	buf := make([]byte, 256)
 	n, err := conn.Read(buf)
 	if err != nil {
-		log.WithError(err).Error("Failed to read SAM response")
+		defaultLog().Error("Failed to read SAM response", "error", err)
 		conn.Close()
 		return nil, fmt.Errorf("error reading onto buffer: %w", err)
 	}
 	if strings.Contains(string(buf[:n]), "HELLO REPLY RESULT=OK") {
-		log.Debug("SAM hello successful")
+		defaultLog().Debug("SAM hello successful")
+		s.version = replyValue(string(buf[:n]), "VERSION")
 		s.Config.I2PConfig.SetSAMAddress(address)
 		s.conn = conn
 		//s.Config.I2PConfig.DestinationKeys = nil
 		s.resolver, err = NewSAMResolver(&s)
 		if err != nil {
-			log.WithError(err).Error("Failed to create SAM resolver")
+			defaultLog().Error("Failed to create SAM resolver", "error", err)
 			return nil, fmt.Errorf("error creating resolver: %w", err)
 		}
 		return &s, nil
 	} else if string(buf[:n]) == "HELLO REPLY RESULT=NOVERSION\n" {
-		log.Error("SAM bridge does not support SAMv3")
+		defaultLog().Error("SAM bridge does not support SAMv3")
 		conn.Close()
 		return nil, errors.New("That SAM bridge does not support SAMv3.")
 	} else {
-		log.WithField("response", string(buf[:n])).Error("Unexpected SAM response")
+		defaultLog().Error("Unexpected SAM response", "response", string(buf[:n]))
 		conn.Close()
 		return nil, errors.New(string(buf[:n]))
 	}
@@ -107,35 +106,33 @@ func NewSAM(address string) (*SAM, error) {
 
 func (sam *SAM) Keys() (k *i2pkeys.I2PKeys) {
 	//TODO: copy them?
-	log.Debug("Retrieving SAM keys")
+	sam.log().Debug("Retrieving SAM keys")
 	k = &sam.Config.I2PConfig.DestinationKeys
 	return
 }
 
 // read public/private keys from an io.Reader
 func (sam *SAM) ReadKeys(r io.Reader) (err error) {
-	log.Debug("Reading keys from io.Reader")
+	sam.log().Debug("Reading keys from io.Reader")
 	var keys i2pkeys.I2PKeys
 	keys, err = i2pkeys.LoadKeysIncompat(r)
 	if err == nil {
-		log.Debug("Keys loaded successfully")
+		sam.log().Debug("Keys loaded successfully")
 		sam.Config.I2PConfig.DestinationKeys = keys
+		return
 	}
-	log.WithError(err).Error("Failed to load keys")
+	sam.log().Error("Failed to load keys", "error", err)
 	return
 }
 
 // if keyfile fname does not exist
 func (sam *SAM) EnsureKeyfile(fname string) (keys i2pkeys.I2PKeys, err error) {
-	log.WithError(err).Error("Failed to load keys")
 	if fname == "" {
 		// transient
 		keys, err = sam.NewKeys()
 		if err == nil {
 			sam.Config.I2PConfig.DestinationKeys = keys
-			log.WithFields(logrus.Fields{
-				"keys": keys,
-			}).Debug("Generated new transient keys")
+			sam.log().Debug("Generated new transient keys", "addr", keys.Addr().Base32())
 		}
 	} else {
 		// persistent
@@ -151,7 +148,7 @@ func (sam *SAM) EnsureKeyfile(fname string) (keys i2pkeys.I2PKeys, err error) {
 				if err == nil {
 					err = i2pkeys.StoreKeysIncompat(keys, f)
 					f.Close()
-					log.Debug("Generated and saved new keys")
+					sam.log().Debug("Generated and saved new keys")
 				}
 			}
 		} else if err == nil {
@@ -162,37 +159,74 @@ func (sam *SAM) EnsureKeyfile(fname string) (keys i2pkeys.I2PKeys, err error) {
 				keys, err = i2pkeys.LoadKeysIncompat(f)
 				if err == nil {
 					sam.Config.I2PConfig.DestinationKeys = keys
-					log.Debug("Loaded existing keys from file")
+					sam.log().Debug("Loaded existing keys from file")
 				}
 			}
 		}
 	}
 	if err != nil {
-		log.WithError(err).Error("Failed to ensure keyfile")
+		sam.log().Error("Failed to ensure keyfile", "error", err)
 	}
 	return
 }
 
 // Creates the I2P-equivalent of an IP address, that is unique and only the one
 // who has the private keys can send messages from. The public keys are the I2P
-// desination (the address) that anyone can send messages to.
-func (sam *SAM) NewKeys(sigType ...string) (i2pkeys.I2PKeys, error) {
-	log.WithField("sigType", sigType).Debug("Generating new keys")
-	sigtmp := ""
+// desination (the address) that anyone can send messages to. If no signature
+// type is given the one from sam.Config is used, if one was set, and the
+// bridge's default of DSA_SHA1 otherwise.
+func (sam *SAM) NewKeys(sigType ...SigType) (i2pkeys.I2PKeys, error) {
+	sam.log().Debug("Generating new keys", "sigType", sigType)
+	emit := sam.Config
 	if len(sigType) > 0 {
-		sigtmp = sigType[0]
+		if !sigType[0].Valid() {
+			sam.log().Error("Invalid signature type", "sigType", sigType[0])
+			return i2pkeys.I2PKeys{}, fmt.Errorf("invalid signature type %s", sigType[0])
+		}
+		emit.I2PConfig.SigType = &sigType[0]
 	}
-	if _, err := sam.conn.Write([]byte("DEST GENERATE " + sigtmp + "\n")); err != nil {
-		log.WithError(err).Error("Failed to write DEST GENERATE command")
+	if _, err := sam.conn.Write(emit.GenerateDestinationBytes()); err != nil {
+		sam.log().Error("Failed to write DEST GENERATE command", "error", err)
 		return i2pkeys.I2PKeys{}, fmt.Errorf("error with writing in SAM: %w", err)
 	}
-	buf := make([]byte, 8192)
-	n, err := sam.conn.Read(buf)
+	reply, err := readReplyLine(sam.conn)
 	if err != nil {
-		log.WithError(err).Error("Failed to read SAM response for key generation")
+		sam.log().Error("Failed to read SAM response for key generation", "error", err)
 		return i2pkeys.I2PKeys{}, fmt.Errorf("error with reading in SAM: %w", err)
 	}
-	s := bufio.NewScanner(bytes.NewReader(buf[:n]))
+	keys, err := parseDestReply(reply)
+	if err != nil {
+		sam.log().Error("Failed to parse keys from SAM response", "error", err)
+		return i2pkeys.I2PKeys{}, err
+	}
+	sam.log().Debug("Successfully generated new keys")
+	return keys, nil
+}
+
+// readReplyLine reads one reply line from the bridge. Replies such as keys of
+// large signature types or lookups with options can arrive in pieces.
+func readReplyLine(conn net.Conn) (string, error) {
+	buf := make([]byte, 4096)
+	n := 0
+	for n == 0 || buf[n-1] != '\n' {
+		if n == len(buf) {
+			buf = append(buf, make([]byte, len(buf))...)
+		}
+		m, err := conn.Read(buf[n:])
+		n += m
+		if err != nil {
+			if err == io.EOF && n > 0 {
+				break
+			}
+			return string(buf[:n]), err
+		}
+	}
+	return string(buf[:n]), nil
+}
+
+// parseDestReply parses the keys out of a DEST REPLY line.
+func parseDestReply(reply string) (i2pkeys.I2PKeys, error) {
+	s := bufio.NewScanner(strings.NewReader(reply))
 	s.Split(bufio.ScanWords)
 
 	var pub, priv string
@@ -206,19 +240,58 @@ func (sam *SAM) NewKeys(sigType ...string) (i2pkeys.I2PKeys, error) {
 			pub = text[4:]
 		} else if strings.HasPrefix(text, "PRIV=") {
 			priv = text[5:]
+		} else if strings.HasPrefix(text, "RESULT=") {
+			return i2pkeys.I2PKeys{}, errors.New("Failed to generate keys: " + strings.TrimSpace(reply))
 		} else {
-			log.Error("Failed to parse keys from SAM response")
 			return i2pkeys.I2PKeys{}, errors.New("Failed to parse keys.")
 		}
 	}
-	log.Debug("Successfully generated new keys")
+	if pub == "" || priv == "" {
+		return i2pkeys.I2PKeys{}, errors.New("Failed to parse keys.")
+	}
 	return NewKeys(I2PAddr(pub), priv), nil
 }
 
+// Version returns the SAM version the bridge agreed to in its HELLO REPLY,
+// such as "3.3". Bridges speaking SAM 3.0 may not say and leave it empty.
+func (sam *SAM) Version() string {
+	return sam.version
+}
+
+// Ping sends a PING to the bridge and waits for the PONG, returning the
+// round trip time. PING was added in SAM 3.2. It must not be used while
+// another command is in progress on the same SAM.
+func (sam *SAM) Ping() (time.Duration, error) {
+	text := "sam3-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	start := time.Now()
+	if _, err := io.WriteString(sam.conn, samEmit.Ping(text)); err != nil {
+		return 0, fmt.Errorf("writing PING: %w", err)
+	}
+	reply, err := readReplyLine(sam.conn)
+	if err != nil {
+		return 0, fmt.Errorf("reading PONG: %w", err)
+	}
+	rtt := time.Since(start)
+	if strings.TrimSpace(reply) != "PONG "+text {
+		return 0, fmt.Errorf("unexpected reply to PING: %s", strings.TrimSpace(reply))
+	}
+	return rtt, nil
+}
+
+// replyValue returns the value of key in a reply line, or "".
+func replyValue(reply, key string) string {
+	for _, w := range tokenizeReply(strings.TrimSpace(reply)) {
+		if k, v, ok := splitPair(w); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
 // Performs a lookup, probably this order: 1) routers known addresses, cached
 // addresses, 3) by asking peers in the I2P network.
 func (sam *SAM) Lookup(name string) (i2pkeys.I2PAddr, error) {
-	log.WithField("name", name).Debug("Looking up address")
+	sam.log().Debug("Looking up address", "name", name)
 	return sam.resolver.Resolve(name)
 }
 
@@ -228,12 +301,12 @@ func (sam *SAM) Lookup(name string) (i2pkeys.I2PAddr, error) {
 // setting extra to something else than []string{}.
 // This sam3 instance is now a session
 func (sam *SAM) newGenericSession(style, id string, keys i2pkeys.I2PKeys, options []string, extras []string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"style": style, "id": id}).Debug("Creating new generic session")
-	return sam.newGenericSessionWithSignature(style, id, keys, Sig_NONE, options, extras)
+	sam.log().Debug("Creating new generic session", "style", style, "id", id)
+	return sam.newGenericSessionWithSignature(style, id, keys, keysSigType(keys), options, extras)
 }
 
-func (sam *SAM) newGenericSessionWithSignature(style, id string, keys i2pkeys.I2PKeys, sigType string, options []string, extras []string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"style": style, "id": id, "sigType": sigType}).Debug("Creating new generic session with signature")
+func (sam *SAM) newGenericSessionWithSignature(style, id string, keys i2pkeys.I2PKeys, sigType SigType, options []string, extras []string) (net.Conn, error) {
+	sam.log().Debug("Creating new generic session with signature", "style", style, "id", id, "sigType", sigType)
 	return sam.newGenericSessionWithSignatureAndPorts(style, id, "0", "0", keys, sigType, options, extras)
 }
 
@@ -242,33 +315,39 @@ func (sam *SAM) newGenericSessionWithSignature(style, id string, keys i2pkeys.I2
 // I2CP/streaminglib-options as specified. Extra arguments can be specified by
 // setting extra to something else than []string{}.
 // This sam3 instance is now a session
-func (sam *SAM) newGenericSessionWithSignatureAndPorts(style, id, from, to string, keys i2pkeys.I2PKeys, sigType string, options []string, extras []string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"style": style, "id": id, "from": from, "to": to, "sigType": sigType}).Debug("Creating new generic session with signature and ports")
+func (sam *SAM) newGenericSessionWithSignatureAndPorts(style, id, from, to string, keys i2pkeys.I2PKeys, sigType SigType, options []string, extras []string) (net.Conn, error) {
+	sam.log().Debug("Creating new generic session with signature and ports", "style", style, "id", id, "from", from, "to", to, "sigType", sigType)
+
+	if !sigType.Valid() {
+		sam.log().Error("Invalid signature type", "sigType", sigType)
+		return nil, fmt.Errorf("invalid signature type %s", sigType)
+	}
+	if ksig, err := KeysSigType(keys); err == nil && ksig != sigType {
+		sam.log().Error("Signature type does not match keys", "keys", ksig, "requested", sigType)
+		return nil, fmt.Errorf("keys have signature type %s, not %s", ksig, sigType)
+	}
 
+	if err := sam.checkOptions(options); err != nil {
+		return nil, fmt.Errorf("session %s: %w", id, err)
+	}
 	optStr := GenerateOptionString(options)
 
 	conn := sam.conn
-	fp := ""
-	tp := ""
-	if from != "0" {
-		fp = " FROM_PORT=" + from
-	}
-	if to != "0" {
-		tp = " TO_PORT=" + to
-	}
-	scmsg := []byte("SESSION CREATE STYLE=" + style + fp + tp + " ID=" + id + " DESTINATION=" + keys.String() + " " + optStr + strings.Join(extras, " ") + "\n")
+	scmsg := []byte(sam.Config.CreateSession(style, id, from, to, keys.String(), sigType, append([]string{optStr}, extras...)...))
 
-	log.WithField("message", string(scmsg)).Debug("Sending SESSION CREATE message")
+	sam.log().Debug("Sending SESSION CREATE message", "message", redact(string(scmsg)))
 
 	for m, i := 0, 0; m != len(scmsg); i++ {
 		if i == 15 {
-			log.Error("Failed to write SESSION CREATE message after 15 attempts")
+			sam.log().Error("Failed to write SESSION CREATE message after 15 attempts")
+			sam.stats().SessionCreated(id, style, ResultNoReply)
 			conn.Close()
 			return nil, errors.New("writing to SAM failed")
 		}
 		n, err := conn.Write(scmsg[m:])
 		if err != nil {
-			log.WithError(err).Error("Failed to write to SAM connection")
+			sam.log().Error("Failed to write to SAM connection", "error", err)
+			sam.stats().SessionCreated(id, style, ResultNoReply)
 			conn.Close()
 			return nil, fmt.Errorf("writing to connection failed: %w", err)
 		}
@@ -277,38 +356,40 @@ func (sam *SAM) newGenericSessionWithSignatureAndPorts(style, id, from, to strin
 	buf := make([]byte, 4096)
 	n, err := conn.Read(buf)
 	if err != nil {
-		log.WithError(err).Error("Failed to read SAM response")
+		sam.log().Error("Failed to read SAM response", "error", err)
+		sam.stats().SessionCreated(id, style, ResultNoReply)
 		conn.Close()
 		return nil, fmt.Errorf("reading from connection failed: %w", err)
 	}
 	text := string(buf[:n])
-	log.WithField("response", text).Debug("Received SAM response")
+	sam.stats().SessionCreated(id, style, replyResult(text))
+	sam.log().Debug("Received SAM response", "response", redact(text))
 	if strings.HasPrefix(text, session_OK) {
 		if keys.String() != text[len(session_OK):len(text)-1] {
-			log.Error("SAM created a tunnel with different keys than requested")
+			sam.log().Error("SAM created a tunnel with different keys than requested")
 			conn.Close()
 			return nil, errors.New("SAMv3 created a tunnel with keys other than the ones we asked it for")
 		}
-		log.Debug("Successfully created new session")
+		sam.log().Debug("Successfully created new session")
 		return conn, nil //&StreamSession{id, conn, keys, nil, sync.RWMutex{}, nil}, nil
 	} else if text == session_DUPLICATE_ID {
-		log.Error("Duplicate tunnel name")
+		sam.log().Error("Duplicate tunnel name")
 		conn.Close()
 		return nil, errors.New("Duplicate tunnel name")
 	} else if text == session_DUPLICATE_DEST {
-		log.Error("Duplicate destination")
+		sam.log().Error("Duplicate destination")
 		conn.Close()
 		return nil, errors.New("Duplicate destination")
 	} else if text == session_INVALID_KEY {
-		log.Error("Invalid key for SAM session")
+		sam.log().Error("Invalid key for SAM session")
 		conn.Close()
 		return nil, errors.New("Invalid key - SAM session")
 	} else if strings.HasPrefix(text, session_I2P_ERROR) {
-		log.WithField("error", text[len(session_I2P_ERROR):]).Error("I2P error")
+		sam.log().Error("I2P error", "error", text[len(session_I2P_ERROR):])
 		conn.Close()
 		return nil, errors.New("I2P error " + text[len(session_I2P_ERROR):])
 	} else {
-		log.WithField("reply", text).Error("Unable to parse SAMv3 reply")
+		sam.log().Error("Unable to parse SAMv3 reply", "reply", redact(text))
 		conn.Close()
 		return nil, errors.New("Unable to parse SAMv3 reply: " + text)
 	}
@@ -316,6 +397,6 @@ func (sam *SAM) newGenericSessionWithSignatureAndPorts(style, id, from, to strin
 
 // close this sam session
 func (sam *SAM) Close() error {
-	log.Debug("Closing SAM session")
+	sam.log().Debug("Closing SAM session")
 	return sam.conn.Close()
 }
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"testing"
 	"time"
+
+	"github.com/go-i2p/sam3/internal/samtest"
 )
 
 const yoursam = "127.0.0.1:7656"
@@ -42,6 +44,24 @@ func Test_Basic(t *testing.T) {
 	}
 }
 
+func Test_VersionPing(t *testing.T) {
+	b := samtest.New(t)
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	if v := sam.Version(); v != "3.3" {
+		t.Errorf("Version = %q", v)
+	}
+	// the PONG is read completely, so a second PING gets its own
+	for i := 0; i < 2; i++ {
+		if _, err := sam.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 /*
 func Test_GenericSession(t *testing.T) {
 	if testing.Short() {
@@ -0,0 +1,166 @@
+package sam3
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+// SigType is an I2P signature type. The value of a SigType is the numeric code
+// used by the I2P key certificate and accepted by the SAM bridge, so the zero
+// value is DSA_SHA1, which is also what the bridge uses when no
+// SIGNATURE_TYPE is given.
+type SigType int
+
+const (
+	Sig_DSA_SHA1               SigType = 0
+	Sig_ECDSA_SHA256_P256      SigType = 1
+	Sig_ECDSA_SHA384_P384      SigType = 2
+	Sig_ECDSA_SHA512_P521      SigType = 3
+	Sig_RSA_SHA256_2048        SigType = 4
+	Sig_RSA_SHA384_3072        SigType = 5
+	Sig_RSA_SHA512_4096        SigType = 6
+	Sig_EdDSA_SHA512_Ed25519   SigType = 7
+	Sig_EdDSA_SHA512_Ed25519ph SigType = 8
+	Sig_RedDSA_SHA512_Ed25519  SigType = 11
+
+	// Sig_NONE is the signature type used by the library when none is
+	// requested explicitly.
+	//
+	// Deprecated: despite its name Sig_NONE has always been Ed25519, use
+	// Sig_EdDSA_SHA512_Ed25519 instead.
+	Sig_NONE = Sig_EdDSA_SHA512_Ed25519
+
+	// Sig_DEFAULT is the signature type recommended for new destinations.
+	Sig_DEFAULT = Sig_EdDSA_SHA512_Ed25519
+)
+
+var sigTypeNames = map[SigType]string{
+	Sig_DSA_SHA1:               "DSA_SHA1",
+	Sig_ECDSA_SHA256_P256:      "ECDSA_SHA256_P256",
+	Sig_ECDSA_SHA384_P384:      "ECDSA_SHA384_P384",
+	Sig_ECDSA_SHA512_P521:      "ECDSA_SHA512_P521",
+	Sig_RSA_SHA256_2048:        "RSA_SHA256_2048",
+	Sig_RSA_SHA384_3072:        "RSA_SHA384_3072",
+	Sig_RSA_SHA512_4096:        "RSA_SHA512_4096",
+	Sig_EdDSA_SHA512_Ed25519:   "EdDSA_SHA512_Ed25519",
+	Sig_EdDSA_SHA512_Ed25519ph: "EdDSA_SHA512_Ed25519ph",
+	Sig_RedDSA_SHA512_Ed25519:  "RedDSA_SHA512_Ed25519",
+}
+
+// Code returns the numeric code of the signature type.
+func (s SigType) Code() int {
+	return int(s)
+}
+
+// String returns the name of the signature type as used in the I2P
+// specifications, e.g. "EdDSA_SHA512_Ed25519".
+func (s SigType) String() string {
+	if name, ok := sigTypeNames[s]; ok {
+		return name
+	}
+	return "SigType(" + strconv.Itoa(int(s)) + ")"
+}
+
+// MarshalText encodes s by name, so configuration files stay readable.
+func (s SigType) MarshalText() ([]byte, error) {
+	if !s.Valid() {
+		return nil, fmt.Errorf("unknown signature type %d", int(s))
+	}
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText accepts anything ParseSigType does.
+func (s *SigType) UnmarshalText(text []byte) error {
+	st, err := ParseSigType(string(text))
+	if err != nil {
+		return err
+	}
+	*s = st
+	return nil
+}
+
+// Valid reports whether s is a signature type known to the library.
+func (s SigType) Valid() bool {
+	_, ok := sigTypeNames[s]
+	return ok
+}
+
+// Option returns the SIGNATURE_TYPE=... token for SAM commands.
+func (s SigType) Option() string {
+	return "SIGNATURE_TYPE=" + strconv.Itoa(int(s))
+}
+
+// ParseSigType parses a signature type from its name or numeric code. A
+// leading "SIGNATURE_TYPE=" is accepted and names are matched without regard
+// to case.
+func ParseSigType(s string) (SigType, error) {
+	v := strings.TrimSpace(s)
+	v = strings.TrimPrefix(v, "SIGNATURE_TYPE=")
+	if code, err := strconv.Atoi(v); err == nil {
+		if st := SigType(code); st.Valid() {
+			return st, nil
+		}
+		return 0, fmt.Errorf("unknown signature type code %d", code)
+	}
+	for st, name := range sigTypeNames {
+		if strings.EqualFold(name, v) {
+			return st, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown signature type %q", s)
+}
+
+const (
+	destPublicKeyLen  = 256
+	destSigningKeyLen = 128
+	certTypeNull      = 0
+	certTypeKey       = 5
+)
+
+// AddrSigType returns the signature type of a destination by inspecting its
+// certificate. Destinations with a NULL certificate are DSA_SHA1.
+func AddrSigType(addr i2pkeys.I2PAddr) (SigType, error) {
+	b, err := addr.ToBytes()
+	if err != nil {
+		return 0, fmt.Errorf("error decoding destination: %w", err)
+	}
+	certOffset := destPublicKeyLen + destSigningKeyLen
+	if len(b) < certOffset+3 {
+		return 0, fmt.Errorf("destination too short: %d bytes", len(b))
+	}
+	certLen := int(binary.BigEndian.Uint16(b[certOffset+1 : certOffset+3]))
+	switch b[certOffset] {
+	case certTypeNull:
+		return Sig_DSA_SHA1, nil
+	case certTypeKey:
+		if certLen < 4 || len(b) < certOffset+3+certLen {
+			return 0, fmt.Errorf("truncated key certificate")
+		}
+		st := SigType(binary.BigEndian.Uint16(b[certOffset+3 : certOffset+5]))
+		if !st.Valid() {
+			return st, fmt.Errorf("unknown signature type code %d", st.Code())
+		}
+		return st, nil
+	default:
+		return 0, fmt.Errorf("unsupported certificate type %d", b[certOffset])
+	}
+}
+
+// KeysSigType returns the signature type of an existing set of I2PKeys.
+func KeysSigType(keys i2pkeys.I2PKeys) (SigType, error) {
+	return AddrSigType(keys.Addr())
+}
+
+// keysSigType is KeysSigType for callers which need a value regardless,
+// falling back to Sig_DEFAULT when the keys can not be inspected.
+func keysSigType(keys i2pkeys.I2PKeys) SigType {
+	st, err := KeysSigType(keys)
+	if err != nil {
+		return Sig_DEFAULT
+	}
+	return st
+}
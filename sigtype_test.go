@@ -0,0 +1,71 @@
+package sam3
+
+import (
+	"testing"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+func fakeDestination(t *testing.T, cert []byte) i2pkeys.I2PAddr {
+	t.Helper()
+	b := make([]byte, destPublicKeyLen+destSigningKeyLen, destPublicKeyLen+destSigningKeyLen+len(cert))
+	b = append(b, cert...)
+	addr, err := i2pkeys.NewI2PAddrFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+func Test_ParseSigType(t *testing.T) {
+	for in, want := range map[string]SigType{
+		"7":                                   Sig_EdDSA_SHA512_Ed25519,
+		"EdDSA_SHA512_Ed25519":                Sig_EdDSA_SHA512_Ed25519,
+		"eddsa_sha512_ed25519":                Sig_EdDSA_SHA512_Ed25519,
+		"SIGNATURE_TYPE=ECDSA_SHA256_P256":    Sig_ECDSA_SHA256_P256,
+		"SIGNATURE_TYPE=0":                    Sig_DSA_SHA1,
+		"RedDSA_SHA512_Ed25519":               Sig_RedDSA_SHA512_Ed25519,
+		"SIGNATURE_TYPE=EdDSA_SHA512_Ed25519": Sig_NONE,
+	} {
+		got, err := ParseSigType(in)
+		if err != nil {
+			t.Errorf("ParseSigType(%q): %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseSigType(%q) = %s, want %s", in, got, want)
+		}
+	}
+	for _, in := range []string{"", "9", "-1", "Ed448"} {
+		if _, err := ParseSigType(in); err == nil {
+			t.Errorf("ParseSigType(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func Test_SigTypeStrings(t *testing.T) {
+	if s := Sig_EdDSA_SHA512_Ed25519.String(); s != "EdDSA_SHA512_Ed25519" {
+		t.Errorf("String() = %q", s)
+	}
+	if s := Sig_EdDSA_SHA512_Ed25519.Option(); s != "SIGNATURE_TYPE=7" {
+		t.Errorf("Option() = %q", s)
+	}
+	if SigType(42).Valid() {
+		t.Error("SigType(42) should not be valid")
+	}
+}
+
+func Test_AddrSigType(t *testing.T) {
+	ed := fakeDestination(t, []byte{certTypeKey, 0, 4, 0, 7, 0, 4})
+	if st, err := AddrSigType(ed); err != nil || st != Sig_EdDSA_SHA512_Ed25519 {
+		t.Errorf("AddrSigType(key cert) = %s, %v", st, err)
+	}
+	dsa := fakeDestination(t, []byte{certTypeNull, 0, 0})
+	if st, err := KeysSigType(i2pkeys.NewKeys(dsa, "")); err != nil || st != Sig_DSA_SHA1 {
+		t.Errorf("KeysSigType(null cert) = %s, %v", st, err)
+	}
+	truncated := fakeDestination(t, []byte{certTypeKey, 0, 4, 0})
+	if _, err := AddrSigType(truncated); err == nil {
+		t.Error("AddrSigType(truncated cert) succeeded, want error")
+	}
+}
@@ -0,0 +1,167 @@
+package socks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-i2p/sam3"
+)
+
+// ErrNoDatagram is returned by Sessions without datagram support, the
+// server refuses UDP ASSOCIATE then.
+var ErrNoDatagram = errors.New("no datagram session")
+
+// Sessions gives the server the sessions to use for a SOCKS user. The user
+// is "" if the client did not authenticate.
+type Sessions interface {
+	Stream(user string) (*sam3.StreamSession, error)
+	Datagram(user string) (*sam3.DatagramSession, error)
+}
+
+type shared struct {
+	stream *sam3.StreamSession
+	dgram  *sam3.DatagramSession
+}
+
+// Shared makes all users share stream and dgram, and with them one
+// destination. dgram may be nil if UDP ASSOCIATE is not needed.
+func Shared(stream *sam3.StreamSession, dgram *sam3.DatagramSession) Sessions {
+	return &shared{stream, dgram}
+}
+
+func (s *shared) Stream(string) (*sam3.StreamSession, error) {
+	return s.stream, nil
+}
+
+func (s *shared) Datagram(string) (*sam3.DatagramSession, error) {
+	if s.dgram == nil {
+		return nil, ErrNoDatagram
+	}
+	return s.dgram, nil
+}
+
+// PerUser isolates SOCKS users from each other: every user gets a primary
+// session with a new destination the first time they connect, with stream
+// and datagram subsessions added when they are first needed.
+type PerUser struct {
+	samAddr string
+	options []string
+	udpPort int
+
+	mu     sync.Mutex
+	users  map[string]*user
+	closed bool
+}
+
+type user struct {
+	mu      sync.Mutex
+	primary *sam3.PrimarySession
+	stream  *sam3.StreamSession
+	dgram   *sam3.DatagramSession
+}
+
+// NewPerUser creates the sessions of each user on the SAM bridge at samAddr
+// with options. udpPort is the UDP port of the bridge, 0 for the default.
+func NewPerUser(samAddr string, options []string, udpPort int) *PerUser {
+	return &PerUser{
+		samAddr: samAddr,
+		options: options,
+		udpPort: udpPort,
+		users:   make(map[string]*user),
+	}
+}
+
+// user returns the user called name with its primary session, which is
+// created if it does not exist yet. The user is returned locked.
+func (p *PerUser) user(name string) (*user, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("sessions closed")
+	}
+	u, ok := p.users[name]
+	if !ok {
+		u = &user{}
+		p.users[name] = u
+	}
+	p.mu.Unlock()
+	u.mu.Lock()
+	if u.primary != nil {
+		return u, nil
+	}
+	sam, err := sam3.NewSAM(p.samAddr)
+	if err != nil {
+		u.mu.Unlock()
+		return nil, err
+	}
+	keys, err := sam.NewKeys()
+	if err != nil {
+		sam.Close()
+		u.mu.Unlock()
+		return nil, fmt.Errorf("generating keys: %w", err)
+	}
+	u.primary, err = sam.NewPrimarySession(sessionID(), keys, p.options)
+	if err != nil {
+		sam.Close()
+		u.mu.Unlock()
+		return nil, fmt.Errorf("creating primary session: %w", err)
+	}
+	return u, nil
+}
+
+// Stream implements Sessions.
+func (p *PerUser) Stream(name string) (*sam3.StreamSession, error) {
+	u, err := p.user(name)
+	if err != nil {
+		return nil, err
+	}
+	defer u.mu.Unlock()
+	if u.stream == nil {
+		u.stream, err = u.primary.NewStreamSubSessionWithPorts(u.primary.ID()+"-stream", "0", "0")
+	}
+	return u.stream, err
+}
+
+// Datagram implements Sessions.
+func (p *PerUser) Datagram(name string) (*sam3.DatagramSession, error) {
+	u, err := p.user(name)
+	if err != nil {
+		return nil, err
+	}
+	defer u.mu.Unlock()
+	if u.dgram == nil {
+		u.dgram, err = u.primary.NewDatagramSubSession(u.primary.ID()+"-dgram", p.udpPort)
+	}
+	return u.dgram, err
+}
+
+// Close closes the sessions of all users.
+func (p *PerUser) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	var err error
+	for _, u := range p.users {
+		u.mu.Lock()
+		if u.dgram != nil {
+			u.dgram.Close()
+		}
+		if u.primary != nil {
+			if cerr := u.primary.Close(); err == nil {
+				err = cerr
+			}
+		}
+		u.mu.Unlock()
+	}
+	p.users = nil
+	return err
+}
+
+func sessionID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "socks-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,568 @@
+// Package socks is a SOCKS5 server which connects unmodified applications
+// to I2P.
+//
+// CONNECT to a .i2p or .b32.i2p host opens a stream, with the port as its
+// TO_PORT. UDP ASSOCIATE relays datagrams to and from .i2p hosts through a
+// repliable datagram session; the port of a datagram is not carried over and
+// replies come from the b32 address of the sender with port 0. Requests for
+// other hosts are refused unless an HTTP outproxy is set, which CONNECT
+// requests are then tunnelled through. Clearnet datagrams are always
+// dropped.
+//
+// Clients may authenticate with a username and password, which selects the
+// sessions they are served with, see Sessions and PerUser.
+package socks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+	samhttp "github.com/go-i2p/sam3/http"
+	"github.com/go-i2p/sam3/internal/netutil"
+)
+
+const (
+	socksVersion = 5
+
+	methodNone     = 0x00
+	methodPassword = 0x02
+	methodRefused  = 0xff
+
+	cmdConnect      = 1
+	cmdBind         = 2
+	cmdUDPAssociate = 3
+
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+)
+
+// reply codes of RFC 1928
+const (
+	repSucceeded byte = iota
+	repFailure
+	repNotAllowed
+	repNetUnreachable
+	repHostUnreachable
+	repRefused
+	repTTLExpired
+	repCmdNotSupported
+	repAddrNotSupported
+)
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("socks: server closed")
+
+// Server is a SOCKS5 server.
+type Server struct {
+	sessions Sessions
+	outproxy string
+	auth     func(user, password string) bool
+	timeout  time.Duration
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	relaying  map[*sam3.DatagramSession]bool
+	closed    bool
+}
+
+// SetOutproxy tunnels CONNECT requests for hosts outside I2P through the
+// HTTP proxy at the I2P address addr, for example
+// "exit.stormycloud.i2p:80". Without one they are refused.
+func SetOutproxy(addr string) func(*Server) error {
+	return func(s *Server) error {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid outproxy %q: %w", addr, err)
+		}
+		if !samhttp.IsI2P(host) {
+			return fmt.Errorf("invalid outproxy %q: not an I2P host", addr)
+		}
+		s.outproxy = addr
+		return nil
+	}
+}
+
+// SetAuth makes clients authenticate with a username and password which
+// check accepts. Without it clients may choose to authenticate, and any
+// password is accepted.
+func SetAuth(check func(user, password string) bool) func(*Server) error {
+	return func(s *Server) error {
+		s.auth = check
+		return nil
+	}
+}
+
+// SetHandshakeTimeout limits how long a client may take to send its
+// request, 30 seconds by default.
+func SetHandshakeTimeout(d time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid handshake timeout %s", d)
+		}
+		s.timeout = d
+		return nil
+	}
+}
+
+// New returns a server getting its sessions from sessions.
+func New(sessions Sessions, opts ...func(*Server) error) (*Server, error) {
+	s := &Server{
+		sessions:  sessions,
+		timeout:   30 * time.Second,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		relaying:  make(map[*sam3.DatagramSession]bool),
+	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ListenAndServe serves SOCKS on the TCP address addr.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves SOCKS clients connecting to l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(conn)
+			defer conn.Close()
+			s.serve(conn)
+		}()
+	}
+}
+
+// Close stops the listeners and closes all client connections. The sessions
+// are left open.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (s *Server) track(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(c net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+// request is a SOCKS request after the handshake.
+type request struct {
+	user string
+	cmd  byte
+	host string
+	port string
+}
+
+func (s *Server) serve(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(s.timeout))
+	rd := bufio.NewReader(conn)
+	user, err := s.handshake(rd, conn)
+	if err != nil {
+		return
+	}
+	req, rep := readRequest(rd)
+	req.user = user
+	if rep != repSucceeded {
+		reply(conn, rep, nil)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	switch req.cmd {
+	case cmdConnect:
+		s.connect(conn, rd, req)
+	case cmdUDPAssociate:
+		s.associate(conn, req)
+	default:
+		reply(conn, repCmdNotSupported, nil)
+	}
+}
+
+// handshake negotiates the authentication method and returns the user.
+func (s *Server) handshake(rd *bufio.Reader, w io.Writer) (string, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(rd, head[:]); err != nil {
+		return "", err
+	}
+	if head[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", head[0])
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(rd, methods); err != nil {
+		return "", err
+	}
+	method := byte(methodRefused)
+	for _, m := range methods {
+		if m == methodPassword {
+			method = methodPassword
+		} else if m == methodNone && s.auth == nil && method == methodRefused {
+			method = methodNone
+		}
+	}
+	if _, err := w.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	switch method {
+	case methodNone:
+		return "", nil
+	case methodRefused:
+		return "", errors.New("no acceptable authentication method")
+	}
+	// RFC 1929
+	user, err := readString(rd, 1)
+	if err != nil {
+		return "", err
+	}
+	password, err := readString(rd, -1)
+	if err != nil {
+		return "", err
+	}
+	if s.auth != nil && !s.auth(user, password) {
+		w.Write([]byte{1, 1})
+		return "", errors.New("authentication failed")
+	}
+	_, err = w.Write([]byte{1, 0})
+	return user, err
+}
+
+// readString reads a byte, which must be version unless it is -1, and a
+// string prefixed with its length.
+func readString(rd *bufio.Reader, version int) (string, error) {
+	if version >= 0 {
+		v, err := rd.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if int(v) != version {
+			return "", fmt.Errorf("unsupported subnegotiation version %d", v)
+		}
+	}
+	n, err := rd.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(rd, b)
+	return string(b), err
+}
+
+func readRequest(rd *bufio.Reader) (request, byte) {
+	var head [4]byte
+	if _, err := io.ReadFull(rd, head[:]); err != nil || head[0] != socksVersion {
+		return request{}, repFailure
+	}
+	host, port, err := readAddr(rd, head[3])
+	if err != nil {
+		return request{}, repAddrNotSupported
+	}
+	return request{cmd: head[1], host: host, port: port}, repSucceeded
+}
+
+// readAddr reads an address of type atyp followed by a port.
+func readAddr(rd io.Reader, atyp byte) (host, port string, err error) {
+	var b []byte
+	switch atyp {
+	case atypIPv4:
+		b = make([]byte, net.IPv4len)
+	case atypIPv6:
+		b = make([]byte, net.IPv6len)
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(rd, n[:]); err != nil {
+			return "", "", err
+		}
+		b = make([]byte, n[0])
+	default:
+		return "", "", fmt.Errorf("unsupported address type %d", atyp)
+	}
+	var p [2]byte
+	if _, err := io.ReadFull(rd, b); err != nil {
+		return "", "", err
+	}
+	if _, err := io.ReadFull(rd, p[:]); err != nil {
+		return "", "", err
+	}
+	host = string(b)
+	if atyp != atypDomain {
+		host = net.IP(b).String()
+	}
+	return host, strconv.Itoa(int(binary.BigEndian.Uint16(p[:]))), nil
+}
+
+// appendAddr appends addr, an IP address and port or a domain name and
+// port, in SOCKS form.
+func appendAddr(b []byte, host string, port int) []byte {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, atypIPv4), ip4...)
+		} else {
+			b = append(append(b, atypIPv6), ip.To16()...)
+		}
+	} else {
+		b = append(append(b, atypDomain, byte(len(host))), host...)
+	}
+	return append(b, byte(port>>8), byte(port))
+}
+
+// reply sends rep with the bound address bound, or 0.0.0.0:0 if it is nil.
+func reply(w io.Writer, rep byte, bound *net.UDPAddr) error {
+	host, port := "0.0.0.0", 0
+	if bound != nil {
+		host, port = bound.IP.String(), bound.Port
+	}
+	_, err := w.Write(appendAddr([]byte{socksVersion, rep, 0}, host, port))
+	return err
+}
+
+func (s *Server) connect(conn net.Conn, rd *bufio.Reader, req request) {
+	session, err := s.sessions.Stream(req.user)
+	if err != nil {
+		reply(conn, repFailure, nil)
+		return
+	}
+	var remote net.Conn
+	var rep byte
+	if samhttp.IsI2P(req.host) {
+		remote, rep = dial(session, req.host, req.port)
+	} else if s.outproxy != "" {
+		remote, rep = s.viaOutproxy(session, req)
+	} else {
+		rep = repNotAllowed
+	}
+	if rep != repSucceeded {
+		reply(conn, rep, nil)
+		return
+	}
+	if err := reply(conn, repSucceeded, nil); err != nil {
+		remote.Close()
+		return
+	}
+	netutil.Join(&netutil.BufferedConn{Conn: conn, Reader: rd}, remote)
+}
+
+// dial opens a stream to port of host through session.
+func dial(session *sam3.StreamSession, host, port string) (net.Conn, byte) {
+	addr, err := session.Lookup(host)
+	if err != nil {
+		return nil, repHostUnreachable
+	}
+	c, err := session.DialI2PPort(addr, port)
+	if err != nil {
+		return nil, repHostUnreachable
+	}
+	return c, repSucceeded
+}
+
+// viaOutproxy connects to req.host with an HTTP CONNECT through the
+// outproxy.
+func (s *Server) viaOutproxy(session *sam3.StreamSession, req request) (net.Conn, byte) {
+	host, port, _ := net.SplitHostPort(s.outproxy)
+	c, rep := dial(session, host, port)
+	if rep != repSucceeded {
+		return nil, rep
+	}
+	c, err := netutil.Connect(c, net.JoinHostPort(req.host, req.port))
+	if errors.Is(err, netutil.ErrRefused) {
+		return nil, repRefused
+	} else if err != nil {
+		return nil, repFailure
+	}
+	return c, repSucceeded
+}
+
+// associate relays datagrams between the client and I2P until the client
+// closes conn.
+func (s *Server) associate(conn net.Conn, req request) {
+	dg, err := s.sessions.Datagram(req.user)
+	if errors.Is(err, ErrNoDatagram) {
+		reply(conn, repCmdNotSupported, nil)
+		return
+	} else if err != nil {
+		reply(conn, repFailure, nil)
+		return
+	}
+	// a datagram session can only serve one association at a time, or
+	// incoming datagrams would go to whichever reads them first
+	s.mu.Lock()
+	busy := s.relaying[dg]
+	s.relaying[dg] = true
+	s.mu.Unlock()
+	if busy {
+		reply(conn, repFailure, nil)
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.relaying, dg)
+		s.mu.Unlock()
+	}()
+	local := conn.LocalAddr().(*net.TCPAddr)
+	client := conn.RemoteAddr().(*net.TCPAddr)
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+	if err != nil {
+		reply(conn, repFailure, nil)
+		return
+	}
+	if err := reply(conn, repSucceeded, udp.LocalAddr().(*net.UDPAddr)); err != nil {
+		udp.Close()
+		return
+	}
+	r := &relay{udp: udp, dg: dg, clientIP: client.IP}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.outbound()
+	}()
+	go func() {
+		defer wg.Done()
+		r.inbound()
+	}()
+	io.Copy(io.Discard, conn)
+	udp.Close()
+	dg.SetReadDeadline(time.Now())
+	wg.Wait()
+	dg.SetReadDeadline(time.Time{})
+}
+
+// relay is one UDP association.
+type relay struct {
+	udp      *net.UDPConn
+	dg       *sam3.DatagramSession
+	clientIP net.IP
+
+	mu     sync.Mutex
+	client *net.UDPAddr // learned from the first datagram of the client
+}
+
+// outbound sends the client's datagrams to I2P.
+func (r *relay) outbound() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := r.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !from.IP.Equal(r.clientIP) {
+			continue
+		}
+		r.mu.Lock()
+		r.client = from
+		r.mu.Unlock()
+		// RSV RSV FRAG ATYP, fragments are not supported
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		host, _, err := readAddr(bytes.NewReader(buf[4:n]), buf[3])
+		if err != nil || !samhttp.IsI2P(host) {
+			continue
+		}
+		hlen := 4 + addrLen(buf[3], buf[4])
+		addr, err := r.dg.Lookup(host)
+		if err != nil {
+			continue
+		}
+		r.dg.WriteTo(buf[hlen:n], addr)
+	}
+}
+
+// inbound sends datagrams from I2P to the client.
+func (r *relay) inbound() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, from, err := r.dg.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		r.mu.Lock()
+		client := r.client
+		r.mu.Unlock()
+		if client == nil {
+			continue
+		}
+		msg := appendAddr([]byte{0, 0, 0}, from.(i2pkeys.I2PAddr).Base32(), 0)
+		r.udp.WriteToUDP(append(msg, buf[:n]...), client)
+	}
+}
+
+func addrLen(atyp, first byte) int {
+	switch atyp {
+	case atypIPv4:
+		return net.IPv4len + 2
+	case atypIPv6:
+		return net.IPv6len + 2
+	}
+	return 1 + int(first) + 2
+}
@@ -0,0 +1,279 @@
+package socks
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/sam3"
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+// echo starts a TCP server which echoes what it reads, after handling an
+// HTTP CONNECT first if connect is set.
+func echo(t *testing.T, connect bool) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				rd := bufio.NewReader(c)
+				if connect {
+					req, err := http.ReadRequest(rd)
+					if err != nil || req.Method != http.MethodConnect || req.Host != "example.com:443" {
+						io.WriteString(c, "HTTP/1.1 403 Forbidden\r\n\r\n")
+						return
+					}
+					io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+				}
+				io.Copy(c, rd)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// lateReply starts a TCP server which answers with what it read only after
+// the client closed its side.
+func lateReply(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b, _ := io.ReadAll(c)
+				c.Write(append([]byte("got "), b...))
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serve(t *testing.T, sessions Sessions, opts ...func(*Server) error) string {
+	t.Helper()
+	s, err := New(sessions, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// socksDial connects to the SOCKS server at addr, authenticating as user unless
+// it is empty, and sends a request. It returns the reply code and the bound
+// address.
+func socksDial(t *testing.T, addr, user string, cmd byte, host string, port int) (net.Conn, byte, *net.UDPAddr) {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	method := byte(methodNone)
+	if user != "" {
+		method = methodPassword
+	}
+	c.Write([]byte{5, 1, method})
+	var b [2]byte
+	if _, err := io.ReadFull(c, b[:]); err != nil || b[1] != method {
+		t.Fatalf("method %v %v", b, err)
+	}
+	if user != "" {
+		c.Write(append(append([]byte{1, byte(len(user))}, user...), 1, 'x'))
+		if _, err := io.ReadFull(c, b[:]); err != nil || b[1] != 0 {
+			t.Fatalf("auth %v %v", b, err)
+		}
+	}
+	c.Write(appendAddr([]byte{5, cmd, 0}, host, port))
+	var head [4]byte
+	if _, err := io.ReadFull(c, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	bhost, bport, err := readAddr(c, head[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound, _ := net.ResolveUDPAddr("udp", net.JoinHostPort(bhost, bport))
+	c.SetDeadline(time.Time{})
+	return c, head[1], bound
+}
+
+func newSAM(t *testing.T, b *samtest.Bridge) *sam3.SAM {
+	t.Helper()
+	sam, err := sam3.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sam.Close() })
+	return sam
+}
+
+func Test_Connect(t *testing.T) {
+	b := samtest.New(t)
+	site, out := samtest.Keys(t).Addr(), samtest.Keys(t).Addr()
+	b.AddName("site.i2p", site)
+	b.Forward(site, echo(t, false))
+	b.AddName("outproxy.i2p", out)
+	b.Forward(out, echo(t, true))
+	stream, err := newSAM(t, b).NewStreamSession("socksTest", samtest.Keys(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serve(t, Shared(stream, nil))
+	c, rep, _ := socksDial(t, addr, "", cmdConnect, "site.i2p", 8080)
+	if rep != repSucceeded {
+		t.Fatalf("CONNECT: reply %d", rep)
+	}
+	if port := samtest.Arg(b.Command("STREAM CONNECT"), "TO_PORT"); port != "8080" {
+		t.Errorf("TO_PORT = %q", port)
+	}
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("echo %q %v", buf, err)
+	}
+
+	// the reply still arrives after the client closed its side
+	late := samtest.Keys(t).Addr()
+	b.AddName("late.i2p", late)
+	b.Forward(late, lateReply(t))
+	c, rep, _ = socksDial(t, addr, "", cmdConnect, "late.i2p", 80)
+	if rep != repSucceeded {
+		t.Fatalf("CONNECT: reply %d", rep)
+	}
+	c.Write([]byte("ping"))
+	c.(*net.TCPConn).CloseWrite()
+	if got, err := io.ReadAll(c); err != nil || string(got) != "got ping" {
+		t.Errorf("after half close %q %v", got, err)
+	}
+
+	if _, rep, _ := socksDial(t, addr, "", cmdConnect, "missing.i2p", 80); rep != repHostUnreachable {
+		t.Errorf("unknown host: reply %d", rep)
+	}
+	if _, rep, _ := socksDial(t, addr, "", cmdConnect, "example.com", 443); rep != repNotAllowed {
+		t.Errorf("clearnet: reply %d", rep)
+	}
+	if _, rep, _ := socksDial(t, addr, "", cmdUDPAssociate, "0.0.0.0", 0); rep != repCmdNotSupported {
+		t.Errorf("UDP without datagram session: reply %d", rep)
+	}
+
+	addr = serve(t, Shared(stream, nil), SetOutproxy("outproxy.i2p:4444"))
+	c, rep, _ = socksDial(t, addr, "", cmdConnect, "example.com", 443)
+	if rep != repSucceeded {
+		t.Fatalf("outproxy: reply %d", rep)
+	}
+	c.Write([]byte("pong"))
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "pong" {
+		t.Errorf("echo through outproxy %q %v", buf, err)
+	}
+}
+
+func Test_UDPAssociate(t *testing.T) {
+	b := samtest.New(t)
+	site := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", site)
+	sam := newSAM(t, b)
+	dg, err := sam.NewDatagramSession("socksUDP", samtest.Keys(t), nil, b.UDPPort())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dg.Close()
+	addr := serve(t, Shared(nil, dg))
+	_, rep, bound := socksDial(t, addr, "", cmdUDPAssociate, "0.0.0.0", 0)
+	if rep != repSucceeded {
+		t.Fatalf("UDP ASSOCIATE: reply %d", rep)
+	}
+	u, err := net.DialUDP("udp", nil, bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	u.Write(append(appendAddr([]byte{0, 0, 0}, "example.com", 53), "dropped"...))
+	u.Write(append(appendAddr([]byte{0, 0, 0}, "site.i2p", 53), "hello"...))
+	d, err := b.Sent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "socksUDP" || string(d.Payload) != "hello" {
+		t.Errorf("sent %+v", d)
+	}
+
+	if err := b.Deliver("socksUDP", site, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	u.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := u.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(appendAddr([]byte{0, 0, 0}, site.Base32(), 0), "world"...)
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("received %q", buf[:n])
+	}
+}
+
+func Test_PerUser(t *testing.T) {
+	b := samtest.New(t)
+	sessions := NewPerUser(b.Addr(), nil, b.UDPPort())
+	defer sessions.Close()
+	alice, err := sessions.Stream("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := sessions.Stream("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := sessions.Stream("alice")
+	if alice.Addr() == bob.Addr() || again != alice {
+		t.Error("users not isolated")
+	}
+	dg, err := sessions.Datagram("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dg.LocalI2PAddr() != alice.Addr() {
+		t.Error("datagrams of a user come from another destination")
+	}
+
+	site := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", site)
+	b.Forward(site, echo(t, false))
+	addr := serve(t, sessions, SetAuth(func(user, password string) bool { return user == "carol" }))
+	if _, rep, _ := socksDial(t, addr, "carol", cmdConnect, "site.i2p", 80); rep != repSucceeded {
+		t.Errorf("CONNECT as carol: reply %d", rep)
+	}
+	if samtest.Arg(b.Command("STREAM CONNECT"), "ID") == alice.ID() {
+		t.Error("carol used alice's session")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"github.com/sirupsen/logrus"
 	"io"
 	"net"
 	"strings"
@@ -22,9 +21,12 @@ type StreamSession struct {
 	keys     i2pkeys.I2PKeys // i2p destination keys
 	Timeout  time.Duration
 	Deadline time.Time
-	sigType  string
+	sigType  SigType
 	from     string
 	to       string
+	resolver Resolver // optional, see SetResolver
+	logger   Logger   // nil for the default, see SetLogger
+	metrics  Metrics  // nil for the default, see SetMetrics
 }
 
 // Read reads data from the stream.
@@ -38,17 +40,17 @@ func (s *StreamSession) Write(data []byte) (int, error) {
 }
 
 func (s *StreamSession) SetDeadline(t time.Time) error {
-	log.WithField("deadline", t).Debug("Setting deadline for StreamSession")
+	s.log().Debug("Setting deadline for StreamSession", "deadline", t)
 	return s.conn.SetDeadline(t)
 }
 
 func (s *StreamSession) SetReadDeadline(t time.Time) error {
-	log.WithField("readDeadline", t).Debug("Setting read deadline for StreamSession")
+	s.log().Debug("Setting read deadline for StreamSession", "readDeadline", t)
 	return s.conn.SetReadDeadline(t)
 }
 
 func (s *StreamSession) SetWriteDeadline(t time.Time) error {
-	log.WithField("writeDeadline", t).Debug("Setting write deadline for StreamSession")
+	s.log().Debug("Setting write deadline for StreamSession", "writeDeadline", t)
 	return s.conn.SetWriteDeadline(t)
 }
 
@@ -60,7 +62,7 @@ func (s *StreamSession) To() string {
 	return s.to
 }
 
-func (s *StreamSession) SignatureType() string {
+func (s *StreamSession) SignatureType() SigType {
 	return s.sigType
 }
 
@@ -70,7 +72,8 @@ func (s *StreamSession) ID() string {
 }
 
 func (s *StreamSession) Close() error {
-	log.WithField("id", s.id).Debug("Closing StreamSession")
+	s.log().Debug("Closing StreamSession", "id", s.id)
+	s.stats().SessionClosed(s.id, "STREAM")
 	return s.conn.Close()
 }
 
@@ -91,68 +94,72 @@ func (s *StreamSession) Keys() i2pkeys.I2PKeys {
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *SAM) NewStreamSession(id string, keys i2pkeys.I2PKeys, options []string) (*StreamSession, error) {
-	log.WithFields(logrus.Fields{"id": id, "options": options}).Debug("Creating new StreamSession")
+	sam.log().Debug("Creating new StreamSession", "id", id, "options", redactOptions(options))
 	conn, err := sam.newGenericSession("STREAM", id, keys, options, []string{})
 	if err != nil {
 		return nil, err
 	}
-	log.WithField("id", id).Debug("Created new StreamSession")
-	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), Sig_NONE, "0", "0"}, nil
+	sam.log().Debug("Created new StreamSession", "id", id)
+	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), keysSigType(keys), "0", "0", nil, sam.logger, sam.metrics}, nil
 }
 
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
-func (sam *SAM) NewStreamSessionWithSignature(id string, keys i2pkeys.I2PKeys, options []string, sigType string) (*StreamSession, error) {
-	log.WithFields(logrus.Fields{"id": id, "options": options, "sigType": sigType}).Debug("Creating new StreamSession with signature")
+func (sam *SAM) NewStreamSessionWithSignature(id string, keys i2pkeys.I2PKeys, options []string, sigType SigType) (*StreamSession, error) {
+	sam.log().Debug("Creating new StreamSession with signature", "id", id, "options", redactOptions(options), "sigType", sigType)
 	conn, err := sam.newGenericSessionWithSignature("STREAM", id, keys, sigType, options, []string{})
 	if err != nil {
 		return nil, err
 	}
-	log.WithFields(logrus.Fields{"id": id, "sigType": sigType}).Debug("Created new StreamSession with signature")
-	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), sigType, "0", "0"}, nil
+	sam.log().Debug("Created new StreamSession with signature", "id", id, "sigType", sigType)
+	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), sigType, "0", "0", nil, sam.logger, sam.metrics}, nil
 }
 
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
-func (sam *SAM) NewStreamSessionWithSignatureAndPorts(id, from, to string, keys i2pkeys.I2PKeys, options []string, sigType string) (*StreamSession, error) {
-	log.WithFields(logrus.Fields{"id": id, "from": from, "to": to, "options": options, "sigType": sigType}).Debug("Creating new StreamSession with signature and ports")
+func (sam *SAM) NewStreamSessionWithSignatureAndPorts(id, from, to string, keys i2pkeys.I2PKeys, options []string, sigType SigType) (*StreamSession, error) {
+	sam.log().Debug("Creating new StreamSession with signature and ports", "id", id, "from", from, "to", to, "options", redactOptions(options), "sigType", sigType)
 	conn, err := sam.newGenericSessionWithSignatureAndPorts("STREAM", id, from, to, keys, sigType, options, []string{})
 	if err != nil {
 		return nil, err
 	}
-	log.WithFields(logrus.Fields{"id": id, "from": from, "to": to, "sigType": sigType}).Debug("Created new StreamSession with signature and ports")
-	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), sigType, from, to}, nil
+	sam.log().Debug("Created new StreamSession with signature and ports", "id", id, "from", from, "to", to, "sigType", sigType)
+	return &StreamSession{sam.Config.I2PConfig.Sam(), id, conn, keys, time.Duration(600 * time.Second), time.Now(), sigType, from, to, nil, sam.logger, sam.metrics}, nil
+}
+
+// SetResolver sets the resolver used by Lookup and Dial. By default lookups
+// from all sessions on the same SAM bridge share one cached SAMResolver.
+func (s *StreamSession) SetResolver(r Resolver) {
+	s.resolver = r
 }
 
 // lookup name, convenience function
 func (s *StreamSession) Lookup(name string) (i2pkeys.I2PAddr, error) {
-	log.WithField("name", name).Debug("Looking up address")
-	sam, err := NewSAM(s.samAddr)
-	if err == nil {
-		addr, err := sam.Lookup(name)
-		defer sam.Close()
-		if err != nil {
-			log.WithError(err).Error("Lookup failed")
-		} else {
-			log.WithField("addr", addr).Debug("Lookup successful")
-		}
-		return addr, err
+	s.log().Debug("Looking up address", "name", name)
+	r, err := sessionResolver(s.resolver, s.samAddr)
+	if err != nil {
+		s.log().Error("Failed to create SAM instance for lookup", "error", err)
+		return i2pkeys.I2PAddr(""), err
+	}
+	addr, err := sessionResolve(r, name, s.stats(), s.id, "STREAM")
+	if err != nil {
+		s.log().Error("Lookup failed", "error", err)
+	} else {
+		s.log().Debug("Lookup successful", "addr", addr)
 	}
-	log.WithError(err).Error("Failed to create SAM instance for lookup")
-	return i2pkeys.I2PAddr(""), err
+	return addr, err
 }
 
 // context-aware dialer, eventually...
 func (s *StreamSession) DialContext(ctx context.Context, n, addr string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"network": n, "addr": addr}).Debug("DialContext called")
+	s.log().Debug("DialContext called", "network", n, "addr", addr)
 	return s.DialContextI2P(ctx, n, addr)
 }
 
 // context-aware dialer, eventually...
 func (s *StreamSession) DialContextI2P(ctx context.Context, n, addr string) (*SAMConn, error) {
-	log.WithFields(logrus.Fields{"network": n, "addr": addr}).Debug("DialContextI2P called")
+	s.log().Debug("DialContextI2P called", "network", n, "addr", addr)
 	if ctx == nil {
-		log.Panic("nil context")
 		panic("nil context")
 	}
 	deadline := s.deadline(ctx, time.Now())
@@ -164,9 +171,9 @@ func (s *StreamSession) DialContextI2P(ctx context.Context, n, addr string) (*SA
 		}
 	}
 
-	i2paddr, err := i2pkeys.NewI2PAddrFromString(addr)
+	i2paddr, err := s.resolveHost(addr)
 	if err != nil {
-		log.WithError(err).Error("Failed to create I2P address from string")
+		s.log().Error("Failed to resolve I2P address", "error", err)
 		return nil, err
 	}
 	return s.DialI2P(i2paddr)
@@ -207,55 +214,75 @@ func (s *StreamSession) deadline(ctx context.Context, now time.Time) (earliest t
 
 // implement net.Dialer
 func (s *StreamSession) Dial(n, addr string) (c net.Conn, err error) {
-	log.WithFields(logrus.Fields{"network": n, "addr": addr}).Debug("Dial called")
+	s.log().Debug("Dial called", "network", n, "addr", addr)
 
-	var i2paddr i2pkeys.I2PAddr
+	i2paddr, err := s.resolveHost(addr)
+	if err == nil {
+		return s.DialI2P(i2paddr)
+	}
+	s.log().Error("Dial failed", "error", err)
+	return
+}
+
+// resolveHost turns the host part of addr into a destination. Names ending in
+// .i2p, including b32 and encrypted b33 addresses, are looked up, anything
+// else is taken to be a base64 destination.
+func (s *StreamSession) resolveHost(addr string) (i2paddr i2pkeys.I2PAddr, err error) {
 	var host string
 	host, _, err = SplitHostPort(addr)
-	//log.Println("Dialing:", host)
-	if err = IgnorePortError(err); err == nil {
-		// check for name
-		if strings.HasSuffix(host, ".b32.i2p") || strings.HasSuffix(host, ".i2p") {
-			// name lookup
-			i2paddr, err = s.Lookup(host)
-			log.WithFields(logrus.Fields{"host": host, "i2paddr": i2paddr}).Debug("Looked up I2P address")
-		} else {
-			// probably a destination
-			i2paddr, err = i2pkeys.NewI2PAddrFromBytes([]byte(host))
-			//i2paddr = i2pkeys.I2PAddr(host)
-			//log.Println("Destination:", i2paddr, err)
-			log.WithFields(logrus.Fields{"host": host, "i2paddr": i2paddr}).Debug("Created I2P address from bytes")
-		}
-		if err == nil {
-			return s.DialI2P(i2paddr)
+	if err = IgnorePortError(err); err != nil {
+		return
+	}
+	if strings.HasSuffix(host, ".i2p") {
+		if IsB33(host) {
+			s.log().Debug("Dialing encrypted b33 address", "host", host)
 		}
+		i2paddr, err = s.Lookup(host)
+		s.log().Debug("Looked up I2P address", "host", host, "i2paddr", i2paddr)
+	} else {
+		// probably a destination
+		i2paddr, err = i2pkeys.NewI2PAddrFromString(host)
+		s.log().Debug("Created I2P address from string", "host", host, "i2paddr", i2paddr)
 	}
-	log.WithError(err).Error("Dial failed")
 	return
 }
 
 // Dials to an I2P destination and returns a SAMConn, which implements a net.Conn.
 func (s *StreamSession) DialI2P(addr i2pkeys.I2PAddr) (*SAMConn, error) {
-	log.WithField("addr", addr).Debug("DialI2P called")
+	s.log().Debug("DialI2P called", "addr", addr)
+	return s.dialI2P(addr, s.to)
+}
+
+// DialI2PPort is DialI2P to port toPort of the destination rather than the
+// session's TO_PORT, for protocols where the port selects the service.
+func (s *StreamSession) DialI2PPort(addr i2pkeys.I2PAddr, toPort string) (*SAMConn, error) {
+	s.log().Debug("DialI2PPort called", "addr", addr, "toPort", toPort)
+	return s.dialI2P(addr, toPort)
+}
+
+func (s *StreamSession) dialI2P(addr i2pkeys.I2PAddr, to string) (*SAMConn, error) {
+	start, result := time.Now(), ResultNoReply
+	defer func() { s.stats().Dialed(s.id, "STREAM", time.Since(start), result) }()
 	sam, err := NewSAM(s.samAddr)
 	if err != nil {
-		log.WithError(err).Error("Failed to create new SAM instance")
+		s.log().Error("Failed to create new SAM instance", "error", err)
 		return nil, err
 	}
 	conn := sam.conn
-	_, err = conn.Write([]byte("STREAM CONNECT ID=" + s.id + " FROM_PORT=" + s.from + " TO_PORT=" + s.to + " DESTINATION=" + addr.Base64() + " SILENT=false\n"))
+	_, err = conn.Write([]byte(sam.Config.StreamConnect(s.id, s.from, to, addr.Base64(), false)))
 	if err != nil {
-		log.WithError(err).Error("Failed to write STREAM CONNECT command")
+		s.log().Error("Failed to write STREAM CONNECT command", "error", err)
 		conn.Close()
 		return nil, err
 	}
 	buf := make([]byte, 4096)
 	n, err := conn.Read(buf)
 	if err != nil && err != io.EOF {
-		log.WithError(err).Error("Failed to write STREAM CONNECT command")
+		s.log().Error("Failed to write STREAM CONNECT command", "error", err)
 		conn.Close()
 		return nil, err
 	}
+	result = replyResult(string(buf[:n]))
 	scanner := bufio.NewScanner(bytes.NewReader(buf[:n]))
 	scanner.Split(bufio.ScanWords)
 	for scanner.Scan() {
@@ -265,41 +292,41 @@ func (s *StreamSession) DialI2P(addr i2pkeys.I2PAddr) (*SAMConn, error) {
 		case "STATUS":
 			continue
 		case "RESULT=OK":
-			log.Debug("Successfully connected to I2P destination")
-			return &SAMConn{s.keys.Addr(), addr, conn}, nil
+			s.log().Debug("Successfully connected to I2P destination")
+			return &SAMConn{laddr: s.keys.Addr(), raddr: addr, conn: conn}, nil
 		case "RESULT=CANT_REACH_PEER":
-			log.Error("Can't reach peer")
+			s.log().Error("Can't reach peer")
 			conn.Close()
 			return nil, errors.New("Can not reach peer")
 		case "RESULT=I2P_ERROR":
-			log.Error("I2P internal error")
+			s.log().Error("I2P internal error")
 			conn.Close()
 			return nil, errors.New("I2P internal error")
 		case "RESULT=INVALID_KEY":
-			log.Error("Invalid key - Stream Session")
+			s.log().Error("Invalid key - Stream Session")
 			conn.Close()
 			return nil, errors.New("Invalid key - Stream Session")
 		case "RESULT=INVALID_ID":
-			log.Error("Invalid tunnel ID")
+			s.log().Error("Invalid tunnel ID")
 			conn.Close()
 			return nil, errors.New("Invalid tunnel ID")
 		case "RESULT=TIMEOUT":
-			log.Error("Connection timeout")
+			s.log().Error("Connection timeout")
 			conn.Close()
 			return nil, errors.New("Timeout")
 		default:
-			log.WithField("error", scanner.Text()).Error("Unknown error")
+			s.log().Error("Unknown error", "error", scanner.Text())
 			conn.Close()
 			return nil, errors.New("Unknown error: " + scanner.Text() + " : " + string(buf[:n]))
 		}
 	}
-	log.Panic("Unexpected end of StreamSession.DialI2P()")
+	s.log().Error("Unexpected end of StreamSession.DialI2P()")
 	panic("sam3 go library error in StreamSession.DialI2P()")
 }
 
 // create a new stream listener to accept inbound connections
 func (s *StreamSession) Listen() (*StreamListener, error) {
-	log.WithFields(logrus.Fields{"id": s.id, "laddr": s.keys.Addr()}).Debug("Creating new StreamListener")
+	s.log().Debug("Creating new StreamListener", "id", s.id, "laddr", s.keys.Addr())
 	return &StreamListener{
 		session: s,
 		id:      s.id,
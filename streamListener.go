@@ -3,13 +3,14 @@ package sam3
 import (
 	"bufio"
 	"errors"
-	"github.com/sirupsen/logrus"
 	"io"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3/internal/netutil"
 )
 
 type StreamListener struct {
@@ -19,6 +20,19 @@ type StreamListener struct {
 	id string
 	// our local address for this sam socket
 	laddr i2pkeys.I2PAddr
+	// consulted on every accepted stream, may be nil
+	policy AcceptPolicy
+	// SAM connections waiting in STREAM ACCEPT, closed by Close
+	mu        sync.Mutex
+	accepting map[net.Conn]struct{}
+	closed    bool
+}
+
+// SetPolicy makes the listener consult p about every incoming stream. Streams
+// p rejects are closed without being returned by Accept. Set it before
+// calling Accept.
+func (l *StreamListener) SetPolicy(p AcceptPolicy) {
+	l.policy = p
 }
 
 func (l *StreamListener) From() string {
@@ -36,59 +50,113 @@ func (l *StreamListener) Addr() net.Addr {
 }
 
 // implements net.Listener
+// Close also aborts pending calls to Accept.
 func (l *StreamListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	for c := range l.accepting {
+		c.Close()
+	}
+	l.accepting = nil
+	l.mu.Unlock()
 	return l.session.Close()
 }
 
+// track registers c as waiting in STREAM ACCEPT, or reports that the
+// listener is closed.
+func (l *StreamListener) track(c net.Conn) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return net.ErrClosed
+	}
+	if l.accepting == nil {
+		l.accepting = make(map[net.Conn]struct{})
+	}
+	l.accepting[c] = struct{}{}
+	return nil
+}
+
+func (l *StreamListener) untrack(c net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.accepting, c)
+}
+
 // implements net.Listener
 func (l *StreamListener) Accept() (net.Conn, error) {
 	return l.AcceptI2P()
 }
 
 func ExtractPairString(input, value string) string {
-	log.WithFields(logrus.Fields{"input": input, "value": value}).Debug("ExtractPairString called")
+	defaultLog().Debug("ExtractPairString called", "input", input, "value", value)
 	parts := strings.Split(input, " ")
 	for _, part := range parts {
 		if strings.HasPrefix(part, value) {
 			kv := strings.SplitN(input, "=", 2)
 			if len(kv) == 2 {
-				log.WithFields(logrus.Fields{"key": kv[0], "value": kv[1]}).Debug("Pair extracted")
+				defaultLog().Debug("Pair extracted", "key", kv[0], "value", kv[1])
 				return kv[1]
 			}
 		}
 	}
-	log.WithFields(logrus.Fields{"input": input, "value": value}).Debug("No pair found")
+	defaultLog().Debug("No pair found", "input", input, "value", value)
 	return ""
 }
 
 func ExtractPairInt(input, value string) int {
 	rv, err := strconv.Atoi(ExtractPairString(input, value))
 	if err != nil {
-		log.WithFields(logrus.Fields{"input": input, "value": value}).Debug("No pair found")
+		defaultLog().Debug("No pair found", "input", input, "value", value)
 		return 0
 	}
-	log.WithField("result", rv).Debug("Pair extracted and converted to int")
+	defaultLog().Debug("Pair extracted and converted to int", "result", rv)
 	return rv
 }
 
 func ExtractDest(input string) string {
-	log.WithField("input", input).Debug("ExtractDest called")
+	defaultLog().Debug("ExtractDest called", "input", input)
 	dest := strings.Split(input, " ")[0]
-	log.WithField("dest", dest).Debug("Destination extracted")
+	defaultLog().Debug("Destination extracted", "dest", dest)
 	return strings.Split(input, " ")[0]
 }
 
-// accept a new inbound connection
+// accept a new inbound connection which the listener's policy, if any, allows
 func (l *StreamListener) AcceptI2P() (*SAMConn, error) {
-	log.Debug("StreamListener.AcceptI2P() called")
+	for {
+		conn, err := l.acceptI2P()
+		if err != nil {
+			return nil, err
+		}
+		if l.policy != nil {
+			remote := conn.raddr
+			if err := l.policy.Allow(remote); err != nil {
+				l.log().Debug("Policy rejected incoming stream", "error", err)
+				conn.conn.Close()
+				continue
+			}
+			conn.onClose = func() { l.policy.Done(remote) }
+		}
+		l.session.stats().Accepted(l.id, "STREAM")
+		return conn, nil
+	}
+}
+
+func (l *StreamListener) acceptI2P() (*SAMConn, error) {
+	l.log().Debug("StreamListener.AcceptI2P() called")
 	s, err := NewSAM(l.session.samAddr)
 	if err == nil {
-		log.Debug("Connected to SAM bridge")
+		l.log().Debug("Connected to SAM bridge")
+		if err := l.track(s.conn); err != nil {
+			s.Close()
+			return nil, err
+		}
+		defer l.untrack(s.conn)
 		// we connected to sam
 		// send accept() command
-		_, err = io.WriteString(s.conn, "STREAM ACCEPT ID="+l.id+" SILENT=false\n")
+		_, err = io.WriteString(s.conn, s.Config.StreamAccept(l.id, false))
 		if err != nil {
-			log.WithError(err).Error("Failed to send STREAM ACCEPT command")
+			l.log().Error("Failed to send STREAM ACCEPT command", "error", err)
 			s.Close()
 			return nil, err
 		}
@@ -97,12 +165,11 @@ func (l *StreamListener) AcceptI2P() (*SAMConn, error) {
 		// read first line
 		line, err := rd.ReadString(10)
 		if err != nil {
-			log.WithError(err).Error("Failed to read SAM bridge response")
+			l.log().Error("Failed to read SAM bridge response", "error", err)
 			s.Close()
 			return nil, err
 		}
-		log.WithField("response", line).Debug("Received SAM bridge response")
-		log.Println(line)
+		l.log().Debug("Received SAM bridge response", "response", line)
 		if strings.HasPrefix(line, "STREAM STATUS RESULT=OK") {
 			// we gud read destination line
 			destline, err := rd.ReadString(10)
@@ -112,29 +179,28 @@ func (l *StreamListener) AcceptI2P() (*SAMConn, error) {
 				l.session.to = ExtractPairString(destline, "TO_PORT")
 				// return wrapped connection
 				dest = strings.Trim(dest, "\n")
-				log.WithFields(logrus.Fields{
-					"dest": dest,
-					"from": l.session.from,
-					"to":   l.session.to,
-				}).Debug("Accepted new I2P connection")
+				l.log().Debug("Accepted new I2P connection", "dest", dest, "from", l.session.from, "to", l.session.to)
+				var conn net.Conn = s.conn
+				if rd.Buffered() > 0 {
+					conn = &netutil.BufferedConn{Conn: s.conn, Reader: rd}
+				}
 				return &SAMConn{
 					laddr: l.laddr,
 					raddr: i2pkeys.I2PAddr(dest),
-					conn:  s.conn,
+					conn:  conn,
 				}, nil
 			} else {
-				log.WithError(err).Error("Failed to read destination line")
+				l.log().Error("Failed to read destination line", "error", err)
 				s.Close()
 				return nil, err
 			}
 		} else {
-			log.WithField("line", line).Error("Invalid SAM response")
+			l.log().Error("Invalid SAM response", "line", line)
 			s.Close()
 			return nil, errors.New("invalid sam line: " + line)
 		}
 	} else {
-		log.WithError(err).Error("Failed to connect to SAM bridge")
-		s.Close()
+		l.log().Error("Failed to connect to SAM bridge", "error", err)
 		return nil, err
 	}
 }
@@ -1,7 +1,6 @@
 package sam3
 
 import (
-	"github.com/sirupsen/logrus"
 	"net"
 	"net/http"
 	"os"
@@ -61,38 +60,38 @@ var (
 )
 
 func PrimarySessionString() string {
-	log.Debug("Determining primary session type")
+	defaultLog().Debug("Determining primary session type")
 	_, err := http.Get("http://127.0.0.1:7070")
 	if err != nil {
-		log.WithError(err).Debug("Failed to connect to 127.0.0.1:7070, trying 127.0.0.1:7657")
+		defaultLog().Debug("Failed to connect to 127.0.0.1:7070, trying 127.0.0.1:7657", "error", err)
 		_, err := http.Get("http://127.0.0.1:7657")
 		if err != nil {
 			return "MASTER"
 		}
-		log.Debug("Connected to 127.0.0.1:7657, attempting to create a PRIMARY session")
+		defaultLog().Debug("Connected to 127.0.0.1:7657, attempting to create a PRIMARY session")
 		// at this point we're probably running on Java I2P and thus probably
 		// have a PRIMARY session. Just to be sure, try to make one, check
 		// for errors, then immediately close it.
 		testSam, err := NewSAM(SAMDefaultAddr(""))
 		if err != nil {
-			log.WithError(err).Debug("Failed to create SAM instance, assuming MASTER session")
+			defaultLog().Debug("Failed to create SAM instance, assuming MASTER session", "error", err)
 			return "MASTER"
 		}
 		newKeys, err := testSam.NewKeys()
 		if err != nil {
-			log.WithError(err).Debug("Failed to create new keys, assuming MASTER session")
+			defaultLog().Debug("Failed to create new keys, assuming MASTER session", "error", err)
 			return "MASTER"
 		}
 		primarySession, err := testSam.newPrimarySession("PRIMARY", "primaryTestTunnel", newKeys, Options_Small)
 		if err != nil {
-			log.WithError(err).Debug("Failed to create primary session, assuming MASTER session")
+			defaultLog().Debug("Failed to create primary session, assuming MASTER session", "error", err)
 			return "MASTER"
 		}
 		primarySession.Close()
-		log.Debug("Successfully created and closed a PRIMARY session")
+		defaultLog().Debug("Successfully created and closed a PRIMARY session")
 		return "PRIMARY"
 	}
-	log.Debug("Connected to 127.0.0.1:7070, assuming MASTER session")
+	defaultLog().Debug("Connected to 127.0.0.1:7070, assuming MASTER session")
 	return "MASTER"
 }
 
@@ -102,16 +101,10 @@ func getEnv(key, fallback string) string {
 	InitializeSAM3Logger()
 	value, ok := os.LookupEnv(key)
 	if !ok {
-		log.WithFields(logrus.Fields{
-			"key":      key,
-			"fallback": fallback,
-		}).Debug("Environment variable not set, using fallback")
+		defaultLog().Debug("Environment variable not set, using fallback", "key", key, "fallback", fallback)
 		return fallback
 	}
-	log.WithFields(logrus.Fields{
-		"key":   key,
-		"value": value,
-	}).Debug("Retrieved environment variable")
+	defaultLog().Debug("Retrieved environment variable", "key", key, "value", value)
 	return value
 }
 
@@ -121,22 +114,22 @@ var SAM_PORT = getEnv("sam_port", "7656")
 func SAMDefaultAddr(fallforward string) string {
 	if fallforward == "" {
 		addr := net.JoinHostPort(SAM_HOST, SAM_PORT)
-		log.WithField("addr", addr).Debug("Using default SAM address")
+		defaultLog().Debug("Using default SAM address", "addr", addr)
 		return addr
 	}
-	log.WithField("addr", fallforward).Debug("Using fallforward SAM address")
+	defaultLog().Debug("Using fallforward SAM address", "addr", fallforward)
 	return fallforward
 }
 
 func GenerateOptionString(opts []string) string {
 	optStr := strings.Join(opts, " ")
-	log.WithField("options", optStr).Debug("Generating option string")
+	defaultLog().Debug("Generating option string", "options", redact(optStr))
 	if strings.Contains(optStr, "i2cp.leaseSetEncType") {
-		log.Debug("i2cp.leaseSetEncType already present in options")
+		defaultLog().Debug("i2cp.leaseSetEncType already present in options")
 		return optStr
 	}
 	finalOpts := optStr + " i2cp.leaseSetEncType=4,0"
-	log.WithField("finalOptions", finalOpts).Debug("Added default i2cp.leaseSetEncType to options")
+	defaultLog().Debug("Added default i2cp.leaseSetEncType to options", "finalOptions", redact(finalOpts))
 	return finalOpts
 	//return optStr + " i2cp.leaseSetEncType=4,0"
 }
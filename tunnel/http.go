@@ -0,0 +1,104 @@
+package tunnel
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-i2p/sam3"
+	"github.com/go-i2p/sam3/proxy"
+)
+
+// HTTPClient is an HTTP proxy tunnel, see package proxy. The first entry of
+// the i2ptunnel setting proxyList is used as outproxy.
+type HTTPClient struct {
+	base
+	listener net.Listener
+}
+
+// NewHTTPClient returns an HTTP proxy tunnel configured by cfg.
+func NewHTTPClient(cfg *sam3.I2PConfig) (*HTTPClient, error) {
+	return &HTTPClient{base: newBase(cfg)}, nil
+}
+
+// ListenAddr is the local address the proxy listens on while it runs.
+func (c *HTTPClient) ListenAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		return nil
+	}
+	return c.listener.Addr()
+}
+
+// Start implements Tunnel.
+func (c *HTTPClient) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		return ErrRunning
+	}
+	sam, keys, err := c.connect()
+	if err != nil {
+		return err
+	}
+	from, to := c.ports()
+	session, err := sam.NewStreamSessionWithSignatureAndPorts(c.cfg.TunName, from, to, keys, c.cfg.Print(), c.keysSigType(keys))
+	if err != nil {
+		sam.Close()
+		return err
+	}
+	var opts []func(*proxy.Proxy) error
+	if list := c.setting("proxyList", ""); list != "" {
+		opts = append(opts, proxy.SetOutproxy(strings.TrimSpace(strings.Split(list, ",")[0])))
+	}
+	p, err := proxy.New(session, opts...)
+	if err != nil {
+		session.Close()
+		return err
+	}
+	l, err := net.Listen("tcp", c.listenAddr())
+	if err != nil {
+		session.Close()
+		return err
+	}
+	c.listener = l
+	srv := &http.Server{Handler: p}
+	c.started(keys.Addr(), srv, session)
+	c.run(func() { srv.Serve(&countingListener{l, &c.base}) })
+	return nil
+}
+
+// countingListener counts the connections it accepts and their traffic,
+// which is what goes to and comes from I2P give or take the headers.
+type countingListener struct {
+	net.Listener
+	b *base
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&l.b.conns, 1)
+	return &countingConn{c, l.b}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	b *base
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.b.out, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddUint64(&c.b.in, uint64(n))
+	return n, err
+}
@@ -0,0 +1,157 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+)
+
+// Client is a TCP client tunnel.
+type Client struct {
+	base
+	listener net.Listener
+}
+
+// NewClient returns a client tunnel configured by cfg.
+func NewClient(cfg *sam3.I2PConfig) (*Client, error) {
+	c := &Client{base: newBase(cfg)}
+	if _, _, err := c.target(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ListenAddr is the local address the tunnel listens on while it runs.
+func (c *Client) ListenAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		return nil
+	}
+	return c.listener.Addr()
+}
+
+// Start implements Tunnel.
+func (c *Client) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		return ErrRunning
+	}
+	name, port, _ := c.target()
+	sam, keys, err := c.connect()
+	if err != nil {
+		return err
+	}
+	from, to := c.ports()
+	session, err := sam.NewStreamSessionWithSignatureAndPorts(c.cfg.TunName, from, to, keys, c.cfg.Print(), c.keysSigType(keys))
+	if err != nil {
+		sam.Close()
+		return err
+	}
+	dest, err := session.Lookup(name)
+	if err != nil {
+		session.Close()
+		return fmt.Errorf("resolving %s: %w", name, err)
+	}
+	l, err := net.Listen("tcp", c.listenAddr())
+	if err != nil {
+		session.Close()
+		return err
+	}
+	c.listener = l
+	c.started(keys.Addr(), l, session)
+	c.run(func() { c.accept(l, session, dest, port) })
+	return nil
+}
+
+func (c *Client) accept(l net.Listener, session *sam3.StreamSession, dest i2pkeys.I2PAddr, port string) {
+	for {
+		local, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.run(func() {
+			var stream net.Conn
+			var err error
+			if port != "" {
+				stream, err = session.DialI2PPort(dest, port)
+			} else {
+				stream, err = session.DialI2P(dest)
+			}
+			if err != nil {
+				local.Close()
+				return
+			}
+			c.pipe(stream, local)
+		})
+	}
+}
+
+// Server is a TCP server tunnel.
+type Server struct {
+	base
+}
+
+// NewServer returns a server tunnel configured by cfg.
+func NewServer(cfg *sam3.I2PConfig) (*Server, error) {
+	s := &Server{base: newBase(cfg)}
+	if _, err := s.targetAddr(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start implements Tunnel.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrRunning
+	}
+	target, _ := s.targetAddr()
+	sam, keys, err := s.connect()
+	if err != nil {
+		return err
+	}
+	from, to := s.ports()
+	session, err := sam.NewStreamSessionWithSignatureAndPorts(s.cfg.TunName, from, to, keys, s.cfg.Print(), s.keysSigType(keys))
+	if err != nil {
+		sam.Close()
+		return err
+	}
+	l, err := session.Listen()
+	if err != nil {
+		session.Close()
+		return err
+	}
+	// closing the listener closes the session and pending accepts
+	s.started(keys.Addr(), l)
+	s.run(func() { s.accept(l, target) })
+	return nil
+}
+
+func (s *Server) accept(l *sam3.StreamListener, target string) {
+	for {
+		stream, err := l.AcceptI2P()
+		if err != nil {
+			if !s.isRunning() {
+				return
+			}
+			// a failed accept does not end the tunnel, try again soon
+			time.Sleep(time.Second)
+			continue
+		}
+		s.run(func() {
+			local, err := net.Dial("tcp", target)
+			if err != nil {
+				stream.Close()
+				return
+			}
+			s.pipe(stream, local)
+		})
+	}
+}
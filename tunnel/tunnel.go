@@ -0,0 +1,333 @@
+// Package tunnel forwards between local TCP or UDP ports and I2P, like the
+// client and server tunnels of the Java router's i2ptunnel.
+//
+// Tunnels are configured with a sam3.I2PConfig, usually read from an
+// i2ptunnel config file. The type of the config selects the tunnel:
+//
+//	client     listens on local TCP and opens a stream to targetDestination
+//	           for every connection
+//	server     accepts streams and connects each to targetHost:targetPort
+//	udpclient  sends datagrams from a local UDP port to targetDestination and
+//	           the replies back to the last local sender
+//	udpserver  relays datagrams between I2P peers and targetHost:targetPort,
+//	           with a local UDP socket per peer
+//	httpclient is an HTTP proxy on the local TCP port, see package proxy
+//
+// The tunnel settings are taken from I2PConfig.Tunnel with the i2ptunnel
+// keys: interface and listenPort (127.0.0.1 and a random port by default),
+// targetDestination, optionally with a :port which becomes TO_PORT,
+// targetHost (127.0.0.1 by default) and targetPort, and privKeyFile, where
+// the keys are kept if the config has none. UDP tunnels also read
+// samUDPPort, the UDP port of the SAM bridge.
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+)
+
+// ErrRunning is returned by Start if the tunnel is already running.
+var ErrRunning = errors.New("tunnel already running")
+
+// Tunnel is a tunnel which can be started and stopped again.
+type Tunnel interface {
+	// Start creates the session and starts forwarding. It returns once the
+	// tunnel is ready.
+	Start() error
+	// Stop closes the session and the connections of the tunnel and
+	// waits until forwarding has stopped.
+	Stop() error
+	// Stats returns the traffic counters, which are kept across restarts.
+	Stats() Stats
+	// Addr is the destination of the tunnel while it runs.
+	Addr() i2pkeys.I2PAddr
+}
+
+// Stats are the traffic counters of a tunnel.
+type Stats struct {
+	// BytesIn counts bytes received from I2P, BytesOut bytes sent to it.
+	BytesIn, BytesOut uint64
+	// Conns counts the streams handled by TCP tunnels.
+	Conns uint64
+}
+
+// New returns the tunnel of the type set in cfg.
+func New(cfg *sam3.I2PConfig) (Tunnel, error) {
+	switch cfg.TunType {
+	case "client":
+		return NewClient(cfg)
+	case "server":
+		return NewServer(cfg)
+	case "udpclient":
+		return NewUDPClient(cfg)
+	case "udpserver":
+		return NewUDPServer(cfg)
+	case "httpclient":
+		return NewHTTPClient(cfg)
+	}
+	return nil, fmt.Errorf("unsupported tunnel type %q", cfg.TunType)
+}
+
+// base is what all tunnels share: the counters and the lifecycle.
+type base struct {
+	// updated atomically, first to keep them aligned
+	in, out, conns uint64
+
+	cfg     *sam3.I2PConfig
+	mu      sync.Mutex
+	running bool
+	addr    i2pkeys.I2PAddr
+	closers map[io.Closer]struct{}
+	wg      sync.WaitGroup
+}
+
+func newBase(cfg *sam3.I2PConfig) base {
+	return base{cfg: cfg}
+}
+
+func (b *base) Stats() Stats {
+	return Stats{
+		BytesIn:  atomic.LoadUint64(&b.in),
+		BytesOut: atomic.LoadUint64(&b.out),
+		Conns:    atomic.LoadUint64(&b.conns),
+	}
+}
+
+func (b *base) Addr() i2pkeys.I2PAddr {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.addr
+}
+
+// started marks the tunnel running with the destination addr and the
+// closers to close on Stop. Called with mu held.
+func (b *base) started(addr i2pkeys.I2PAddr, closers ...io.Closer) {
+	b.running = true
+	b.addr = addr
+	b.closers = make(map[io.Closer]struct{})
+	for _, c := range closers {
+		b.closers[c] = struct{}{}
+	}
+}
+
+func (b *base) Stop() error {
+	b.mu.Lock()
+	if !b.running {
+		b.mu.Unlock()
+		return nil
+	}
+	b.running = false
+	b.addr = ""
+	var err error
+	for c := range b.closers {
+		if cerr := c.Close(); err == nil && cerr != nil && !errors.Is(cerr, net.ErrClosed) {
+			err = cerr
+		}
+	}
+	b.closers = nil
+	b.mu.Unlock()
+	b.wg.Wait()
+	return err
+}
+
+func (b *base) isRunning() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.running
+}
+
+// track makes Stop close c. It returns false if the tunnel is stopping, in
+// which case c is closed right away.
+func (b *base) track(c io.Closer) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.running {
+		c.Close()
+		return false
+	}
+	b.closers[c] = struct{}{}
+	return true
+}
+
+func (b *base) untrack(c io.Closer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.closers, c)
+}
+
+// run runs fn in a goroutine Stop waits for.
+func (b *base) run(fn func()) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		fn()
+	}()
+}
+
+// pipe copies between a stream and a local connection until either side is
+// done, counting the bytes, and closes both.
+func (b *base) pipe(stream, local net.Conn) {
+	atomic.AddUint64(&b.conns, 1)
+	if !b.track(stream) {
+		local.Close()
+		return
+	}
+	defer b.untrack(stream)
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(&counter{local, &b.in}, stream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(&counter{stream, &b.out}, local)
+		done <- struct{}{}
+	}()
+	<-done
+	stream.Close()
+	local.Close()
+	<-done
+}
+
+// counter counts the bytes written to w in n.
+type counter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+func (b *base) setting(key, def string) string {
+	if v := b.cfg.Tunnel[key]; v != "" {
+		return v
+	}
+	return def
+}
+
+// listenAddr is the local address client tunnels listen on.
+func (b *base) listenAddr() string {
+	return net.JoinHostPort(b.setting("interface", "127.0.0.1"), b.setting("listenPort", "0"))
+}
+
+// targetAddr is the local address server tunnels forward to.
+func (b *base) targetAddr() (string, error) {
+	port := b.setting("targetPort", "")
+	if port == "" {
+		return "", errors.New("server tunnel without targetPort")
+	}
+	return net.JoinHostPort(b.setting("targetHost", "127.0.0.1"), port), nil
+}
+
+// target splits the targetDestination of client tunnels into the name and
+// the port, which is "" if there is none.
+func (b *base) target() (name, port string, err error) {
+	dest := b.setting("targetDestination", "")
+	if dest == "" {
+		return "", "", errors.New("client tunnel without targetDestination")
+	}
+	if host, port, err := net.SplitHostPort(dest); err == nil {
+		return host, port, nil
+	}
+	return dest, "", nil
+}
+
+func (b *base) samUDPPort() (int, error) {
+	p, err := strconv.Atoi(b.setting("samUDPPort", "0"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid samUDPPort: %w", err)
+	}
+	return p, nil
+}
+
+func (b *base) ports() (from, to string) {
+	from, to = b.cfg.Fromport, b.cfg.Toport
+	if from == "" {
+		from = "0"
+	}
+	if to == "" {
+		to = "0"
+	}
+	return from, to
+}
+
+// sigType is the signature type of new keys, Sig_DEFAULT if none was
+// configured.
+func (b *base) sigType() sam3.SigType {
+	if b.cfg.SigType == nil {
+		return sam3.Sig_DEFAULT
+	}
+	return *b.cfg.SigType
+}
+
+// keysSigType is the signature type to create a session with keys with:
+// that of the keys themselves, which may come from the config or a file,
+// falling back to sigType if they can not be inspected.
+func (b *base) keysSigType(keys i2pkeys.I2PKeys) sam3.SigType {
+	if st, err := sam3.KeysSigType(keys); err == nil {
+		return st
+	}
+	return b.sigType()
+}
+
+// connect connects to the SAM bridge and gets the keys of the tunnel: those
+// of the config, those in privKeyFile, or new ones which are then stored
+// there.
+func (b *base) connect() (*sam3.SAM, i2pkeys.I2PKeys, error) {
+	sam, err := sam3.NewSAM(b.cfg.Sam())
+	if err != nil {
+		return nil, i2pkeys.I2PKeys{}, err
+	}
+	b.cfg.ID() // names the tunnel if it has no name yet
+	if b.cfg.DestinationKeys.String() != "" {
+		return sam, b.cfg.DestinationKeys, nil
+	}
+	path := b.setting("privKeyFile", "")
+	if path != "" {
+		if f, err := os.Open(path); err == nil {
+			defer f.Close()
+			keys, err := i2pkeys.LoadKeysIncompat(f)
+			if err != nil {
+				sam.Close()
+				return nil, i2pkeys.I2PKeys{}, fmt.Errorf("loading keys from %s: %w", path, err)
+			}
+			return sam, keys, nil
+		}
+	}
+	keys, err := sam.NewKeys(b.sigType())
+	if err != nil {
+		sam.Close()
+		return nil, i2pkeys.I2PKeys{}, err
+	}
+	if path != "" {
+		if err := storeKeys(path, keys); err != nil {
+			sam.Close()
+			return nil, i2pkeys.I2PKeys{}, err
+		}
+	}
+	return sam, keys, nil
+}
+
+func storeKeys(path string, keys i2pkeys.I2PKeys) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("storing keys: %w", err)
+	}
+	if err := i2pkeys.StoreKeysIncompat(keys, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("storing keys: %w", err)
+	}
+	return f.Close()
+}
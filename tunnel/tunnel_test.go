@@ -0,0 +1,271 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func config(t *testing.T, b *samtest.Bridge, typ string, settings map[string]string) *sam3.I2PConfig {
+	t.Helper()
+	cfg, err := sam3.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetSAMAddress(b.Addr())
+	cfg.TunName = typ + "Tun"
+	cfg.TunType = typ
+	cfg.DestinationKeys = samtest.Keys(t)
+	cfg.Tunnel = settings
+	return cfg
+}
+
+func start(t *testing.T, cfg *sam3.I2PConfig) Tunnel {
+	t.Helper()
+	tun, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tun.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tun.Stop() })
+	if err := tun.Start(); err != ErrRunning {
+		t.Errorf("second Start: %v", err)
+	}
+	return tun
+}
+
+func echoTCP(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// roundTrip writes msg to c and reads the echo.
+func roundTrip(t *testing.T, c io.ReadWriter, msg string) {
+	t.Helper()
+	if _, err := io.WriteString(c, msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != msg {
+		t.Fatalf("echo %q %v", buf, err)
+	}
+}
+
+// waitStats waits for the counters to settle on want.
+func waitStats(t *testing.T, tun Tunnel, want Stats) {
+	t.Helper()
+	for i := 0; i < 100 && tun.Stats() != want; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := tun.Stats(); got != want {
+		t.Errorf("stats %+v, want %+v", got, want)
+	}
+}
+
+func Test_ServerTunnel(t *testing.T) {
+	b := samtest.New(t)
+	_, port, _ := net.SplitHostPort(echoTCP(t))
+	tun := start(t, config(t, b, "server", map[string]string{"targetPort": port}))
+
+	c, err := b.Connect(samtest.Keys(t).Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	roundTrip(t, c, "hello")
+	waitStats(t, tun, Stats{BytesIn: 5, BytesOut: 5, Conns: 1})
+
+	if err := tun.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if tun.Addr() != "" {
+		t.Error("stopped tunnel has an address")
+	}
+	// the stream was closed by Stop
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("stream after Stop: %v", err)
+	}
+}
+
+func Test_TunnelKeysSigType(t *testing.T) {
+	b := samtest.New(t)
+	_, port, _ := net.SplitHostPort(echoTCP(t))
+	cfg := config(t, b, "server", map[string]string{"targetPort": port})
+	// the keys are Ed25519, the signature type only applies to new keys
+	st := sam3.Sig_ECDSA_SHA256_P256
+	cfg.SigType = &st
+	start(t, cfg)
+	if st := samtest.Arg(b.Command("SESSION CREATE"), "SIGNATURE_TYPE"); st != "7" {
+		t.Errorf("session created with SIGNATURE_TYPE=%s, want the keys' 7", st)
+	}
+}
+
+func Test_TunnelNewKeysSigType(t *testing.T) {
+	for _, tc := range []struct {
+		sigType *sam3.SigType
+		want    string
+	}{{nil, "7"}, {new(sam3.SigType), "0"}} {
+		b := samtest.New(t)
+		_, port, _ := net.SplitHostPort(echoTCP(t))
+		cfg := config(t, b, "server", map[string]string{"targetPort": port, "privKeyFile": filepath.Join(t.TempDir(), "server.dat")})
+		cfg.DestinationKeys = i2pkeys.I2PKeys{}
+		// DSA_SHA1 is the zero value, but set explicitly it is honoured
+		cfg.SigType = tc.sigType
+		start(t, cfg)
+		if st := samtest.Arg(b.Command("DEST GENERATE"), "SIGNATURE_TYPE"); st != tc.want {
+			t.Errorf("keys generated with SIGNATURE_TYPE=%s, want %s", st, tc.want)
+		}
+	}
+}
+
+func Test_ClientTunnel(t *testing.T) {
+	b := samtest.New(t)
+	site := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", site)
+	b.Forward(site, echoTCP(t))
+	keyFile := filepath.Join(t.TempDir(), "client.dat")
+	cfg := config(t, b, "client", map[string]string{"targetDestination": "site.i2p:81", "privKeyFile": keyFile})
+	cfg.DestinationKeys = i2pkeys.I2PKeys{}
+	tun := start(t, cfg)
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Errorf("keys not stored: %v", err)
+	}
+
+	c, err := net.Dial("tcp", tun.(*Client).ListenAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	roundTrip(t, c, "ping")
+	if port := samtest.Arg(b.Command("STREAM CONNECT"), "TO_PORT"); port != "81" {
+		t.Errorf("TO_PORT = %q", port)
+	}
+	waitStats(t, tun, Stats{BytesIn: 4, BytesOut: 4, Conns: 1})
+
+	// restarting keeps the destination stored in the key file
+	addr := tun.Addr()
+	tun.Stop()
+	if err := tun.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if tun.Addr() != addr {
+		t.Error("destination changed on restart")
+	}
+}
+
+func Test_UDPTunnels(t *testing.T) {
+	b := samtest.New(t)
+	site := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", site)
+	udpPort := strconv.Itoa(b.UDPPort())
+	client := start(t, config(t, b, "udpclient", map[string]string{"targetDestination": "site.i2p", "samUDPPort": udpPort}))
+
+	u, err := net.DialUDP("udp", nil, client.(*UDPClient).ListenAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	u.Write([]byte("ping"))
+	d, err := b.Sent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "udpclientTun" || d.Dest != site.String() || string(d.Payload) != "ping" {
+		t.Errorf("sent %+v", d)
+	}
+	b.Deliver("udpclientTun", samtest.Keys(t).Addr(), []byte("spoofed"))
+	b.Deliver("udpclientTun", site, []byte("pong"))
+	u.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, err := u.Read(buf)
+	if err != nil || string(buf[:n]) != "pong" {
+		t.Fatalf("reply %q %v", buf[:n], err)
+	}
+	waitStats(t, client, Stats{BytesIn: 4, BytesOut: 4})
+
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+	port := strconv.Itoa(echo.LocalAddr().(*net.UDPAddr).Port)
+	server := start(t, config(t, b, "udpserver", map[string]string{"targetPort": port, "samUDPPort": udpPort}))
+	peer := samtest.Keys(t).Addr()
+	b.Deliver("udpserverTun", peer, []byte("hello"))
+	d, err = b.Sent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "udpserverTun" || d.Dest != peer.String() || string(d.Payload) != "hello" {
+		t.Errorf("sent %+v", d)
+	}
+	waitStats(t, server, Stats{BytesIn: 5, BytesOut: 5})
+}
+
+func Test_HTTPClientTunnel(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.Host)
+	}))
+	defer site.Close()
+	b := samtest.New(t)
+	dest := samtest.Keys(t).Addr()
+	b.AddName("site.i2p", dest)
+	b.Forward(dest, site.Listener.Addr().String())
+	tun := start(t, config(t, b, "httpclient", nil))
+
+	proxy := &url.URL{Scheme: "http", Host: tun.(*HTTPClient).ListenAddr().String()}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+	resp, err := client.Get("http://site.i2p/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello site.i2p" {
+		t.Errorf("got %q", body)
+	}
+	if s := tun.Stats(); s.Conns != 1 || s.BytesIn == 0 || s.BytesOut == 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
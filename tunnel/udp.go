@@ -0,0 +1,239 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/go-i2p/sam3"
+)
+
+// peerIdle is how long a UDP server tunnel keeps the local socket of a peer
+// which sent nothing.
+const peerIdle = 2 * time.Minute
+
+// maxDatagram is the largest datagram I2P carries.
+const maxDatagram = 32 * 1024
+
+// datagramSession creates the datagram session of a UDP tunnel.
+func (b *base) datagramSession() (*sam3.DatagramSession, i2pkeys.I2PKeys, error) {
+	udpPort, err := b.samUDPPort()
+	if err != nil {
+		return nil, i2pkeys.I2PKeys{}, err
+	}
+	sam, keys, err := b.connect()
+	if err != nil {
+		return nil, i2pkeys.I2PKeys{}, err
+	}
+	options := b.cfg.Print()
+	if from, to := b.ports(); from != "0" || to != "0" {
+		options = append(options, "FROM_PORT="+from, "TO_PORT="+to)
+	}
+	dg, err := sam.NewDatagramSession(b.cfg.TunName, keys, options, udpPort)
+	if err != nil {
+		sam.Close()
+		return nil, i2pkeys.I2PKeys{}, err
+	}
+	return dg, keys, nil
+}
+
+// UDPClient is a UDP client tunnel.
+type UDPClient struct {
+	base
+	conn *net.UDPConn
+
+	peerMu sync.Mutex
+	peer   *net.UDPAddr // the last local sender
+}
+
+// NewUDPClient returns a UDP client tunnel configured by cfg.
+func NewUDPClient(cfg *sam3.I2PConfig) (*UDPClient, error) {
+	c := &UDPClient{base: newBase(cfg)}
+	if _, _, err := c.target(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ListenAddr is the local address the tunnel receives datagrams on while it
+// runs.
+func (c *UDPClient) ListenAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		return nil
+	}
+	return c.conn.LocalAddr()
+}
+
+// Start implements Tunnel.
+func (c *UDPClient) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		return ErrRunning
+	}
+	name, _, _ := c.target()
+	laddr, err := net.ResolveUDPAddr("udp", c.listenAddr())
+	if err != nil {
+		return err
+	}
+	dg, keys, err := c.datagramSession()
+	if err != nil {
+		return err
+	}
+	dest, err := dg.Lookup(name)
+	if err != nil {
+		dg.Close()
+		return err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		dg.Close()
+		return err
+	}
+	c.conn = conn
+	c.started(keys.Addr(), conn, dg)
+	c.run(func() { c.outbound(dg, dest) })
+	c.run(func() { c.inbound(dg, dest) })
+	return nil
+}
+
+func (c *UDPClient) outbound(dg *sam3.DatagramSession, dest net.Addr) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		c.peerMu.Lock()
+		c.peer = from
+		c.peerMu.Unlock()
+		if _, err := dg.WriteTo(buf[:n], dest); err == nil {
+			atomic.AddUint64(&c.out, uint64(n))
+		}
+	}
+}
+
+func (c *UDPClient) inbound(dg *sam3.DatagramSession, dest net.Addr) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := dg.ReadFrom(buf)
+		if err != nil {
+			if !c.isRunning() {
+				return
+			}
+			continue
+		}
+		// only the target may answer
+		if from.String() != dest.String() {
+			continue
+		}
+		c.peerMu.Lock()
+		peer := c.peer
+		c.peerMu.Unlock()
+		if peer == nil {
+			continue
+		}
+		if _, err := c.conn.WriteToUDP(buf[:n], peer); err == nil {
+			atomic.AddUint64(&c.in, uint64(n))
+		}
+	}
+}
+
+// UDPServer is a UDP server tunnel.
+type UDPServer struct {
+	base
+	peerMu sync.Mutex
+	peers  map[string]*net.UDPConn
+}
+
+// NewUDPServer returns a UDP server tunnel configured by cfg.
+func NewUDPServer(cfg *sam3.I2PConfig) (*UDPServer, error) {
+	s := &UDPServer{base: newBase(cfg)}
+	if _, err := s.targetAddr(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start implements Tunnel.
+func (s *UDPServer) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrRunning
+	}
+	target, _ := s.targetAddr()
+	taddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return err
+	}
+	dg, keys, err := s.datagramSession()
+	if err != nil {
+		return err
+	}
+	s.peers = make(map[string]*net.UDPConn)
+	s.started(keys.Addr(), dg)
+	s.run(func() { s.inbound(dg, taddr) })
+	return nil
+}
+
+func (s *UDPServer) inbound(dg *sam3.DatagramSession, target *net.UDPAddr) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := dg.ReadFrom(buf)
+		if err != nil {
+			if !s.isRunning() {
+				return
+			}
+			continue
+		}
+		conn := s.peer(dg, from, target)
+		if conn == nil {
+			continue
+		}
+		if _, err := conn.Write(buf[:n]); err == nil {
+			atomic.AddUint64(&s.in, uint64(n))
+		}
+	}
+}
+
+// peer returns the local socket of the I2P peer from, which is created with
+// a goroutine relaying replies to the peer if it does not exist yet.
+func (s *UDPServer) peer(dg *sam3.DatagramSession, from net.Addr, target *net.UDPAddr) *net.UDPConn {
+	key := from.String()
+	s.peerMu.Lock()
+	defer s.peerMu.Unlock()
+	if conn, ok := s.peers[key]; ok {
+		return conn
+	}
+	conn, err := net.DialUDP("udp", nil, target)
+	if err != nil || !s.track(conn) {
+		return nil
+	}
+	s.peers[key] = conn
+	s.run(func() {
+		defer func() {
+			s.peerMu.Lock()
+			delete(s.peers, key)
+			s.peerMu.Unlock()
+			s.untrack(conn)
+			conn.Close()
+		}()
+		buf := make([]byte, maxDatagram)
+		for {
+			conn.SetReadDeadline(time.Now().Add(peerIdle))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := dg.WriteTo(buf[:n], from); err == nil {
+				atomic.AddUint64(&s.out, uint64(n))
+			}
+		}
+	})
+	return conn
+}
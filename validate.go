@@ -0,0 +1,352 @@
+package sam3
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// OptionKind is the type of value a session option takes.
+type OptionKind int
+
+const (
+	OptionString OptionKind = iota
+	OptionBool
+	OptionInt
+	OptionEnum    // one of OptionSpec.Values, case-insensitive
+	OptionIntList // comma separated integers, such as i2cp.leaseSetEncType
+)
+
+// OptionSpec describes a known I2CP or streaming library option.
+type OptionSpec struct {
+	Key string
+	// Prefix makes the spec match every option starting with Key, for
+	// numbered options such as i2cp.leaseSetClient.dh.0
+	Prefix   bool
+	Kind     OptionKind
+	Min, Max int      // range of OptionInt values, if Min < Max
+	Values   []string // allowed OptionEnum values
+	Default  string   // the router's default, for documentation
+	// Check, if set, validates the value in addition to Kind
+	Check func(value string) error
+}
+
+// Validate checks a value against the spec.
+func (o OptionSpec) Validate(value string) error {
+	switch o.Kind {
+	case OptionBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("must be true or false")
+		}
+	case OptionInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		if o.Min < o.Max && (n < o.Min || n > o.Max) {
+			return fmt.Errorf("must be between %d and %d", o.Min, o.Max)
+		}
+	case OptionEnum:
+		ok := false
+		for _, v := range o.Values {
+			ok = ok || strings.EqualFold(v, value)
+		}
+		if !ok {
+			return fmt.Errorf("must be one of %s", strings.Join(o.Values, ", "))
+		}
+	case OptionIntList:
+		for _, v := range strings.Split(value, ",") {
+			if _, err := strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return fmt.Errorf("must be a comma separated list of integers")
+			}
+		}
+	}
+	if o.Check != nil {
+		return o.Check(value)
+	}
+	return nil
+}
+
+const (
+	maxInt      = int(^uint(0) >> 1)
+	minIdleTime = 300000 // the router does not reduce or close tunnels sooner
+)
+
+var optionRegistry = struct {
+	sync.RWMutex
+	m map[string]OptionSpec
+}{m: make(map[string]OptionSpec)}
+
+func init() {
+	for _, o := range []OptionSpec{
+		{Key: "inbound.length", Kind: OptionInt, Min: 0, Max: 7, Default: "3"},
+		{Key: "outbound.length", Kind: OptionInt, Min: 0, Max: 7, Default: "3"},
+		{Key: "inbound.lengthVariance", Kind: OptionInt, Min: -7, Max: 7, Default: "0"},
+		{Key: "outbound.lengthVariance", Kind: OptionInt, Min: -7, Max: 7, Default: "0"},
+		{Key: "inbound.quantity", Kind: OptionInt, Min: 1, Max: 16, Default: "2"},
+		{Key: "outbound.quantity", Kind: OptionInt, Min: 1, Max: 16, Default: "2"},
+		{Key: "inbound.backupQuantity", Kind: OptionInt, Min: 0, Max: 16, Default: "0"},
+		{Key: "outbound.backupQuantity", Kind: OptionInt, Min: 0, Max: 16, Default: "0"},
+		{Key: "inbound.allowZeroHop", Kind: OptionBool, Default: "true"},
+		{Key: "outbound.allowZeroHop", Kind: OptionBool, Default: "true"},
+		{Key: "inbound.IPRestriction", Kind: OptionInt, Min: 0, Max: 4, Default: "2"},
+		{Key: "outbound.IPRestriction", Kind: OptionInt, Min: 0, Max: 4, Default: "2"},
+		{Key: "inbound.nickname", Kind: OptionString},
+		{Key: "outbound.nickname", Kind: OptionString},
+		{Key: "inbound.randomKey", Kind: OptionString},
+		{Key: "outbound.randomKey", Kind: OptionString},
+		{Key: "outbound.priority", Kind: OptionInt, Min: -25, Max: 25, Default: "0"},
+		{Key: "i2cp.gzip", Kind: OptionBool, Default: "true"},
+		{Key: "i2cp.fastReceive", Kind: OptionBool, Default: "true"},
+		{Key: "i2cp.messageReliability", Kind: OptionEnum, Values: []string{"BestEffort", "None"}, Default: "BestEffort"},
+		{Key: "i2cp.reduceOnIdle", Kind: OptionBool, Default: "false"},
+		{Key: "i2cp.reduceIdleTime", Kind: OptionInt, Min: minIdleTime, Max: maxInt, Default: "1200000"},
+		{Key: "i2cp.reduceQuantity", Kind: OptionInt, Min: 0, Max: 16, Default: "1"},
+		{Key: "i2cp.closeOnIdle", Kind: OptionBool, Default: "false"},
+		{Key: "i2cp.closeIdleTime", Kind: OptionInt, Min: minIdleTime, Max: maxInt, Default: "1800000"},
+		{Key: "i2cp.dontPublishLeaseSet", Kind: OptionBool, Default: "false"},
+		{Key: "i2cp.encryptLeaseSet", Kind: OptionBool, Default: "false"},
+		{Key: "i2cp.leaseSetKey", Kind: OptionString},
+		{Key: "i2cp.leaseSetPrivateKey", Kind: OptionString},
+		{Key: "i2cp.leaseSetSigningPrivateKey", Kind: OptionString},
+		{Key: "i2cp.leaseSetPrivateSigningKey", Kind: OptionString},
+		{Key: "i2cp.leaseSetEncType", Kind: OptionIntList, Default: "0"},
+		{Key: "i2cp.leaseSetType", Kind: OptionEnum, Values: []string{"1", "3", "5", "7"}, Default: "1"},
+		{Key: "i2cp.leaseSetSecret", Kind: OptionString},
+		{Key: "i2cp.leaseSetAuthType", Kind: OptionInt, Min: 0, Max: 2, Default: "0"},
+		{Key: "i2cp.leaseSetClient.dh.", Prefix: true, Kind: OptionString, Check: checkLeaseSetClient},
+		{Key: "i2cp.leaseSetClient.psk.", Prefix: true, Kind: OptionString, Check: checkLeaseSetClient},
+		{Key: "i2cp.leaseSetOption.", Prefix: true, Kind: OptionString},
+		{Key: "i2cp.destination.sigType", Kind: OptionString, Check: func(v string) error {
+			_, err := ParseSigType(v)
+			return err
+		}},
+		{Key: "i2cp.enableAccessList", Kind: OptionBool, Default: "false"},
+		{Key: "i2cp.enableBlackList", Kind: OptionBool, Default: "false"},
+		{Key: "i2cp.accessList", Kind: OptionString},
+		{Key: "i2p.streaming.connectDelay", Kind: OptionInt, Min: -1, Max: maxInt, Default: "-1"},
+		{Key: "i2p.streaming.connectTimeout", Kind: OptionInt, Min: 0, Max: maxInt, Default: "60000"},
+		{Key: "i2p.streaming.readTimeout", Kind: OptionInt, Min: -1, Max: maxInt, Default: "-1"},
+		{Key: "i2p.streaming.writeTimeout", Kind: OptionInt, Min: -1, Max: maxInt, Default: "-1"},
+		{Key: "i2p.streaming.maxWindowSize", Kind: OptionInt, Min: 1, Max: 128, Default: "128"},
+		{Key: "i2p.streaming.initialWindowSize", Kind: OptionInt, Min: 1, Max: 128, Default: "6"},
+		{Key: "i2p.streaming.maxMessageSize", Kind: OptionInt, Min: 512, Max: 65536, Default: "1730"},
+		{Key: "i2p.streaming.maxResends", Kind: OptionInt, Min: 0, Max: maxInt, Default: "8"},
+		{Key: "i2p.streaming.maxConnsPerMinute", Kind: OptionInt, Min: 0, Max: maxInt, Default: "0"},
+		{Key: "i2p.streaming.maxConnsPerHour", Kind: OptionInt, Min: 0, Max: maxInt, Default: "0"},
+		{Key: "i2p.streaming.maxConnsPerDay", Kind: OptionInt, Min: 0, Max: maxInt, Default: "0"},
+		{Key: "i2p.streaming.maxTotalConnsPerMinute", Kind: OptionInt, Min: 0, Max: maxInt, Default: "0"},
+		{Key: "i2p.streaming.maxTotalConnsPerHour", Kind: OptionInt, Min: 0, Max: maxInt, Default: "0"},
+		{Key: "i2p.streaming.maxTotalConnsPerDay", Kind: OptionInt, Min: 0, Max: maxInt, Default: "0"},
+		{Key: "i2p.streaming.maxConcurrentStreams", Kind: OptionInt, Min: -1, Max: maxInt, Default: "-1"},
+		{Key: "i2p.streaming.answerPings", Kind: OptionBool, Default: "true"},
+		{Key: "i2p.streaming.profile", Kind: OptionEnum, Values: []string{"1", "2"}, Default: "1"},
+		{Key: "i2p.streaming.inactivityTimeout", Kind: OptionInt, Min: 0, Max: maxInt, Default: "90000"},
+		{Key: "i2p.streaming.inactivityAction", Kind: OptionEnum, Values: []string{"0", "1", "2"}, Default: "2"},
+		{Key: "i2p.streaming.enforceProtocol", Kind: OptionBool, Default: "true"},
+		{Key: "i2p.streaming.disableRejectLogging", Kind: OptionBool, Default: "false"},
+		{Key: "i2p.streaming.limitAction", Kind: OptionEnum, Values: []string{"reset", "drop", "http"}, Default: "reset"},
+	} {
+		RegisterOption(o)
+	}
+}
+
+func checkLeaseSetClient(v string) error {
+	_, err := parseLeaseSetClient(v)
+	return err
+}
+
+// RegisterOption adds an option to the registry used to validate session
+// options, or replaces the spec of a known one. Applications can use it for
+// options of newer routers.
+func RegisterOption(o OptionSpec) {
+	optionRegistry.Lock()
+	defer optionRegistry.Unlock()
+	optionRegistry.m[o.Key] = o
+}
+
+// LookupOption returns the spec of a known option.
+func LookupOption(key string) (OptionSpec, bool) {
+	optionRegistry.RLock()
+	defer optionRegistry.RUnlock()
+	if o, ok := optionRegistry.m[key]; ok && !o.Prefix {
+		return o, true
+	}
+	for k, o := range optionRegistry.m {
+		if o.Prefix && strings.HasPrefix(key, k) {
+			return o, true
+		}
+	}
+	return OptionSpec{}, false
+}
+
+// OptionError reports an invalid session option.
+type OptionError struct {
+	Option string
+	Value  string
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return "invalid option " + e.Option + "=" + e.Value + ": " + e.Reason
+}
+
+// OptionErrors is every problem found in a set of options.
+type OptionErrors []*OptionError
+
+func (e OptionErrors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// ValidationMode says what the session constructors do with invalid options.
+type ValidationMode int
+
+const (
+	// ValidationWarn logs invalid options and sends them anyway. This is
+	// the default.
+	ValidationWarn ValidationMode = iota
+	// ValidationStrict refuses to create sessions with invalid options.
+	ValidationStrict
+	// ValidationOff sends options to the bridge unchecked.
+	ValidationOff
+)
+
+// SetOptionValidation sets how sessions created by this SAM treat invalid
+// options.
+func (sam *SAM) SetOptionValidation(mode ValidationMode) {
+	sam.validation = mode
+}
+
+// ValidateOptions checks session options, given as key=value strings as in
+// the options argument of the session constructors, against the registry.
+// It reports unknown options, values of the wrong type or out of range,
+// options given twice and options which contradict each other. Upper case
+// keys are SAM parameters rather than I2CP options and are not checked.
+func ValidateOptions(options []string) error {
+	var errs OptionErrors
+	seen := make(map[string]string)
+	for _, opt := range options {
+		for _, word := range tokenizeReply(opt) {
+			key, value, ok := splitPair(word)
+			if !ok {
+				errs = append(errs, &OptionError{word, "", "not a key=value pair"})
+				continue
+			}
+			if isSAMParameter(key) {
+				continue
+			}
+			if prev, dup := seen[key]; dup {
+				if prev != value {
+					errs = append(errs, &OptionError{key, value, "conflicts with earlier value " + prev})
+				} else {
+					errs = append(errs, &OptionError{key, value, "given twice"})
+				}
+				continue
+			}
+			seen[key] = value
+			spec, known := LookupOption(key)
+			if !known {
+				errs = append(errs, &OptionError{key, value, "unknown option" + suggestOption(key)})
+				continue
+			}
+			if err := spec.Validate(value); err != nil {
+				errs = append(errs, &OptionError{key, value, err.Error()})
+			}
+		}
+	}
+	if seen["i2cp.enableAccessList"] == "true" && seen["i2cp.enableBlackList"] == "true" {
+		errs = append(errs, &OptionError{"i2cp.enableBlackList", "true", "conflicts with i2cp.enableAccessList=true"})
+	}
+	if t := seen["i2cp.leaseSetAuthType"]; t != "" && t != "0" && !hasPrefixKey(seen, "i2cp.leaseSetClient.") {
+		errs = append(errs, &OptionError{"i2cp.leaseSetAuthType", t, "no i2cp.leaseSetClient options"})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func isSAMParameter(key string) bool {
+	for _, r := range key {
+		if unicode.IsLower(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasPrefixKey(m map[string]string, prefix string) bool {
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestOption names a known option which differs from key only by case
+// or two swapped letters, to point out typos.
+func suggestOption(key string) string {
+	optionRegistry.RLock()
+	defer optionRegistry.RUnlock()
+	var candidates []string
+	for k, o := range optionRegistry.m {
+		if !o.Prefix && (strings.EqualFold(k, key) || swappedLetters(k, key)) {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Strings(candidates)
+	return ", did you mean " + candidates[0] + "?"
+}
+
+func swappedLetters(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	diff := []int{}
+	for i := range a {
+		if a[i] != b[i] {
+			diff = append(diff, i)
+		}
+	}
+	return len(diff) == 2 && diff[1] == diff[0]+1 && a[diff[0]] == b[diff[1]] && a[diff[1]] == b[diff[0]]
+}
+
+// checkOptions validates options according to the SAM's validation mode.
+func (sam *SAM) checkOptions(options []string) error {
+	return checkOptions(sam.validation, sam.log(), options)
+}
+
+// checkOptions validates the options of a SESSION ADD according to the
+// validation mode of the SAM the primary session was created with.
+func (ss *PrimarySession) checkOptions(options []string) error {
+	return checkOptions(ss.validation, ss.log(), options)
+}
+
+func checkOptions(mode ValidationMode, l Logger, options []string) error {
+	if mode == ValidationOff {
+		return nil
+	}
+	err := ValidateOptions(options)
+	if err == nil {
+		return nil
+	}
+	if mode == ValidationStrict {
+		l.Error("Invalid session options", "error", err)
+		return err
+	}
+	for _, e := range err.(OptionErrors) {
+		l.Warn("Invalid session option", "option", e.Option, "reason", e.Reason)
+	}
+	return nil
+}
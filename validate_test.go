@@ -0,0 +1,128 @@
+package sam3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/sam3/internal/samtest"
+)
+
+func Test_ValidateOptions(t *testing.T) {
+	for _, c := range []struct {
+		options []string
+		bad     []string // options named in the error
+	}{
+		{[]string{"inbound.length=3", "outbound.length=0", "i2cp.leaseSetEncType=4,0"}, nil},
+		{[]string{"inbound.length=3 outbound.quantity=4", "SIGNATURE_TYPE=7"}, nil},
+		{[]string{"i2cp.messageReliability=none", "i2p.streaming.profile=2"}, nil},
+		{[]string{"inbound.length=9"}, []string{"inbound.length"}},
+		{[]string{"inbound.quantity=lots"}, []string{"inbound.quantity"}},
+		{[]string{"i2cp.gzip=yes"}, []string{"i2cp.gzip"}},
+		{[]string{"i2cp.leaseSetEncType=4,x"}, []string{"i2cp.leaseSetEncType"}},
+		{[]string{"i2cp.destination.sigType=RSA_NOPE"}, []string{"i2cp.destination.sigType"}},
+		{[]string{"inbound.lenght=3"}, []string{"inbound.lenght"}},
+		{[]string{"inbound.length=3", "inbound.length=2"}, []string{"inbound.length"}},
+		{[]string{"inbound.length=3", "inbound.length=3"}, []string{"inbound.length"}},
+		{[]string{"i2cp.enableAccessList=true", "i2cp.enableBlackList=true"}, []string{"i2cp.enableBlackList"}},
+		{[]string{"i2cp.leaseSetAuthType=1"}, []string{"i2cp.leaseSetAuthType"}},
+		{[]string{"i2cp.leaseSetClient.psk.0=bad"}, []string{"i2cp.leaseSetClient.psk.0"}},
+	} {
+		err := ValidateOptions(c.options)
+		if len(c.bad) == 0 {
+			if err != nil {
+				t.Errorf("%v: %v", c.options, err)
+			}
+			continue
+		}
+		var errs OptionErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("%v: got %v, want OptionErrors", c.options, err)
+			continue
+		}
+		for i, name := range c.bad {
+			if i >= len(errs) || errs[i].Option != name {
+				t.Errorf("%v: got %v, want an error for %s", c.options, err, name)
+			}
+		}
+	}
+	if err := ValidateOptions([]string{"inbound.lenght=3"}); !strings.Contains(err.Error(), "did you mean inbound.length") {
+		t.Errorf("no suggestion in %v", err)
+	}
+
+	RegisterOption(OptionSpec{Key: "i2cp.testOption", Kind: OptionBool})
+	if err := ValidateOptions([]string{"i2cp.testOption=true"}); err != nil {
+		t.Errorf("registered option rejected: %v", err)
+	}
+}
+
+func Test_OptionValidationMode(t *testing.T) {
+	b := samtest.New(t)
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	bad := []string{"inbound.length=42"}
+
+	// the default only warns
+	if _, err := sam.NewStreamSession("warnTun", samtest.Keys(t), bad); err != nil {
+		t.Fatalf("warn mode: %v", err)
+	}
+	if samtest.Arg(b.Command("SESSION CREATE"), "inbound.length") != "42" {
+		t.Error("warn mode did not send the option")
+	}
+
+	sam2, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam2.Close()
+	sam2.SetOptionValidation(ValidationStrict)
+	_, err = sam2.NewStreamSession("strictTun", samtest.Keys(t), bad)
+	var oe *OptionError
+	var errs OptionErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("strict mode: got %v", err)
+	}
+	if oe = errs[0]; oe.Option != "inbound.length" || oe.Value != "42" {
+		t.Errorf("error names %s=%s", oe.Option, oe.Value)
+	}
+	if strings.Contains(b.Command("SESSION CREATE"), "strictTun") {
+		t.Error("strict mode sent the session anyway")
+	}
+}
+
+func Test_PrimaryOptionValidation(t *testing.T) {
+	b := samtest.New(t)
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sam.Close()
+	sam.SetOptionValidation(ValidationStrict)
+	if _, err := sam.newPrimarySession("PRIMARY", "badPrimary", samtest.Keys(t), []string{"inbound.length=42"}); err == nil {
+		t.Error("strict mode created a primary session with invalid options")
+	}
+	ps, err := sam.newPrimarySession("PRIMARY", "primary", samtest.Keys(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	// SESSION ADD is checked in the SAM's mode too
+	_, err = ps.newGenericSubSession("STREAM", "strictSub", []string{"inbound.lenght=3"})
+	var errs OptionErrors
+	if !errors.As(err, &errs) || errs[0].Option != "inbound.lenght" {
+		t.Fatalf("strict mode: got %v", err)
+	}
+	if b.Command("SESSION ADD") != "" {
+		t.Error("strict mode sent the subsession anyway")
+	}
+	if _, err := ps.newGenericSubSession("DATAGRAM", "sub", []string{"PORT=7655", "i2cp.gzip=true"}); err != nil {
+		t.Fatalf("valid subsession: %v", err)
+	}
+	if samtest.Arg(b.Command("SESSION ADD"), "i2cp.gzip") != "true" {
+		t.Errorf("SESSION ADD = %q", b.Command("SESSION ADD"))
+	}
+}